@@ -0,0 +1,221 @@
+// Package achievement decides which achievements a user has earned from
+// their visits. The rules are data, loaded from a toml file like
+// data/achievements.toml, so new ones don't need new code.
+package achievement
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bobisme/RestApiProject/geo"
+)
+
+// Kinds of rule
+const (
+	// KindStates is visiting a number of states, from a list or at all
+	KindStates = "states"
+	// KindCities is visiting a number of the listed cities
+	KindCities = "cities"
+	// KindDistance is visiting two cities far apart within a few days
+	KindDistance = "distance"
+)
+
+// Rule for earning an achievement
+type Rule struct {
+	Key         string `toml:"key" json:"key"`
+	Name        string `toml:"name" json:"name"`
+	Description string `toml:"description" json:"description"`
+	Kind        string `toml:"kind" json:"kind"`
+	// Count is how many states or cities are needed
+	Count int `toml:"count" json:"-"`
+	// States are abbreviations, like "NC"
+	States []string `toml:"states" json:"-"`
+	// Cities are written "Name, ST"
+	Cities []string `toml:"cities" json:"-"`
+	MinKm  float64  `toml:"min_km" json:"-"`
+	Days   int      `toml:"days" json:"-"`
+}
+
+// Target is what progress has to reach for the rule to be earned
+func (r *Rule) Target() int {
+	switch r.Kind {
+	case KindStates:
+		if r.Count > 0 {
+			return r.Count
+		}
+		return len(r.States)
+	case KindCities:
+		if r.Count > 0 {
+			return r.Count
+		}
+		return len(r.Cities)
+	}
+	return 1
+}
+
+func (r *Rule) validate() error {
+	if r.Key == "" {
+		return fmt.Errorf("achievement %q has no key", r.Name)
+	}
+	switch r.Kind {
+	case KindStates:
+		if r.Target() < 1 {
+			return fmt.Errorf("achievement %q needs states or a count", r.Key)
+		}
+	case KindCities:
+		if len(r.Cities) == 0 {
+			return fmt.Errorf("achievement %q has no cities", r.Key)
+		}
+	case KindDistance:
+		if r.MinKm <= 0 || r.Days <= 0 {
+			return fmt.Errorf("achievement %q needs min_km and days", r.Key)
+		}
+	default:
+		return fmt.Errorf("achievement %q has unknown kind %q", r.Key, r.Kind)
+	}
+	if r.Target() > len(r.States) && len(r.States) > 0 ||
+		r.Target() > len(r.Cities) && len(r.Cities) > 0 {
+		return fmt.Errorf("achievement %q can never be earned", r.Key)
+	}
+	return nil
+}
+
+// Decode rules from toml
+func Decode(r io.Reader) ([]Rule, error) {
+	var file struct {
+		Achievement []Rule `toml:"achievement"`
+	}
+	if _, err := toml.DecodeReader(r, &file); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i := range file.Achievement {
+		rule := &file.Achievement[i]
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		if seen[rule.Key] {
+			return nil, fmt.Errorf("achievement %q is declared twice", rule.Key)
+		}
+		seen[rule.Key] = true
+	}
+	return file.Achievement, nil
+}
+
+// LoadFile loads rules from a toml file
+func LoadFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// Visit is what the rules need to know about a visit
+type Visit struct {
+	City  string
+	State string
+	Lat   float64
+	Lon   float64
+	Time  time.Time
+}
+
+func (v *Visit) cityKey() string {
+	return v.City + ", " + v.State
+}
+
+// Result of checking a rule
+type Result struct {
+	Rule     *Rule
+	Progress int
+	// EarnedAt is the time of the visit that earned it, or nil
+	EarnedAt *time.Time
+}
+
+// Evaluate every rule against the visits
+func Evaluate(rules []Rule, visits []Visit) []Result {
+	// rules are checked by replaying visits in order, so the visit that
+	// earned each one is known
+	sorted := make([]Visit, len(visits))
+	copy(sorted, visits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+	results := make([]Result, len(rules))
+	for i := range rules {
+		results[i] = evaluate(&rules[i], sorted)
+	}
+	return results
+}
+
+func evaluate(rule *Rule, visits []Visit) Result {
+	if rule.Kind == KindDistance {
+		return evaluateDistance(rule, visits)
+	}
+	var wanted map[string]bool
+	var names []string
+	if rule.Kind == KindStates {
+		names = rule.States
+	} else {
+		names = rule.Cities
+	}
+	if len(names) > 0 {
+		wanted = map[string]bool{}
+		for _, name := range names {
+			wanted[strings.ToLower(name)] = true
+		}
+	}
+	target := rule.Target()
+	result := Result{Rule: rule}
+	seen := map[string]bool{}
+	for i := range visits {
+		key := visits[i].State
+		if rule.Kind == KindCities {
+			key = visits[i].cityKey()
+		}
+		key = strings.ToLower(key)
+		if seen[key] || (wanted != nil && !wanted[key]) {
+			continue
+		}
+		seen[key] = true
+		result.Progress++
+		if result.Progress == target {
+			earned := visits[i].Time
+			result.EarnedAt = &earned
+		}
+	}
+	if result.Progress > target {
+		result.Progress = target
+	}
+	return result
+}
+
+// evaluateDistance looks for the earliest visit that is far enough from
+// another in the window before it. visits must be in time order.
+func evaluateDistance(rule *Rule, visits []Visit) Result {
+	result := Result{Rule: rule}
+	window := time.Duration(rule.Days) * 24 * time.Hour
+	start := 0
+	for i := range visits {
+		for visits[i].Time.Sub(visits[start].Time) > window {
+			start++
+		}
+		b := geo.Point{Lat: visits[i].Lat, Lon: visits[i].Lon}
+		for j := start; j < i; j++ {
+			a := geo.Point{Lat: visits[j].Lat, Lon: visits[j].Lon}
+			if geo.Distance(a, b) >= rule.MinKm {
+				earned := visits[i].Time
+				result.Progress = 1
+				result.EarnedAt = &earned
+				return result
+			}
+		}
+	}
+	return result
+}
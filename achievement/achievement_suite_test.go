@@ -0,0 +1,13 @@
+package achievement_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestAchievement(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Achievement Suite")
+}
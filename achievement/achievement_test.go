@@ -0,0 +1,163 @@
+package achievement_test
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/achievement"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var day = 24 * time.Hour
+var start = time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+func visit(city, state string, lat, lon float64, days int) Visit {
+	return Visit{
+		City: city, State: state, Lat: lat, Lon: lon,
+		Time: start.Add(time.Duration(days) * day),
+	}
+}
+
+var (
+	boston     = visit("Boston", "MA", 42.3601, -71.0589, 0)
+	providence = visit("Providence", "RI", 41.8240, -71.4128, 1)
+	hartford   = visit("Hartford", "CT", 41.7658, -72.6734, 2)
+	concord    = visit("Concord", "NH", 43.2081, -71.5376, 3)
+	montpelier = visit("Montpelier", "VT", 44.2601, -72.5754, 4)
+	augusta    = visit("Augusta", "ME", 44.3106, -69.7795, 5)
+	denver     = visit("Denver", "CO", 39.7392, -104.9903, 12)
+)
+
+func decode(toml string) []Rule {
+	rules, err := Decode(strings.NewReader(toml))
+	Ω(err).ShouldNot(HaveOccurred())
+	return rules
+}
+
+var _ = Describe("Achievement", func() {
+	Describe("LoadFile", func() {
+		It("loads the shipped rules", func() {
+			rules, err := LoadFile("../data/achievements.toml")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(rules).ShouldNot(BeEmpty())
+			Ω(rules[0].Key).Should(Equal("first-visit"))
+		})
+	})
+
+	DescribeTable("Decode rejects bad rules",
+		func(toml string) {
+			_, err := Decode(strings.NewReader(toml))
+			Ω(err).Should(HaveOccurred())
+		},
+		Entry("no key", `[[achievement]]
+			kind = "states"
+			count = 1`),
+		Entry("unknown kind", `[[achievement]]
+			key = "a"
+			kind = "planets"`),
+		Entry("no cities", `[[achievement]]
+			key = "a"
+			kind = "cities"`),
+		Entry("distance without days", `[[achievement]]
+			key = "a"
+			kind = "distance"
+			min_km = 10.0`),
+		Entry("impossible count", `[[achievement]]
+			key = "a"
+			kind = "states"
+			states = ["NC"]
+			count = 2`),
+		Entry("duplicate keys", `[[achievement]]
+			key = "a"
+			kind = "states"
+			count = 1
+			[[achievement]]
+			key = "a"
+			kind = "states"
+			count = 2`),
+	)
+
+	Describe("Evaluate", func() {
+		newEngland := decode(`[[achievement]]
+			key = "new-england"
+			kind = "states"
+			states = ["CT", "ME", "MA", "NH", "RI", "VT"]`)
+
+		It("tracks progress through a list of states", func() {
+			results := Evaluate(newEngland, []Visit{boston, providence, denver})
+			Ω(results).Should(HaveLen(1))
+			Ω(results[0].Progress).Should(Equal(2))
+			Ω(results[0].Rule.Target()).Should(Equal(6))
+			Ω(results[0].EarnedAt).Should(BeNil())
+		})
+
+		It("is earned by the visit that completes it", func() {
+			// out of order on purpose
+			results := Evaluate(newEngland, []Visit{
+				augusta, boston, boston, providence, hartford, concord,
+				montpelier})
+			Ω(results[0].Progress).Should(Equal(6))
+			Ω(results[0].EarnedAt).ShouldNot(BeNil())
+			Ω(*results[0].EarnedAt).Should(Equal(augusta.Time))
+		})
+
+		It("counts any states without a list", func() {
+			rules := decode(`[[achievement]]
+				key = "three"
+				kind = "states"
+				count = 3`)
+			results := Evaluate(rules, []Visit{boston, boston, denver, hartford})
+			Ω(results[0].Progress).Should(Equal(3))
+			Ω(*results[0].EarnedAt).Should(Equal(denver.Time))
+		})
+
+		It("counts listed cities", func() {
+			rules := decode(`[[achievement]]
+				key = "capitals"
+				kind = "cities"
+				count = 2
+				cities = ["Boston, MA", "Denver, CO", "Hartford, CT"]`)
+			results := Evaluate(rules, []Visit{boston, providence})
+			Ω(results[0].Progress).Should(Equal(1))
+			Ω(results[0].EarnedAt).Should(BeNil())
+			results = Evaluate(rules, []Visit{boston, providence, denver})
+			Ω(*results[0].EarnedAt).Should(Equal(denver.Time))
+		})
+
+		Context("distance", func() {
+			rules := decode(`[[achievement]]
+				key = "whirlwind"
+				kind = "distance"
+				min_km = 2000.0
+				days = 7`)
+
+			It("needs the cities to be far enough apart", func() {
+				results := Evaluate(rules, []Visit{boston, augusta})
+				Ω(results[0].EarnedAt).Should(BeNil())
+			})
+
+			It("needs the visits to be close enough in time", func() {
+				// denver is 12 days after boston
+				results := Evaluate(rules, []Visit{boston, denver})
+				Ω(results[0].EarnedAt).Should(BeNil())
+			})
+
+			It("includes the last day of the window", func() {
+				// denver is exactly a week after augusta
+				results := Evaluate(rules, []Visit{boston, augusta, denver})
+				Ω(*results[0].EarnedAt).Should(Equal(denver.Time))
+			})
+
+			It("is earned by the later visit", func() {
+				montpelierAgain := montpelier
+				montpelierAgain.Time = denver.Time.Add(-6 * day)
+				results := Evaluate(rules, []Visit{boston, denver, montpelierAgain})
+				Ω(results[0].Progress).Should(Equal(1))
+				Ω(*results[0].EarnedAt).Should(Equal(denver.Time))
+			})
+		})
+	})
+})
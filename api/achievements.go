@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bobisme/RestApiProject/achievement"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// AchievementResponse is a rule and how close the user is to earning it
+type AchievementResponse struct {
+	*achievement.Rule
+	Earned   bool       `json:"earned"`
+	EarnedAt *time.Time `json:"earnedAt,omitempty"`
+	Progress int        `json:"progress"`
+	Target   int        `json:"target"`
+}
+
+// load the user's visits the audience can see in the form the rules use
+func getAchievementVisits(
+	db *gorm.DB, user *models.User, audience string,
+) ([]achievement.Visit, error) {
+	visible, visibleArgs := visibleClause(user, audience)
+	rows, err := db.Raw(`
+		SELECT cities.name, states.abbrev, cities.lat, cities.lon,
+			visits.created_at
+		FROM visits
+		JOIN cities ON cities.id = visits.city_id
+		JOIN states ON states.id = cities.state_id
+		WHERE visits.user_id = ? AND visits.deleted_at IS NULL AND `+visible,
+		append([]interface{}{user.ID}, visibleArgs...)...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	visits := []achievement.Visit{}
+	for rows.Next() {
+		var v achievement.Visit
+		if err := rows.Scan(&v.City, &v.State, &v.Lat, &v.Lon, &v.Time); err != nil {
+			return nil, err
+		}
+		visits = append(visits, v)
+	}
+	return visits, rows.Err()
+}
+
+// awardAchievements saves any achievements the user has newly earned. All
+// their visits count, whoever can see them.
+func awardAchievements(db *gorm.DB, rules []achievement.Rule, userID uint) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return err
+	}
+	visits, err := getAchievementVisits(db, &user, models.VisibilityPrivate)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, result := range achievement.Evaluate(rules, visits) {
+		if result.EarnedAt == nil {
+			continue
+		}
+		// earned achievements are kept, so the first time is the one that
+		// sticks
+		err := db.Exec(`
+			INSERT OR IGNORE INTO user_achievements (
+				user_id, achievement_key, earned_at, created_at)
+			VALUES (?, ?, ?, ?)`,
+			userID, result.Rule.Key, *result.EarnedAt, now).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAchievementsHandler lists every achievement, with the ones the user
+// has earned and their progress on the rest. Earned achievements are only
+// shown to viewers who can see the user's visits by default, and progress
+// only counts visits the viewer can see.
+func getAchievementsHandler(
+	cfg *conf.Config, db *gorm.DB, rules []achievement.Rule,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getUser(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return
+		}
+		version, err := visitsVersion(db, user)
+		if err != nil {
+			jsonError(c, "error looking up visits", err)
+			return
+		}
+		// earned achievements are only ever added, so counting them is
+		// enough to see if they changed
+		var earnedCount int
+		err = db.Model(&models.UserAchievement{}).
+			Where("user_id = ?", user.ID).Count(&earnedCount).Error
+		if err != nil {
+			jsonError(c, "error looking up achievements", err)
+			return
+		}
+		version = version.and(resourceVersion{count: earnedCount})
+		if notModified(c, version, audience) {
+			return
+		}
+		visits, err := getAchievementVisits(db, user, audience)
+		if err != nil {
+			jsonError(c, "error looking up visits", err)
+			return
+		}
+		earned := []models.UserAchievement{}
+		if canSee(audience, user.DefaultVisibility()) {
+			err := db.Where("user_id = ?", user.ID).Find(&earned).Error
+			if err != nil {
+				jsonError(c, "error looking up achievements", err)
+				return
+			}
+		}
+		earnedAt := map[string]time.Time{}
+		for _, e := range earned {
+			earnedAt[e.AchievementKey] = e.EarnedAt
+		}
+
+		results := achievement.Evaluate(rules, visits)
+		response := make([]AchievementResponse, len(results))
+		for i, result := range results {
+			response[i] = AchievementResponse{
+				Rule:     result.Rule,
+				Progress: result.Progress,
+				Target:   result.Rule.Target(),
+			}
+			if t, ok := earnedAt[result.Rule.Key]; ok {
+				response[i].Earned = true
+				response[i].EarnedAt = &t
+				response[i].Progress = response[i].Target
+			}
+		}
+		limit, offset := getLimitOffset(c)
+		start, end := pageBounds(len(response), limit, offset)
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(len(response)), response[start:end],
+		})
+	}
+}
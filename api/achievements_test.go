@@ -0,0 +1,210 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/bobisme/RestApiProject/achievement"
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/cmd"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Achievements", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	const (
+		winterfell = `{ "city": "Winterfell", "state": "WS" }`
+		qarth      = `{ "city": "Qarth", "state": "ES" }`
+	)
+
+	// achievements by key
+	parse := func(body []byte) map[string]AchievementResponse {
+		var out struct {
+			Count int
+			Data  []AchievementResponse
+		}
+		Ω(json.Unmarshal(body, &out)).Should(Succeed())
+		Ω(out.Data).Should(HaveLen(out.Count))
+		achievements := map[string]AchievementResponse{}
+		for _, a := range out.Data {
+			achievements[a.Key] = a
+		}
+		return achievements
+	}
+	getAchievements := func(email, password string) map[string]AchievementResponse {
+		status, body := doAuthRequest(
+			"GET", ts.URL+"/user/2/achievements", email, password, "")
+		Ω(status).Should(Equal(200), string(body))
+		return parse(body)
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("lists every achievement with progress", func() {
+		achievements := getAchievements("", "")
+		Ω(achievements).Should(HaveKey("all-states"))
+		Ω(achievements["all-states"].Earned).Should(BeFalse())
+		Ω(achievements["all-states"].Progress).Should(Equal(0))
+		Ω(achievements["all-states"].Target).Should(Equal(51))
+	})
+
+	It("awards achievements as visits are made", func() {
+		postVisits(ts, 2, winterfell)
+		achievements := getAchievements("", "")
+		Ω(achievements["first-visit"].Earned).Should(BeTrue())
+		Ω(achievements["first-visit"].EarnedAt).ShouldNot(BeNil())
+		Ω(achievements["whirlwind"].Earned).Should(BeFalse())
+
+		postVisits(ts, 2, qarth)
+		achievements = getAchievements("", "")
+		Ω(achievements["whirlwind"].Earned).Should(BeTrue())
+		Ω(achievements["two-states"].Progress).Should(Equal(2))
+		Ω(achievements["two-states"].Earned).Should(BeTrue())
+		Ω(achievements["ten-states"].Progress).Should(Equal(2))
+
+		var count int
+		db.Model(&models.UserAchievement{}).Where("user_id = ?", 2).Count(&count)
+		Ω(count).Should(Equal(3))
+	})
+
+	It("keeps achievements when visits are deleted", func() {
+		visits := postVisits(ts, 2, winterfell)
+		db.Delete(&visits[0])
+		achievements := getAchievements("", "")
+		Ω(achievements["first-visit"].Earned).Should(BeTrue())
+	})
+
+	It("hides private achievements from the public", func() {
+		status, _ := doAuthRequest("PUT", ts.URL+"/user/2/visibility",
+			"arya@winterfell.net", "needle", `{"visibility": "private"}`)
+		Ω(status).Should(Equal(200))
+		postVisits(ts, 2, winterfell)
+
+		achievements := getAchievements("", "")
+		Ω(achievements["first-visit"].Earned).Should(BeFalse())
+		Ω(achievements["first-visit"].Progress).Should(Equal(0))
+
+		achievements = getAchievements("arya@winterfell.net", "needle")
+		Ω(achievements["first-visit"].Earned).Should(BeTrue())
+	})
+})
+
+var _ = Describe("Achievement rules", func() {
+	const seedDB = "test-seed.db"
+
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		os.Remove(seedDB)
+		Ω(cmd.CreateInitialDatabase(seedDB)).Should(Succeed())
+		var err error
+		db, err = gorm.Open("sqlite3", seedDB)
+		Ω(err).ShouldNot(HaveOccurred())
+		cfg := conf.Default()
+		cfg.DBPath = seedDB
+		cfg.AchievementsPath = testAchievementsPath
+		cfg.StateBoundariesPath = testStateBoundariesPath
+		cfg.RateLimits = nil
+		r := gin.New()
+		SetRoutes(cfg, db, r)
+		ts = httptest.NewServer(r)
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		db.Close()
+		os.Remove(seedDB)
+	})
+
+	It("only names real states", func() {
+		rules, err := achievement.LoadFile(testAchievementsPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, rule := range rules {
+			abbrevs := append([]string{}, rule.States...)
+			for _, name := range rule.Cities {
+				i := strings.LastIndex(name, ", ")
+				Ω(i).Should(BeNumerically(">", 0), name)
+				abbrevs = append(abbrevs, name[i+2:])
+			}
+			for _, abbrev := range abbrevs {
+				var count int
+				db.Model(&models.State{}).Where("abbrev = ?", abbrev).Count(&count)
+				Ω(count).Should(Equal(1), "%s in %s", abbrev, rule.Key)
+			}
+		}
+	})
+
+	It("has rules that can be earned with the seed data", func() {
+		rules, err := achievement.LoadFile(testAchievementsPath)
+		Ω(err).ShouldNot(HaveOccurred())
+		user := createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		visit := func(city, state string) {
+			body, _ := json.Marshal(VisitRequest{City: city, State: state})
+			status, resp := doAuthRequest("POST",
+				fmt.Sprintf("%s/user/%d/visits", ts.URL, user.ID),
+				"arya@winterfell.net", "needle", string(body))
+			Ω(status).Should(Equal(201), "%s, %s: %s", city, state, resp)
+		}
+
+		seeded := map[string]bool{
+			"grand-tour": true, "first-capitals": true, "last-frontier": true,
+		}
+		for _, rule := range rules {
+			if !seeded[rule.Key] {
+				continue
+			}
+			// every city has to be found the way visits look them up
+			for _, name := range rule.Cities {
+				i := strings.LastIndex(name, ", ")
+				visit(name[:i], name[i+2:])
+			}
+			// and every state needs a city to visit
+			for _, abbrev := range rule.States {
+				var city models.City
+				q := db.Joins("JOIN states ON states.id = cities.state_id").
+					Where("states.abbrev = ?", abbrev).First(&city)
+				Ω(q.Error).ShouldNot(HaveOccurred(), abbrev)
+				visit(city.Name, abbrev)
+			}
+		}
+
+		status, body := doAuthRequest("GET",
+			fmt.Sprintf("%s/user/%d/achievements", ts.URL, user.ID), "", "", "")
+		Ω(status).Should(Equal(200))
+		var out struct {
+			Data []AchievementResponse
+		}
+		Ω(json.Unmarshal(body, &out)).Should(Succeed())
+		earned := []string{}
+		for _, a := range out.Data {
+			if a.Earned {
+				earned = append(earned, a.Key)
+			}
+		}
+		Ω(earned).Should(ConsistOf("first-visit", "two-states", "grand-tour",
+			"first-capitals", "last-frontier", "whirlwind"))
+	})
+})
@@ -1,13 +1,19 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/bobisme/RestApiProject/cache"
 	"github.com/bobisme/RestApiProject/conf"
 	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/bobisme/RestApiProject/validate"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
+	"github.com/mattn/go-sqlite3"
 )
 
 const defaultLimit = 100
@@ -15,14 +21,84 @@ const maxLimit = 1000
 
 // VisitRequest is the struct for posting visit data
 type VisitRequest struct {
-	City  string `json:"city"`
-	State string `json:"state"`
+	City  string `json:"city" validate:"required,max=100"`
+	State string `json:"state" validate:"required,state"`
+	// Visibility overrides the user's default visibility
+	Visibility string `json:"visibility" validate:"oneof=public followers private"`
 }
 
 func jsonError(c *gin.Context, message string, err error) {
-	c.JSON(http.StatusBadRequest, []map[string]map[string]string{
-		{"error": {"message": message, "detail": err.Error()}},
-	})
+	jsonErrorStatus(c, http.StatusBadRequest, message, err)
+}
+
+// jsonErrorStatus is jsonError with a status other than 400
+func jsonErrorStatus(c *gin.Context, status int, message string, err error) {
+	c.JSON(status, errorBody(message, err))
+}
+
+// jsonFieldErrors responds with an error for each field that was invalid
+func jsonFieldErrors(c *gin.Context, errs validate.Errors) {
+	c.JSON(http.StatusBadRequest, fieldErrorBody(errs))
+}
+
+// errorBody is the JSON for an error response
+func errorBody(message string, err error) []map[string]map[string]string {
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	return []map[string]map[string]string{
+		{"error": {"message": message, "detail": detail}},
+	}
+}
+
+// fieldErrorBody is the JSON for an error response with an error for each
+// field that was invalid
+func fieldErrorBody(errs validate.Errors) []map[string]map[string]string {
+	body := make([]map[string]map[string]string, len(errs))
+	for i, e := range errs {
+		body[i] = map[string]map[string]string{"error": {
+			"message": "invalid " + e.Field, "detail": e.Message, "field": e.Field,
+		}}
+	}
+	return body
+}
+
+// bindJSON reads the request body into req and checks it against its
+// validate tags
+// sends a json error response and returns false if it can't
+func bindJSON(c *gin.Context, req interface{}) bool {
+	if err := c.BindJSON(req); err != nil {
+		jsonError(c, "could not understand your data", err)
+		return false
+	}
+	if errs := validate.Struct(req); errs != nil {
+		jsonFieldErrors(c, errs)
+		return false
+	}
+	return true
+}
+
+// getPathID reads the id in the path param
+// sends a json error response and returns false if it isn't one
+func getPathID(c *gin.Context, name string) (uint, bool) {
+	id, errs := validate.ID(name, c.Param(name))
+	if errs != nil {
+		jsonFieldErrors(c, errs)
+		return 0, false
+	}
+	return id, true
+}
+
+// getQueryID reads the id in the query param
+// sends a json error response and returns false if it isn't one
+func getQueryID(c *gin.Context, name string) (uint, bool) {
+	id, errs := validate.ID(name, c.Query(name))
+	if errs != nil {
+		jsonFieldErrors(c, errs)
+		return 0, false
+	}
+	return id, true
 }
 
 func getLimitOffset(c *gin.Context) (uint, uint) {
@@ -52,21 +128,26 @@ func getLimitOffset(c *gin.Context) (uint, uint) {
 	return uint(limit), uint(offset)
 }
 
-func getStateCitiesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+func getStateCitiesHandler(
+	cfg *conf.Config, db *gorm.DB, store cache.Cache,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		stateID, err := strconv.Atoi(c.Param("stateID"))
+		stateID, ok := getPathID(c, "stateID")
+		if !ok {
+			return
+		}
+		state, err := getStateCities(store, cacheTTL(cfg), db, stateID)
 		if err != nil {
-			jsonError(c, "Could not get id for state", err)
+			jsonError(c, "error looking up cities", err)
+			return
+		}
+		if notModified(c, state.version()) {
 			return
 		}
-		var cities []models.City
 		limit, offset := getLimitOffset(c)
-		var count int
-		q := db.Model(&models.City{}).Where("state_id = ?", stateID)
-		q.Count(&count)
-		q.Limit(limit).Offset(offset).Find(&cities)
+		start, end := pageBounds(len(state.Cities), limit, offset)
 		c.JSON(http.StatusOK, &MetaResponse{
-			limit, offset, uint(count), cities,
+			limit, offset, uint(len(state.Cities)), state.Cities[start:end],
 		})
 	}
 }
@@ -74,12 +155,21 @@ func getStateCitiesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
 // parse and verify that the user exists
 // sends a json error response and returns 0 if invalid
 func getUser(c *gin.Context, db *gorm.DB) *models.User {
-	userID, err := strconv.Atoi(c.Param("userID"))
-	if err != nil {
-		jsonError(c, "could not parse user id", err)
+	return getUserParam(c, db, "userID")
+}
+
+// getUserParam is getUser for a user id in any path param
+func getUserParam(c *gin.Context, db *gorm.DB, param string) *models.User {
+	userID, ok := getPathID(c, param)
+	if !ok {
 		return nil
 	}
+	return lookupUser(c, db, userID)
+}
 
+// lookupUser sends a json error response and returns nil if the user
+// doesn't exist
+func lookupUser(c *gin.Context, db *gorm.DB, userID uint) *models.User {
 	user := &models.User{}
 	q := db.Where("id = ?", userID).First(user)
 	if err := q.Error; err != nil {
@@ -92,74 +182,179 @@ func getUser(c *gin.Context, db *gorm.DB) *models.User {
 	return user
 }
 
-func getNewVisitHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var req VisitRequest
-		err := c.BindJSON(&req)
-		if err != nil {
-			jsonError(c, "could not understand your data", err)
+// lookupCity by name and state abbreviation
+// sends a json error response and returns nil if it doesn't exist
+func lookupCity(c *gin.Context, db *gorm.DB, name, abbrev string) *models.City {
+	var state models.State
+	var city models.City
+	q := db.Where("abbrev = ?", abbrev).First(&state)
+	if err := q.Error; err != nil {
+		jsonError(c, "error looking up state", err)
+		return nil
+	} else if q.RecordNotFound() {
+		jsonError(c, "state not found", nil)
+		return nil
+	}
+	q = db.Where("name = ? AND state_id = ?", name, state.ID).First(&city)
+	if err := q.Error; err != nil {
+		jsonError(c, "error looking up city", err)
+		return nil
+	} else if q.RecordNotFound() {
+		jsonError(c, "city not found", nil)
+		return nil
+	}
+	return &city
+}
+
+// saveVisit inserts the visit and puts it in followers' feeds
+func saveVisit(tx *gorm.DB, cfg *conf.Config, v *models.Visit) error {
+	if err := tx.Create(v).Error; err != nil {
+		return err
+	}
+	if cfg.MaterializeFeed {
+		return fanOutVisit(tx, v)
+	}
+	return nil
+}
+
+// repeatVisit finds the user's latest visit to the city within the dedup
+// window, or returns nil if the policy allows repeats or there isn't one
+func repeatVisit(tx *gorm.DB, cfg *conf.Config, v *models.Visit) (*models.Visit, error) {
+	if cfg.VisitDedup != conf.DedupReject && cfg.VisitDedup != conf.DedupMerge {
+		return nil, nil
+	}
+	cityID := v.CityID
+	if cityID == 0 {
+		cityID = v.City.ID
+	}
+	q := tx.Where("user_id = ? AND city_id = ?", v.UserID, cityID)
+	if cfg.VisitDedupWindowSeconds > 0 {
+		window := time.Duration(cfg.VisitDedupWindowSeconds) * time.Second
+		q = q.Where("created_at >= ?", time.Now().Add(-window))
+	}
+	var prior models.Visit
+	q = q.Order("created_at DESC, id DESC").First(&prior)
+	if q.RecordNotFound() {
+		return nil, nil
+	}
+	return &prior, q.Error
+}
+
+// createVisit saves a visit to the city, putting it in followers' feeds and
+// publishing it, and responds with it. extra is run in the same
+// transaction. A repeat visit is turned away with a 409 and the earlier
+// visit, or with the DedupMerge policy the earlier visit is the response,
+// unchanged and not published again, and extra is still run. The earlier
+// visit is only sent back if the caller is allowed to see it.
+// sends a json error response if it can't
+func createVisit(
+	c *gin.Context, cfg *conf.Config, db *gorm.DB, publish publisher,
+	user *models.User, city *models.City, visibility string,
+	extra func(tx *gorm.DB) error,
+) {
+	audience := getPathAudience(c, db, user)
+	if audience == "" {
+		return
+	}
+	v := models.Visit{UserID: user.ID, City: *city, Visibility: visibility}
+	tx := db.Begin()
+	prior, err := repeatVisit(tx, cfg, &v)
+	if err != nil {
+		tx.Rollback()
+		jsonError(c, "error looking up earlier visits", err)
+		return
+	}
+	if prior != nil && cfg.VisitDedup == conf.DedupReject {
+		tx.Rollback()
+		if visibleVisit(audience, user, prior) == nil {
+			jsonErrorStatus(c, http.StatusConflict, "city was already visited", nil)
 			return
 		}
-		var state models.State
-		var city models.City
-		user := getUser(c, db)
-		if user == nil {
+		c.JSON(http.StatusConflict, prior)
+		return
+	}
+	if prior == nil {
+		if err := saveVisit(tx, cfg, &v); err != nil {
+			tx.Rollback()
+			jsonError(c, "error saving visit", err)
+			return
+		}
+	}
+	if extra != nil {
+		if err := extra(tx); err != nil {
+			tx.Rollback()
+			jsonError(c, "error saving visit", err)
 			return
 		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		jsonError(c, "error saving visit", err)
+		return
+	}
+	if prior != nil {
+		if visibleVisit(audience, user, prior) == nil {
+			c.Status(http.StatusOK)
+			return
+		}
+		c.JSON(http.StatusOK, prior)
+		return
+	}
+	publish(stream.Event{
+		Type: stream.VisitCreated, UserID: user.ID, Data: &v,
+		Visibility: visitVisibility(user, &v),
+	})
+	c.JSON(http.StatusCreated, &v)
+}
 
-		if req.City != "" && req.State != "" {
-			q := db.Where("abbrev = ?", req.State).First(&state)
-			if err := q.Error; err != nil {
-				jsonError(c, "error looking up state", err)
-				return
-			} else if q.RecordNotFound() {
-				jsonError(c, "state not found", nil)
-				return
-			}
-			q = db.Where(
-				"name = ? AND state_id = ?", req.City, state.ID).First(&city)
-			if err := q.Error; err != nil {
-				jsonError(c, "error looking up city", err)
-				return
-			} else if q.RecordNotFound() {
-				jsonError(c, "city not found", nil)
-				return
-			}
-			v := models.Visit{UserID: user.ID, City: city}
-			if err := db.Create(&v).Error; err != nil {
-				jsonError(c, "error saving visit", err)
-				return
-			}
-			c.JSON(http.StatusCreated, &v)
+func getNewVisitHandler(
+	cfg *conf.Config, db *gorm.DB, publish publisher,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
 			return
 		}
+		var req VisitRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		city := lookupCity(c, db, req.City, req.State)
+		if city == nil {
+			return
+		}
+		createVisit(c, cfg, db, publish, user, city, req.Visibility, nil)
 	}
 }
 
-func getDeleteVisitHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+func getDeleteVisitHandler(
+	cfg *conf.Config, db *gorm.DB, publish publisher,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user := getUser(c, db)
+		user := getActor(c, db)
 		if user == nil {
 			return
 		}
-		visitID, err := strconv.Atoi(c.Param("visitID"))
-		if err != nil {
-			jsonError(c, "could not parse visit id", err)
+		visitID, ok := getPathID(c, "visitID")
+		if !ok {
 			return
 		}
 		var visit models.Visit
-		q := db.Where("id = ?", visitID).First(&visit)
-		if err := q.Error; err != nil {
-			jsonError(c, "error looking up visit", err)
+		q := db.Where("id = ? AND user_id = ?", visitID, user.ID).First(&visit)
+		if q.RecordNotFound() {
+			jsonErrorStatus(c, http.StatusNotFound, "visit not found", nil)
 			return
-		} else if q.RecordNotFound() {
-			jsonError(c, "visit not found", nil)
+		} else if err := q.Error; err != nil {
+			jsonError(c, "error looking up visit", err)
 			return
 		}
 		if err := db.Delete(&visit).Error; err != nil {
 			jsonError(c, "error removing visit", err)
 			return
 		}
+		publish(stream.Event{
+			Type: stream.VisitDeleted, UserID: user.ID, Data: &visit,
+			Visibility: visitVisibility(user, &visit),
+		})
 		c.Status(http.StatusNoContent)
 	}
 }
@@ -172,68 +367,218 @@ type MetaResponse struct {
 	Data   interface{} `json:"data"`
 }
 
-func getVisitedCitiesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+// pageBounds are the start and end of the page in a list of n things
+// that were worked out in memory rather than paged by the database
+func pageBounds(n int, limit, offset uint) (int, int) {
+	start := int(offset)
+	if start > n {
+		start = n
+	}
+	end := start + int(limit)
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+func getVisitedCitiesHandler(
+	cfg *conf.Config, db *gorm.DB, store cache.Cache,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := getUser(c, db)
 		if user == nil {
 			return
 		}
-		var cities []models.City
-		limit, offset := getLimitOffset(c)
-		queryBase := `
-			FROM cities
-			WHERE cities.id IN (
-				SELECT DISTINCT city_id
-				FROM visits
-				WHERE user_id = ?
-			)
-		`
-		var count int
-		q := db.Raw(`SELECT COUNT(*) `+queryBase, user.ID).Count(&count)
-		if err := q.Error; err != nil {
-			jsonError(c, "error counting cities", err)
-			return
-		}
-		// not the most efficient method, but easiest to implement
-		q = db.Raw(
-			`SELECT cities.* `+queryBase+` LIMIT ? OFFSET ?`,
-			user.ID, limit, offset).Scan(&cities)
-		if err := q.Error; err != nil {
-			jsonError(c, "error looking up cities", err)
+		audience := getPathAudience(c, db, user)
+		if audience == "" || visitsNotModified(c, db, user, audience) {
 			return
 		}
-		c.JSON(http.StatusOK, &MetaResponse{
-			limit, offset, uint(count), cities,
+		limit, offset := getLimitOffset(c)
+		key := userCacheKey(store, user.ID, "visited-cities", audience,
+			fmt.Sprint(limit), fmt.Sprint(offset))
+		serveCached(c, store, key, cacheTTL(cfg), func() {
+			listVisitedCities(c, db, user, audience)
 		})
 	}
 }
 
-func getVisitedStatesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+// visitsVersion is the version of the user's visits and default
+// visibility, which together decide what each audience sees of them
+func visitsVersion(db *gorm.DB, user *models.User) (resourceVersion, error) {
+	version, err := rowsVersion(db, "visits", "user_id = ?", user.ID)
+	return version.and(resourceVersion{modified: user.UpdatedAt}), err
+}
+
+// visitsNotModified checks the request's conditional headers against the
+// user's visits, responding 304 if they haven't changed
+// sends a json error response and returns true if it can't check
+func visitsNotModified(
+	c *gin.Context, db *gorm.DB, user *models.User, audience string,
+) bool {
+	version, err := visitsVersion(db, user)
+	if err != nil {
+		jsonError(c, "error looking up visits", err)
+		return true
+	}
+	return notModified(c, version, audience)
+}
+
+// listVisitedCities responds with the cities the user has visited that the
+// audience is allowed to see
+func listVisitedCities(
+	c *gin.Context, db *gorm.DB, user *models.User, audience string,
+) {
+	var cities []models.City
+	limit, offset := getLimitOffset(c)
+	visible, visibleArgs := visibleClause(user, audience)
+	queryBase := `
+		FROM cities
+		WHERE cities.id IN (
+			SELECT DISTINCT city_id
+			FROM visits
+			WHERE user_id = ? AND deleted_at IS NULL AND ` + visible + `
+		)
+	`
+	args := append([]interface{}{user.ID}, visibleArgs...)
+	var count int
+	q := db.Raw(`SELECT COUNT(*) `+queryBase, args...).Count(&count)
+	if err := q.Error; err != nil {
+		jsonError(c, "error counting cities", err)
+		return
+	}
+	// not the most efficient method, but easiest to implement
+	q = db.Raw(
+		`SELECT cities.* `+queryBase+` LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...).Scan(&cities)
+	if err := q.Error; err != nil {
+		jsonError(c, "error looking up cities", err)
+		return
+	}
+	c.JSON(http.StatusOK, &MetaResponse{
+		limit, offset, uint(count), cities,
+	})
+}
+
+// VisitedState is a state the user has visited along with their progress
+// through its cities
+type VisitedState struct {
+	models.State
+	CitiesVisited   uint      `json:"citiesVisited"`
+	CitiesTotal     uint      `json:"citiesTotal"`
+	PercentComplete float64   `json:"percentComplete"`
+	FirstVisit      time.Time `json:"firstVisit"`
+	LastVisit       time.Time `json:"lastVisit"`
+}
+
+// sqlite only knows the column type of plain columns, so aggregates over
+// DATETIME columns come back as strings and need to be parsed by hand
+func parseDBTime(value string) (time.Time, error) {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse time: %q", value)
+}
+
+func getVisitedStatesHandler(
+	cfg *conf.Config, db *gorm.DB, store cache.Cache,
+) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := getUser(c, db)
 		if user == nil {
 			return
 		}
-		var states []models.State
-		q := db.Raw(`
-			SELECT *
-			FROM states
-			WHERE id IN (
-				SELECT cities.state_id
-				FROM cities
-				LEFT JOIN visits ON cities.id = visits.city_id
-				WHERE visits.user_id = ?
-				GROUP BY cities.state_id
-			)
-		`, user.ID).Scan(&states)
-		if err := q.Error; err != nil {
-			jsonError(c, "error looking up states", err)
+		audience := getPathAudience(c, db, user)
+		if audience == "" || visitsNotModified(c, db, user, audience) {
 			return
 		}
-		c.JSON(http.StatusOK, &states)
+		limit, offset := getLimitOffset(c)
+		key := userCacheKey(store, user.ID, "visited-states", audience,
+			fmt.Sprint(limit), fmt.Sprint(offset))
+		serveCached(c, store, key, cacheTTL(cfg), func() {
+			listVisitedStates(c, db, user, audience)
+		})
 	}
 }
 
+// listVisitedStates responds with the states the user has visited that the
+// audience is allowed to see
+func listVisitedStates(
+	c *gin.Context, db *gorm.DB, user *models.User, audience string,
+) {
+	limit, offset := getLimitOffset(c)
+	visible, visibleArgs := visibleClause(user, audience)
+	queryBase := `
+		FROM states
+		JOIN cities ON cities.state_id = states.id
+		JOIN visits ON visits.city_id = cities.id
+		WHERE visits.user_id = ? AND visits.deleted_at IS NULL
+			AND ` + visible + `
+	`
+	args := append([]interface{}{user.ID}, visibleArgs...)
+	var count int
+	q := db.Raw(`SELECT COUNT(DISTINCT states.id) `+queryBase, args...).
+		Count(&count)
+	if err := q.Error; err != nil {
+		jsonError(c, "error counting states", err)
+		return
+	}
+	rows, err := db.Raw(`
+		SELECT states.id, states.name, states.abbrev,
+			COUNT(DISTINCT cities.id),
+			(
+				SELECT COUNT(*)
+				FROM cities AS all_cities
+				WHERE all_cities.state_id = states.id
+					AND all_cities.deleted_at IS NULL
+			),
+			MIN(visits.created_at),
+			MAX(visits.created_at)
+		`+queryBase+`
+		GROUP BY states.id
+		ORDER BY states.id
+		LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...).Rows()
+	if err != nil {
+		jsonError(c, "error looking up states", err)
+		return
+	}
+	defer rows.Close()
+	states := []VisitedState{}
+	for rows.Next() {
+		var s VisitedState
+		var first, last string
+		err := rows.Scan(
+			&s.ID, &s.Name, &s.Abbrev, &s.CitiesVisited, &s.CitiesTotal,
+			&first, &last)
+		if err != nil {
+			jsonError(c, "error reading states", err)
+			return
+		}
+		if s.FirstVisit, err = parseDBTime(first); err != nil {
+			jsonError(c, "error reading first visit", err)
+			return
+		}
+		if s.LastVisit, err = parseDBTime(last); err != nil {
+			jsonError(c, "error reading last visit", err)
+			return
+		}
+		if s.CitiesTotal > 0 {
+			s.PercentComplete =
+				100 * float64(s.CitiesVisited) / float64(s.CitiesTotal)
+		}
+		states = append(states, s)
+	}
+	if err := rows.Err(); err != nil {
+		jsonError(c, "error reading states", err)
+		return
+	}
+	c.JSON(http.StatusOK, &MetaResponse{
+		limit, offset, uint(count), states,
+	})
+}
+
 // GetRouter for the API Server router
 func GetRouter(cfg *conf.Config, db *gorm.DB) *gin.Engine {
 	// create a default router with logger and recovery
@@ -242,14 +587,72 @@ func GetRouter(cfg *conf.Config, db *gorm.DB) *gin.Engine {
 	return r
 }
 
-// SetRoutes for the API Server router
+// SetRoutes for the API Server router. The app's background work isn't
+// started, so leaderboards are never rebuilt. Use an App directly, with
+// Start and Close, for that and to be able to shut it down cleanly.
 func SetRoutes(cfg *conf.Config, db *gorm.DB, r *gin.Engine) {
+	NewApp(cfg, db).SetRoutes(r)
+}
+
+// SetRoutes for the API Server router. Call Start too, or leaderboards are
+// never built.
+func (a *App) SetRoutes(r *gin.Engine) {
+	cfg, db := a.cfg, a.db
+	// before any routes so that they apply to all of them. Limits come
+	// first so that replayed requests count too.
+	r.Use(rateLimit(cfg, db, a.limiter))
+	r.Use(idempotent(db, time.Duration(cfg.IdempotencyKeySeconds)*time.Second))
 	r.GET("/", func(c *gin.Context) {
 		c.String(http.StatusOK, "HELLO")
 	})
-	r.GET("/state/:stateID/cities", getStateCitiesHandler(cfg, db))
-	r.POST("/user/:userID/visits", getNewVisitHandler(cfg, db))
-	r.DELETE("/user/:userID/visits/:visitID", getDeleteVisitHandler(cfg, db))
-	r.GET("/user/:userID/visits/states", getVisitedStatesHandler(cfg, db))
-	r.GET("/user/:userID/visits", getVisitedCitiesHandler(cfg, db))
+	r.GET("/openapi.json", getOpenAPIHandler(r))
+	r.GET("/docs", getDocsHandler())
+	// anyone can look, but what they see depends on who they are
+	viewer := optionalAuth(db)
+	auth := requireAuth(db)
+	r.GET("/state/:stateID/cities",
+		getStateCitiesHandler(cfg, db, a.cache))
+	r.GET("/cities/near", getNearbyCitiesHandler(cfg, db))
+	r.POST("/user/:userID/visits", auth, getNewVisitHandler(cfg, db, a.publish))
+	r.POST("/user/:userID/visits/batch", auth,
+		getBatchVisitsHandler(cfg, db, a.states, a.publish))
+	r.DELETE("/user/:userID/visits/:visitID", auth,
+		getDeleteVisitHandler(cfg, db, a.publish))
+	r.GET("/user/:userID/visits/states", viewer,
+		getVisitedStatesHandler(cfg, db, a.cache))
+	r.GET("/user/:userID/visits", viewer, getVisitedCitiesHandler(cfg, db, a.cache))
+	r.GET("/user/:userID/visits/map.svg", viewer,
+		getVisitMapHandler(cfg, db, a.states, "svg"))
+	r.GET("/user/:userID/visits/map.png", viewer,
+		getVisitMapHandler(cfg, db, a.states, "png"))
+	r.GET("/user/:userID/stats", viewer,
+		getUserStatsHandler(cfg, db, a.cache))
+	r.GET("/user/:userID/achievements", viewer,
+		getAchievementsHandler(cfg, db, a.rules))
+	r.GET("/user/:userID/feed", auth, getFeedHandler(cfg, db))
+	r.GET("/leaderboards/:metric", viewer,
+		getLeaderboardHandler(cfg, db, a.board))
+	r.POST("/routes/optimize", viewer, getOptimizeRouteHandler(cfg, db))
+	r.GET("/reverse", getReverseHandler(cfg, db, a.states))
+	r.GET("/map/clusters", viewer,
+		getClustersHandler(cfg, db, a.cache, a.cityClusters, a.visitClusters))
+	r.GET("/tiles/:layer/:z/:x/:y", viewer,
+		getTileHandler(cfg, db, a.cache, a.tiles, a.states))
+	setFollowRoutes(cfg, db, r)
+	setTripRoutes(cfg, db, r)
+	setWishlistRoutes(cfg, db, r, a.publish)
+
+	r.PUT("/user/:userID/visibility", auth,
+		getSetUserVisibilityHandler(cfg, db, a.publish))
+	r.PUT("/user/:userID/visits/:visitID/visibility", auth,
+		getSetVisitVisibilityHandler(cfg, db, a.publish))
+	r.POST("/user/:userID/share-links", auth, getNewShareLinkHandler(cfg, db))
+	r.GET("/user/:userID/share-links", auth, getShareLinksHandler(cfg, db))
+	r.DELETE("/user/:userID/share-links/:linkID", auth,
+		getRevokeShareLinkHandler(cfg, db))
+	r.GET("/shared/:token/visits/states", getSharedStatesHandler(cfg, db))
+	r.GET("/shared/:token/visits", getSharedCitiesHandler(cfg, db))
+
+	r.GET("/stream", viewer, getStreamHandler(cfg, db, a.hub))
+	setWebhookRoutes(cfg, db, r)
 }
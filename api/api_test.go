@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -22,10 +23,28 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var marchFirst = time.Date(2015, time.Month(3), 1, 0, 0, 0, 0, time.UTC)
 
+// the real rules, since tests run from the package directory
+const testAchievementsPath = "../data/achievements.toml"
+
+// made up outlines for the made up states
+const testStateBoundariesPath = "testdata/states.geojson"
+
+// johnPassword lets specs log in as the user in the test data. Its hash is
+// made once, as cheaply as possible, since every spec loads the data.
+const johnPassword = "ghost"
+
+var johnPasswordHash, _ = bcrypt.GenerateFromPassword(
+	[]byte(johnPassword), bcrypt.MinCost)
+
+// testLogins are the email and password of each user in the test database,
+// by id
+var testLogins = map[uint][2]string{}
+
 func loadTestData(filename string) {
 	check := func(err error) {
 		if err != nil {
@@ -49,33 +68,36 @@ func loadTestData(filename string) {
 	// city
 	_, err = db.Exec(
 		`INSERT INTO cities (name, state_id, lat, lon,
-			lat_sin, lat_cos, lon_sin, lon_cos, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			lat_sin, lat_cos, lon_sin, lon_cos, geohash, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		"Winterfell", 1, 35.2271, -80.8431,
-		0.57681874832, 0.81687216354, -0.9872562543, 0.15913858219,
+		0.57681874832, 0.81687216354, -0.9872562543, 0.15913858219, "dnq82kgue",
 		marchFirst, marchFirst)
 	check(err)
 	_, err = db.Exec(
 		`INSERT INTO cities (name, state_id, lat, lon,
-			lat_sin, lat_cos, lon_sin, lon_cos, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			lat_sin, lat_cos, lon_sin, lon_cos, geohash, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		"Kings Landing", 1, 32.7765, -79.9311, 0.54136, 0.840789, -0.984598, 0.17483,
-		marchFirst, marchFirst)
+		"djz4mngby", marchFirst, marchFirst)
 	check(err)
 	_, err = db.Exec(
 		`INSERT INTO cities (name, state_id, lat, lon,
-			lat_sin, lat_cos, lon_sin, lon_cos, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			lat_sin, lat_cos, lon_sin, lon_cos, geohash, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		"Qarth", 2, 26.8206, 30.8025, 0.45120, 0.892424, 0.51208, 0.858938,
-		marchFirst, marchFirst)
+		"ssvbsft9q", marchFirst, marchFirst)
 	check(err)
 	_, err = db.Exec(
 		`INSERT INTO users (
 			first_name, last_name, email, password_hash, created_at, updated_at)
 		VALUES (?, ?, ?, ?, ?, ?)`,
-		"John", "Snow", "john@northernbastards.net", "HASHNOTTESTEDHERE",
+		"John", "Snow", "john@northernbastards.net", johnPasswordHash,
 		marchFirst, marchFirst)
 	check(err)
+	testLogins = map[uint][2]string{
+		1: {"john@northernbastards.net", johnPassword},
+	}
 }
 
 func getRespBody(resp *http.Response) []byte {
@@ -85,6 +107,114 @@ func getRespBody(resp *http.Response) []byte {
 	return body
 }
 
+// start a server on a fresh test database for specs outside the main suite
+func startTestServer() (*gorm.DB, *httptest.Server) {
+	return startTestServerWith(nil)
+}
+
+// startTestServerWith lets the spec change the config first
+func startTestServerWith(configure func(*conf.Config)) (*gorm.DB, *httptest.Server) {
+	db, r := newTestRouter(configure)
+	return db, httptest.NewServer(r)
+}
+
+// newTestRouter sets up the test database and routes without serving them
+func newTestRouter(configure func(*conf.Config)) (*gorm.DB, *gin.Engine) {
+	cfg := conf.Default()
+	cfg.DBPath = "test-rest-api.db"
+	cfg.AchievementsPath = testAchievementsPath
+	cfg.StateBoundariesPath = testStateBoundariesPath
+	if configure != nil {
+		configure(cfg)
+	}
+	cmd.CreateDb(cfg.DBPath, true)
+	loadTestData(cfg.DBPath)
+	db, err := gorm.Open("sqlite3", cfg.DBPath)
+	Ω(err).ShouldNot(HaveOccurred())
+	r := gin.New()
+	SetRoutes(cfg, db, r)
+	return db, r
+}
+
+func stopTestServer(db *gorm.DB, ts *httptest.Server) {
+	ts.Close()
+	db.Close()
+	os.Remove("test-rest-api.db")
+}
+
+// post each visit as the user and return the created visits
+func postVisits(ts *httptest.Server, userID uint, visits ...string) []models.Visit {
+	created := []models.Visit{}
+	url := ts.URL + "/user/" + strconv.Itoa(int(userID)) + "/visits"
+	for _, data := range visits {
+		resp, err := postAs(userID, url, "application/json", strings.NewReader(data))
+		Ω(err).ShouldNot(HaveOccurred())
+		body := getRespBody(resp)
+		Ω(resp.StatusCode).Should(Equal(201), string(body))
+		var visit models.Visit
+		json.Unmarshal(body, &visit)
+		created = append(created, visit)
+	}
+	return created
+}
+
+// get the url and decode the json response, returning the status code
+func getTestJSON(ts *httptest.Server, url string, out interface{}) int {
+	resp, err := http.Get(ts.URL + url)
+	Ω(err).ShouldNot(HaveOccurred())
+	json.Unmarshal(getRespBody(resp), out)
+	return resp.StatusCode
+}
+
+// create a user who can log in with the password
+func createTestUser(db *gorm.DB, firstName, email, password string) models.User {
+	user := models.User{FirstName: firstName, Email: email}
+	Ω(db.Create(&user).Error).ShouldNot(HaveOccurred())
+	Ω(models.SetPassword(db, &user, password)).Should(Succeed())
+	testLogins[user.ID] = [2]string{email, password}
+	return user
+}
+
+// doAs sends the request logged in as the user with the id
+func doAs(userID uint, req *http.Request) (*http.Response, error) {
+	login := testLogins[userID]
+	req.SetBasicAuth(login[0], login[1])
+	return http.DefaultClient.Do(req)
+}
+
+// postAs is http.Post logged in as the user with the id
+func postAs(userID uint, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return doAs(userID, req)
+}
+
+// make a request with basic auth and return the status code and body
+func doAuthRequest(
+	method, url, email, password, body string,
+) (int, []byte) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	Ω(err).ShouldNot(HaveOccurred())
+	if email != "" {
+		req.SetBasicAuth(email, password)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	Ω(err).ShouldNot(HaveOccurred())
+	return resp.StatusCode, getRespBody(resp)
+}
+
+// doUserRequest is doAuthRequest logged in as the user with the id
+func doUserRequest(method, url string, userID uint, body string) (int, []byte) {
+	login := testLogins[userID]
+	return doAuthRequest(method, url, login[0], login[1], body)
+}
+
 var _ = Describe("Api", func() {
 	gin.SetMode(gin.ReleaseMode)
 
@@ -114,6 +244,8 @@ var _ = Describe("Api", func() {
 		var err error
 		cfg = conf.Default()
 		cfg.DBPath = "test-rest-api.db"
+		cfg.AchievementsPath = testAchievementsPath
+		cfg.StateBoundariesPath = testStateBoundariesPath
 		cmd.CreateDb("test-rest-api.db", true)
 		loadTestData("test-rest-api.db")
 		db, err = gorm.Open("sqlite3", "test-rest-api.db")
@@ -194,7 +326,7 @@ var _ = Describe("Api", func() {
 				"city": "Winterfell",
 				"state": "WS"
 			}`)
-			resp, err := http.Post(
+			resp, err := postAs(1,
 				ts.URL+`/user/1/visits`, "application/json", req)
 			Ω(err).ShouldNot(HaveOccurred())
 			// body := getRespBody(resp)
@@ -211,7 +343,7 @@ var _ = Describe("Api", func() {
 				"city": "Kings Landing",
 				"state": "WS"
 			}`)
-			resp2, err := http.Post(
+			resp2, err := postAs(1,
 				ts.URL+`/user/1/visits`, "application/json", req2)
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(resp2.StatusCode).Should(Equal(201))
@@ -222,7 +354,7 @@ var _ = Describe("Api", func() {
 				"city": "Kings Landing",
 				"state": "WS"
 			}`)
-			resp3, err := http.Post(
+			resp3, err := postAs(1,
 				ts.URL+`/user/1/visits`, "application/json", req3)
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(resp3.StatusCode).Should(Equal(201))
@@ -234,7 +366,7 @@ var _ = Describe("Api", func() {
 			func(url string) {
 				reqData := `{ "city": "Winterfell", "state": "WS" }`
 				req := strings.NewReader(reqData)
-				resp, err := http.Post(ts.URL+url, "application/json", req)
+				resp, err := postAs(1, ts.URL+url, "application/json", req)
 				Ω(err).ShouldNot(HaveOccurred())
 				Ω(resp.StatusCode).Should(Equal(400))
 			},
@@ -247,7 +379,7 @@ var _ = Describe("Api", func() {
 		DescribeTable("fails on invalid city",
 			func(reqData string) {
 				req := strings.NewReader(reqData)
-				resp, err := http.Post(
+				resp, err := postAs(1,
 					ts.URL+`/user/1/visits`, "application/json", req)
 				Ω(err).ShouldNot(HaveOccurred())
 				Ω(resp.StatusCode).Should(Equal(400))
@@ -259,10 +391,22 @@ var _ = Describe("Api", func() {
 		It("fails on invalid state", func() {
 			reqData := `{ "city": "Winterfall", "state": "XS" }`
 			req := strings.NewReader(reqData)
-			resp, err := http.Post(ts.URL+`/user/1/visits`, "application/json", req)
+			resp, err := postAs(1, ts.URL+`/user/1/visits`, "application/json", req)
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(resp.StatusCode).Should(Equal(400))
 		})
+
+		It("only lets users record their own visits", func() {
+			data := `{ "city": "Winterfell", "state": "WS" }`
+			status, _ := doAuthRequest("POST", ts.URL+"/user/1/visits", "", "", data)
+			Ω(status).Should(Equal(401))
+			createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+			status, _ = doUserRequest("POST", ts.URL+"/user/1/visits", 2, data)
+			Ω(status).Should(Equal(403))
+			var visitCount int
+			db.Model(&models.Visit{}).Count(&visitCount)
+			Ω(visitCount).Should(Equal(0))
+		})
 	})
 
 	Context("delete visit", func() {
@@ -276,7 +420,7 @@ var _ = Describe("Api", func() {
 			}
 			for _, data := range visits {
 				req := strings.NewReader(data)
-				resp, _ := http.Post(
+				resp, _ := postAs(1,
 					ts.URL+`/user/1/visits`, "application/json", req)
 				body := getRespBody(resp)
 				var visit models.Visit
@@ -293,7 +437,7 @@ var _ = Describe("Api", func() {
 			id := strconv.Itoa(int(ids[1]))
 			req, err := http.NewRequest("DELETE", ts.URL+`/user/1/visits/`+id, nil)
 			Ω(err).ShouldNot(HaveOccurred())
-			resp, err := http.DefaultClient.Do(req)
+			resp, err := doAs(1, req)
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(resp.StatusCode).Should(Equal(204))
 			db.Model(&models.Visit{}).Count(&visitCount)
@@ -303,7 +447,7 @@ var _ = Describe("Api", func() {
 		DescribeTable("fails on invalid user",
 			func(url string) {
 				req, _ := http.NewRequest("DELETE", ts.URL+url, nil)
-				resp, _ := http.DefaultClient.Do(req)
+				resp, _ := doAs(1, req)
 				Ω(resp.StatusCode).Should(Equal(400))
 			},
 			Entry("0", `/user/0/visits/2`),
@@ -315,13 +459,39 @@ var _ = Describe("Api", func() {
 		DescribeTable("fails on invalid visit",
 			func(url string) {
 				req, _ := http.NewRequest("DELETE", ts.URL+url, nil)
-				resp, _ := http.DefaultClient.Do(req)
+				resp, _ := doAs(1, req)
 				Ω(resp.StatusCode).Should(Equal(400))
 			},
 			Entry("0", `/user/1/visits/0`),
-			Entry("non-existant", `/user/1/visits/20`),
 			Entry("not a number", `/user/1/visits/NO`),
 		)
+
+		It("only finds the user's own visits", func() {
+			createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+			other := postVisits(ts, 2, `{ "city": "Qarth", "state": "ES" }`)
+			for _, id := range []uint{20, other[0].ID} {
+				req, _ := http.NewRequest("DELETE",
+					ts.URL+"/user/1/visits/"+strconv.Itoa(int(id)), nil)
+				resp, err := doAs(1, req)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(resp.StatusCode).Should(Equal(404))
+			}
+			var visitCount int
+			db.Model(&models.Visit{}).Where("user_id = 2").Count(&visitCount)
+			Ω(visitCount).Should(Equal(1))
+		})
+
+		It("only lets users remove their own visits", func() {
+			url := ts.URL + "/user/1/visits/" + strconv.Itoa(int(ids[0]))
+			status, _ := doAuthRequest("DELETE", url, "", "", "")
+			Ω(status).Should(Equal(401))
+			createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+			status, _ = doUserRequest("DELETE", url, 2, "")
+			Ω(status).Should(Equal(403))
+			var visitCount int
+			db.Model(&models.Visit{}).Count(&visitCount)
+			Ω(visitCount).Should(Equal(3))
+		})
 	})
 	Context("cities visited", func() {
 		BeforeEach(func() {
@@ -336,7 +506,7 @@ var _ = Describe("Api", func() {
 			}
 			for _, data := range visits {
 				req := strings.NewReader(data)
-				resp, _ := http.Post(
+				resp, _ := postAs(1,
 					ts.URL+`/user/1/visits`, "application/json", req)
 				body := getRespBody(resp)
 				var visit models.Visit
@@ -402,7 +572,7 @@ var _ = Describe("Api", func() {
 			}
 			for _, data := range visits {
 				req := strings.NewReader(data)
-				resp, _ := http.Post(
+				resp, _ := postAs(1,
 					ts.URL+`/user/1/visits`, "application/json", req)
 				body := getRespBody(resp)
 				var visit models.Visit
@@ -410,13 +580,58 @@ var _ = Describe("Api", func() {
 			}
 		})
 		It("should be ok", func() {
-			var out []models.State
+			var out struct {
+				Limit, Offset, Count int
+				Data                 []VisitedState
+			}
 			body := get("/user/1/visits/states")
 			json.Unmarshal(body, &out)
-			Ω(len(out)).Should(Equal(2))
-			Ω(out[0].Name).Should(Equal("Westeros"))
-			Ω(out[0].Abbrev).Should(Equal("WS"))
-			Ω(out[0].ID).Should(Equal(uint(1)))
+			Ω(len(out.Data)).Should(Equal(2))
+			Ω(out.Limit).Should(Equal(100))
+			Ω(out.Offset).Should(Equal(0))
+			Ω(out.Count).Should(Equal(2))
+			Ω(out.Data[0].Name).Should(Equal("Westeros"))
+			Ω(out.Data[0].Abbrev).Should(Equal("WS"))
+			Ω(out.Data[0].ID).Should(Equal(uint(1)))
+		})
+
+		It("includes progress through each state", func() {
+			var out struct {
+				Data []VisitedState
+			}
+			getJSON("/user/1/visits/states", &out)
+			Ω(out.Data[0].CitiesVisited).Should(Equal(uint(2)))
+			Ω(out.Data[0].CitiesTotal).Should(Equal(uint(2)))
+			Ω(out.Data[0].PercentComplete).Should(BeNumerically("~", 100))
+			Ω(out.Data[1].CitiesVisited).Should(Equal(uint(1)))
+			Ω(out.Data[1].CitiesTotal).Should(Equal(uint(1)))
+			Ω(out.Data[0].FirstVisit).ShouldNot(BeZero())
+			Ω(out.Data[0].LastVisit).ShouldNot(
+				BeTemporally("<", out.Data[0].FirstVisit))
+		})
+
+		It("accepts limit and offset", func() {
+			var out struct {
+				Limit, Offset, Count int
+				Data                 []VisitedState
+			}
+			getJSON("/user/1/visits/states?limit=1&offset=1", &out)
+			Ω(len(out.Data)).Should(Equal(1))
+			Ω(out.Limit).Should(Equal(1))
+			Ω(out.Offset).Should(Equal(1))
+			Ω(out.Count).Should(Equal(2))
+			Ω(out.Data[0].Abbrev).Should(Equal("ES"))
+		})
+
+		It("ignores removed visits", func() {
+			db.Where("city_id = ?", 3).Delete(&models.Visit{})
+			var out struct {
+				Count int
+				Data  []VisitedState
+			}
+			getJSON("/user/1/visits/states", &out)
+			Ω(out.Count).Should(Equal(1))
+			Ω(out.Data[0].Abbrev).Should(Equal("WS"))
 		})
 	})
 })
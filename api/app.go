@@ -0,0 +1,101 @@
+package api
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bobisme/RestApiProject/achievement"
+	"github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/leaderboard"
+	"github.com/bobisme/RestApiProject/ratelimit"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/bobisme/RestApiProject/webhook"
+	"github.com/jinzhu/gorm"
+)
+
+// publisher is given events whenever a user's data changes
+type publisher func(stream.Event)
+
+// App holds the state shared by the handlers for the life of the server
+type App struct {
+	cfg *conf.Config
+	db  *gorm.DB
+	// cache is for responses, and can be shared with other servers
+	cache cache.Cache
+	hub   *stream.Hub
+	rules []achievement.Rule
+	board *leaderboard.Board
+	// states finds which state a point is in
+	states *geo.RegionIndex
+	// cities don't change, so they are clustered once
+	cityClusters  *geo.ClusterIndex
+	visitClusters *lruCache
+	tiles         *lruCache
+	limiter       ratelimit.Limiter
+}
+
+// NewApp for the config and database
+func NewApp(cfg *conf.Config, db *gorm.DB) *App {
+	// the server is still useful without achievements
+	rules, err := achievement.LoadFile(cfg.AchievementsPath)
+	if err != nil {
+		log.Errorln("could not load achievements:", err)
+	}
+	// without boundaries, points just aren't in any state
+	regions, err := geo.LoadRegions(cfg.StateBoundariesPath, "abbrev")
+	if err != nil {
+		log.Errorln("could not load state boundaries:", err)
+	}
+	cityClusters, err := loadCityClusters(db)
+	if err != nil {
+		log.Errorln("could not cluster cities:", err)
+		cityClusters = geo.NewClusterIndex(nil, clusterMaxZoom)
+	}
+	return &App{
+		cfg:   cfg,
+		db:    db,
+		cache: newCache(cfg),
+		hub:   stream.NewHub(cfg.StreamBufferSize),
+		rules: rules,
+		board: leaderboard.NewBoard(db,
+			time.Duration(cfg.LeaderboardRefreshSeconds)*time.Second),
+		states: geo.NewRegionIndex(regions),
+
+		cityClusters:  cityClusters,
+		visitClusters: newLRUCache(clusterCacheSize),
+		tiles:         newLRUCache(cfg.TileCacheSize),
+		limiter:       ratelimit.NewMemory(),
+	}
+}
+
+// publish lets everything that depends on a user's data know it changed
+func (a *App) publish(e stream.Event) {
+	// stamped once so streams and webhooks agree on it
+	e.Time = time.Now()
+	// clusters and tiles are tagged with the user's generation too
+	invalidateUser(a.cache, e.UserID)
+	a.hub.Publish(e)
+	// only new visits can earn anything
+	if e.Type == stream.VisitCreated {
+		if err := awardAchievements(a.db, a.rules, e.UserID); err != nil {
+			log.Errorln("could not award achievements:", err)
+		}
+	}
+	if err := webhook.Enqueue(a.db, e); err != nil {
+		log.Errorln("could not queue webhook deliveries:", err)
+	}
+}
+
+// Start the work done in the background, like rebuilding leaderboards
+func (a *App) Start() {
+	a.board.Start()
+}
+
+// Close ends all streams and stops the background work. Call it before
+// shutting down the http server.
+func (a *App) Close() {
+	a.hub.Close()
+	a.board.Stop()
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	authUserKey   = "authUser"
+	authResultKey = "authResult"
+)
+
+var (
+	errAuthRequired       = errors.New("authentication required")
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// authenticate checks HTTP basic auth credentials (email and password).
+// Returns nil and an http status if they aren't valid.
+func authenticate(c *gin.Context, db *gorm.DB) (*models.User, int, error) {
+	email, password, ok := c.Request.BasicAuth()
+	if !ok || email == "" {
+		return nil, http.StatusUnauthorized, errAuthRequired
+	}
+	var user models.User
+	q := db.Where("email = ?", email).First(&user)
+	if q.RecordNotFound() {
+		return nil, http.StatusUnauthorized, errInvalidCredentials
+	} else if err := q.Error; err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if models.CheckPassword(db, &user, password) != nil {
+		return nil, http.StatusUnauthorized, errInvalidCredentials
+	}
+	return &user, http.StatusOK, nil
+}
+
+type authResult struct {
+	user   *models.User
+	status int
+	err    error
+}
+
+// authenticateOnce is authenticate that remembers the answer for the rest
+// of the request, since the rate limiter needs it before the route does
+func authenticateOnce(c *gin.Context, db *gorm.DB) (*models.User, int, error) {
+	if r, ok := c.Get(authResultKey); ok {
+		result := r.(*authResult)
+		return result.user, result.status, result.err
+	}
+	user, status, err := authenticate(c, db)
+	c.Set(authResultKey, &authResult{user, status, err})
+	return user, status, err
+}
+
+// requireAuth stores the authenticated user in the context. Requests
+// without valid credentials are aborted with a 401.
+func requireAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, status, err := authenticateOnce(c, db)
+		if err != nil {
+			abortAuth(c, status, err)
+			return
+		}
+		c.Set(authUserKey, user)
+		c.Next()
+	}
+}
+
+// optionalAuth is requireAuth for routes that anonymous users can see
+// too. Bad credentials are still rejected.
+func optionalAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, status, err := authenticateOnce(c, db)
+		if err == errAuthRequired {
+			c.Next()
+			return
+		} else if err != nil {
+			abortAuth(c, status, err)
+			return
+		}
+		c.Set(authUserKey, user)
+		c.Next()
+	}
+}
+
+func abortAuth(c *gin.Context, status int, err error) {
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Basic realm="api"`)
+		jsonErrorStatus(c, status, err.Error(), nil)
+	} else {
+		jsonErrorStatus(c, status, "error looking up user", err)
+	}
+	c.Abort()
+}
+
+// authUser returns the user authenticated by requireAuth, or nil
+func authUser(c *gin.Context) *models.User {
+	if user, ok := c.Get(authUserKey); ok {
+		return user.(*models.User)
+	}
+	return nil
+}
+
+// getActor is getUser for changes a user makes to their own data. The
+// authenticated user has to be the user in the path.
+// sends a json error response and returns nil if not
+func getActor(c *gin.Context, db *gorm.DB) *models.User {
+	user := getUser(c, db)
+	if user == nil {
+		return nil
+	}
+	if actor := authUser(c); actor == nil || actor.ID != user.ID {
+		jsonErrorStatus(c, http.StatusForbidden,
+			"you may only make changes as yourself", nil)
+		return nil
+	}
+	return user
+}
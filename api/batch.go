@@ -0,0 +1,261 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// batch modes
+const (
+	// every operation is applied or none are
+	batchAtomic = "atomic"
+	// the operations that work are applied and the rest are skipped
+	batchPartial = "partial"
+)
+
+// BatchRequest is the struct for creating and removing many visits at once,
+// like the ones an app queued up while it was offline
+type BatchRequest struct {
+	// Mode is "atomic", the default, or "partial"
+	Mode       string           `json:"mode" validate:"oneof=atomic partial"`
+	Operations []BatchOperation `json:"operations" validate:"required"`
+}
+
+// BatchOperation creates a visit to a city, going by its name and state or
+// by where the user was, or removes one of the user's visits
+type BatchOperation struct {
+	// Op is "create" or "delete"
+	Op    string `json:"op" validate:"required,oneof=create delete"`
+	City  string `json:"city" validate:"max=100"`
+	State string `json:"state" validate:"state"`
+	// Lat and Lon are snapped to the nearest city
+	Lat        *float64 `json:"lat" validate:"lat"`
+	Lon        *float64 `json:"lon" validate:"lon"`
+	Visibility string   `json:"visibility" validate:"oneof=public followers private"`
+	// VisitID is the visit to remove
+	VisitID uint `json:"visitId"`
+}
+
+// Validate that the operation says what to create or remove
+func (op BatchOperation) Validate() validate.Errors {
+	if op.Op == "delete" {
+		return validate.Var("visitId", op.VisitID, "required")
+	}
+	if op.Lat != nil || op.Lon != nil {
+		if op.Lat == nil || op.Lon == nil {
+			return validate.Errors{{
+				Field: "lat", Rule: "coords",
+				Message: "lat and lon are needed together",
+			}}
+		}
+		return nil
+	}
+	errs := validate.Var("city", op.City, "required")
+	return append(errs, validate.Var("state", op.State, "required")...)
+}
+
+// BatchResult is how one operation in a batch went
+type BatchResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	// Status is what the operation would have responded with by itself
+	Status int `json:"status"`
+	// Visit that was created or removed, or the earlier visit a repeat one
+	// was merged into or turned away for
+	Visit *models.Visit `json:"visit,omitempty"`
+	// Errors say why the operation failed, like an error response would
+	Errors []map[string]map[string]string `json:"errors,omitempty"`
+}
+
+// BatchResponse has a result for each operation that was tried. An atomic
+// batch stops at the first operation that fails.
+type BatchResponse struct {
+	Mode string `json:"mode"`
+	// Committed is false if nothing was saved
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// applyBatchOperation makes the operation's change in the transaction
+func applyBatchOperation(
+	tx *gorm.DB, cfg *conf.Config, states *geo.RegionIndex,
+	user *models.User, op BatchOperation,
+) BatchResult {
+	result := BatchResult{Op: op.Op, Status: http.StatusBadRequest}
+	fail := func(status int, message string, err error) BatchResult {
+		result.Status = status
+		result.Errors = errorBody(message, err)
+		return result
+	}
+	if errs := validate.Struct(op); errs != nil {
+		result.Errors = fieldErrorBody(errs)
+		return result
+	}
+
+	if op.Op == "delete" {
+		var visit models.Visit
+		q := tx.Where("id = ? AND user_id = ?", op.VisitID, user.ID).First(&visit)
+		if q.RecordNotFound() {
+			return fail(http.StatusNotFound, "visit not found", nil)
+		} else if q.Error != nil {
+			return fail(http.StatusBadRequest, "error looking up visit", q.Error)
+		}
+		if err := tx.Delete(&visit).Error; err != nil {
+			return fail(http.StatusBadRequest, "error removing visit", err)
+		}
+		result.Status, result.Visit = http.StatusNoContent, &visit
+		return result
+	}
+
+	visit := models.Visit{UserID: user.ID, Visibility: op.Visibility}
+	if op.Lat != nil {
+		p := geo.Point{Lat: *op.Lat, Lon: *op.Lon}
+		_, city, err := reverseGeocode(tx, states, p)
+		if err != nil {
+			return fail(http.StatusBadRequest, "error looking up city", err)
+		} else if city == nil {
+			return fail(http.StatusNotFound, "city not found", nil)
+		}
+		visit.City = *city
+		visit.Lat, visit.Lon = p.Lat, p.Lon
+		visit.LatSin, visit.LatCos, visit.LonSin, visit.LonCos =
+			geo.LatLonSinCos(p.Lat, p.Lon)
+		visit.VisitMethod = "coords"
+	} else {
+		q := tx.Select("cities.*").
+			Joins("JOIN states ON states.id = cities.state_id").
+			Where("cities.name = ? AND states.abbrev = ?", op.City, op.State).
+			First(&visit.City)
+		if q.RecordNotFound() {
+			return fail(http.StatusNotFound, "city not found", nil)
+		} else if q.Error != nil {
+			return fail(http.StatusBadRequest, "error looking up city", q.Error)
+		}
+	}
+	prior, err := repeatVisit(tx, cfg, &visit)
+	if err != nil {
+		return fail(http.StatusBadRequest, "error looking up earlier visits", err)
+	} else if prior != nil && cfg.VisitDedup == conf.DedupReject {
+		result = fail(http.StatusConflict, "city was already visited", nil)
+		result.Visit = prior
+		return result
+	} else if prior != nil {
+		result.Status, result.Visit = http.StatusOK, prior
+		return result
+	}
+	if err := saveVisit(tx, cfg, &visit); err != nil {
+		return fail(http.StatusBadRequest, "error saving visit", err)
+	}
+	result.Status, result.Visit = http.StatusCreated, &visit
+	return result
+}
+
+// hideBatchVisits takes out the visits in the results that the audience
+// isn't allowed to see
+func hideBatchVisits(results []BatchResult, audience string, user *models.User) {
+	for i := range results {
+		results[i].Visit = visibleVisit(audience, user, results[i].Visit)
+	}
+}
+
+// getBatchVisitsHandler creates and removes the user's visits in one
+// transaction. Atomic batches are rolled back if any operation fails, with
+// the failed operation's status. Partial batches roll back just the
+// operations that fail, using a savepoint around each.
+func getBatchVisitsHandler(
+	cfg *conf.Config, db *gorm.DB, states *geo.RegionIndex, publish publisher,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return
+		}
+		var req BatchRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		limit := "max=" + strconv.Itoa(cfg.BatchMaxOperations)
+		if errs := validate.Var("operations", req.Operations, limit); errs != nil {
+			jsonFieldErrors(c, errs)
+			return
+		}
+		if req.Mode == "" {
+			req.Mode = batchAtomic
+		}
+		response := BatchResponse{
+			Mode: req.Mode, Results: make([]BatchResult, 0, len(req.Operations)),
+		}
+
+		tx := db.Begin()
+		exec := func(sql string) bool {
+			if err := tx.Exec(sql).Error; err != nil {
+				tx.Rollback()
+				jsonError(c, "error saving visits", err)
+				return false
+			}
+			return true
+		}
+		for i, op := range req.Operations {
+			if req.Mode == batchPartial && !exec("SAVEPOINT batch_operation") {
+				return
+			}
+			result := applyBatchOperation(tx, cfg, states, user, op)
+			result.Index = i
+			response.Results = append(response.Results, result)
+			if result.Errors != nil && req.Mode == batchAtomic {
+				tx.Rollback()
+				// the visits that were saved are gone again
+				for i := range response.Results {
+					if response.Results[i].Errors == nil {
+						response.Results[i].Visit = nil
+					}
+				}
+				hideBatchVisits(response.Results, audience, user)
+				c.JSON(result.Status, &response)
+				return
+			}
+			if result.Errors != nil && !exec("ROLLBACK TO SAVEPOINT batch_operation") {
+				return
+			}
+			if req.Mode == batchPartial && !exec("RELEASE SAVEPOINT batch_operation") {
+				return
+			}
+		}
+		if err := tx.Commit().Error; err != nil {
+			jsonError(c, "error saving visits", err)
+			return
+		}
+		response.Committed = true
+
+		for _, result := range response.Results {
+			var event string
+			switch result.Status {
+			case http.StatusCreated:
+				event = stream.VisitCreated
+			case http.StatusNoContent:
+				event = stream.VisitDeleted
+			default:
+				// failed, or merged into an earlier visit
+				continue
+			}
+			publish(stream.Event{
+				Type: event, UserID: user.ID, Data: result.Visit,
+				Visibility: visitVisibility(user, result.Visit),
+			})
+		}
+		hideBatchVisits(response.Results, audience, user)
+		c.JSON(http.StatusOK, &response)
+	}
+}
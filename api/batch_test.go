@@ -0,0 +1,142 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch visits", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	// batch as Arya
+	batch := func(userID uint, body string) (int, BatchResponse) {
+		status, data := doAuthRequest("POST",
+			ts.URL+"/user/"+strconv.Itoa(int(userID))+"/visits/batch",
+			"arya@winterfell.net", "needle", body)
+		var out BatchResponse
+		Ω(json.Unmarshal(data, &out)).Should(Succeed(), string(data))
+		return status, out
+	}
+	statuses := func(out BatchResponse) []int {
+		s := make([]int, len(out.Results))
+		for i, result := range out.Results {
+			s[i] = result.Status
+		}
+		return s
+	}
+	visitCount := func(userID uint) int {
+		var count int
+		db.Model(&models.Visit{}).Where("user_id = ?", userID).Count(&count)
+		return count
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServerWith(func(cfg *conf.Config) {
+			cfg.BatchMaxOperations = 4
+		})
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("creates by city or coordinates and deletes in one go", func() {
+		old := postVisits(ts, 2, `{"city": "Kings Landing", "state": "WS"}`)
+		status, out := batch(2, `{"operations": [
+			{"op": "create", "city": "Winterfell", "state": "WS"},
+			{"op": "create", "lat": 26.9, "lon": 30.7, "visibility": "private"},
+			{"op": "delete", "visitId": `+strconv.Itoa(int(old[0].ID))+`}
+		]}`)
+		Ω(status).Should(Equal(200))
+		Ω(out.Mode).Should(Equal("atomic"))
+		Ω(out.Committed).Should(BeTrue())
+		Ω(statuses(out)).Should(Equal([]int{201, 201, 204}))
+		Ω(out.Results[0].Visit.CityID).Should(Equal(uint(1)))
+		Ω(out.Results[1].Visit.CityID).Should(Equal(uint(3)))
+		Ω(out.Results[1].Visit.VisitMethod).Should(Equal("coords"))
+		Ω(out.Results[1].Visit.Lat).Should(BeNumerically("~", 26.9))
+		Ω(out.Results[2].Visit.ID).Should(Equal(old[0].ID))
+		Ω(visitCount(2)).Should(Equal(2))
+	})
+
+	It("saves nothing when an atomic operation fails", func() {
+		status, out := batch(2, `{"operations": [
+			{"op": "create", "city": "Winterfell", "state": "WS"},
+			{"op": "create", "city": "Braavos", "state": "ES"},
+			{"op": "create", "city": "Qarth", "state": "ES"}
+		]}`)
+		Ω(status).Should(Equal(404))
+		Ω(out.Committed).Should(BeFalse())
+		Ω(statuses(out)).Should(Equal([]int{201, 404}))
+		Ω(out.Results[0].Visit).Should(BeNil())
+		Ω(out.Results[1].Errors[0]["error"]["message"]).Should(Equal("city not found"))
+		Ω(visitCount(2)).Should(Equal(0))
+	})
+
+	It("saves what it can in partial mode", func() {
+		theirs := postVisits(ts, 3, `{"city": "Qarth", "state": "ES"}`)
+		status, out := batch(2, `{"mode": "partial", "operations": [
+			{"op": "create", "city": "Winterfell", "state": "WS"},
+			{"op": "create", "city": "Winterfell", "state": "ws"},
+			{"op": "delete", "visitId": `+strconv.Itoa(int(theirs[0].ID))+`},
+			{"op": "create", "city": "Qarth", "state": "ES"}
+		]}`)
+		Ω(status).Should(Equal(200))
+		Ω(out.Committed).Should(BeTrue())
+		Ω(statuses(out)).Should(Equal([]int{201, 400, 404, 201}))
+		Ω(out.Results[1].Errors[0]["error"]["field"]).Should(Equal("state"))
+		Ω(visitCount(2)).Should(Equal(2))
+		Ω(visitCount(3)).Should(Equal(1))
+	})
+
+	It("rejects operations that don't say what to do", func() {
+		_, out := batch(2, `{"mode": "partial", "operations": [
+			{"op": "create"},
+			{"op": "create", "lat": 26.9},
+			{"op": "delete"},
+			{"op": "visit", "city": "Qarth", "state": "ES"}
+		]}`)
+		Ω(statuses(out)).Should(Equal([]int{400, 400, 400, 400}))
+		Ω(out.Results[0].Errors).Should(HaveLen(2))
+		Ω(out.Results[1].Errors[0]["error"]["field"]).Should(Equal("lat"))
+		Ω(out.Results[2].Errors[0]["error"]["field"]).Should(Equal("visitId"))
+		Ω(out.Results[3].Errors[0]["error"]["field"]).Should(Equal("op"))
+	})
+
+	It("limits how many operations there can be", func() {
+		op := `{"op": "create", "city": "Qarth", "state": "ES"}`
+		status, body := doAuthRequest("POST", ts.URL+"/user/2/visits/batch",
+			"arya@winterfell.net", "needle",
+			`{"operations": [`+strings.Repeat(op+",", 4)+op+`]}`)
+		Ω(status).Should(Equal(400))
+		Ω(string(body)).Should(ContainSubstring(`"field":"operations"`))
+		Ω(visitCount(2)).Should(Equal(0))
+	})
+
+	It("only changes the authenticated user's visits", func() {
+		body := `{"operations": [{"op": "create", "city": "Qarth", "state": "ES"}]}`
+		status, _ := doAuthRequest("POST", ts.URL+"/user/2/visits/batch",
+			"", "", body)
+		Ω(status).Should(Equal(401))
+		status, _ = doAuthRequest("POST", ts.URL+"/user/2/visits/batch",
+			"sansa@winterfell.net", "lemoncakes", body)
+		Ω(status).Should(Equal(403))
+		Ω(visitCount(2)).Should(Equal(0))
+		Ω(visitCount(3)).Should(Equal(0))
+	})
+})
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/gin-gonic/gin"
+)
+
+// newCache for the configured backend
+func newCache(cfg *conf.Config) cache.Cache {
+	if cfg.CacheBackend != conf.CacheRedis {
+		return cache.NewMemory(cfg.CacheSize)
+	}
+	redis := cache.NewRedis(cfg.RedisAddr, cfg.RedisPassword)
+	// requests are still answered without the cache, so carry on
+	if err := redis.Ping(); err != nil {
+		log.Errorln("could not reach redis:", err)
+	}
+	return redis
+}
+
+// cacheGet treats errors as misses, since the answer can always be worked
+// out again
+func cacheGet(store cache.Cache, key string) ([]byte, bool) {
+	value, ok, err := store.Get(key)
+	if err != nil {
+		log.Warnln("could not read from cache:", err)
+		return nil, false
+	}
+	return value, ok
+}
+
+func cacheSet(store cache.Cache, key string, value []byte, ttl time.Duration) {
+	if err := store.Set(key, value, ttl); err != nil {
+		log.Warnln("could not write to cache:", err)
+	}
+}
+
+// the generation key holds a random token that's part of the key of
+// everything cached about the user. Deleting it invalidates all of those at
+// once, on every server sharing the cache, without having to find them.
+func userGenerationKey(userID uint) string {
+	return fmt.Sprintf("user:%d:gen", userID)
+}
+
+// userGeneration is the user's current generation token, or blank if the
+// cache can't be used. Anything kept elsewhere about the user can be tagged
+// with it too, and is stale once it changes.
+func userGeneration(store cache.Cache, userID uint) string {
+	genKey := userGenerationKey(userID)
+	gen, ok := cacheGet(store, genKey)
+	if !ok {
+		b := make([]byte, 8)
+		rand.Read(b)
+		gen = []byte(hex.EncodeToString(b))
+		// losing it only means everything is looked up again
+		if err := store.Set(genKey, gen, 0); err != nil {
+			log.Warnln("could not write to cache:", err)
+			return ""
+		}
+	}
+	return string(gen)
+}
+
+// userCacheKey is the key for something cached about the user, or blank if
+// the cache can't be used
+func userCacheKey(store cache.Cache, userID uint, parts ...string) string {
+	gen := userGeneration(store, userID)
+	if gen == "" {
+		return ""
+	}
+	return fmt.Sprintf("user:%d:%s:%s", userID, gen, strings.Join(parts, ":"))
+}
+
+// invalidateUser drops everything cached about the user
+func invalidateUser(store cache.Cache, userID uint) {
+	if err := store.Delete(userGenerationKey(userID)); err != nil {
+		log.Errorln("could not invalidate cache:", err)
+	}
+}
+
+// serveCached responds with the json cached under the key, or else calls
+// respond and caches what it sends if it's a 200. A blank key skips the
+// cache.
+func serveCached(
+	c *gin.Context, store cache.Cache, key string, ttl time.Duration,
+	respond func(),
+) {
+	if key == "" {
+		respond()
+		return
+	}
+	if body, ok := cacheGet(store, key); ok {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+		return
+	}
+	w := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = w
+	respond()
+	c.Writer = w.ResponseWriter
+	if w.Status() == http.StatusOK {
+		cacheSet(store, key, w.body.Bytes(), ttl)
+	}
+}
+
+// cacheTTL is how long responses are cached for
+func cacheTTL(cfg *conf.Config) time.Duration {
+	return time.Duration(cfg.CacheTTLSeconds) * time.Second
+}
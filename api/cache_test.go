@@ -0,0 +1,172 @@
+package api_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/cache/redistest"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Caching", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	type visitedCities struct {
+		Count int `json:"count"`
+		Data  []struct {
+			Name string `json:"name"`
+		} `json:"data"`
+	}
+	type stats struct {
+		TotalVisits int `json:"totalVisits"`
+	}
+	cityNames := func(ts *httptest.Server) []string {
+		var out visitedCities
+		Ω(getTestJSON(ts, "/user/2/visits", &out)).Should(Equal(200))
+		names := []string{}
+		for _, city := range out.Data {
+			names = append(names, city.Name)
+		}
+		return names
+	}
+	totalVisits := func(ts *httptest.Server) int {
+		var out stats
+		Ω(getTestJSON(ts, "/user/2/stats", &out)).Should(Equal(200))
+		return out.TotalVisits
+	}
+
+	Context("in memory", func() {
+		BeforeEach(func() {
+			db, ts = startTestServer()
+			createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		})
+
+		AfterEach(func() {
+			stopTestServer(db, ts)
+		})
+
+		It("keeps visited cities until the user's visits change", func() {
+			postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+			Ω(cityNames(ts)).Should(Equal([]string{"Winterfell"}))
+			Ω(db.Exec("UPDATE cities SET name = 'Moat Cailin' WHERE id = 1").Error).
+				Should(Succeed())
+			Ω(cityNames(ts)).Should(Equal([]string{"Winterfell"}))
+
+			postVisits(ts, 2, `{"city": "Qarth", "state": "ES"}`)
+			Ω(cityNames(ts)).Should(ConsistOf("Moat Cailin", "Qarth"))
+		})
+
+		It("keeps each page separately", func() {
+			postVisits(ts, 2,
+				`{"city": "Winterfell", "state": "WS"}`,
+				`{"city": "Qarth", "state": "ES"}`)
+			var first, second visitedCities
+			getTestJSON(ts, "/user/2/visits?limit=1", &first)
+			getTestJSON(ts, "/user/2/visits?limit=1&offset=1", &second)
+			Ω(first.Data).Should(HaveLen(1))
+			Ω(second.Data).Should(HaveLen(1))
+			Ω(first.Data[0].Name).ShouldNot(Equal(second.Data[0].Name))
+		})
+
+		It("drops stats and visited states when a visit is removed", func() {
+			visits := postVisits(ts, 2, `{"city": "Qarth", "state": "ES"}`)
+			Ω(totalVisits(ts)).Should(Equal(1))
+			var states visitedCities
+			getTestJSON(ts, "/user/2/visits/states", &states)
+			Ω(states.Count).Should(Equal(1))
+
+			status, _ := doAuthRequest("DELETE",
+				ts.URL+"/user/2/visits/"+strconv.Itoa(int(visits[0].ID)),
+				"arya@winterfell.net", "needle", "")
+			Ω(status).Should(Equal(204))
+			Ω(totalVisits(ts)).Should(Equal(0))
+			getTestJSON(ts, "/user/2/visits/states", &states)
+			Ω(states.Count).Should(Equal(0))
+		})
+	})
+
+	Context("in redis", func() {
+		var (
+			server *redistest.Server
+			cfg    *conf.Config
+		)
+
+		BeforeEach(func() {
+			server = redistest.NewServer("")
+			db, ts = startTestServerWith(func(c *conf.Config) {
+				c.CacheBackend = conf.CacheRedis
+				c.RedisAddr = server.Addr
+				cfg = c
+			})
+			createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		})
+
+		AfterEach(func() {
+			stopTestServer(db, ts)
+			server.Close()
+		})
+
+		It("is shared between servers", func() {
+			r := gin.New()
+			SetRoutes(cfg, db, r)
+			other := httptest.NewServer(r)
+			defer other.Close()
+
+			Ω(totalVisits(other)).Should(Equal(0))
+			Ω(cityNames(other)).Should(BeEmpty())
+			Ω(server.Keys()).ShouldNot(BeZero())
+
+			postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+			Ω(totalVisits(other)).Should(Equal(1))
+			Ω(cityNames(other)).Should(Equal([]string{"Winterfell"}))
+
+			Ω(db.Exec("UPDATE cities SET name = 'Moat Cailin' WHERE id = 1").Error).
+				Should(Succeed())
+			Ω(cityNames(ts)).Should(Equal([]string{"Winterfell"}))
+		})
+
+		It("drops visit clusters and tiles on every server", func() {
+			r := gin.New()
+			SetRoutes(cfg, db, r)
+			other := httptest.NewServer(r)
+			defer other.Close()
+
+			clusters := func() int {
+				var out ClustersResponse
+				Ω(getTestJSON(other, "/map/clusters?zoom=0&userId=2", &out)).
+					Should(Equal(200))
+				return len(out.Clusters)
+			}
+			tileFeatures := func() int {
+				status, body := doAuthRequest("GET",
+					other.URL+"/tiles/visits/0/0/0.mvt?userId=2", "", "", "")
+				Ω(status).Should(Equal(200))
+				return decodeTile(body)["visits"].features
+			}
+			Ω(clusters()).Should(Equal(0))
+			Ω(tileFeatures()).Should(Equal(0))
+
+			postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+			Ω(clusters()).Should(Equal(1))
+			Ω(tileFeatures()).Should(Equal(1))
+		})
+
+		It("still answers when redis is down", func() {
+			server.Close()
+			Ω(totalVisits(ts)).Should(Equal(0))
+			postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+			Ω(totalVisits(ts)).Should(Equal(1))
+			Ω(cityNames(ts)).Should(Equal([]string{"Winterfell"}))
+			server = redistest.NewServer("")
+		})
+	})
+})
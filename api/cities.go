@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+)
+
+// stateCities are all the cities in a state and their version, as they're
+// cached. Cities are only loaded by init-db, so they don't change while the
+// server runs.
+type stateCities struct {
+	Cities   []models.City `json:"cities"`
+	Modified time.Time     `json:"modified"`
+	Count    int           `json:"count"`
+}
+
+func (s *stateCities) version() resourceVersion {
+	return resourceVersion{modified: s.Modified, count: s.Count}
+}
+
+// getStateCities from the cache, looking them up if they aren't there
+func getStateCities(
+	store cache.Cache, ttl time.Duration, db *gorm.DB, stateID uint,
+) (*stateCities, error) {
+	key := fmt.Sprintf("state:%d:cities", stateID)
+	var state stateCities
+	if b, ok := cacheGet(store, key); ok && json.Unmarshal(b, &state) == nil {
+		return &state, nil
+	}
+	version, err := rowsVersion(db, "cities", "state_id = ?", stateID)
+	if err != nil {
+		return nil, err
+	}
+	state = stateCities{[]models.City{}, version.modified, version.count}
+	if err := db.Where("state_id = ?", stateID).Order("id").Find(&state.Cities).Error; err != nil {
+		return nil, err
+	}
+	// no cities probably means no state, and those aren't worth keeping
+	if len(state.Cities) == 0 {
+		return &state, nil
+	}
+	if b, err := json.Marshal(&state); err == nil {
+		cacheSet(store, key, b, ttl)
+	}
+	return &state, nil
+}
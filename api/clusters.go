@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// past this zoom clusters stop splitting up, since the points in a cell are
+// only a few meters apart
+const clusterMaxZoom = 16
+
+// ClustersResponse is the clusters to draw on a map
+type ClustersResponse struct {
+	Zoom     int           `json:"zoom"`
+	Clusters []geo.Cluster `json:"clusters"`
+}
+
+// how many sets of visit clusters are kept
+const clusterCacheSize = 1024
+
+// visit clusters differ depending on which visits the audience can see, and
+// are made for the user's cache generation
+type clusterKey struct {
+	userID   uint
+	audience string
+	gen      string
+}
+
+// scanClusterPoints reads rows of id, lat and lon
+func scanClusterPoints(q *gorm.DB) ([]geo.ClusterPoint, error) {
+	rows, err := q.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := []geo.ClusterPoint{}
+	for rows.Next() {
+		var p geo.ClusterPoint
+		if err := rows.Scan(&p.ID, &p.Lat, &p.Lon); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// loadCityClusters clusters every city
+func loadCityClusters(db *gorm.DB) (*geo.ClusterIndex, error) {
+	points, err := scanClusterPoints(db.Raw(`
+		SELECT id, lat, lon FROM cities WHERE deleted_at IS NULL`))
+	if err != nil {
+		return nil, err
+	}
+	return geo.NewClusterIndex(points, clusterMaxZoom), nil
+}
+
+// getVisitClusters clusters the user's visits the audience can see, where
+// they are by city
+func getVisitClusters(
+	db *gorm.DB, store cache.Cache, clusters *lruCache,
+	user *models.User, audience string,
+) (*geo.ClusterIndex, error) {
+	// read before the visits, so clusters made from visits that change
+	// part way through are already stale
+	key := clusterKey{user.ID, audience, userGeneration(store, user.ID)}
+	if key.gen != "" {
+		if idx, ok := clusters.get(key); ok {
+			return idx.(*geo.ClusterIndex), nil
+		}
+	}
+	visible, visibleArgs := visibleClause(user, audience)
+	points, err := scanClusterPoints(db.Raw(`
+		SELECT visits.id, cities.lat, cities.lon
+		FROM visits
+		JOIN cities ON cities.id = visits.city_id
+		WHERE visits.user_id = ? AND visits.deleted_at IS NULL AND `+visible,
+		append([]interface{}{user.ID}, visibleArgs...)...))
+	if err != nil {
+		return nil, err
+	}
+	idx := geo.NewClusterIndex(points, clusterMaxZoom)
+	if key.gen != "" {
+		clusters.set(key, idx)
+	}
+	return idx, nil
+}
+
+// parseBBox reads "minLon,minLat,maxLon,maxLat". The box goes across the
+// antimeridian if minLon is more than maxLon.
+func parseBBox(s string) (geo.BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return geo.BBox{}, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	var n [4]float64
+	for i, part := range parts {
+		var err error
+		if n[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+			return geo.BBox{}, err
+		}
+	}
+	box := geo.BBox{MinLon: n[0], MinLat: n[1], MaxLon: n[2], MaxLat: n[3]}
+	if box.MinLat < -90 || box.MaxLat > 90 || box.MinLat > box.MaxLat {
+		return geo.BBox{}, errors.New("bbox latitudes must go from -90 to 90")
+	}
+	if box.MinLon < -180 || box.MaxLon > 180 {
+		return geo.BBox{}, errors.New("bbox longitudes must be from -180 to 180")
+	}
+	return box, nil
+}
+
+// getClustersHandler clusters what's in the `bbox` for the `zoom`. It's
+// cities unless there's a `userId`, then it's the visits of that user the
+// viewer can see.
+func getClustersHandler(
+	cfg *conf.Config, db *gorm.DB, store cache.Cache,
+	cities *geo.ClusterIndex, visits *lruCache,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		box := geo.BBox{MinLat: -90, MinLon: -180, MaxLat: 90, MaxLon: 180}
+		if s := c.Query("bbox"); s != "" {
+			var err error
+			if box, err = parseBBox(s); err != nil {
+				jsonError(c, "invalid bbox", err)
+				return
+			}
+		}
+		zoom, errs := validate.Int("zoom", c.Query("zoom"), "min=0")
+		if errs != nil {
+			jsonFieldErrors(c, errs)
+			return
+		}
+
+		idx := cities
+		if c.Query("userId") != "" {
+			userID, ok := getQueryID(c, "userId")
+			if !ok {
+				return
+			}
+			user := lookupUser(c, db, userID)
+			if user == nil {
+				return
+			}
+			audience := getPathAudience(c, db, user)
+			if audience == "" {
+				return
+			}
+			var err error
+			if idx, err = getVisitClusters(db, store, visits, user, audience); err != nil {
+				jsonError(c, "error looking up visits", err)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, &ClustersResponse{zoom, idx.Clusters(box, zoom)})
+	}
+}
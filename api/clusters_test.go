@@ -0,0 +1,92 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Clusters", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	clusters := func(email, password, query string) ClustersResponse {
+		status, body := doAuthRequest(
+			"GET", ts.URL+"/map/clusters?"+query, email, password, "")
+		Ω(status).Should(Equal(200), string(body))
+		var res ClustersResponse
+		Ω(json.Unmarshal(body, &res)).Should(Succeed())
+		return res
+	}
+	counts := func(res ClustersResponse) []int {
+		n := []int{}
+		for _, c := range res.Clusters {
+			n = append(n, c.Count)
+		}
+		return n
+	}
+	visit := func(city, state, visibility string) {
+		status, body := doUserRequest("POST", ts.URL+"/user/2/visits", 2,
+			`{"city": "`+city+`", "state": "`+state+`", "visibility": "`+visibility+`"}`)
+		Ω(status).Should(Equal(201), string(body))
+	}
+
+	It("clusters cities by zoom", func() {
+		res := clusters("", "", "zoom=0")
+		Ω(res.Zoom).Should(Equal(0))
+		// Winterfell and Kings Landing together, then Qarth
+		Ω(counts(res)).Should(Equal([]int{2, 1}))
+		Ω(res.Clusters[1].ID).Should(Equal(uint(3)))
+		Ω(counts(clusters("", "", "zoom=10"))).Should(Equal([]int{1, 1, 1}))
+	})
+
+	It("only has clusters in the box", func() {
+		res := clusters("", "", "zoom=10&bbox=-85,30,-75,40")
+		Ω(counts(res)).Should(Equal([]int{1, 1}))
+		res = clusters("", "", "zoom=10&bbox=170,-10,-170,10")
+		Ω(res.Clusters).Should(BeEmpty())
+	})
+
+	It("clusters the visits the viewer can see", func() {
+		visit("Winterfell", "WS", "")
+		visit("Winterfell", "WS", "")
+		visit("Qarth", "ES", "private")
+		Ω(counts(clusters("", "", "zoom=10&userId=2"))).Should(Equal([]int{2}))
+		Ω(counts(clusters("arya@winterfell.net", "needle", "zoom=10&userId=2"))).
+			Should(Equal([]int{2, 1}))
+
+		// new visits show up
+		visit("Kings Landing", "WS", "")
+		Ω(counts(clusters("", "", "zoom=0&userId=2"))).Should(Equal([]int{3}))
+	})
+
+	DescribeTable("rejects bad requests",
+		func(query string) {
+			var out interface{}
+			Ω(getTestJSON(ts, "/map/clusters?"+query, &out)).Should(Equal(400))
+		},
+		Entry("no zoom", "bbox=-85,30,-75,40"),
+		Entry("negative zoom", "zoom=-1"),
+		Entry("short bbox", "zoom=1&bbox=-85,30,-75"),
+		Entry("upside down bbox", "zoom=1&bbox=-85,40,-75,30"),
+		Entry("bbox off the map", "zoom=1&bbox=-185,30,-75,40"),
+		Entry("bad user", "zoom=1&userId=99"),
+	)
+})
@@ -0,0 +1,168 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Repeat visits", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	start := func(policy string, windowSeconds int) {
+		db, ts = startTestServerWith(func(cfg *conf.Config) {
+			cfg.VisitDedup = policy
+			cfg.VisitDedupWindowSeconds = windowSeconds
+		})
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	}
+	visit := func(body string) (int, models.Visit) {
+		status, data := doUserRequest("POST", ts.URL+"/user/2/visits", 2, body)
+		var v models.Visit
+		Ω(json.Unmarshal(data, &v)).Should(Succeed(), string(data))
+		return status, v
+	}
+	visitCount := func() int {
+		var count int
+		db.Model(&models.Visit{}).Count(&count)
+		return count
+	}
+	age := func(v models.Visit, d time.Duration) {
+		Ω(db.Model(&v).UpdateColumn("created_at", time.Now().Add(-d)).Error).
+			Should(Succeed())
+	}
+	winterfell := `{"city": "Winterfell", "state": "WS"}`
+	qarth := `{"city": "Qarth", "state": "ES"}`
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("allows repeats by default", func() {
+		start(conf.DedupAllow, 3600)
+		status, _ := visit(winterfell)
+		Ω(status).Should(Equal(201))
+		status, _ = visit(winterfell)
+		Ω(status).Should(Equal(201))
+		Ω(visitCount()).Should(Equal(2))
+	})
+
+	Describe("rejecting", func() {
+		BeforeEach(func() { start(conf.DedupReject, 3600) })
+
+		It("turns away repeats within the window with the earlier visit", func() {
+			_, first := visit(winterfell)
+			status, v := visit(winterfell)
+			Ω(status).Should(Equal(409))
+			Ω(v.ID).Should(Equal(first.ID))
+			status, _ = visit(qarth)
+			Ω(status).Should(Equal(201))
+			Ω(visitCount()).Should(Equal(2))
+		})
+
+		It("shows owners their earlier private visits", func() {
+			_, first := visit(winterfell)
+			Ω(db.Model(&first).Update("visibility", models.VisibilityPrivate).Error).
+				Should(Succeed())
+			status, v := visit(winterfell)
+			Ω(status).Should(Equal(409))
+			Ω(v.ID).Should(Equal(first.ID))
+			Ω(visitCount()).Should(Equal(1))
+		})
+
+		It("allows repeats after the window", func() {
+			_, first := visit(winterfell)
+			age(first, 2*time.Hour)
+			status, v := visit(winterfell)
+			Ω(status).Should(Equal(201))
+			Ω(v.ID).ShouldNot(Equal(first.ID))
+		})
+
+		It("rejects repeats in batches", func() {
+			_, body := doAuthRequest("POST", ts.URL+"/user/2/visits/batch",
+				"arya@winterfell.net", "needle", `{"mode": "partial", "operations": [
+					{"op": "create", "city": "Winterfell", "state": "WS"},
+					{"op": "create", "city": "Winterfell", "state": "WS"}
+				]}`)
+			var out BatchResponse
+			Ω(json.Unmarshal(body, &out)).Should(Succeed())
+			Ω(out.Results[0].Status).Should(Equal(201))
+			Ω(out.Results[1].Status).Should(Equal(409))
+			Ω(out.Results[1].Visit.ID).Should(Equal(out.Results[0].Visit.ID))
+			Ω(visitCount()).Should(Equal(1))
+		})
+	})
+
+	Describe("merging", func() {
+		It("returns the earlier visit instead of saving a new one", func() {
+			start(conf.DedupMerge, 3600)
+			_, first := visit(winterfell)
+			status, v := visit(winterfell)
+			Ω(status).Should(Equal(200))
+			Ω(v.ID).Should(Equal(first.ID))
+			Ω(visitCount()).Should(Equal(1))
+		})
+
+		It("leaves the earlier visit as it was and publishes nothing", func() {
+			start(conf.DedupMerge, 3600)
+			Ω(db.Create(&models.Webhook{
+				UserID: 2, URL: "https://example.com/hook", Secret: "shh",
+				EventTypes: []string{stream.VisitCreated},
+			}).Error).Should(Succeed())
+			_, first := visit(winterfell)
+			status, v := visit(`{"city": "Winterfell", "state": "WS", "visibility": "private"}`)
+			Ω(status).Should(Equal(200))
+			Ω(v.ID).Should(Equal(first.ID))
+			Ω(v.Visibility).Should(Equal(first.Visibility))
+			var saved models.Visit
+			Ω(db.First(&saved, first.ID).Error).Should(Succeed())
+			Ω(saved.Visibility).Should(Equal(first.Visibility))
+			var deliveries int
+			db.Model(&models.WebhookDelivery{}).Count(&deliveries)
+			Ω(deliveries).Should(Equal(1))
+		})
+
+		It("looks back forever with no window", func() {
+			start(conf.DedupMerge, 0)
+			_, first := visit(winterfell)
+			age(first, 24*365*time.Hour)
+			status, v := visit(winterfell)
+			Ω(status).Should(Equal(200))
+			Ω(v.ID).Should(Equal(first.ID))
+		})
+
+		It("still takes merged cities off the wishlist", func() {
+			start(conf.DedupMerge, 3600)
+			_, first := visit(qarth)
+			status, body := doAuthRequest("POST", ts.URL+"/user/2/wishlist",
+				"arya@winterfell.net", "needle", qarth)
+			Ω(status).Should(Equal(201), string(body))
+			var entry models.WishlistEntry
+			Ω(json.Unmarshal(body, &entry)).Should(Succeed())
+			status, body = doAuthRequest("POST",
+				ts.URL+"/user/2/wishlist/"+strconv.Itoa(int(entry.ID))+"/visit",
+				"arya@winterfell.net", "needle", "")
+			Ω(status).Should(Equal(200), string(body))
+			var v models.Visit
+			Ω(json.Unmarshal(body, &v)).Should(Succeed())
+			Ω(v.ID).Should(Equal(first.ID))
+			var count int
+			db.Model(&models.WishlistEntry{}).Count(&count)
+			Ω(count).Should(Equal(0))
+			Ω(visitCount()).Should(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// docsPage lists the operations in /openapi.json. It has no outside
+// scripts or styles so it works offline.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>API docs</title>
+<style>
+body { font: 15px/1.4 sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h2 { border-bottom: 1px solid #ddd; text-transform: capitalize; }
+details { border: 1px solid #ddd; border-radius: 4px; margin: .5em 0; padding: .4em .8em; }
+summary { cursor: pointer; }
+code, pre { font: 13px monospace; }
+pre { background: #f6f8fa; padding: .6em; overflow: auto; }
+.method { display: inline-block; width: 4.5em; font-weight: bold; }
+.get { color: #1a7f37; } .post { color: #0969da; }
+.put { color: #9a6700; } .delete { color: #cf222e; }
+.auth { color: #777; font-size: 90%; }
+</style>
+</head>
+<body>
+<h1>API docs</h1>
+<p>Generated from <a href="openapi.json">openapi.json</a>.</p>
+<div id="ops">Loading...</div>
+<script>
+function el(tag, attrs, children) {
+  var e = document.createElement(tag);
+  for (var k in attrs || {}) e.setAttribute(k, attrs[k]);
+  (children || []).forEach(function (c) {
+    e.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+  });
+  return e;
+}
+
+function schemaBlock(spec, schema) {
+  var names = {};
+  (function find(s) {
+    if (!s || typeof s !== "object") return;
+    if (s.$ref) {
+      var name = s.$ref.split("/").pop();
+      if (names[name]) return;
+      names[name] = true;
+      find(spec.components.schemas[name]);
+    }
+    for (var k in s) find(s[k]);
+  })(schema);
+  var text = JSON.stringify(schema, null, 2);
+  Object.keys(names).forEach(function (name) {
+    text += "\n\n" + name + " " +
+      JSON.stringify(spec.components.schemas[name], null, 2);
+  });
+  return el("pre", {}, [text]);
+}
+
+function operation(spec, path, method, op) {
+  var access = "";
+  if (op.security) access = op.security.length > 1 ? "login optional" : "login required";
+  var body = [el("summary", {}, [
+    el("span", {"class": "method " + method}, [method.toUpperCase()]),
+    el("code", {}, [path]), " " + op.summary + " ",
+    el("span", {"class": "auth"}, [access])
+  ])];
+  if (op.parameters.length) {
+    body.push(el("h4", {}, ["Parameters"]));
+    body.push(el("ul", {}, op.parameters.map(function (p) {
+      return el("li", {}, [
+        el("code", {}, [p.name]),
+        " (" + p.in + ", " + p.schema.type + (p.required ? ", required" : "") + ")" +
+          (p.description ? " " + p.description : "")
+      ]);
+    })));
+  }
+  if (op.requestBody) {
+    body.push(el("h4", {}, ["Body"]));
+    body.push(schemaBlock(spec, op.requestBody.content["application/json"].schema));
+  }
+  Object.keys(op.responses).sort().forEach(function (status) {
+    var res = op.responses[status];
+    body.push(el("h4", {}, ["Response " + status + ": " + res.description]));
+    for (var type in res.content || {}) {
+      body.push(el("p", {}, [el("code", {}, [type])]));
+      body.push(schemaBlock(spec, res.content[type].schema));
+    }
+  });
+  return el("details", {}, body);
+}
+
+fetch("openapi.json").then(function (r) { return r.json(); }).then(function (spec) {
+  var tags = {};
+  Object.keys(spec.paths).sort().forEach(function (path) {
+    for (var method in spec.paths[path]) {
+      var op = spec.paths[path][method];
+      var tag = op.tags[0];
+      (tags[tag] = tags[tag] || []).push(operation(spec, path, method, op));
+    }
+  });
+  var ops = document.getElementById("ops");
+  ops.textContent = "";
+  Object.keys(tags).sort().forEach(function (tag) {
+    ops.appendChild(el("h2", {}, [tag]));
+    tags[tag].forEach(function (e) { ops.appendChild(e); });
+  });
+});
+</script>
+</body>
+</html>
+`
+
+func getDocsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+	}
+}
@@ -0,0 +1,247 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Conditional GETs", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	get := func(url string, headers ...string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", ts.URL+url, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		return resp, string(getRespBody(resp))
+	}
+	etag := func(url string) string {
+		resp, _ := get(url)
+		Ω(resp.StatusCode).Should(Equal(200))
+		tag := resp.Header.Get("ETag")
+		Ω(tag).ShouldNot(BeEmpty())
+		return tag
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	Describe("state cities", func() {
+		It("answers 304 when the client has the cities already", func() {
+			resp, _ := get("/state/1/cities")
+			tag := resp.Header.Get("ETag")
+			Ω(tag).Should(HavePrefix(`W/"`))
+			modified := resp.Header.Get("Last-Modified")
+			Ω(modified).ShouldNot(BeEmpty())
+
+			resp, body := get("/state/1/cities", "If-None-Match", tag)
+			Ω(resp.StatusCode).Should(Equal(304))
+			Ω(body).Should(BeEmpty())
+			resp, _ = get("/state/1/cities", "If-None-Match", `"other", `+tag)
+			Ω(resp.StatusCode).Should(Equal(304))
+			resp, _ = get("/state/1/cities", "If-None-Match", `"other"`)
+			Ω(resp.StatusCode).Should(Equal(200))
+
+			resp, _ = get("/state/1/cities", "If-Modified-Since", modified)
+			Ω(resp.StatusCode).Should(Equal(304))
+			resp, _ = get("/state/1/cities", "If-Modified-Since",
+				time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+			Ω(resp.StatusCode).Should(Equal(200))
+		})
+
+		It("tags each page differently", func() {
+			Ω(etag("/state/1/cities?limit=1")).ShouldNot(Equal(etag("/state/1/cities")))
+		})
+
+		It("keeps cities in memory", func() {
+			_, before := get("/state/1/cities")
+			Ω(before).Should(ContainSubstring("Winterfell"))
+			Ω(db.Exec("UPDATE cities SET name = 'Moat Cailin'").Error).Should(Succeed())
+			_, after := get("/state/1/cities")
+			Ω(after).Should(Equal(before))
+			_, other := get("/state/2/cities")
+			Ω(other).Should(ContainSubstring("Moat Cailin"))
+		})
+
+		It("pages the cached cities", func() {
+			var out struct {
+				Count uint `json:"count"`
+				Data  []struct {
+					Name string `json:"name"`
+				} `json:"data"`
+			}
+			Ω(getTestJSON(ts, "/state/1/cities?limit=1&offset=1", &out)).Should(Equal(200))
+			Ω(out.Count).Should(Equal(uint(2)))
+			Ω(out.Data).Should(HaveLen(1))
+			Ω(out.Data[0].Name).Should(Equal("Kings Landing"))
+		})
+	})
+
+	Describe("visits", func() {
+		It("changes the tag when visits are added or removed", func() {
+			first := etag("/user/2/visits")
+			visits := postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+			second := etag("/user/2/visits")
+			Ω(second).ShouldNot(Equal(first))
+			resp, _ := get("/user/2/visits", "If-None-Match", first)
+			Ω(resp.StatusCode).Should(Equal(200))
+			resp, _ = get("/user/2/visits", "If-None-Match", second)
+			Ω(resp.StatusCode).Should(Equal(304))
+
+			status, _ := doUserRequest("DELETE",
+				ts.URL+"/user/2/visits/"+strconv.Itoa(int(visits[0].ID)), 2, "")
+			Ω(status).Should(Equal(204))
+			Ω(etag("/user/2/visits")).ShouldNot(Equal(second))
+		})
+
+		It("tags what each audience sees differently", func() {
+			postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+			req, _ := http.NewRequest("GET", ts.URL+"/user/2/visits/states", nil)
+			req.SetBasicAuth("arya@winterfell.net", "needle")
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			getRespBody(resp)
+			Ω(resp.StatusCode).Should(Equal(200))
+			Ω(resp.Header.Get("ETag")).ShouldNot(Equal(etag("/user/2/visits/states")))
+		})
+	})
+
+	Describe("everything else", func() {
+		getAs := func(email, password, url string, headers ...string) *http.Response {
+			req, err := http.NewRequest("GET", ts.URL+url, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			if email != "" {
+				req.SetBasicAuth(email, password)
+			}
+			for i := 0; i < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			getRespBody(resp)
+			return resp
+		}
+		// revalidate gets the url as arya, then again with the tag it
+		// answered with, and returns the tag
+		revalidate := func(url string) string {
+			resp := getAs("arya@winterfell.net", "needle", url)
+			Ω(resp.StatusCode).Should(Equal(200), url)
+			tag := resp.Header.Get("ETag")
+			Ω(tag).ShouldNot(BeEmpty(), url)
+			resp = getAs("arya@winterfell.net", "needle", url, "If-None-Match", tag)
+			Ω(resp.StatusCode).Should(Equal(304), url)
+			return tag
+		}
+		asArya := func(method, url, body string) []byte {
+			status, resp := doAuthRequest(method, ts.URL+url,
+				"arya@winterfell.net", "needle", body)
+			Ω(status).Should(BeNumerically("<", 300), string(resp))
+			return resp
+		}
+		asSansa := func(method, url, body string) {
+			status, resp := doAuthRequest(method, ts.URL+url,
+				"sansa@winterfell.net", "lemoncakes", body)
+			Ω(status).Should(BeNumerically("<", 300), string(resp))
+		}
+
+		BeforeEach(func() {
+			createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+			postVisits(ts, 2, `{"city": "Winterfell", "state": "WS"}`)
+		})
+
+		It("answers 304 when nothing has changed", func() {
+			asArya("POST", "/user/2/trips", `{"name": "North", "visitIds": [1]}`)
+			var link struct {
+				Token string `json:"token"`
+			}
+			Ω(json.Unmarshal(asArya("POST", "/user/2/share-links", ""), &link)).
+				Should(Succeed())
+			for _, url := range []string{
+				"/user/2/stats", "/user/2/achievements",
+				"/user/2/trips", "/user/2/trips/1",
+				"/user/2/followers", "/user/2/following", "/user/2/follow-requests",
+				"/user/2/feed", "/user/2/share-links", "/leaderboards/states",
+				"/shared/" + link.Token + "/visits",
+				"/shared/" + link.Token + "/visits/states",
+			} {
+				revalidate(url)
+			}
+		})
+
+		It("changes trip tags when a trip changes", func() {
+			asArya("POST", "/user/2/trips", `{"name": "North", "visitIds": [1]}`)
+			list, detail := revalidate("/user/2/trips"), revalidate("/user/2/trips/1")
+			asArya("PUT", "/user/2/trips/1", `{"name": "The North"}`)
+			Ω(revalidate("/user/2/trips")).ShouldNot(Equal(list))
+			Ω(revalidate("/user/2/trips/1")).ShouldNot(Equal(detail))
+		})
+
+		It("changes follow list tags when someone follows", func() {
+			requests, followers := revalidate("/user/2/follow-requests"),
+				revalidate("/user/2/followers")
+			asSansa("POST", "/user/3/following", `{"userId": 2}`)
+			Ω(revalidate("/user/2/follow-requests")).ShouldNot(Equal(requests))
+			followers, requests = revalidate("/user/2/followers"),
+				revalidate("/user/2/follow-requests")
+			asArya("POST", "/user/2/follow-requests/3/accept", "")
+			Ω(revalidate("/user/2/followers")).ShouldNot(Equal(followers))
+			Ω(revalidate("/user/2/follow-requests")).ShouldNot(Equal(requests))
+		})
+
+		It("changes the feed tag when someone followed goes somewhere", func() {
+			asArya("POST", "/user/2/following", `{"userId": 3}`)
+			feed := revalidate("/user/2/feed")
+			asSansa("POST", "/user/3/follow-requests/2/accept", "")
+			Ω(revalidate("/user/2/feed")).ShouldNot(Equal(feed))
+			feed = revalidate("/user/2/feed")
+			postVisits(ts, 3, `{"city": "Qarth", "state": "ES"}`)
+			Ω(revalidate("/user/2/feed")).ShouldNot(Equal(feed))
+		})
+
+		It("changes the achievements tag when one is earned", func() {
+			achievements := revalidate("/user/2/achievements")
+			postVisits(ts, 2, `{"city": "Qarth", "state": "ES"}`)
+			Ω(revalidate("/user/2/achievements")).ShouldNot(Equal(achievements))
+		})
+
+		It("changes the share links tag when a link is made", func() {
+			links := revalidate("/user/2/share-links")
+			asArya("POST", "/user/2/share-links", "")
+			Ω(revalidate("/user/2/share-links")).ShouldNot(Equal(links))
+		})
+
+		It("tags the leaderboard for each caller", func() {
+			resp := getAs("", "", "/leaderboards/states")
+			Ω(resp.StatusCode).Should(Equal(200))
+			Ω(resp.Header.Get("ETag")).ShouldNot(Equal(revalidate("/leaderboards/states")))
+		})
+	})
+
+	It("changes the wishlist tag when the wishlist changes", func() {
+		first := etag("/user/2/wishlist")
+		status, _ := doAuthRequest("POST", ts.URL+"/user/2/wishlist",
+			"arya@winterfell.net", "needle", `{"city": "Qarth", "state": "ES"}`)
+		Ω(status).Should(Equal(201))
+		Ω(etag("/user/2/wishlist")).ShouldNot(Equal(first))
+	})
+})
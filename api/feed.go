@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// FeedEntry is a visit made by someone the user follows
+type FeedEntry struct {
+	VisitID   uint        `json:"visitId"`
+	VisitedAt time.Time   `json:"visitedAt"`
+	UserID    uint        `json:"userId"`
+	FirstName string      `json:"firstName"`
+	LastName  string      `json:"lastName"`
+	City      models.City `json:"city"`
+}
+
+// FeedResponse is a page of a feed. Pass NextCursor as `cursor` to get the
+// next page. It is empty on the last page.
+type FeedResponse struct {
+	Limit      uint        `json:"limit"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	Data       []FeedEntry `json:"data"`
+}
+
+// feedCursor is the position of the last entry on a page
+type feedCursor struct {
+	visitedAt time.Time
+	visitID   uint
+}
+
+func (fc feedCursor) String() string {
+	raw := fc.visitedAt.Format(time.RFC3339Nano) + "|" +
+		strconv.Itoa(int(fc.visitID))
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseFeedCursor(cursor string) (*feedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("malformed cursor")
+	}
+	visitedAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	visitID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &feedCursor{visitedAt, uint(visitID)}, nil
+}
+
+// fanOutVisit puts a new visit in the feed of each of the user's followers.
+// Whether they can see it is checked when the feed is read, since it can
+// change.
+func fanOutVisit(db *gorm.DB, visit *models.Visit) error {
+	return db.Exec(`
+		INSERT INTO feed_items (user_id, visit_id, created_at)
+		SELECT follower_id, ?, ?
+		FROM follows
+		WHERE followee_id = ? AND status = ? AND deleted_at IS NULL
+	`, visit.ID, visit.CreatedAt, visit.UserID, models.FollowAccepted).Error
+}
+
+// feed sources. both have `follows` and `visits` for the rest of the query
+const (
+	// fan out on read by going through everyone the user follows
+	feedFromVisits = `
+		FROM follows
+		JOIN visits ON visits.user_id = follows.followee_id`
+	// items written by fanOutVisit. new follows only see new visits.
+	feedFromItems = `
+		FROM feed_items
+		JOIN visits ON visits.id = feed_items.visit_id
+		JOIN follows ON follows.follower_id = feed_items.user_id
+			AND follows.followee_id = visits.user_id`
+)
+
+func getFeed(
+	db *gorm.DB, source string, userID uint, cursor *feedCursor, limit uint,
+) ([]FeedEntry, error) {
+	followerLevels := audienceVisibilities[models.VisibilityFollowers]
+	args := []interface{}{userID, models.FollowAccepted}
+	for _, level := range followerLevels {
+		args = append(args, level)
+	}
+	page := ""
+	if cursor != nil {
+		page = `AND (visits.created_at < ?
+			OR (visits.created_at = ? AND visits.id < ?))`
+		args = append(args, cursor.visitedAt, cursor.visitedAt, cursor.visitID)
+	}
+	args = append(args, limit)
+	rows, err := db.Raw(`
+		SELECT visits.id, visits.created_at,
+			users.id, users.first_name, users.last_name,
+			cities.id, cities.name, cities.state_id, cities.lat, cities.lon
+		`+source+`
+		JOIN users ON users.id = visits.user_id
+		JOIN cities ON cities.id = visits.city_id
+		WHERE follows.follower_id = ?
+			AND follows.status = ? AND follows.deleted_at IS NULL
+			AND visits.deleted_at IS NULL AND users.deleted_at IS NULL
+			AND `+effectiveVisibility+` IN (`+
+		strings.TrimSuffix(strings.Repeat("?, ", len(followerLevels)), ", ")+`)
+			`+page+`
+		ORDER BY visits.created_at DESC, visits.id DESC
+		LIMIT ?
+	`, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []FeedEntry{}
+	for rows.Next() {
+		var e FeedEntry
+		err := rows.Scan(
+			&e.VisitID, &e.VisitedAt, &e.UserID, &e.FirstName, &e.LastName,
+			&e.City.ID, &e.City.Name, &e.City.StateID, &e.City.Lat, &e.City.Lon)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// feedVersion is the version of everything the user's feed is made from:
+// their follows, and the visits, names and default visibility of everyone
+// they follow
+func feedVersion(db *gorm.DB, userID uint) (resourceVersion, error) {
+	version, err := rowsVersion(db, "follows", "follower_id = ?", userID)
+	if err != nil {
+		return version, err
+	}
+	followees := "SELECT followee_id FROM follows WHERE follower_id = ?"
+	visits, err := rowsVersion(db, "visits", "user_id IN ("+followees+")", userID)
+	if err != nil {
+		return version, err
+	}
+	users, err := rowsVersion(db, "users", "id IN ("+followees+")", userID)
+	return version.and(visits).and(users), err
+}
+
+func getFeedHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	source := feedFromVisits
+	if cfg.MaterializeFeed {
+		source = feedFromItems
+	}
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		limit, _ := getLimitOffset(c)
+		var cursor *feedCursor
+		if s := c.Query("cursor"); s != "" {
+			var err error
+			if cursor, err = parseFeedCursor(s); err != nil {
+				jsonError(c, "invalid cursor", err)
+				return
+			}
+		}
+		version, err := feedVersion(db, user.ID)
+		if err != nil {
+			jsonError(c, "error looking up feed", err)
+			return
+		}
+		if notModified(c, version) {
+			return
+		}
+		entries, err := getFeed(db, source, user.ID, cursor, limit)
+		if err != nil {
+			jsonError(c, "error looking up feed", err)
+			return
+		}
+		resp := FeedResponse{Limit: limit, Data: entries}
+		if uint(len(entries)) == limit {
+			last := entries[len(entries)-1]
+			resp.NextCursor = feedCursor{last.VisitedAt, last.VisitID}.String()
+		}
+		c.JSON(http.StatusOK, &resp)
+	}
+}
@@ -0,0 +1,139 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Feed", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+	asSansa := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "sansa@winterfell.net", "lemoncakes", body)
+	}
+	aryaFeed := func(query string) FeedResponse {
+		var feed FeedResponse
+		status, body := asArya("GET", "/user/2/feed"+query, "")
+		Ω(status).Should(Equal(200), string(body))
+		Ω(json.Unmarshal(body, &feed)).Should(Succeed())
+		return feed
+	}
+	cityNames := func(feed FeedResponse) []string {
+		names := []string{}
+		for _, entry := range feed.Data {
+			names = append(names, entry.City.Name)
+		}
+		return names
+	}
+
+	// arya follows sansa but not john
+	setup := func(configure func(*conf.Config)) {
+		db, ts = startTestServerWith(configure)
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+		status, _ := asArya("POST", "/user/2/following", `{"userId": 3}`)
+		Ω(status).Should(Equal(201))
+		status, _ = asSansa("POST", "/user/3/follow-requests/2/accept", "")
+		Ω(status).Should(Equal(200))
+	}
+
+	feedSpecs := func() {
+		BeforeEach(func() {
+			postVisits(ts, 3,
+				`{ "city": "Winterfell", "state": "WS" }`,
+				`{ "city": "Qarth", "state": "ES", "visibility": "private" }`,
+				`{ "city": "Kings Landing", "state": "WS" }`,
+				`{ "city": "Qarth", "state": "ES", "visibility": "followers" }`,
+			)
+			postVisits(ts, 1, `{ "city": "Winterfell", "state": "WS" }`)
+		})
+
+		It("shows visible visits of followed users, newest first", func() {
+			feed := aryaFeed("")
+			Ω(cityNames(feed)).Should(
+				Equal([]string{"Qarth", "Kings Landing", "Winterfell"}))
+			Ω(feed.Data[0].UserID).Should(Equal(uint(3)))
+			Ω(feed.Data[0].FirstName).Should(Equal("Sansa"))
+			Ω(feed.Data[0].VisitedAt).Should(
+				BeTemporally(">=", feed.Data[1].VisitedAt))
+			Ω(feed.NextCursor).Should(BeEmpty())
+		})
+
+		It("pages with a cursor", func() {
+			feed := aryaFeed("?limit=2")
+			Ω(cityNames(feed)).Should(Equal([]string{"Qarth", "Kings Landing"}))
+			Ω(feed.NextCursor).ShouldNot(BeEmpty())
+			feed = aryaFeed("?limit=2&cursor=" + feed.NextCursor)
+			Ω(cityNames(feed)).Should(Equal([]string{"Winterfell"}))
+			Ω(feed.NextCursor).Should(BeEmpty())
+		})
+
+		It("leaves out removed visits", func() {
+			db.Where("city_id = ? AND user_id = ?", 2, 3).Delete(&models.Visit{})
+			Ω(cityNames(aryaFeed(""))).Should(
+				Equal([]string{"Qarth", "Winterfell"}))
+		})
+
+		It("stops after unfollowing", func() {
+			status, _ := asArya("DELETE", "/user/2/following/3", "")
+			Ω(status).Should(Equal(204))
+			Ω(aryaFeed("").Data).Should(BeEmpty())
+		})
+
+		It("rejects bad cursors", func() {
+			status, _ := asArya("GET", "/user/2/feed?cursor=nope", "")
+			Ω(status).Should(Equal(400))
+		})
+
+		It("is only for the user", func() {
+			status, _ := asSansa("GET", "/user/2/feed", "")
+			Ω(status).Should(Equal(403))
+		})
+	}
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	Context("read from visits", func() {
+		BeforeEach(func() {
+			setup(nil)
+		})
+
+		feedSpecs()
+	})
+
+	Context("materialized", func() {
+		BeforeEach(func() {
+			setup(func(cfg *conf.Config) {
+				cfg.MaterializeFeed = true
+			})
+		})
+
+		feedSpecs()
+
+		It("writes new visits to followers' feeds", func() {
+			var count int
+			db.Model(&models.FeedItem{}).Where("user_id = ?", 2).Count(&count)
+			Ω(count).Should(Equal(4))
+			db.Model(&models.FeedItem{}).Count(&count)
+			Ω(count).Should(Equal(4))
+		})
+	})
+})
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// PublicUser is what anyone can see of a user in a list of users
+type PublicUser struct {
+	ID        uint   `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+// FollowRequest is the struct for posting a follow request
+type FollowRequest struct {
+	UserID uint `json:"userId" validate:"required"`
+}
+
+// look up the follow from follower to followee
+// sends a json error response and returns nil if there isn't one
+func getFollow(
+	c *gin.Context, db *gorm.DB, followerID, followeeID uint,
+) *models.Follow {
+	var follow models.Follow
+	q := db.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).
+		First(&follow)
+	if q.RecordNotFound() {
+		jsonError(c, "follow not found", nil)
+		return nil
+	} else if err := q.Error; err != nil {
+		jsonError(c, "error looking up follow", err)
+		return nil
+	}
+	return &follow
+}
+
+func getFollowHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		var req FollowRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		if req.UserID == user.ID {
+			jsonError(c, "you can't follow yourself", nil)
+			return
+		}
+		followee := lookupUser(c, db, req.UserID)
+		if followee == nil {
+			return
+		}
+		var count int
+		err := db.Model(&models.Follow{}).
+			Where("follower_id = ? AND followee_id = ?", user.ID, followee.ID).
+			Count(&count).Error
+		if err != nil {
+			jsonError(c, "error looking up follow", err)
+			return
+		} else if count > 0 {
+			jsonErrorStatus(c, http.StatusConflict, "already following", nil)
+			return
+		}
+		follow := models.Follow{
+			FollowerID: user.ID,
+			FolloweeID: followee.ID,
+			Status:     models.FollowPending,
+		}
+		if err := db.Create(&follow).Error; err != nil {
+			jsonError(c, "error saving follow", err)
+			return
+		}
+		c.JSON(http.StatusCreated, &follow)
+	}
+}
+
+func getUnfollowHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		followee := getUserParam(c, db, "otherID")
+		if followee == nil {
+			return
+		}
+		follow := getFollow(c, db, user.ID, followee.ID)
+		if follow == nil {
+			return
+		}
+		// hard delete so the pair can follow again later
+		if err := db.Unscoped().Delete(follow).Error; err != nil {
+			jsonError(c, "error removing follow", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func getAcceptFollowHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		follower := getUserParam(c, db, "otherID")
+		if follower == nil {
+			return
+		}
+		follow := getFollow(c, db, follower.ID, user.ID)
+		if follow == nil {
+			return
+		}
+		err := db.Model(follow).Update("status", models.FollowAccepted).Error
+		if err != nil {
+			jsonError(c, "error accepting follow", err)
+			return
+		}
+		c.JSON(http.StatusOK, follow)
+	}
+}
+
+func getDeclineFollowHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		follower := getUserParam(c, db, "otherID")
+		if follower == nil {
+			return
+		}
+		follow := getFollow(c, db, follower.ID, user.ID)
+		if follow == nil {
+			return
+		}
+		if err := db.Unscoped().Delete(follow).Error; err != nil {
+			jsonError(c, "error removing follow", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getFollowListHandler lists users on the other end of the user's follows.
+// `column` is the user's side of the follow and `status` which follows to
+// include.
+func getFollowListHandler(
+	cfg *conf.Config, db *gorm.DB, column, status string,
+) gin.HandlerFunc {
+	otherColumn := "followee_id"
+	if column == "followee_id" {
+		otherColumn = "follower_id"
+	}
+	queryBase := fmt.Sprintf(`
+		FROM users
+		WHERE users.deleted_at IS NULL AND users.id IN (
+			SELECT %s
+			FROM follows
+			WHERE %s = ? AND status = ? AND deleted_at IS NULL
+		)
+	`, otherColumn, column)
+	// everyone the user has ever had a follow with, to version the list
+	otherUsers := fmt.Sprintf(
+		"SELECT %s FROM follows WHERE %s = ?", otherColumn, column)
+	return func(c *gin.Context) {
+		var user *models.User
+		if status == models.FollowPending {
+			// only the user gets to see who is waiting on them
+			user = getActor(c, db)
+		} else {
+			user = getUser(c, db)
+		}
+		if user == nil {
+			return
+		}
+		// the follows, and the names of the users on the other end
+		version, err := rowsVersion(db, "follows", column+" = ?", user.ID)
+		if err != nil {
+			jsonError(c, "error looking up follows", err)
+			return
+		}
+		others, err := rowsVersion(db, "users", "id IN ("+otherUsers+")", user.ID)
+		if err != nil {
+			jsonError(c, "error looking up users", err)
+			return
+		}
+		if notModified(c, version.and(others)) {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		var count int
+		q := db.Raw(`SELECT COUNT(*) `+queryBase, user.ID, status).Count(&count)
+		if err := q.Error; err != nil {
+			jsonError(c, "error counting users", err)
+			return
+		}
+		users := []PublicUser{}
+		q = db.Raw(`SELECT users.id, users.first_name, users.last_name `+
+			queryBase+` ORDER BY users.id LIMIT ? OFFSET ?`,
+			user.ID, status, limit, offset).Scan(&users)
+		if err := q.Error; err != nil {
+			jsonError(c, "error looking up users", err)
+			return
+		}
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(count), users,
+		})
+	}
+}
+
+func setFollowRoutes(cfg *conf.Config, db *gorm.DB, r *gin.Engine) {
+	auth := requireAuth(db)
+	r.GET("/user/:userID/following", getFollowListHandler(
+		cfg, db, "follower_id", models.FollowAccepted))
+	r.GET("/user/:userID/followers", getFollowListHandler(
+		cfg, db, "followee_id", models.FollowAccepted))
+	r.POST("/user/:userID/following", auth, getFollowHandler(cfg, db))
+	r.DELETE("/user/:userID/following/:otherID", auth,
+		getUnfollowHandler(cfg, db))
+	r.GET("/user/:userID/follow-requests", auth, getFollowListHandler(
+		cfg, db, "followee_id", models.FollowPending))
+	r.POST("/user/:userID/follow-requests/:otherID/accept", auth,
+		getAcceptFollowHandler(cfg, db))
+	r.DELETE("/user/:userID/follow-requests/:otherID", auth,
+		getDeclineFollowHandler(cfg, db))
+}
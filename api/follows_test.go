@@ -0,0 +1,171 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Follows", func() {
+	var (
+		db          *gorm.DB
+		ts          *httptest.Server
+		arya, sansa models.User
+	)
+
+	// arya's requests
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+	asSansa := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "sansa@winterfell.net", "lemoncakes", body)
+	}
+	userList := func(url string) []models.User {
+		var out struct {
+			Count int
+			Data  []models.User
+		}
+		Ω(getTestJSON(ts, url, &out)).Should(Equal(200))
+		return out.Data
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		// user 1 is john snow
+		arya = createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		sansa = createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	Context("following", func() {
+		It("creates a pending request", func() {
+			status, body := asArya("POST", "/user/2/following", `{"userId": 3}`)
+			Ω(status).Should(Equal(201), string(body))
+			var follow models.Follow
+			json.Unmarshal(body, &follow)
+			Ω(follow.FollowerID).Should(Equal(arya.ID))
+			Ω(follow.FolloweeID).Should(Equal(sansa.ID))
+			Ω(follow.Status).Should(Equal(models.FollowPending))
+			// not following until accepted
+			Ω(userList("/user/2/following")).Should(BeEmpty())
+		})
+
+		It("requires authentication", func() {
+			status, _ := doAuthRequest(
+				"POST", ts.URL+"/user/2/following", "", "", `{"userId": 3}`)
+			Ω(status).Should(Equal(401))
+		})
+
+		It("rejects a bad password", func() {
+			status, _ := doAuthRequest("POST", ts.URL+"/user/2/following",
+				"arya@winterfell.net", "stick", `{"userId": 3}`)
+			Ω(status).Should(Equal(401))
+		})
+
+		It("requires the actor to be the authenticated user", func() {
+			status, _ := asArya("POST", "/user/3/following", `{"userId": 1}`)
+			Ω(status).Should(Equal(403))
+		})
+
+		DescribeTable("fails on bad requests",
+			func(body string, expected int) {
+				status, _ := asArya("POST", "/user/2/following", body)
+				Ω(status).Should(Equal(expected))
+			},
+			Entry("self", `{"userId": 2}`, 400),
+			Entry("non-existant", `{"userId": 20}`, 400),
+			Entry("not json", `nope`, 400),
+		)
+
+		It("rejects duplicate follows", func() {
+			status, _ := asArya("POST", "/user/2/following", `{"userId": 3}`)
+			Ω(status).Should(Equal(201))
+			status, _ = asArya("POST", "/user/2/following", `{"userId": 3}`)
+			Ω(status).Should(Equal(409))
+		})
+	})
+
+	Context("with a pending request", func() {
+		BeforeEach(func() {
+			status, _ := asArya("POST", "/user/2/following", `{"userId": 3}`)
+			Ω(status).Should(Equal(201))
+		})
+
+		It("lists incoming requests", func() {
+			var out struct {
+				Count int
+				Data  []models.User
+			}
+			status, body := asSansa("GET", "/user/3/follow-requests", "")
+			Ω(status).Should(Equal(200))
+			json.Unmarshal(body, &out)
+			Ω(out.Count).Should(Equal(1))
+			Ω(out.Data[0].FirstName).Should(Equal("Arya"))
+		})
+
+		It("keeps requests private", func() {
+			status, _ := asArya("GET", "/user/3/follow-requests", "")
+			Ω(status).Should(Equal(403))
+		})
+
+		It("can be accepted by the followee", func() {
+			status, _ := asArya("POST", "/user/3/follow-requests/2/accept", "")
+			Ω(status).Should(Equal(403))
+			status, body := asSansa("POST", "/user/3/follow-requests/2/accept", "")
+			Ω(status).Should(Equal(200), string(body))
+
+			following := userList("/user/2/following")
+			Ω(following).Should(HaveLen(1))
+			Ω(following[0].ID).Should(Equal(sansa.ID))
+			followers := userList("/user/3/followers")
+			Ω(followers).Should(HaveLen(1))
+			Ω(followers[0].ID).Should(Equal(arya.ID))
+			Ω(followers[0].FirstName).Should(Equal("Arya"))
+
+			// anyone can list them, so nothing private is included
+			for _, url := range []string{"/user/2/following", "/user/3/followers"} {
+				var out struct {
+					Data []map[string]interface{}
+				}
+				Ω(getTestJSON(ts, url, &out)).Should(Equal(200))
+				Ω(out.Data).Should(HaveLen(1))
+				Ω(out.Data[0]).ShouldNot(HaveKey("email"))
+				Ω(out.Data[0]).ShouldNot(HaveKey("visibility"))
+			}
+		})
+
+		It("can be declined by the followee", func() {
+			status, _ := asSansa("DELETE", "/user/3/follow-requests/2", "")
+			Ω(status).Should(Equal(204))
+			var count int
+			db.Model(&models.Follow{}).Count(&count)
+			Ω(count).Should(BeZero())
+		})
+
+		It("can be unfollowed and followed again", func() {
+			status, _ := asSansa("POST", "/user/3/follow-requests/2/accept", "")
+			Ω(status).Should(Equal(200))
+			status, _ = asArya("DELETE", "/user/2/following/3", "")
+			Ω(status).Should(Equal(204))
+			Ω(userList("/user/3/followers")).Should(BeEmpty())
+			status, _ = asArya("POST", "/user/2/following", `{"userId": 3}`)
+			Ω(status).Should(Equal(201))
+		})
+
+		It("fails to unfollow someone not followed", func() {
+			status, _ := asArya("DELETE", "/user/2/following/1", "")
+			Ω(status).Should(Equal(400))
+		})
+	})
+})
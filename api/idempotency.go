@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// IdempotencyHeader is the header clients send a key for a POST in, so
+// retrying it doesn't make the change twice
+const IdempotencyHeader = "Idempotency-Key"
+
+// the longest idempotency key that is accepted
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder keeps a copy of the body the handler writes
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// requestHash tells requests with the same key apart by what they sent
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyScope is what a key is used for: the method and url, and the
+// user who sent it, so users can't run into each other's keys. Requests
+// without valid credentials share a scope.
+func idempotencyScope(c *gin.Context, db *gorm.DB) string {
+	scope := c.Request.Method + " " + c.Request.URL.RequestURI()
+	if user, _, err := authenticateOnce(c, db); err == nil {
+		scope += " user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return scope
+}
+
+// claimIdempotencyKey saves the key for the request, returning true, or else
+// returns the one saved by an earlier request. Expired keys are replaced.
+func claimIdempotencyKey(
+	db *gorm.DB, key, scope, hash string, ttl time.Duration,
+) (*models.IdempotencyKey, bool, error) {
+	now := time.Now()
+	var prior models.IdempotencyKey
+	q := db.Where("idempotency_key = ? AND scope = ?", key, scope).First(&prior)
+	if q.Error == nil && prior.ExpiresAt.After(now) {
+		return &prior, false, nil
+	} else if q.Error == nil {
+		if err := db.Delete(&prior).Error; err != nil {
+			return nil, false, err
+		}
+	} else if !q.RecordNotFound() {
+		return nil, false, q.Error
+	}
+	record := models.IdempotencyKey{
+		Key: key, Scope: scope, RequestHash: hash, ExpiresAt: now.Add(ttl),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		// another request with the key got there first
+		q := db.Where("idempotency_key = ? AND scope = ?", key, scope).First(&prior)
+		if q.Error == nil {
+			return &prior, false, nil
+		}
+		return nil, false, err
+	}
+	return &record, true, nil
+}
+
+// idempotent replays the stored response when a POST is retried with the
+// same Idempotency-Key header, instead of running the handler again. Each
+// user has their own keys. Using a key again with different data, or
+// before the first request is done, gets a 409. Responses with a 5xx status
+// aren't kept, so those requests can be retried for real.
+func idempotent(db *gorm.DB, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		limit := "max=" + strconv.Itoa(maxIdempotencyKeyLength)
+		if errs := validate.Var(IdempotencyHeader, key, limit); errs != nil {
+			jsonFieldErrors(c, errs)
+			c.Abort()
+			return
+		}
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			jsonError(c, "could not understand your data", err)
+			c.Abort()
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		hash := requestHash(body)
+		scope := idempotencyScope(c, db)
+		record, claimed, err := claimIdempotencyKey(db, key, scope, hash, ttl)
+		if err != nil {
+			jsonError(c, "error checking idempotency key", err)
+			c.Abort()
+			return
+		}
+		if record.RequestHash != hash {
+			jsonErrorStatus(c, http.StatusConflict,
+				"idempotency key was used for a different request", nil)
+			c.Abort()
+			return
+		} else if !claimed && record.Status == 0 {
+			jsonErrorStatus(c, http.StatusConflict,
+				"a request with this idempotency key is still in progress", nil)
+			c.Abort()
+			return
+		} else if !claimed {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(record.Status, record.ContentType, record.Body)
+			c.Abort()
+			return
+		}
+
+		w := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		saved := false
+		// let the key be used again if the handler panics
+		defer func() {
+			if !saved {
+				db.Delete(record)
+			}
+		}()
+		c.Next()
+		if w.Status() >= http.StatusInternalServerError {
+			return
+		}
+		err = db.Model(record).Updates(map[string]interface{}{
+			"status":       w.Status(),
+			"content_type": w.Header().Get("Content-Type"),
+			"body":         w.body.Bytes(),
+		}).Error
+		if err != nil {
+			log.Errorln("could not save idempotent response:", err)
+			return
+		}
+		saved = true
+	}
+}
+
+// SweepIdempotencyKeys deletes the keys that expired by now, returning how
+// many there were
+func SweepIdempotencyKeys(db *gorm.DB, now time.Time) (int64, error) {
+	q := db.Where("expires_at <= ?", now).Delete(&models.IdempotencyKey{})
+	return q.RowsAffected, q.Error
+}
+
+// IdempotencySweeper deletes expired idempotency keys in the background
+type IdempotencySweeper struct {
+	db       *gorm.DB
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewIdempotencySweeper that sweeps every interval once it's started
+func NewIdempotencySweeper(db *gorm.DB, interval time.Duration) *IdempotencySweeper {
+	return &IdempotencySweeper{db: db, interval: interval}
+}
+
+// Start sweeping
+func (s *IdempotencySweeper) Start() {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop sweeping, waiting for a sweep in progress to finish
+func (s *IdempotencySweeper) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *IdempotencySweeper) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			n, err := SweepIdempotencyKeys(s.db, now)
+			if err != nil {
+				log.Errorln("could not delete expired idempotency keys:", err)
+			} else if n > 0 {
+				log.Debugln("deleted expired idempotency keys:", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package api_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Idempotency keys", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	// post the body to the url as the user
+	postTo := func(url string, userID uint, key, body string) (*http.Response, string) {
+		req, err := http.NewRequest("POST", ts.URL+url, strings.NewReader(body))
+		Ω(err).ShouldNot(HaveOccurred())
+		req.Header.Set("Content-Type", "application/json")
+		login := testLogins[userID]
+		req.SetBasicAuth(login[0], login[1])
+		if key != "" {
+			req.Header.Set(IdempotencyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		return resp, string(getRespBody(resp))
+	}
+	// post a visit as the user
+	post := func(userID uint, key, body string) (*http.Response, string) {
+		return postTo("/user/"+strconv.Itoa(int(userID))+"/visits", userID, key, body)
+	}
+	visitCount := func() int {
+		var count int
+		db.Model(&models.Visit{}).Count(&count)
+		return count
+	}
+	keyCount := func() int {
+		var count int
+		db.Model(&models.IdempotencyKey{}).Count(&count)
+		return count
+	}
+	winterfell := `{"city": "Winterfell", "state": "WS"}`
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("replays the first response to a retry", func() {
+		first, firstBody := post(2, "abc", winterfell)
+		Ω(first.StatusCode).Should(Equal(201))
+		Ω(first.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+		retry, retryBody := post(2, "abc", winterfell)
+		Ω(retry.StatusCode).Should(Equal(201))
+		Ω(retry.Header.Get("Idempotent-Replayed")).Should(Equal("true"))
+		Ω(retry.Header.Get("Content-Type")).Should(Equal(first.Header.Get("Content-Type")))
+		Ω(retryBody).Should(Equal(firstBody))
+		Ω(visitCount()).Should(Equal(1))
+	})
+
+	It("replays errors too", func() {
+		bad := `{"city": "Braavos", "state": "ES"}`
+		first, firstBody := post(2, "abc", bad)
+		Ω(first.StatusCode).Should(Equal(400))
+		retry, retryBody := post(2, "abc", bad)
+		Ω(retry.StatusCode).Should(Equal(400))
+		Ω(retry.Header.Get("Idempotent-Replayed")).Should(Equal("true"))
+		Ω(retryBody).Should(Equal(firstBody))
+	})
+
+	It("makes the change every time without a key", func() {
+		post(2, "", winterfell)
+		post(2, "", winterfell)
+		Ω(visitCount()).Should(Equal(2))
+		Ω(keyCount()).Should(Equal(0))
+	})
+
+	It("rejects a key used again for different data", func() {
+		post(2, "abc", winterfell)
+		resp, body := post(2, "abc", `{"city": "Qarth", "state": "ES"}`)
+		Ω(resp.StatusCode).Should(Equal(409))
+		Ω(body).Should(ContainSubstring("different request"))
+		Ω(visitCount()).Should(Equal(1))
+	})
+
+	It("keeps keys for different urls apart", func() {
+		createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+		post(2, "abc", winterfell)
+		resp, _ := post(3, "abc", winterfell)
+		Ω(resp.StatusCode).Should(Equal(201))
+		Ω(resp.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+		Ω(visitCount()).Should(Equal(2))
+	})
+
+	It("keeps keys for different users apart", func() {
+		route := `{"cityIds": [3, 2, 1]}`
+		first, firstBody := postTo("/routes/optimize", 1, "abc", route)
+		Ω(first.StatusCode).Should(Equal(200))
+		resp, body := postTo("/routes/optimize", 2, "abc", `{"cityIds": [1, 2]}`)
+		Ω(resp.StatusCode).Should(Equal(200), body)
+		Ω(resp.Header.Get("Idempotent-Replayed")).Should(BeEmpty())
+		Ω(body).ShouldNot(Equal(firstBody))
+		retry, retryBody := postTo("/routes/optimize", 1, "abc", route)
+		Ω(retry.Header.Get("Idempotent-Replayed")).Should(Equal("true"))
+		Ω(retryBody).Should(Equal(firstBody))
+		Ω(keyCount()).Should(Equal(2))
+	})
+
+	It("rejects a retry while the first request is going", func() {
+		post(2, "abc", winterfell)
+		// as if the first request hadn't finished
+		Ω(db.Model(&models.IdempotencyKey{}).Update("status", 0).Error).Should(Succeed())
+		resp, body := post(2, "abc", winterfell)
+		Ω(resp.StatusCode).Should(Equal(409))
+		Ω(body).Should(ContainSubstring("in progress"))
+		Ω(visitCount()).Should(Equal(1))
+	})
+
+	It("lets expired keys be used again", func() {
+		post(2, "abc", winterfell)
+		Ω(db.Model(&models.IdempotencyKey{}).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error).Should(Succeed())
+		resp, _ := post(2, "abc", `{"city": "Qarth", "state": "ES"}`)
+		Ω(resp.StatusCode).Should(Equal(201))
+		Ω(visitCount()).Should(Equal(2))
+		Ω(keyCount()).Should(Equal(1))
+	})
+
+	It("rejects keys that are too long", func() {
+		resp, body := post(2, strings.Repeat("k", 256), winterfell)
+		Ω(resp.StatusCode).Should(Equal(400))
+		Ω(body).Should(ContainSubstring(IdempotencyHeader))
+		Ω(visitCount()).Should(Equal(0))
+	})
+
+	Describe("sweeping", func() {
+		BeforeEach(func() {
+			post(2, "old", winterfell)
+			post(2, "new", winterfell)
+			Ω(db.Model(&models.IdempotencyKey{}).Where("idempotency_key = ?", "old").
+				Update("expires_at", time.Now().Add(-time.Minute)).Error).Should(Succeed())
+		})
+
+		It("deletes expired keys", func() {
+			n, err := SweepIdempotencyKeys(db, time.Now())
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(int64(1)))
+			var keys []models.IdempotencyKey
+			db.Find(&keys)
+			Ω(keys).Should(HaveLen(1))
+			Ω(keys[0].Key).Should(Equal("new"))
+		})
+
+		It("sweeps in the background until stopped", func() {
+			sweeper := NewIdempotencySweeper(db, 10*time.Millisecond)
+			sweeper.Start()
+			Eventually(keyCount).Should(Equal(1))
+			sweeper.Stop()
+		})
+	})
+})
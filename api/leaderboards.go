@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/leaderboard"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// LeaderboardResponse is a page of a leaderboard, and where the caller is
+// on it if they are logged in and on it
+type LeaderboardResponse struct {
+	Metric string              `json:"metric"`
+	Period string              `json:"period"`
+	Limit  uint                `json:"limit"`
+	Offset uint                `json:"offset"`
+	Count  uint                `json:"count"`
+	Data   []leaderboard.Entry `json:"data"`
+	You    *leaderboard.Entry  `json:"you,omitempty"`
+}
+
+// getLeaderboardHandler ranks users by the metric in the path over the
+// `period` query param, all time by default
+func getLeaderboardHandler(
+	cfg *conf.Config, db *gorm.DB, board *leaderboard.Board,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metric := c.Param("metric")
+		period := c.DefaultQuery("period", leaderboard.PeriodAll)
+		if !leaderboard.Valid(metric, period) {
+			jsonError(c, "unknown leaderboard", fmt.Errorf(
+				"metric must be one of %v and period one of %v",
+				leaderboard.Metrics, leaderboard.Periods))
+			return
+		}
+		computedAt, err := board.ComputedAt()
+		if err != nil {
+			jsonError(c, "error looking up leaderboard", err)
+			return
+		}
+		// names are looked up as the board is read
+		users, err := rowsVersion(db, "users",
+			"id IN (SELECT user_id FROM leaderboard_entries)")
+		if err != nil {
+			jsonError(c, "error looking up leaderboard", err)
+			return
+		}
+		version := users.and(resourceVersion{modified: computedAt})
+		// the caller's own rank is part of the response
+		caller := authUser(c)
+		viewer := ""
+		if caller != nil {
+			viewer = fmt.Sprint(caller.ID)
+		}
+		if notModified(c, version, viewer) {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		entries, count, err := board.Top(metric, period, limit, offset)
+		if err != nil {
+			jsonError(c, "error looking up leaderboard", err)
+			return
+		}
+		response := &LeaderboardResponse{
+			Metric: metric, Period: period,
+			Limit: limit, Offset: offset, Count: count, Data: entries,
+		}
+		if caller != nil {
+			response.You, err = board.Rank(metric, period, caller.ID)
+			if err != nil {
+				jsonError(c, "error looking up your rank", err)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/leaderboard"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Leaderboards", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	const (
+		winterfell = `{ "city": "Winterfell", "state": "WS" }`
+		qarth      = `{ "city": "Qarth", "state": "ES" }`
+	)
+
+	getLeaderboard := func(url, email, password string) (int, LeaderboardResponse) {
+		status, body := doAuthRequest("GET", ts.URL+url, email, password, "")
+		var out LeaderboardResponse
+		json.Unmarshal(body, &out)
+		return status, out
+	}
+
+	// the test server's app isn't started, so it doesn't rebuild its boards.
+	// Do it here. They're read from the database, so any board will do.
+	rebuild := func() {
+		Ω(leaderboard.NewBoard(db, time.Hour).Refresh(time.Now())).Should(Succeed())
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+		postVisits(ts, 1, winterfell)
+		postVisits(ts, 2, winterfell, qarth)
+		rebuild()
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("is built when the app starts", func() {
+		Ω(db.Delete(&models.LeaderboardEntry{}).Error).ShouldNot(HaveOccurred())
+		cfg := conf.Default()
+		cfg.AchievementsPath = testAchievementsPath
+		cfg.StateBoundariesPath = testStateBoundariesPath
+		app := NewApp(cfg, db)
+		r := gin.New()
+		app.SetRoutes(r)
+		started := httptest.NewServer(r)
+		defer started.Close()
+		app.Start()
+		defer app.Close()
+
+		var board LeaderboardResponse
+		Ω(getTestJSON(started, "/leaderboards/states", &board)).Should(Equal(200))
+		Ω(board.Count).Should(Equal(uint(2)))
+	})
+
+	It("ranks users", func() {
+		status, board := getLeaderboard("/leaderboards/states", "", "")
+		Ω(status).Should(Equal(200))
+		Ω(board.Metric).Should(Equal("states"))
+		Ω(board.Period).Should(Equal("all"))
+		Ω(board.Count).Should(Equal(uint(2)))
+		Ω(board.Data[0].UserID).Should(Equal(uint(2)))
+		Ω(board.Data[0].Value).Should(Equal(2.0))
+		Ω(board.Data[1].UserID).Should(Equal(uint(1)))
+		Ω(board.You).Should(BeNil())
+	})
+
+	It("includes the caller's rank", func() {
+		_, board := getLeaderboard(
+			"/leaderboards/distance?period=30d", "arya@winterfell.net", "needle")
+		Ω(board.You).ShouldNot(BeNil())
+		Ω(board.You.Rank).Should(Equal(1))
+		Ω(board.You.Value).Should(BeNumerically(">", 10000))
+	})
+
+	It("leaves out private users", func() {
+		status, _ := doAuthRequest("PUT", ts.URL+"/user/2/visibility",
+			"arya@winterfell.net", "needle", `{"visibility": "private"}`)
+		Ω(status).Should(Equal(200))
+		rebuild()
+		_, board := getLeaderboard(
+			"/leaderboards/cities", "arya@winterfell.net", "needle")
+		Ω(board.Count).Should(Equal(uint(1)))
+		Ω(board.Data[0].UserID).Should(Equal(uint(1)))
+		Ω(board.You).Should(BeNil())
+	})
+
+	It("rejects unknown metrics and periods", func() {
+		status, _ := getLeaderboard("/leaderboards/visits", "", "")
+		Ω(status).Should(Equal(400))
+		status, _ = getLeaderboard("/leaderboards/states?period=decade", "", "")
+		Ω(status).Should(Equal(400))
+	})
+})
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruEntry struct {
+	key, value interface{}
+}
+
+// lruCache holds the most recently used values computed in this process,
+// like encoded tiles and visit clusters. Anything about a user should have
+// the user's cache generation in its key, so it's never read once the user
+// changes and ages out instead of being found and dropped.
+type lruCache struct {
+	sync.Mutex
+	size    int
+	order   *list.List
+	entries map[interface{}]*list.Element
+}
+
+// newLRUCache holding up to size values. A size of 0 caches nothing.
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[interface{}]*list.Element{},
+	}
+}
+
+func (s *lruCache) get(key interface{}) (interface{}, bool) {
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (s *lruCache) set(key, value interface{}) {
+	s.Lock()
+	defer s.Unlock()
+	if s.size <= 0 {
+		return
+	}
+	if e, ok := s.entries[key]; ok {
+		e.Value.(*lruEntry).value = value
+		s.order.MoveToFront(e)
+		return
+	}
+	s.entries[key] = s.order.PushFront(&lruEntry{key, value})
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/geo/usmap"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// visitMap has the states shaded and cities marked that the user has
+// visited and the audience can see
+func visitMap(
+	db *gorm.DB, states *geo.RegionIndex, user *models.User, audience string,
+) (*usmap.Map, error) {
+	visible, visibleArgs := visibleClause(user, audience)
+	rows, err := db.Raw(`
+		SELECT DISTINCT cities.id, cities.lat, cities.lon, states.abbrev
+		FROM visits
+		JOIN cities ON cities.id = visits.city_id
+		JOIN states ON states.id = cities.state_id
+		WHERE visits.user_id = ? AND visits.deleted_at IS NULL AND `+visible+`
+		ORDER BY cities.id`,
+		append([]interface{}{user.ID}, visibleArgs...)...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	m := &usmap.Map{
+		Title:   "Places " + user.FirstName + " has visited",
+		Regions: states.Regions(),
+		Shaded:  map[string]bool{},
+	}
+	for rows.Next() {
+		var id uint
+		var p geo.Point
+		var abbrev string
+		if err := rows.Scan(&id, &p.Lat, &p.Lon, &abbrev); err != nil {
+			return nil, err
+		}
+		m.Points = append(m.Points, p)
+		m.Shaded[abbrev] = true
+	}
+	return m, rows.Err()
+}
+
+// getVisitMapHandler draws a map of the US with the states and cities the
+// user has visited, as "svg" or "png"
+func getVisitMapHandler(
+	cfg *conf.Config, db *gorm.DB, states *geo.RegionIndex, format string,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getUser(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return
+		}
+		m, err := visitMap(db, states, user, audience)
+		if err != nil {
+			jsonError(c, "error looking up visits", err)
+			return
+		}
+		var b bytes.Buffer
+		contentType := "image/svg+xml"
+		if format == "png" {
+			contentType = "image/png"
+			err = m.WritePNG(&b)
+		} else {
+			err = m.WriteSVG(&b)
+		}
+		if err != nil {
+			jsonErrorStatus(c, http.StatusInternalServerError,
+				"error drawing map", err)
+			return
+		}
+		c.Data(http.StatusOK, contentType, b.Bytes())
+	}
+}
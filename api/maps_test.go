@@ -0,0 +1,83 @@
+package api_test
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Visit maps", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		postVisits(ts, 2,
+			`{"city": "Winterfell", "state": "WS"}`,
+			`{"city": "Qarth", "state": "ES", "visibility": "private"}`,
+		)
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	svg := func(email, password string) string {
+		status, body := doAuthRequest(
+			"GET", ts.URL+"/user/2/visits/map.svg", email, password, "")
+		Ω(status).Should(Equal(200), string(body))
+		return string(body)
+	}
+
+	It("draws the states and cities visited as SVG", func() {
+		resp, err := http.Get(ts.URL + "/user/2/visits/map.svg")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(resp.Header.Get("Content-Type")).Should(Equal("image/svg+xml"))
+		body := string(getRespBody(resp))
+		Ω(body).Should(ContainSubstring("<title>Places Arya has visited</title>"))
+		// every state outline, with only the visible visits marked
+		Ω(strings.Count(body, "<path ")).Should(Equal(3))
+		Ω(strings.Count(body, "<circle ")).Should(Equal(1))
+	})
+
+	It("shades the visited states", func() {
+		fill := func(body, state string) string {
+			i := strings.Index(body, `data-state="`+state+`" fill="`)
+			Ω(i).Should(BeNumerically(">=", 0))
+			return body[i+len(`data-state="`+state+`" fill="`):][:7]
+		}
+		public := svg("", "")
+		Ω(fill(public, "WS")).ShouldNot(Equal(fill(public, "ES")))
+		Ω(fill(public, "ES")).Should(Equal(fill(public, "SO")))
+
+		own := svg("arya@winterfell.net", "needle")
+		Ω(strings.Count(own, "<circle ")).Should(Equal(2))
+		Ω(fill(own, "ES")).Should(Equal(fill(own, "WS")))
+	})
+
+	It("draws PNG", func() {
+		resp, err := http.Get(ts.URL + "/user/2/visits/map.png")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(resp.Header.Get("Content-Type")).Should(Equal("image/png"))
+		defer resp.Body.Close()
+		img, err := png.Decode(resp.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(img.Bounds().Dx()).Should(Equal(960))
+	})
+
+	It("needs a real user", func() {
+		status, _ := doAuthRequest("GET", ts.URL+"/user/99/visits/map.svg", "", "", "")
+		Ω(status).Should(Equal(400))
+	})
+})
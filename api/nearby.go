@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	defaultNearbyRadiusKm = 50
+	// past this the geohash cells are too big to narrow things down much
+	maxNearbyRadiusKm = 1000
+)
+
+// NearbyCity is a city and how far it is from where the search was
+type NearbyCity struct {
+	models.City
+	DistanceKm float64 `json:"distanceKm"`
+}
+
+// whereGeohashIn narrows the query to rows whose geohash column starts with
+// one of the prefixes. Each prefix is a range so the column's index is used,
+// where LIKE would scan the table.
+func whereGeohashIn(q *gorm.DB, column string, prefixes []string) *gorm.DB {
+	if len(prefixes) == 0 {
+		return q
+	}
+	clauses := make([]string, len(prefixes))
+	args := make([]interface{}, 0, 2*len(prefixes))
+	for i, prefix := range prefixes {
+		clauses[i] = fmt.Sprintf("(%s >= ? AND %s < ?)", column, column)
+		// "~" sorts after every geohash character
+		args = append(args, prefix, prefix+"~")
+	}
+	return q.Where(strings.Join(clauses, " OR "), args...)
+}
+
+// citiesNear finds the cities within radiusKm of the point, nearest first
+func citiesNear(db *gorm.DB, p geo.Point, radiusKm float64) ([]NearbyCity, error) {
+	var candidates []models.City
+	q := whereGeohashIn(db, "geohash", geo.GeohashCover(p, radiusKm))
+	if err := q.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	// the cells are bigger than the circle, so some are too far
+	nearby := []NearbyCity{}
+	for _, city := range candidates {
+		d := geo.Distance(p, geo.Point{Lat: city.Lat, Lon: city.Lon})
+		if d <= radiusKm {
+			nearby = append(nearby, NearbyCity{city, d})
+		}
+	}
+	sort.SliceStable(nearby, func(i, j int) bool {
+		return nearby[i].DistanceKm < nearby[j].DistanceKm
+	})
+	return nearby, nil
+}
+
+// getNearbyCitiesHandler lists the cities within `radiusKm` of the `lat` and
+// `lon` in the query, nearest first
+func getNearbyCitiesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lat, ok := getCoordinate(c, "lat")
+		if !ok {
+			return
+		}
+		lon, ok := getCoordinate(c, "lon")
+		if !ok {
+			return
+		}
+		radius := float64(defaultNearbyRadiusKm)
+		if s := c.Query("radiusKm"); s != "" {
+			var errs validate.Errors
+			radius, errs = validate.Float("radiusKm", s,
+				"max="+strconv.Itoa(maxNearbyRadiusKm))
+			if errs == nil && radius <= 0 {
+				errs = validate.Errors{{
+					Field: "radiusKm", Rule: "min",
+					Message: "radiusKm must be more than 0",
+				}}
+			}
+			if errs != nil {
+				jsonFieldErrors(c, errs)
+				return
+			}
+		}
+		cities, err := citiesNear(db, geo.Point{Lat: lat, Lon: lon}, radius)
+		if err != nil {
+			jsonError(c, "error looking up cities", err)
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		start, end := pageBounds(len(cities), limit, offset)
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(len(cities)), cities[start:end],
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Nearby", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	near := func(query string) []NearbyCity {
+		var out struct {
+			Count int
+			Data  []NearbyCity
+		}
+		Ω(getTestJSON(ts, "/cities/near?"+query, &out)).Should(Equal(200))
+		Ω(out.Data).Should(HaveLen(out.Count))
+		return out.Data
+	}
+
+	It("finds cities in the radius, nearest first", func() {
+		cities := near("lat=35&lon=-80.5&radiusKm=300")
+		Ω(cities).Should(HaveLen(2))
+		Ω(cities[0].Name).Should(Equal("Winterfell"))
+		Ω(cities[0].DistanceKm).Should(BeNumerically("~", 40.15, 0.01))
+		Ω(cities[1].Name).Should(Equal("Kings Landing"))
+
+		cities = near("lat=35&lon=-80.5&radiusKm=100")
+		Ω(cities).Should(HaveLen(1))
+		Ω(near("lat=35&lon=-80.5&radiusKm=10")).Should(BeEmpty())
+	})
+
+	It("uses a default radius", func() {
+		Ω(near("lat=35.2&lon=-80.8")).Should(HaveLen(1))
+		Ω(near("lat=0&lon=0")).Should(BeEmpty())
+	})
+
+	It("leaves out deleted cities", func() {
+		Ω(db.Delete(&models.City{}, 1).Error).ShouldNot(HaveOccurred())
+		Ω(near("lat=35.2&lon=-80.8")).Should(BeEmpty())
+	})
+
+	It("stores the city's geohash with new visits", func() {
+		status, body := doUserRequest("POST", ts.URL+"/user/1/visits", 1,
+			`{"city": "Winterfell", "state": "WS"}`)
+		Ω(status).Should(Equal(201), string(body))
+		var visit models.Visit
+		Ω(json.Unmarshal(body, &visit)).Should(Succeed())
+		Ω(visit.Geohash).Should(Equal("dnq82kgue"))
+	})
+
+	DescribeTable("rejects bad searches",
+		func(query string) {
+			var out interface{}
+			Ω(getTestJSON(ts, "/cities/near?"+query, &out)).Should(Equal(400))
+		},
+		Entry("no lat", "lon=0"),
+		Entry("not a radius", "lat=0&lon=0&radiusKm=far"),
+		Entry("no radius", "lat=0&lon=0&radiusKm=0"),
+		Entry("too big a radius", "lat=0&lon=0&radiusKm=1001"),
+	)
+})
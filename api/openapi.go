@@ -0,0 +1,541 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/openapi"
+	"github.com/gin-gonic/gin"
+)
+
+// who can call a route
+const (
+	// accessAnyone needs no credentials
+	accessAnyone = ""
+	// accessViewer takes credentials if given and shows more to the right
+	// people
+	accessViewer = "viewer"
+	// accessAuth needs credentials
+	accessAuth = "auth"
+)
+
+// apiParam is a query parameter
+type apiParam struct {
+	name, typ, description string
+	required               bool
+}
+
+// apiOperation documents a route for the OpenAPI spec
+type apiOperation struct {
+	id, summary string
+	access      string
+	query       []apiParam
+	// body is a value of the type of JSON the route reads
+	body interface{}
+	// status on success, 200 if not set
+	status int
+	// response is a value of the type of JSON the route writes, or nil if
+	// it writes nothing
+	response interface{}
+	// list responses are a MetaResponse with a page of response in data
+	list bool
+	// contentType of responses that aren't JSON
+	contentType string
+}
+
+var pageParams = []apiParam{
+	{"limit", "integer", "how many to return, up to 1000", false},
+	{"offset", "integer", "how many to skip", false},
+}
+
+func withPage(params ...apiParam) []apiParam {
+	return append(params, pageParams...)
+}
+
+// apiOperations documents every route, keyed by method and OpenAPI path.
+// Routes that aren't here are left out of the spec.
+var apiOperations = map[string]apiOperation{
+	"GET /": {
+		id: "ping", summary: "Check the server is up",
+		response: "", contentType: "text/plain",
+	},
+	"GET /openapi.json": {
+		id: "getOpenAPI", summary: "This OpenAPI spec",
+		response: map[string]interface{}{},
+	},
+	"GET /docs": {
+		id: "getDocs", summary: "Browse this OpenAPI spec",
+		response: "", contentType: "text/html",
+	},
+
+	"GET /state/{stateID}/cities": {
+		id: "listStateCities", summary: "List the cities in a state",
+		query: pageParams, response: models.City{}, list: true,
+	},
+	"GET /cities/near": {
+		id: "listNearbyCities", summary: "List cities near a point, nearest first",
+		query: withPage(
+			apiParam{"lat", "number", "latitude of the point", true},
+			apiParam{"lon", "number", "longitude of the point", true},
+			apiParam{"radiusKm", "number", "how far to look, 50 by default", false},
+		),
+		response: NearbyCity{}, list: true,
+	},
+	"GET /reverse": {
+		id: "reverseGeocode", summary: "Find the state and nearest city to a point",
+		query: []apiParam{
+			{"lat", "number", "latitude of the point", true},
+			{"lon", "number", "longitude of the point", true},
+		},
+		response: ReverseResponse{},
+	},
+	"GET /map/clusters": {
+		id: "listClusters", access: accessViewer,
+		summary: "Cluster cities, or a user's visits, for drawing on a map",
+		query: []apiParam{
+			{"zoom", "integer", "map zoom, from 0", true},
+			{"bbox", "string", "minLon,minLat,maxLon,maxLat", false},
+			{"userId", "integer", "cluster this user's visits instead of cities", false},
+		},
+		response: ClustersResponse{},
+	},
+	"GET /tiles/{layer}/{z}/{x}/{y}": {
+		id: "getTile", access: accessViewer,
+		summary: "Draw cities, states or a user's visits as a Mapbox Vector Tile",
+		query: []apiParam{
+			{"userId", "integer", "whose visits, for the visits layer", false},
+		},
+		response: "", contentType: tileContentType,
+	},
+	"POST /routes/optimize": {
+		id: "optimizeRoute", access: accessViewer,
+		summary: "Order cities, or a user's wishlist, into a short route",
+		body:    RouteRequest{}, response: RouteResponse{},
+	},
+	"GET /leaderboards/{metric}": {
+		id: "getLeaderboard", access: accessViewer,
+		summary: "Rank users by states, cities or distance",
+		query: withPage(
+			apiParam{"period", "string", "all, year or 30d", false},
+		),
+		response: LeaderboardResponse{},
+	},
+	"GET /stream": {
+		id: "streamEvents", access: accessViewer,
+		summary: "Follow visits as they happen, with server-sent events or a websocket",
+		query: []apiParam{
+			{"userId", "integer", "only these users' events, can be repeated", false},
+		},
+		response: "", contentType: "text/event-stream",
+	},
+
+	"POST /user/{userID}/visits": {
+		id: "createVisit", access: accessAuth,
+		summary: "Record a visit to a city",
+		body:    VisitRequest{}, status: http.StatusCreated, response: models.Visit{},
+	},
+	"POST /user/{userID}/visits/batch": {
+		id: "batchVisits", access: accessAuth,
+		summary: "Record and remove many visits at once",
+		body:    BatchRequest{}, response: BatchResponse{},
+	},
+	"DELETE /user/{userID}/visits/{visitID}": {
+		id: "deleteVisit", access: accessAuth, summary: "Remove a visit",
+		status: http.StatusNoContent,
+	},
+	"GET /user/{userID}/visits": {
+		id: "listVisitedCities", access: accessViewer,
+		summary: "List the cities a user has visited",
+		query:   pageParams, response: models.City{}, list: true,
+	},
+	"GET /user/{userID}/visits/states": {
+		id: "listVisitedStates", access: accessViewer,
+		summary: "List the states a user has visited",
+		query:   pageParams, response: VisitedState{}, list: true,
+	},
+	"GET /user/{userID}/visits/map.svg": {
+		id: "getVisitMapSVG", access: accessViewer,
+		summary:  "Draw a map of a user's visits as SVG",
+		response: "", contentType: "image/svg+xml",
+	},
+	"GET /user/{userID}/visits/map.png": {
+		id: "getVisitMapPNG", access: accessViewer,
+		summary:  "Draw a map of a user's visits as PNG",
+		response: "", contentType: "image/png",
+	},
+	"PUT /user/{userID}/visits/{visitID}/visibility": {
+		id: "setVisitVisibility", access: accessAuth,
+		summary: "Change who can see a visit",
+		body:    VisibilityRequest{}, response: models.Visit{},
+	},
+	"PUT /user/{userID}/visibility": {
+		id: "setUserVisibility", access: accessAuth,
+		summary: "Change who can see a user's visits by default",
+		body:    VisibilityRequest{}, response: models.User{},
+	},
+	"GET /user/{userID}/stats": {
+		id: "getUserStats", access: accessViewer,
+		summary: "Summarize everywhere a user has been", response: UserStats{},
+	},
+	"GET /user/{userID}/achievements": {
+		id: "listAchievements", access: accessViewer,
+		summary: "List achievements and a user's progress on them",
+		query:   pageParams, response: AchievementResponse{}, list: true,
+	},
+	"GET /user/{userID}/feed": {
+		id: "getFeed", access: accessAuth,
+		summary: "List visits by the users someone follows, newest first",
+		query: []apiParam{
+			{"limit", "integer", "how many to return", false},
+			{"cursor", "string", "nextCursor from the last page", false},
+		},
+		response: FeedResponse{},
+	},
+
+	"GET /user/{userID}/following": {
+		id: "listFollowing", summary: "List who a user follows",
+		query: pageParams, response: PublicUser{}, list: true,
+	},
+	"GET /user/{userID}/followers": {
+		id: "listFollowers", summary: "List who follows a user",
+		query: pageParams, response: PublicUser{}, list: true,
+	},
+	"POST /user/{userID}/following": {
+		id: "follow", access: accessAuth,
+		summary: "Follow someone, or ask to if their visits aren't public",
+		body:    FollowRequest{}, status: http.StatusCreated,
+		response: models.Follow{},
+	},
+	"DELETE /user/{userID}/following/{otherID}": {
+		id: "unfollow", access: accessAuth, summary: "Stop following someone",
+		status: http.StatusNoContent,
+	},
+	"GET /user/{userID}/follow-requests": {
+		id: "listFollowRequests", access: accessAuth,
+		summary: "List who has asked to follow a user",
+		query:   pageParams, response: PublicUser{}, list: true,
+	},
+	"POST /user/{userID}/follow-requests/{otherID}/accept": {
+		id: "acceptFollowRequest", access: accessAuth,
+		summary: "Let someone follow", response: models.Follow{},
+	},
+	"DELETE /user/{userID}/follow-requests/{otherID}": {
+		id: "declineFollowRequest", access: accessAuth,
+		summary: "Turn down a follow request", status: http.StatusNoContent,
+	},
+
+	"POST /user/{userID}/trips": {
+		id: "createTrip", access: accessAuth, summary: "Group visits into a trip",
+		body: TripRequest{}, status: http.StatusCreated, response: TripResponse{},
+	},
+	"GET /user/{userID}/trips": {
+		id: "listTrips", access: accessViewer, summary: "List a user's trips",
+		query: pageParams, response: TripResponse{}, list: true,
+	},
+	"GET /user/{userID}/trips/{tripID}": {
+		id: "getTrip", access: accessViewer, summary: "Get a trip",
+		response: TripResponse{},
+	},
+	"PUT /user/{userID}/trips/{tripID}": {
+		id: "updateTrip", access: accessAuth, summary: "Change a trip",
+		body: TripRequest{}, response: TripResponse{},
+	},
+	"DELETE /user/{userID}/trips/{tripID}": {
+		id: "deleteTrip", access: accessAuth, summary: "Remove a trip",
+		status: http.StatusNoContent,
+	},
+	"GET /user/{userID}/trip-suggestions": {
+		id: "listTripSuggestions", access: accessAuth,
+		summary: "Suggest trips from runs of visits close together in time",
+		query: withPage(
+			apiParam{"gapDays", "integer", "days between visits that start a new trip", false},
+			apiParam{"minVisits", "integer", "fewest visits in a trip", false},
+		),
+		response: TripSuggestion{}, list: true,
+	},
+
+	"POST /user/{userID}/wishlist": {
+		id: "addWishlistEntry", access: accessAuth,
+		summary: "Add a city a user wants to visit",
+		body:    WishlistRequest{}, status: http.StatusCreated,
+		response: models.WishlistEntry{},
+	},
+	"GET /user/{userID}/wishlist": {
+		id: "listWishlist", access: accessViewer,
+		summary: "List the cities a user wants to visit",
+		query:   pageParams, response: models.WishlistEntry{}, list: true,
+	},
+	"GET /user/{userID}/wishlist/suggested": {
+		id: "listSuggestedWishlist", access: accessAuth,
+		summary: "List a user's wishlist nearest first from their last visit",
+		query:   pageParams, response: SuggestedWishlistEntry{}, list: true,
+	},
+	"DELETE /user/{userID}/wishlist/{entryID}": {
+		id: "deleteWishlistEntry", access: accessAuth,
+		summary: "Remove a city from a wishlist", status: http.StatusNoContent,
+	},
+	"POST /user/{userID}/wishlist/{entryID}/visit": {
+		id: "visitWishlistEntry", access: accessAuth,
+		summary: "Record a visit to a wishlist city and take it off the list",
+		status:  http.StatusCreated, response: models.Visit{},
+	},
+
+	"POST /user/{userID}/share-links": {
+		id: "createShareLink", access: accessAuth,
+		summary: "Make a link anyone can use to see a user's visits",
+		status:  http.StatusCreated, response: models.ShareLink{},
+	},
+	"GET /user/{userID}/share-links": {
+		id: "listShareLinks", access: accessAuth,
+		summary: "List a user's share links",
+		query:   pageParams, response: models.ShareLink{}, list: true,
+	},
+	"DELETE /user/{userID}/share-links/{linkID}": {
+		id: "revokeShareLink", access: accessAuth,
+		summary: "Stop a share link from working", status: http.StatusNoContent,
+	},
+	"GET /shared/{token}/visits": {
+		id: "listSharedCities", summary: "List the cities visited, by share link",
+		query: pageParams, response: models.City{}, list: true,
+	},
+	"GET /shared/{token}/visits/states": {
+		id: "listSharedStates", summary: "List the states visited, by share link",
+		query: pageParams, response: VisitedState{}, list: true,
+	},
+
+	"POST /user/{userID}/webhooks": {
+		id: "createWebhook", access: accessAuth,
+		summary: "Have visit events posted to a URL",
+		body:    WebhookRequest{}, status: http.StatusCreated,
+		response: NewWebhookResponse{},
+	},
+	"GET /user/{userID}/webhooks": {
+		id: "listWebhooks", access: accessAuth, summary: "List a user's webhooks",
+		query: pageParams, response: models.Webhook{}, list: true,
+	},
+	"DELETE /user/{userID}/webhooks/{webhookID}": {
+		id: "deleteWebhook", access: accessAuth, summary: "Remove a webhook",
+		status: http.StatusNoContent,
+	},
+	"GET /user/{userID}/webhooks/{webhookID}/deliveries": {
+		id: "listWebhookDeliveries", access: accessAuth,
+		summary: "List the events sent to a webhook, newest first",
+		query: withPage(
+			apiParam{"status", "string", "pending, delivered or failed", false},
+		),
+		response: models.WebhookDelivery{}, list: true,
+	},
+}
+
+// path params are strings unless they're here or end in ID
+var integerPathParams = map[string]bool{"z": true, "x": true}
+
+// apiTag groups operations by the first part of the path after the user
+func apiTag(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 2 && parts[0] == "user" {
+		return parts[2]
+	}
+	if parts[0] == "" {
+		return "server"
+	}
+	return parts[0]
+}
+
+func (op *apiOperation) build(
+	schemas *openapi.Schemas, method, path string, pathParams []string,
+) map[string]interface{} {
+	params := []map[string]interface{}{}
+	for _, name := range pathParams {
+		typ := "string"
+		if strings.HasSuffix(name, "ID") || integerPathParams[name] {
+			typ = "integer"
+		}
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true,
+			"schema": openapi.Schema{"type": typ},
+		})
+	}
+	for _, p := range op.query {
+		params = append(params, map[string]interface{}{
+			"name": p.name, "in": "query", "required": p.required,
+			"description": p.description,
+			"schema":      openapi.Schema{"type": p.typ},
+		})
+	}
+	if method == http.MethodPost {
+		params = append(params, map[string]interface{}{
+			"name": IdempotencyHeader, "in": "header", "required": false,
+			"description": "retries with the same key get the first response " +
+				"instead of making the change again",
+			"schema": openapi.Schema{
+				"type": "string", "maxLength": maxIdempotencyKeyLength},
+		})
+	}
+
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if op.response != nil {
+		var schema openapi.Schema
+		contentType := op.contentType
+		if contentType == "" {
+			contentType = "application/json"
+			schema = schemas.Of(op.response)
+			if op.list {
+				schema = openapi.Schema{"allOf": []openapi.Schema{
+					schemas.Of(MetaResponse{}),
+					{"properties": map[string]openapi.Schema{
+						"data": {"type": "array", "items": schema},
+					}},
+				}}
+			}
+		} else if contentType == "text/plain" || contentType == "text/html" {
+			schema = openapi.Schema{"type": "string"}
+		} else {
+			schema = openapi.Schema{"type": "string", "format": "binary"}
+		}
+		success["content"] = map[string]interface{}{
+			contentType: map[string]interface{}{"schema": schema},
+		}
+	}
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": openapi.Ref("Error"),
+				},
+			},
+		}
+	}
+	// every route is rate limited
+	tooMany := errorResponse("Too many requests were made too quickly")
+	tooMany["headers"] = map[string]interface{}{
+		"Retry-After": map[string]interface{}{
+			"description": "how many seconds to wait before trying again",
+			"schema":      openapi.Schema{"type": "integer"},
+		},
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"429":                tooMany,
+		"default":            errorResponse("Something was wrong with the request"),
+	}
+
+	operation := map[string]interface{}{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"tags":        []string{apiTag(path)},
+		"parameters":  params,
+		"responses":   responses,
+	}
+	switch op.access {
+	case accessAuth:
+		operation["security"] = []map[string][]string{{"basicAuth": {}}}
+		responses["401"] = errorResponse("Credentials are needed")
+	case accessViewer:
+		// logging in is optional
+		operation["security"] = []map[string][]string{{"basicAuth": {}}, {}}
+		responses["401"] = errorResponse("The credentials given were wrong")
+	}
+	if op.body != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemas.Of(op.body),
+				},
+			},
+		}
+	}
+	return operation
+}
+
+// buildOpenAPI documents the routes. Routes missing from apiOperations are
+// left out.
+func buildOpenAPI(routes gin.RoutesInfo) map[string]interface{} {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	schemas := openapi.NewSchemas()
+	schemas.Define("Error", openapi.Schema{
+		"type": "array",
+		"items": openapi.Schema{
+			"type": "object",
+			"properties": map[string]openapi.Schema{
+				"error": {
+					"type": "object",
+					"properties": map[string]openapi.Schema{
+						"message": {"type": "string"},
+						"detail":  {"type": "string"},
+						// field is only there for invalid request data
+						"field": {"type": "string"},
+					},
+					"required": []string{"message", "detail"},
+				},
+			},
+			"required": []string{"error"},
+		},
+	})
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		path, params := openapi.Path(route.Path)
+		op, ok := apiOperations[route.Method+" "+path]
+		if !ok {
+			continue
+		}
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op.build(schemas, route.Method, path, params)
+	}
+	return map[string]interface{}{
+		"openapi": openapi.Version,
+		"info": map[string]interface{}{
+			"title":   "RestApiProject",
+			"version": "1.0.0",
+			"description": "Track the cities and states users have visited " +
+				"and share them with other users.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.Components(),
+			"securitySchemes": map[string]interface{}{
+				"basicAuth": map[string]interface{}{
+					"type": "http", "scheme": "basic",
+					"description": "email and password",
+				},
+			},
+		},
+	}
+}
+
+// getOpenAPIHandler serves the spec for the routes on the engine. It's made
+// the first time it's asked for, once every route has been added.
+func getOpenAPIHandler(r *gin.Engine) gin.HandlerFunc {
+	var once sync.Once
+	var spec []byte
+	var specErr error
+	return func(c *gin.Context) {
+		once.Do(func() {
+			spec, specErr = json.Marshal(buildOpenAPI(r.Routes()))
+		})
+		if specErr != nil {
+			jsonErrorStatus(c, http.StatusInternalServerError,
+				"error building spec", specErr)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+	}
+}
@@ -0,0 +1,143 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// refs finds every $ref in the document
+func refs(v interface{}) []string {
+	found := []string{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if ref, ok := child.(string); ok && k == "$ref" {
+				found = append(found, ref)
+			}
+			found = append(found, refs(child)...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			found = append(found, refs(child)...)
+		}
+	}
+	return found
+}
+
+var _ = Describe("OpenAPI", func() {
+	var (
+		db   *gorm.DB
+		r    *gin.Engine
+		ts   *httptest.Server
+		spec map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		db, r = newTestRouter(nil)
+		ts = httptest.NewServer(r)
+		spec = map[string]interface{}{}
+		Ω(getTestJSON(ts, "/openapi.json", &spec)).Should(Equal(200))
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	schema := func(name string) map[string]interface{} {
+		schemas := spec["components"].(map[string]interface{})["schemas"]
+		s, ok := schemas.(map[string]interface{})[name]
+		Ω(ok).Should(BeTrue(), name)
+		return s.(map[string]interface{})
+	}
+	properties := func(name string) []string {
+		names := []string{}
+		for k := range schema(name)["properties"].(map[string]interface{}) {
+			names = append(names, k)
+		}
+		return names
+	}
+
+	It("is OpenAPI 3", func() {
+		Ω(spec["openapi"]).Should(HavePrefix("3."))
+		Ω(spec["info"]).Should(HaveKey("title"))
+	})
+
+	It("documents every route", func() {
+		paths := spec["paths"].(map[string]interface{})
+		Ω(r.Routes()).ShouldNot(BeEmpty())
+		for _, route := range r.Routes() {
+			path := route.Path
+			for _, part := range strings.Split(path, "/") {
+				if strings.HasPrefix(part, ":") {
+					path = strings.Replace(path, part, "{"+part[1:]+"}", 1)
+				}
+			}
+			name := route.Method + " " + route.Path
+			Ω(paths).Should(HaveKey(path), name)
+			ops := paths[path].(map[string]interface{})
+			Ω(ops).Should(HaveKey(strings.ToLower(route.Method)), name)
+			op := ops[strings.ToLower(route.Method)].(map[string]interface{})
+			Ω(op["summary"]).ShouldNot(BeEmpty(), name)
+			Ω(op["responses"]).Should(HaveKey("default"), name)
+		}
+	})
+
+	It("has unique operation ids", func() {
+		seen := map[string]bool{}
+		for _, ops := range spec["paths"].(map[string]interface{}) {
+			for _, op := range ops.(map[string]interface{}) {
+				id := op.(map[string]interface{})["operationId"].(string)
+				Ω(seen).ShouldNot(HaveKey(id))
+				seen[id] = true
+			}
+		}
+	})
+
+	It("has every schema it refers to", func() {
+		all := refs(spec)
+		Ω(all).ShouldNot(BeEmpty())
+		for _, ref := range all {
+			Ω(ref).Should(HavePrefix("#/components/schemas/"))
+			schema(strings.TrimPrefix(ref, "#/components/schemas/"))
+		}
+	})
+
+	It("describes the request bodies, envelope and errors", func() {
+		Ω(properties("VisitRequest")).
+			Should(ConsistOf("city", "state", "visibility"))
+		Ω(properties("MetaResponse")).
+			Should(ConsistOf("limit", "offset", "count", "data"))
+		Ω(properties("City")).Should(ContainElement("stateId"))
+		Ω(properties("City")).ShouldNot(ContainElement("LatSin"))
+		Ω(schema("Error")["type"]).Should(Equal("array"))
+
+		paths := spec["paths"].(map[string]interface{})
+		post := paths["/user/{userID}/visits"].(map[string]interface{})["post"]
+		body, err := json.Marshal(post)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(body)).Should(ContainSubstring(`"#/components/schemas/VisitRequest"`))
+		Ω(post.(map[string]interface{})["responses"]).Should(HaveKey("201"))
+	})
+
+	It("marks who has to log in", func() {
+		paths := spec["paths"].(map[string]interface{})
+		feed := paths["/user/{userID}/feed"].(map[string]interface{})["get"]
+		Ω(feed).Should(HaveKey("security"))
+		Ω(feed.(map[string]interface{})["responses"]).Should(HaveKey("401"))
+		state := paths["/state/{stateID}/cities"].(map[string]interface{})["get"]
+		Ω(state).ShouldNot(HaveKey("security"))
+	})
+
+	It("serves a docs page", func() {
+		status, body := doAuthRequest("GET", ts.URL+"/docs", "", "", "")
+		Ω(status).Should(Equal(200))
+		Ω(string(body)).Should(ContainSubstring(`fetch("openapi.json")`))
+	})
+})
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// rateLimits turns the configured limits into token bucket ones. Routes
+// whose limit is turned off get a zero Limit.
+func rateLimits(cfg *conf.Config) map[string]ratelimit.Limit {
+	limits := map[string]ratelimit.Limit{}
+	for route, limit := range cfg.RateLimits {
+		if limit.Requests <= 0 || limit.PerSeconds <= 0 {
+			limits[route] = ratelimit.Limit{}
+			continue
+		}
+		burst := limit.Burst
+		if burst == 0 {
+			burst = limit.Requests
+		}
+		limits[route] = ratelimit.Limit{
+			Rate:  float64(limit.Requests) / float64(limit.PerSeconds),
+			Burst: burst,
+		}
+	}
+	return limits
+}
+
+// rateLimitAddress is the address the request is counted against before
+// its credentials are checked. It's the one connecting, since headers like
+// X-Forwarded-For can say anything.
+func rateLimitAddress(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// wholeSeconds rounds up, so that clients don't come back too soon
+func wholeSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// rateLimit turns clients away with a 429 once they've used up their
+// requests to a route. Each address has a bucket for each route, with the
+// route's own limit or the default one, and so does each user once their
+// credentials are checked. A request needs both to have some left.
+//
+// The address is counted first, so wrong passwords and made up users use
+// up the sender's requests, not the user's, and cost at most one password
+// hash per request the address is allowed.
+func rateLimit(
+	cfg *conf.Config, db *gorm.DB, limiter ratelimit.Limiter,
+) gin.HandlerFunc {
+	limits := rateLimits(cfg)
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		limit, ok := limits[route]
+		if !ok {
+			limit, ok = limits[conf.DefaultRateLimit]
+		}
+		if !ok || limit.Burst == 0 {
+			c.Next()
+			return
+		}
+		result, err := limiter.Take(rateLimitAddress(c)+" "+route, limit)
+		if err == nil && result.Allowed {
+			if user, _, authErr := authenticateOnce(c, db); authErr == nil {
+				client := "user:" + strconv.FormatUint(uint64(user.ID), 10)
+				var userResult ratelimit.Result
+				userResult, err = limiter.Take(client+" "+route, limit)
+				// the headers are for whichever bucket is emptier
+				if !userResult.Allowed || userResult.Remaining < result.Remaining {
+					result = userResult
+				}
+			}
+		}
+		if err != nil {
+			// better to let everyone through than no one
+			log.Errorln("could not check rate limit:", err)
+			c.Next()
+			return
+		}
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		reset := time.Now().Unix() + wholeSeconds(result.Reset)
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		if !result.Allowed {
+			c.Header("Retry-After",
+				strconv.FormatInt(wholeSeconds(result.RetryAfter), 10))
+			jsonErrorStatus(c, http.StatusTooManyRequests,
+				"too many requests, try again later", nil)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,180 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rate limiting", func() {
+	var (
+		db *gorm.DB
+		r  *gin.Engine
+		ts *httptest.Server
+	)
+
+	request := func(method, url, email, password, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		Ω(err).ShouldNot(HaveOccurred())
+		if email != "" {
+			req.SetBasicAuth(email, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		getRespBody(resp)
+		return resp
+	}
+	get := func(url string) *http.Response {
+		return request("GET", url, "", "", "")
+	}
+	// getFrom gets the url as if from another address
+	getFrom := func(addr, url, email, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.RemoteAddr = addr + ":1234"
+		if email != "" {
+			req.SetBasicAuth(email, password)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		db, r = newTestRouter(func(cfg *conf.Config) {
+			cfg.RateLimits = map[string]conf.RateLimit{
+				conf.DefaultRateLimit:       {Requests: 3, PerSeconds: 60},
+				"POST /user/:userID/visits": {Requests: 1, PerSeconds: 60},
+				"GET /cities/near":          {Requests: 0},
+			}
+		})
+		ts = httptest.NewServer(r)
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("says how many requests are left", func() {
+		for i := 2; i >= 0; i-- {
+			resp := get("/state/1/cities")
+			Ω(resp.StatusCode).Should(Equal(200))
+			Ω(resp.Header.Get("X-RateLimit-Limit")).Should(Equal("3"))
+			Ω(resp.Header.Get("X-RateLimit-Remaining")).Should(Equal(strconv.Itoa(i)))
+			reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(reset).Should(BeNumerically(">", time.Now().Unix()))
+		}
+	})
+
+	It("turns clients away once they've used them up", func() {
+		for i := 0; i < 3; i++ {
+			Ω(get("/state/1/cities").StatusCode).Should(Equal(200))
+		}
+		req, _ := http.NewRequest("GET", ts.URL+"/state/1/cities", nil)
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resp.StatusCode).Should(Equal(429))
+		Ω(resp.Header.Get("Retry-After")).Should(Equal("20"))
+		Ω(resp.Header.Get("X-RateLimit-Remaining")).Should(Equal("0"))
+
+		var out []struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		Ω(json.Unmarshal(getRespBody(resp), &out)).Should(Succeed())
+		Ω(out).Should(HaveLen(1))
+		Ω(out[0].Error.Message).Should(ContainSubstring("too many requests"))
+	})
+
+	It("uses each route's own limit and bucket", func() {
+		body := `{"city": "Winterfell", "state": "WS"}`
+		resp := request("POST", "/user/2/visits", "arya@winterfell.net", "needle", body)
+		Ω(resp.StatusCode).Should(Equal(201))
+		Ω(resp.Header.Get("X-RateLimit-Limit")).Should(Equal("1"))
+		resp = request("POST", "/user/2/visits", "arya@winterfell.net", "needle", body)
+		Ω(resp.StatusCode).Should(Equal(429))
+		Ω(resp.Header.Get("Retry-After")).Should(Equal("60"))
+
+		var count int
+		db.Table("visits").Count(&count)
+		Ω(count).Should(Equal(1))
+		Ω(get("/user/2/visits").StatusCode).Should(Equal(200))
+	})
+
+	It("doesn't limit routes whose limit is turned off", func() {
+		for i := 0; i < 5; i++ {
+			resp := get("/cities/near?lat=30&lon=-80")
+			Ω(resp.StatusCode).Should(Equal(200))
+			Ω(resp.Header.Get("X-RateLimit-Limit")).Should(BeEmpty())
+		}
+	})
+
+	It("counts the address before checking credentials", func() {
+		for i := 0; i < 3; i++ {
+			get("/user/2/stats")
+		}
+		Ω(get("/user/2/stats").StatusCode).Should(Equal(429))
+		resp := request("GET", "/user/2/stats", "arya@winterfell.net", "needle", "")
+		Ω(resp.StatusCode).Should(Equal(429))
+	})
+
+	It("doesn't give made up users their own requests", func() {
+		for i := 0; i < 3; i++ {
+			resp := request("GET", "/user/2/stats",
+				"nobody"+strconv.Itoa(i)+"@winterfell.net", "wolf", "")
+			Ω(resp.StatusCode).Should(Equal(401))
+		}
+		resp := request("GET", "/user/2/stats", "nobody@winterfell.net", "wolf", "")
+		Ω(resp.StatusCode).Should(Equal(429))
+	})
+
+	It("counts users across addresses once they've logged in", func() {
+		for i := 0; i < 3; i++ {
+			w := getFrom("10.0.0."+strconv.Itoa(i), "/user/2/stats",
+				"arya@winterfell.net", "needle")
+			Ω(w.Code).Should(Equal(200))
+			Ω(w.Header().Get("X-RateLimit-Remaining")).Should(Equal(strconv.Itoa(2 - i)))
+		}
+		w := getFrom("10.0.0.3", "/user/2/stats", "arya@winterfell.net", "needle")
+		Ω(w.Code).Should(Equal(429))
+		// but not without credentials
+		Ω(getFrom("10.0.0.3", "/user/2/stats", "", "").Code).Should(Equal(200))
+	})
+
+	It("doesn't let wrong passwords use up the user's requests", func() {
+		for i := 0; i < 4; i++ {
+			getFrom("10.0.0.1", "/user/2/stats", "arya@winterfell.net", "wolf")
+		}
+		w := getFrom("10.0.0.2", "/user/2/stats", "arya@winterfell.net", "needle")
+		Ω(w.Code).Should(Equal(200))
+		Ω(w.Header().Get("X-RateLimit-Remaining")).Should(Equal("2"))
+	})
+
+	It("goes by the connecting address, not X-Forwarded-For", func() {
+		for i := 0; i < 4; i++ {
+			req, _ := http.NewRequest("GET", ts.URL+"/state/1/cities", nil)
+			req.Header.Set("X-Forwarded-For", "10.0.0."+strconv.Itoa(i))
+			req.Header.Set("X-Real-IP", "10.0.1."+strconv.Itoa(i))
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			getRespBody(resp)
+			if i < 3 {
+				Ω(resp.StatusCode).Should(Equal(200))
+			} else {
+				Ω(resp.StatusCode).Should(Equal(429))
+			}
+		}
+	})
+})
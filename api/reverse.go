@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// ReverseResponse is what's at a point
+type ReverseResponse struct {
+	// State the point is in, or null if it isn't inside any known state
+	State *models.State `json:"state"`
+	// City nearest the point. When the state is known the city is in it.
+	City *models.City `json:"city"`
+	// DistanceKm from the point to the city
+	DistanceKm *float64 `json:"distanceKm"`
+}
+
+// nearestCity to the point, only looking in the state unless stateID is 0.
+// Returns nil if there aren't any cities to look in.
+func nearestCity(db *gorm.DB, p geo.Point, stateID uint) (*models.City, error) {
+	latSin, latCos, lonSin, lonCos := geo.LatLonSinCos(p.Lat, p.Lon)
+	// the cosine of the angle between the point and the city, which is
+	// biggest for the closest one
+	q := db.Order(gorm.Expr(
+		"lat_sin * ? + lat_cos * ? * (lon_cos * ? + lon_sin * ?) DESC",
+		latSin, latCos, lonCos, lonSin))
+	if stateID != 0 {
+		q = q.Where("state_id = ?", stateID)
+	}
+	var city models.City
+	if q = q.First(&city); q.RecordNotFound() {
+		return nil, nil
+	}
+	return &city, q.Error
+}
+
+// reverseGeocode finds the state a point is in and the city to snap it to.
+// Near a border the nearest city can be over the line, so the city is the
+// nearest one in the state when the state is known.
+func reverseGeocode(
+	db *gorm.DB, states *geo.RegionIndex, p geo.Point,
+) (*models.State, *models.City, error) {
+	var state *models.State
+	if region := states.Find(p); region != nil {
+		state = &models.State{}
+		q := db.Where("abbrev = ?", region.Key).First(state)
+		if q.RecordNotFound() {
+			state = nil
+		} else if q.Error != nil {
+			return nil, nil, q.Error
+		}
+	}
+	if state != nil {
+		city, err := nearestCity(db, p, state.ID)
+		if city != nil || err != nil {
+			return state, city, err
+		}
+	}
+	city, err := nearestCity(db, p, 0)
+	return state, city, err
+}
+
+// getCoordinate parses the `lat` or `lon` query param and checks it's in
+// range
+// sends a json error response and returns false if it can't
+func getCoordinate(c *gin.Context, name string) (float64, bool) {
+	x, errs := validate.Float(name, c.Query(name), name)
+	if errs != nil {
+		jsonFieldErrors(c, errs)
+		return 0, false
+	}
+	return x, true
+}
+
+// getReverseHandler finds what's at the `lat` and `lon` in the query
+func getReverseHandler(
+	cfg *conf.Config, db *gorm.DB, states *geo.RegionIndex,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lat, ok := getCoordinate(c, "lat")
+		if !ok {
+			return
+		}
+		lon, ok := getCoordinate(c, "lon")
+		if !ok {
+			return
+		}
+		p := geo.Point{Lat: lat, Lon: lon}
+		state, city, err := reverseGeocode(db, states, p)
+		if err != nil {
+			jsonError(c, "error looking up location", err)
+			return
+		}
+		res := ReverseResponse{State: state, City: city}
+		if city != nil {
+			d := geo.Distance(p, geo.Point{Lat: city.Lat, Lon: city.Lon})
+			res.DistanceKm = &d
+		}
+		c.JSON(http.StatusOK, &res)
+	}
+}
@@ -0,0 +1,71 @@
+package api_test
+
+import (
+	"net/http/httptest"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reverse", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	reverse := func(query string) ReverseResponse {
+		var res ReverseResponse
+		Ω(getTestJSON(ts, "/reverse?"+query, &res)).Should(Equal(200))
+		return res
+	}
+
+	It("finds the state and nearest city", func() {
+		res := reverse("lat=33&lon=-80")
+		Ω(res.State.Abbrev).Should(Equal("WS"))
+		Ω(res.City.Name).Should(Equal("Kings Landing"))
+		Ω(*res.DistanceKm).Should(BeNumerically("~", 25.67, 0.01))
+	})
+
+	It("snaps to a city in the state across a border", func() {
+		// Winterfell is much closer, but it's in Westeros
+		res := reverse("lat=35&lon=-73")
+		Ω(res.State.Abbrev).Should(Equal("ES"))
+		Ω(res.City.Name).Should(Equal("Qarth"))
+	})
+
+	It("finds the nearest city outside every state", func() {
+		res := reverse("lat=0&lon=-40")
+		Ω(res.State).Should(BeNil())
+		Ω(res.City.Name).Should(Equal("Kings Landing"))
+	})
+
+	It("finds the nearest city in a state with none of its own", func() {
+		res := reverse("lat=0&lon=0")
+		Ω(res.State).Should(BeNil())
+		Ω(res.City.Name).Should(Equal("Qarth"))
+	})
+
+	DescribeTable("rejects bad coordinates",
+		func(query string) {
+			var out interface{}
+			Ω(getTestJSON(ts, "/reverse?"+query, &out)).Should(Equal(400))
+		},
+		Entry("no lat", "lon=0"),
+		Entry("no lon", "lat=0"),
+		Entry("not a number", "lat=north&lon=0"),
+		Entry("lat too big", "lat=91&lon=0"),
+		Entry("lon too small", "lat=0&lon=-181"),
+	)
+})
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/geo/route"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// the most cities a route can go through
+	maxRouteCities = 200
+	// how long improving a route takes when the request doesn't say
+	defaultRouteBudget = 200 * time.Millisecond
+	// the longest a request can ask to spend on a route
+	maxRouteBudget = 2 * time.Second
+)
+
+// RouteRequest is the struct for finding a short route through cities.
+// Either CityIDs or WishlistUserID says which cities to go through.
+type RouteRequest struct {
+	CityIDs []uint `json:"cityIds"`
+	// WishlistUserID routes through the cities on the user's wishlist
+	WishlistUserID uint `json:"wishlistUserId"`
+	// StartCityID and EndCityID fix where the route starts and ends. They
+	// are added to the cities if they aren't there already.
+	StartCityID uint `json:"startCityId"`
+	EndCityID   uint `json:"endCityId"`
+	// TimeBudgetMs is how long to spend improving the route
+	TimeBudgetMs int `json:"timeBudgetMs" validate:"min=0"`
+}
+
+// RouteResponse is the cities in the order to visit them
+type RouteResponse struct {
+	CityIDs    []uint        `json:"cityIds"`
+	Cities     []models.City `json:"cities"`
+	DistanceKm float64       `json:"distanceKm"`
+}
+
+// getRouteCityIDs works out which cities the request wants to go through
+// sends a json error response and returns nil if it can't
+func getRouteCityIDs(c *gin.Context, db *gorm.DB, req *RouteRequest) []uint {
+	ids := req.CityIDs
+	if req.WishlistUserID != 0 {
+		user := lookupUser(c, db, req.WishlistUserID)
+		if user == nil {
+			return nil
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return nil
+		}
+		if !canSee(audience, user.DefaultVisibility()) {
+			jsonErrorStatus(c, http.StatusForbidden,
+				"you can't see that user's wishlist", nil)
+			return nil
+		}
+		entries, err := getWishlist(db, user)
+		if err != nil {
+			jsonError(c, "error looking up wishlist", err)
+			return nil
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.CityID)
+		}
+	}
+	// each city only needs visiting once
+	seen := map[uint]bool{}
+	unique := []uint{}
+	for _, id := range append(ids, req.StartCityID, req.EndCityID) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	if len(unique) == 0 {
+		jsonError(c, "no cities to route through", nil)
+		return nil
+	}
+	if len(unique) > maxRouteCities {
+		jsonError(c, "too many cities to route through", nil)
+		return nil
+	}
+	return unique
+}
+
+// indexOf the id, or -1 if it isn't there
+func indexOf(ids []uint, id uint) int {
+	for i, other := range ids {
+		if other == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func getOptimizeRouteHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RouteRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		ids := getRouteCityIDs(c, db, &req)
+		if ids == nil {
+			return
+		}
+		var found []models.City
+		if err := db.Where("id IN (?)", ids).Find(&found).Error; err != nil {
+			jsonError(c, "error looking up cities", err)
+			return
+		}
+		if len(found) != len(ids) {
+			jsonErrorStatus(c, http.StatusNotFound, "city not found", nil)
+			return
+		}
+		// keep the cities in the order they were asked for, so the same
+		// request always gets the same route
+		cities := make([]models.City, len(ids))
+		for _, city := range found {
+			cities[indexOf(ids, city.ID)] = city
+		}
+		points := make([]geo.Point, len(cities))
+		for i, city := range cities {
+			points[i] = geo.Point{Lat: city.Lat, Lon: city.Lon}
+		}
+
+		opts := route.NewOptions()
+		if req.StartCityID != 0 {
+			opts.Start = indexOf(ids, req.StartCityID)
+		}
+		if req.EndCityID != 0 {
+			opts.End = indexOf(ids, req.EndCityID)
+		}
+		opts.TimeBudget = defaultRouteBudget
+		if req.TimeBudgetMs > 0 {
+			opts.TimeBudget = time.Duration(req.TimeBudgetMs) * time.Millisecond
+		}
+		if opts.TimeBudget > maxRouteBudget {
+			opts.TimeBudget = maxRouteBudget
+		}
+		order := route.Optimize(points, opts)
+
+		res := RouteResponse{
+			CityIDs:    make([]uint, len(order)),
+			Cities:     make([]models.City, len(order)),
+			DistanceKm: route.Length(points, order),
+		}
+		for i, index := range order {
+			res.CityIDs[i] = cities[index].ID
+			res.Cities[i] = cities[index]
+		}
+		c.JSON(http.StatusOK, &res)
+	}
+}
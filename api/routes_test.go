@@ -0,0 +1,97 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routes", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	optimize := func(email, password, body string) (int, RouteResponse) {
+		status, resBody := doAuthRequest(
+			"POST", ts.URL+"/routes/optimize", email, password, body)
+		var res RouteResponse
+		if status == 200 {
+			Ω(json.Unmarshal(resBody, &res)).Should(Succeed())
+		}
+		return status, res
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("orders the cities to go the shortest way", func() {
+		// Winterfell, Kings Landing, Qarth
+		status, res := optimize("", "", `{"cityIds": [3, 2, 1]}`)
+		Ω(status).Should(Equal(200))
+		Ω(res.CityIDs).Should(Equal([]uint{3, 1, 2}))
+		Ω(res.Cities[0].Name).Should(Equal("Qarth"))
+		Ω(res.DistanceKm).Should(BeNumerically("~", 10062.6+285.16, 1))
+	})
+
+	It("keeps a fixed start and end", func() {
+		status, res := optimize("", "", `{"cityIds": [1, 2], "endCityId": 1}`)
+		Ω(status).Should(Equal(200))
+		Ω(res.CityIDs).Should(Equal([]uint{2, 1}))
+
+		status, res = optimize("", "",
+			`{"cityIds": [2], "startCityId": 1, "endCityId": 3}`)
+		Ω(status).Should(Equal(200))
+		Ω(res.CityIDs).Should(Equal([]uint{1, 2, 3}))
+	})
+
+	It("routes through a wishlist", func() {
+		for _, body := range []string{
+			`{"city": "Qarth", "state": "ES"}`,
+			`{"city": "Kings Landing", "state": "WS"}`,
+		} {
+			status, _ := doAuthRequest("POST", ts.URL+"/user/2/wishlist",
+				"arya@winterfell.net", "needle", body)
+			Ω(status).Should(Equal(201))
+		}
+		status, res := optimize("", "",
+			`{"wishlistUserId": 2, "startCityId": 1}`)
+		Ω(status).Should(Equal(200))
+		Ω(res.CityIDs).Should(Equal([]uint{1, 2, 3}))
+
+		Ω(db.Model(&models.User{}).Where("id = ?", 2).
+			Update("visibility", models.VisibilityPrivate).Error).
+			ShouldNot(HaveOccurred())
+		status, _ = optimize("sansa@winterfell.net", "lemoncakes",
+			`{"wishlistUserId": 2}`)
+		Ω(status).Should(Equal(403))
+		status, res = optimize("arya@winterfell.net", "needle",
+			`{"wishlistUserId": 2}`)
+		Ω(status).Should(Equal(200))
+		Ω(res.CityIDs).Should(HaveLen(2))
+	})
+
+	It("rejects bad requests", func() {
+		status, _ := optimize("", "", `{"cityIds": []}`)
+		Ω(status).Should(Equal(400))
+		status, _ = optimize("", "", `{"cityIds": [1, 99]}`)
+		Ω(status).Should(Equal(404))
+		status, _ = optimize("", "", `{"cityIds": [1], "timeBudgetMs": -1}`)
+		Ω(status).Should(Equal(400))
+		status, _ = optimize("", "", `{"wishlistUserId": 99}`)
+		Ω(status).Should(Equal(400))
+	})
+})
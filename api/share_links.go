@@ -0,0 +1,153 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// shareLinkAudience is anyone holding one of the user's share links. They
+// see what followers see, and visits left at the user's default even when
+// that's private, since making the link is the user choosing to share their
+// map. Visits marked private themselves stay hidden.
+const shareLinkAudience = "link"
+
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// look up the owner of the share link in the path
+// sends a json error response and returns nil if the link is invalid
+func getShareLinkUser(c *gin.Context, db *gorm.DB) *models.User {
+	var link models.ShareLink
+	q := db.Where("token_hash = ?", hashShareToken(c.Param("token"))).
+		First(&link)
+	if q.RecordNotFound() {
+		jsonErrorStatus(c, http.StatusNotFound, "share link not found", nil)
+		return nil
+	} else if err := q.Error; err != nil {
+		jsonError(c, "error looking up share link", err)
+		return nil
+	}
+	return lookupUser(c, db, link.UserID)
+}
+
+func getNewShareLinkHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		token, err := newShareToken()
+		if err != nil {
+			jsonErrorStatus(c, http.StatusInternalServerError,
+				"error creating share link", err)
+			return
+		}
+		link := models.ShareLink{
+			UserID:    user.ID,
+			TokenHash: hashShareToken(token),
+		}
+		if err := db.Create(&link).Error; err != nil {
+			jsonError(c, "error saving share link", err)
+			return
+		}
+		link.Token = token
+		c.JSON(http.StatusCreated, &link)
+	}
+}
+
+func getShareLinksHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		version, err := rowsVersion(db, "share_links", "user_id = ?", user.ID)
+		if err != nil {
+			jsonError(c, "error looking up share links", err)
+			return
+		}
+		if notModified(c, version) {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		var count int
+		links := []models.ShareLink{}
+		q := db.Model(&models.ShareLink{}).Where("user_id = ?", user.ID)
+		if err := q.Count(&count).Error; err != nil {
+			jsonError(c, "error counting share links", err)
+			return
+		}
+		if err := q.Limit(limit).Offset(offset).Find(&links).Error; err != nil {
+			jsonError(c, "error looking up share links", err)
+			return
+		}
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(count), links,
+		})
+	}
+}
+
+func getRevokeShareLinkHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		linkID, ok := getPathID(c, "linkID")
+		if !ok {
+			return
+		}
+		var link models.ShareLink
+		q := db.Where("id = ? AND user_id = ?", linkID, user.ID).First(&link)
+		if q.RecordNotFound() {
+			jsonErrorStatus(c, http.StatusNotFound, "share link not found", nil)
+			return
+		} else if err := q.Error; err != nil {
+			jsonError(c, "error looking up share link", err)
+			return
+		}
+		if err := db.Delete(&link).Error; err != nil {
+			jsonError(c, "error revoking share link", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func getSharedCitiesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getShareLinkUser(c, db)
+		if user == nil || visitsNotModified(c, db, user, shareLinkAudience) {
+			return
+		}
+		listVisitedCities(c, db, user, shareLinkAudience)
+	}
+}
+
+func getSharedStatesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getShareLinkUser(c, db)
+		if user == nil || visitsNotModified(c, db, user, shareLinkAudience) {
+			return
+		}
+		listVisitedStates(c, db, user, shareLinkAudience)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// UserStats summarizes everywhere a user has been
+type UserStats struct {
+	DistinctCities uint `json:"distinctCities"`
+	DistinctStates uint `json:"distinctStates"`
+	TotalVisits    uint `json:"totalVisits"`
+	// FarthestPair is the two visited cities that are farthest apart
+	FarthestPair       []models.City `json:"farthestPair"`
+	FarthestDistanceKm float64       `json:"farthestDistanceKm"`
+	// Centroid is the geographic center of all visits
+	Centroid     *geo.Point   `json:"centroid"`
+	Northernmost *models.City `json:"northernmost"`
+	Southernmost *models.City `json:"southernmost"`
+	Easternmost  *models.City `json:"easternmost"`
+	Westernmost  *models.City `json:"westernmost"`
+	// TotalDistanceKm is the distance travelled going from visit to visit in
+	// the order they were made
+	TotalDistanceKm float64 `json:"totalDistanceKm"`
+}
+
+// visitedCity is a row of a user's visit history
+type visitedCity struct {
+	city      models.City
+	visitedAt time.Time
+}
+
+// load the user's visits the audience can see along with the city, oldest
+// first
+func getVisitHistory(
+	db *gorm.DB, user *models.User, audience string,
+) ([]visitedCity, error) {
+	visible, visibleArgs := visibleClause(user, audience)
+	rows, err := db.Raw(`
+		SELECT cities.id, cities.name, cities.state_id, cities.lat, cities.lon,
+			visits.created_at
+		FROM visits
+		JOIN cities ON cities.id = visits.city_id
+		WHERE visits.user_id = ? AND visits.deleted_at IS NULL AND `+visible+`
+		ORDER BY visits.created_at, visits.id
+	`, append([]interface{}{user.ID}, visibleArgs...)...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := []visitedCity{}
+	for rows.Next() {
+		var v visitedCity
+		err := rows.Scan(
+			&v.city.ID, &v.city.Name, &v.city.StateID, &v.city.Lat, &v.city.Lon,
+			&v.visitedAt)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, v)
+	}
+	return history, rows.Err()
+}
+
+func computeUserStats(history []visitedCity) *UserStats {
+	stats := &UserStats{
+		TotalVisits:  uint(len(history)),
+		FarthestPair: []models.City{},
+	}
+	if len(history) == 0 {
+		return stats
+	}
+
+	points := make([]geo.Point, len(history))
+	cities := []models.City{}
+	seenCities := map[uint]bool{}
+	seenStates := map[uint]bool{}
+	for i := range history {
+		city := &history[i].city
+		points[i] = geo.Point{Lat: city.Lat, Lon: city.Lon}
+		if !seenCities[city.ID] {
+			seenCities[city.ID] = true
+			cities = append(cities, *city)
+		}
+		seenStates[city.StateID] = true
+		if stats.Northernmost == nil || city.Lat > stats.Northernmost.Lat {
+			stats.Northernmost = city
+		}
+		if stats.Southernmost == nil || city.Lat < stats.Southernmost.Lat {
+			stats.Southernmost = city
+		}
+		if stats.Easternmost == nil || city.Lon > stats.Easternmost.Lon {
+			stats.Easternmost = city
+		}
+		if stats.Westernmost == nil || city.Lon < stats.Westernmost.Lon {
+			stats.Westernmost = city
+		}
+	}
+	stats.DistinctCities = uint(len(cities))
+	stats.DistinctStates = uint(len(seenStates))
+
+	centroid := geo.Centroid(points)
+	stats.Centroid = &centroid
+	stats.TotalDistanceKm = geo.PathLength(points)
+
+	// there are only a few hundred cities, so brute force is fine
+	for i := range cities {
+		a := geo.Point{Lat: cities[i].Lat, Lon: cities[i].Lon}
+		for j := i + 1; j < len(cities); j++ {
+			b := geo.Point{Lat: cities[j].Lat, Lon: cities[j].Lon}
+			if d := geo.Distance(a, b); d > stats.FarthestDistanceKm {
+				stats.FarthestDistanceKm = d
+				stats.FarthestPair = []models.City{cities[i], cities[j]}
+			}
+		}
+	}
+	return stats
+}
+
+func getUserStatsHandler(
+	cfg *conf.Config, db *gorm.DB, store cache.Cache,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getUser(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" || visitsNotModified(c, db, user, audience) {
+			return
+		}
+		// stats differ depending on which visits the audience can see
+		key := userCacheKey(store, user.ID, "stats", audience)
+		serveCached(c, store, key, cacheTTL(cfg), func() {
+			history, err := getVisitHistory(db, user, audience)
+			if err != nil {
+				jsonError(c, "error looking up visits", err)
+				return
+			}
+			c.JSON(http.StatusOK, computeUserStats(history))
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package api_test
+
+import (
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stats", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("is empty without visits", func() {
+		var stats UserStats
+		Ω(getTestJSON(ts, "/user/1/stats", &stats)).Should(Equal(200))
+		Ω(stats.TotalVisits).Should(BeZero())
+		Ω(stats.FarthestPair).Should(BeEmpty())
+		Ω(stats.Centroid).Should(BeNil())
+		Ω(stats.Northernmost).Should(BeNil())
+	})
+
+	It("fails on invalid user", func() {
+		var out interface{}
+		Ω(getTestJSON(ts, "/user/20/stats", &out)).Should(Equal(400))
+	})
+
+	Context("with visits", func() {
+		BeforeEach(func() {
+			postVisits(ts, 1,
+				`{ "city": "Winterfell", "state": "WS" }`,
+				`{ "city": "Qarth", "state": "ES" }`,
+				`{ "city": "Kings Landing", "state": "WS" }`,
+				`{ "city": "Winterfell", "state": "WS" }`,
+			)
+		})
+
+		It("counts visits", func() {
+			var stats UserStats
+			getTestJSON(ts, "/user/1/stats", &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(4)))
+			Ω(stats.DistinctCities).Should(Equal(uint(3)))
+			Ω(stats.DistinctStates).Should(Equal(uint(2)))
+		})
+
+		It("finds the farthest pair", func() {
+			var stats UserStats
+			getTestJSON(ts, "/user/1/stats", &stats)
+			Ω(stats.FarthestPair).Should(HaveLen(2))
+			names := []string{
+				stats.FarthestPair[0].Name, stats.FarthestPair[1].Name}
+			Ω(names).Should(ConsistOf("Kings Landing", "Qarth"))
+			Ω(stats.FarthestDistanceKm).Should(BeNumerically(">", 10000))
+		})
+
+		It("finds the extremes", func() {
+			var stats UserStats
+			getTestJSON(ts, "/user/1/stats", &stats)
+			Ω(stats.Northernmost.Name).Should(Equal("Winterfell"))
+			Ω(stats.Southernmost.Name).Should(Equal("Qarth"))
+			Ω(stats.Easternmost.Name).Should(Equal("Qarth"))
+			Ω(stats.Westernmost.Name).Should(Equal("Winterfell"))
+			Ω(stats.Centroid).ShouldNot(BeNil())
+		})
+
+		It("adds up the distance in visit order", func() {
+			var stats UserStats
+			getTestJSON(ts, "/user/1/stats", &stats)
+			// winterfell -> qarth -> kings landing -> winterfell
+			Ω(stats.TotalDistanceKm).Should(
+				BeNumerically("~", 2*stats.FarthestDistanceKm, 1000))
+		})
+
+		It("is invalidated when visits change", func() {
+			var stats UserStats
+			getTestJSON(ts, "/user/1/stats", &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(4)))
+
+			var visit models.Visit
+			db.Where("city_id = ?", 2).First(&visit)
+			status, _ := doUserRequest("DELETE",
+				ts.URL+"/user/1/visits/"+strconv.Itoa(int(visit.ID)), 1, "")
+			Ω(status).Should(Equal(204))
+			getTestJSON(ts, "/user/1/stats", &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(3)))
+			Ω(stats.DistinctStates).Should(Equal(uint(2)))
+
+			postVisits(ts, 1, `{ "city": "Kings Landing", "state": "WS" }`)
+			getTestJSON(ts, "/user/1/stats", &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(4)))
+		})
+	})
+})
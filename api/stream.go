@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// how often idle streams are pinged so proxies keep them open
+	streamPingInterval = 30 * time.Second
+	// how long a websocket write can take before the client is dropped
+	streamWriteWait = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// getStreamFilter builds a filter for the users in the `userId` query
+// params, limited to what the viewer can see of each of them. Without any
+// users the stream gets public events for everyone.
+// sends a json error response and returns false if a user is invalid
+func getStreamFilter(c *gin.Context, db *gorm.DB) (func(stream.Event) bool, bool) {
+	ids := c.Request.URL.Query()["userId"]
+	if len(ids) == 0 {
+		return func(e stream.Event) bool {
+			return e.Visibility == models.VisibilityPublic
+		}, true
+	}
+	// audiences are worked out once, so follows made after connecting
+	// need a reconnect
+	audiences := map[uint]string{}
+	for _, id := range ids {
+		userID, errs := validate.ID("userId", id)
+		if errs != nil {
+			jsonFieldErrors(c, errs)
+			return nil, false
+		}
+		user := lookupUser(c, db, userID)
+		if user == nil {
+			return nil, false
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return nil, false
+		}
+		audiences[user.ID] = audience
+	}
+	return func(e stream.Event) bool {
+		audience, ok := audiences[e.UserID]
+		return ok && canSee(audience, e.Visibility)
+	}, true
+}
+
+// serveSSE sends events as server-sent events until the client goes away
+// or the subscription ends
+func serveSSE(c *gin.Context, sub *stream.Subscriber) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// get the headers out now so the client knows it is connected
+	fmt.Fprint(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					c.SSEvent("error", gin.H{"message": err.Error()})
+					c.Writer.Flush()
+				}
+				return
+			}
+			c.SSEvent(e.Type, e)
+			c.Writer.Flush()
+		case <-ticker.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// serveWebSocket sends events as json text messages until the client goes
+// away or the subscription ends
+func serveWebSocket(c *gin.Context, sub *stream.Subscriber) {
+	// Upgrade responds with an error itself
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// clients don't send anything, but reading is how we find out they've
+	// gone and how pongs get handled
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * streamPingInterval))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				code := websocket.CloseNormalClosure
+				reason := ""
+				if err := sub.Err(); err == stream.ErrEvicted {
+					code, reason = websocket.ClosePolicyViolation, err.Error()
+				} else if err != nil {
+					code, reason = websocket.CloseGoingAway, err.Error()
+				}
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(code, reason),
+					time.Now().Add(streamWriteWait))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil,
+				time.Now().Add(streamWriteWait))
+			if err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// getStreamHandler pushes events to the client over a websocket if it asks
+// for one, or server-sent events otherwise
+func getStreamHandler(
+	cfg *conf.Config, db *gorm.DB, hub *stream.Hub,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, ok := getStreamFilter(c, db)
+		if !ok {
+			return
+		}
+		sub := hub.Subscribe(filter)
+		defer hub.Unsubscribe(sub)
+		if websocket.IsWebSocketUpgrade(c.Request) {
+			serveWebSocket(c, sub)
+		} else {
+			serveSSE(c, sub)
+		}
+	}
+}
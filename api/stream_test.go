@@ -0,0 +1,161 @@
+package api_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/gorilla/websocket"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	deleteVisit := func(userID, visitID uint) {
+		url := ts.URL + "/user/" + strconv.Itoa(int(userID)) +
+			"/visits/" + strconv.Itoa(int(visitID))
+		status, _ := doUserRequest("DELETE", url, userID, "")
+		Ω(status).Should(Equal(204))
+	}
+
+	Context("server-sent events", func() {
+		var (
+			resp  *http.Response
+			lines chan string
+		)
+
+		connect := func(query string) {
+			var err error
+			resp, err = http.Get(ts.URL + "/stream" + query)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resp.StatusCode).Should(Equal(200))
+			Ω(resp.Header.Get("Content-Type")).Should(
+				HavePrefix("text/event-stream"))
+			lines = make(chan string, 100)
+			go func(body io.Reader, lines chan string) {
+				defer GinkgoRecover()
+				scanner := bufio.NewScanner(body)
+				for scanner.Scan() {
+					if strings.HasPrefix(scanner.Text(), "event:") {
+						lines <- strings.TrimPrefix(scanner.Text(), "event:")
+					}
+				}
+				close(lines)
+			}(resp.Body, lines)
+		}
+
+		AfterEach(func() {
+			resp.Body.Close()
+		})
+
+		It("sends visit events for the user", func() {
+			connect("?userId=1")
+			visits := postVisits(ts, 1, `{ "city": "Winterfell", "state": "WS" }`)
+			Eventually(lines).Should(Receive(Equal(stream.VisitCreated)))
+			deleteVisit(1, visits[0].ID)
+			Eventually(lines).Should(Receive(Equal(stream.VisitDeleted)))
+		})
+
+		It("leaves out other users", func() {
+			createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+			connect("?userId=2")
+			postVisits(ts, 1, `{ "city": "Winterfell", "state": "WS" }`)
+			Consistently(lines, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("leaves out private visits", func() {
+			connect("")
+			postVisits(ts, 1,
+				`{ "city": "Winterfell", "state": "WS", "visibility": "private" }`)
+			Consistently(lines, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("fails on invalid users", func() {
+			resp, err := http.Get(ts.URL + "/stream?userId=20")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resp.StatusCode).Should(Equal(400))
+			resp.Body.Close()
+			connect("")
+		})
+	})
+
+	Context("websocket", func() {
+		var conn *websocket.Conn
+
+		BeforeEach(func() {
+			var err error
+			url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/stream?userId=1"
+			conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			conn.Close()
+		})
+
+		It("sends visit events as json", func() {
+			visits := postVisits(ts, 1, `{ "city": "Qarth", "state": "ES" }`)
+			var e struct {
+				Type   string
+				UserID uint
+				Data   struct{ CityID uint }
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			Ω(conn.ReadJSON(&e)).Should(Succeed())
+			Ω(e.Type).Should(Equal(stream.VisitCreated))
+			Ω(e.UserID).Should(Equal(uint(1)))
+			Ω(e.Data.CityID).Should(Equal(uint(3)))
+
+			deleteVisit(1, visits[0].ID)
+			Ω(conn.ReadJSON(&e)).Should(Succeed())
+			Ω(e.Type).Should(Equal(stream.VisitDeleted))
+		})
+
+		It("only sends what's public about users", func() {
+			var jon models.User
+			Ω(db.First(&jon, 1).Error).Should(Succeed())
+			Ω(db.Model(&jon).Update("email", "jon@nightswatch.org").Error).
+				Should(Succeed())
+			Ω(models.SetPassword(db, &jon, "ghost")).Should(Succeed())
+			status, body := doAuthRequest("PUT", ts.URL+"/user/1/visibility",
+				"jon@nightswatch.org", "ghost", `{"visibility": "followers"}`)
+			Ω(status).Should(Equal(200), string(body))
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, msg, err := conn.ReadMessage()
+			Ω(err).ShouldNot(HaveOccurred())
+			var e struct {
+				Type string
+				Data map[string]interface{}
+			}
+			Ω(json.Unmarshal(msg, &e)).Should(Succeed())
+			Ω(e.Type).Should(Equal(stream.UserUpdated))
+			Ω(e.Data).Should(HaveKeyWithValue("visibility", "followers"))
+			Ω(e.Data).Should(HaveKeyWithValue("id", BeNumerically("==", 1)))
+			Ω(string(msg)).ShouldNot(ContainSubstring("nightswatch"))
+			Ω(e.Data).ShouldNot(HaveKey("visits"))
+		})
+	})
+})
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/geo/tile"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	tileContentType = "application/vnd.mapbox-vector-tile"
+	// tiles are drawn 256 pixels across, so this many tile units to a pixel
+	tileUnitsPerPixel = tile.DefaultExtent / 256
+	// polygons are clipped this far past the edge of the tile so their
+	// outlines don't show where tiles meet
+	tileBuffer = 4 * tileUnitsPerPixel
+)
+
+// tileKey is a tile of a layer as some audience sees it. Cities and states
+// look the same to everyone, so they have no user. Visits are drawn for the
+// user's cache generation, so invalidateUser leaves them to age out.
+type tileKey struct {
+	layer    string
+	tile     tile.Tile
+	userID   uint
+	audience string
+	gen      string
+}
+
+// tilePoint is a point to draw on a tile with its properties
+type tilePoint struct {
+	id    uint64
+	point geo.Point
+	props map[string]interface{}
+}
+
+// addTilePoints projects the points onto the layer. Where several land on
+// the same pixel, only the first is kept, which thins out the points at low
+// zooms.
+func addTilePoints(l *tile.Layer, t tile.Tile, points []tilePoint) {
+	type pixel struct{ x, y int }
+	seen := map[pixel]bool{}
+	for _, p := range points {
+		x, y := t.Project(p.point, l.Extent)
+		px := pixel{int(x) / tileUnitsPerPixel, int(y) / tileUnitsPerPixel}
+		if seen[px] {
+			continue
+		}
+		if l.AddPoints(p.id, []tile.Coord{{X: x, Y: y}}, p.props) {
+			seen[px] = true
+		}
+	}
+}
+
+// inTileClause limits lat and lon columns to the tile's box
+func inTileClause(table string, t tile.Tile) (string, []interface{}) {
+	box := t.BBox()
+	return table + ".lat BETWEEN ? AND ? AND " + table + ".lon BETWEEN ? AND ?",
+		[]interface{}{box.MinLat, box.MaxLat, box.MinLon, box.MaxLon}
+}
+
+// cityTileLayer has a point for each city on the tile
+func cityTileLayer(db *gorm.DB, t tile.Tile) (*tile.Layer, error) {
+	inTile, args := inTileClause("cities", t)
+	rows, err := db.Raw(`
+		SELECT id, name, state_id, lat, lon FROM cities
+		WHERE deleted_at IS NULL AND `+inTile+`
+		ORDER BY id`, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := []tilePoint{}
+	for rows.Next() {
+		var city models.City
+		if err := rows.Scan(
+			&city.ID, &city.Name, &city.StateID, &city.Lat, &city.Lon,
+		); err != nil {
+			return nil, err
+		}
+		points = append(points, tilePoint{
+			uint64(city.ID), geo.Point{Lat: city.Lat, Lon: city.Lon},
+			map[string]interface{}{"name": city.Name, "stateId": city.StateID},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	l := tile.NewLayer("cities", tile.DefaultExtent)
+	addTilePoints(l, t, points)
+	return l, nil
+}
+
+// visitTileLayer has a point for each of the user's visits on the tile the
+// audience can see, where they are by city
+func visitTileLayer(
+	db *gorm.DB, t tile.Tile, user *models.User, audience string,
+) (*tile.Layer, error) {
+	inTile, tileArgs := inTileClause("cities", t)
+	visible, visibleArgs := visibleClause(user, audience)
+	args := append([]interface{}{user.ID}, tileArgs...)
+	rows, err := db.Raw(`
+		SELECT visits.id, visits.city_id, cities.name, visits.created_at,
+			cities.lat, cities.lon
+		FROM visits
+		JOIN cities ON cities.id = visits.city_id
+		WHERE visits.user_id = ? AND visits.deleted_at IS NULL
+			AND `+inTile+` AND `+visible+`
+		ORDER BY visits.created_at DESC, visits.id DESC`,
+		append(args, visibleArgs...)...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	points := []tilePoint{}
+	for rows.Next() {
+		var visit models.Visit
+		var name string
+		var lat, lon float64
+		if err := rows.Scan(
+			&visit.ID, &visit.CityID, &name, &visit.CreatedAt, &lat, &lon,
+		); err != nil {
+			return nil, err
+		}
+		points = append(points, tilePoint{
+			uint64(visit.ID), geo.Point{Lat: lat, Lon: lon},
+			map[string]interface{}{
+				"cityId":    visit.CityID,
+				"name":      name,
+				"visitedAt": visit.CreatedAt.UTC().Format(time.RFC3339),
+			},
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// newest visits win when they share a pixel
+	l := tile.NewLayer("visits", tile.DefaultExtent)
+	addTilePoints(l, t, points)
+	return l, nil
+}
+
+func overlaps(a, b geo.BBox) bool {
+	return a.MinLat <= b.MaxLat && b.MinLat <= a.MaxLat &&
+		a.MinLon <= b.MaxLon && b.MinLon <= a.MaxLon
+}
+
+// stateTileLayer has the outline of each state on the tile, simplified to
+// about half a pixel and clipped just past the tile's edges
+func stateTileLayer(states *geo.RegionIndex, t tile.Tile) *tile.Layer {
+	l := tile.NewLayer("states", tile.DefaultExtent)
+	box := t.BufferedBBox(float64(tileBuffer) / float64(l.Extent))
+	lo, hi := float64(-tileBuffer), float64(l.Extent+tileBuffer)
+	for i, region := range states.Regions() {
+		if !overlaps(region.BBox, box) {
+			continue
+		}
+		polygons := [][][]tile.Coord{}
+		for _, poly := range region.Polygons {
+			if !overlaps(poly.BBox(), box) {
+				continue
+			}
+			rings := [][]tile.Coord{}
+			for _, ring := range poly {
+				projected := make([]tile.Coord, 0, len(ring))
+				for _, p := range ring {
+					x, y := t.Project(p, l.Extent)
+					projected = append(projected, tile.Coord{X: x, Y: y})
+				}
+				// GeoJSON rings repeat the first point at the end
+				if n := len(projected); n > 1 && projected[0] == projected[n-1] {
+					projected = projected[:n-1]
+				}
+				simplified := tile.SimplifyRing(projected, tileUnitsPerPixel/2)
+				rings = append(rings, tile.ClipRing(simplified, lo, hi))
+			}
+			polygons = append(polygons, rings)
+		}
+		l.AddPolygons(uint64(i+1), polygons, map[string]interface{}{
+			"abbrev": region.Key, "name": region.Name,
+		})
+	}
+	return l
+}
+
+// getPathTile reads the tile from the path, where y ends in .mvt
+// sends a json error response and returns false if it isn't one on the map
+func getPathTile(c *gin.Context) (tile.Tile, bool) {
+	ys := c.Param("y")
+	if !strings.HasSuffix(ys, ".mvt") {
+		jsonFieldErrors(c, validate.Errors{{
+			Field: "y", Rule: "mvt", Message: "tiles are only served as .mvt",
+		}})
+		return tile.Tile{}, false
+	}
+	z, errs := validate.Int("z", c.Param("z"), "min=0,max="+strconv.Itoa(tile.MaxZoom))
+	// x and y can only be checked against the map once the zoom is good
+	xy := "min=0"
+	if errs == nil {
+		xy += ",max=" + strconv.Itoa(1<<uint(z)-1)
+	}
+	x, xErrs := validate.Int("x", c.Param("x"), xy)
+	y, yErrs := validate.Int("y", strings.TrimSuffix(ys, ".mvt"), xy)
+	if errs = append(append(errs, xErrs...), yErrs...); len(errs) > 0 {
+		jsonFieldErrors(c, errs)
+		return tile.Tile{}, false
+	}
+	t, err := tile.New(z, x, y)
+	if err != nil {
+		jsonError(c, "invalid tile", err)
+		return tile.Tile{}, false
+	}
+	return t, true
+}
+
+// getTileHandler draws a layer of cities, states or a user's visits as a
+// Mapbox Vector Tile. Visits need a `userId` and only show the ones the
+// viewer can see.
+func getTileHandler(
+	cfg *conf.Config, db *gorm.DB, store cache.Cache, tiles *lruCache,
+	states *geo.RegionIndex,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t, ok := getPathTile(c)
+		if !ok {
+			return
+		}
+		key := tileKey{layer: c.Param("layer"), tile: t}
+		var user *models.User
+		switch key.layer {
+		case "cities", "states":
+		case "visits":
+			userID, ok := getQueryID(c, "userId")
+			if !ok {
+				return
+			}
+			if user = lookupUser(c, db, userID); user == nil {
+				return
+			}
+			if key.audience = getPathAudience(c, db, user); key.audience == "" {
+				return
+			}
+			key.userID = user.ID
+			key.gen = userGeneration(store, user.ID)
+		default:
+			jsonErrorStatus(c, http.StatusNotFound, "layer not found",
+				errors.New("layer must be cities, visits or states"))
+			return
+		}
+
+		// without a generation there's no telling when visits change
+		cacheable := key.layer != "visits" || key.gen != ""
+		var data []byte
+		if cached, ok := tiles.get(key); cacheable && ok {
+			data = cached.([]byte)
+		} else {
+			var l *tile.Layer
+			var err error
+			switch key.layer {
+			case "cities":
+				l, err = cityTileLayer(db, t)
+			case "visits":
+				l, err = visitTileLayer(db, t, user, key.audience)
+			case "states":
+				l = stateTileLayer(states, t)
+			}
+			if err != nil {
+				jsonError(c, "error drawing tile", err)
+				return
+			}
+			data = tile.Encode(l)
+			if cacheable {
+				tiles.set(key, data)
+			}
+		}
+		c.Data(http.StatusOK, tileContentType, data)
+	}
+}
@@ -0,0 +1,147 @@
+package api_test
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// protobufFields reads the byte string fields of a protobuf message by field
+// number, skipping the rest
+func protobufFields(b []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		Ω(n).Should(BeNumerically(">", 0))
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			_, n = binary.Uvarint(b)
+			b = b[n:]
+		case 1:
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			fields[int(key>>3)] = append(fields[int(key>>3)], b[n:n+int(size)])
+			b = b[n+int(size):]
+		default:
+			Fail("unexpected wire type")
+		}
+	}
+	return fields
+}
+
+// tileLayer is the names of a tile's layers with their features and string
+// values
+type tileLayer struct {
+	features int
+	strings  []string
+}
+
+func decodeTile(data []byte) map[string]tileLayer {
+	layers := map[string]tileLayer{}
+	for _, b := range protobufFields(data)[3] {
+		fields := protobufFields(b)
+		layer := tileLayer{features: len(fields[2]), strings: []string{}}
+		for _, value := range fields[4] {
+			for _, s := range protobufFields(value)[1] {
+				layer.strings = append(layer.strings, string(s))
+			}
+		}
+		layers[string(fields[1][0])] = layer
+	}
+	return layers
+}
+
+var _ = Describe("Tiles", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	getTile := func(email, password, path string) tileLayer {
+		status, body := doAuthRequest("GET", ts.URL+"/tiles/"+path, email, password, "")
+		Ω(status).Should(Equal(200), string(body))
+		layers := decodeTile(body)
+		Ω(layers).Should(HaveLen(1))
+		for _, l := range layers {
+			return l
+		}
+		return tileLayer{}
+	}
+	visit := func(city, state, visibility string) {
+		status, body := doUserRequest("POST", ts.URL+"/user/2/visits", 2,
+			`{"city": "`+city+`", "state": "`+state+`", "visibility": "`+visibility+`"}`)
+		Ω(status).Should(Equal(201), string(body))
+	}
+
+	It("serves vector tiles", func() {
+		resp, err := http.Get(ts.URL + "/tiles/cities/0/0/0.mvt")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(resp.StatusCode).Should(Equal(200))
+		Ω(resp.Header.Get("Content-Type")).
+			Should(Equal("application/vnd.mapbox-vector-tile"))
+		Ω(decodeTile(getRespBody(resp))).Should(HaveKey("cities"))
+	})
+
+	It("draws the cities on the tile", func() {
+		l := getTile("", "", "cities/0/0/0.mvt")
+		Ω(l.features).Should(Equal(3))
+		Ω(l.strings).Should(ConsistOf("Winterfell", "Kings Landing", "Qarth"))
+
+		l = getTile("", "", "cities/10/282/404.mvt")
+		Ω(l.strings).Should(Equal([]string{"Winterfell"}))
+		Ω(getTile("", "", "cities/10/0/0.mvt").features).Should(Equal(0))
+	})
+
+	It("draws the outlines of states", func() {
+		l := getTile("", "", "states/0/0/0.mvt")
+		Ω(l.features).Should(Equal(3))
+		Ω(l.strings).Should(ContainElement("WS"))
+		// Westeros doesn't reach this tile
+		l = getTile("", "", "states/4/8/7.mvt")
+		Ω(l.strings).ShouldNot(ContainElement("WS"))
+	})
+
+	It("draws the visits the viewer can see", func() {
+		visit("Winterfell", "WS", "")
+		visit("Qarth", "ES", "private")
+		Ω(getTile("", "", "visits/0/0/0.mvt?userId=2").features).Should(Equal(1))
+		l := getTile("arya@winterfell.net", "needle", "visits/0/0/0.mvt?userId=2")
+		Ω(l.features).Should(Equal(2))
+		Ω(l.strings).Should(ContainElement("Qarth"))
+
+		// new visits show up
+		visit("Kings Landing", "WS", "")
+		Ω(getTile("", "", "visits/0/0/0.mvt?userId=2").features).Should(Equal(2))
+	})
+
+	DescribeTable("rejects bad requests",
+		func(path string, expected int) {
+			status, _ := doAuthRequest("GET", ts.URL+"/tiles/"+path, "", "", "")
+			Ω(status).Should(Equal(expected))
+		},
+		Entry("not mvt", "cities/0/0/0.png", 400),
+		Entry("zoom too deep", "cities/30/0/0.mvt", 400),
+		Entry("off the map", "cities/1/2/0.mvt", 400),
+		Entry("not a number", "cities/1/a/0.mvt", 400),
+		Entry("unknown layer", "roads/0/0/0.mvt", 404),
+		Entry("visits without a user", "visits/0/0/0.mvt", 400),
+		Entry("visits of an unknown user", "visits/0/0/0.mvt?userId=99", 400),
+	)
+})
@@ -0,0 +1,509 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// visits further apart than this are suggested as separate trips
+	defaultTripGapDays = 2
+	// a trip needs to go at least a couple places
+	defaultTripMinVisits = 2
+)
+
+// TripRequest is the struct for creating or replacing a trip
+type TripRequest struct {
+	Name        string     `json:"name" validate:"required,max=100"`
+	Description string     `json:"description" validate:"max=1000"`
+	StartDate   *time.Time `json:"startDate"`
+	EndDate     *time.Time `json:"endDate"`
+	// VisitIDs in the order they were made. Leaving them out when updating
+	// keeps the trip's visits.
+	VisitIDs []uint `json:"visitIds"`
+}
+
+// Validate that the trip doesn't end before it starts
+func (req TripRequest) Validate() validate.Errors {
+	if req.StartDate != nil && req.EndDate != nil &&
+		req.EndDate.Before(*req.StartDate) {
+		return validate.Errors{{
+			Field: "endDate", Rule: "after",
+			Message: "endDate can't be before startDate",
+		}}
+	}
+	return nil
+}
+
+// TripResponse is a trip with its visits in order
+type TripResponse struct {
+	models.Trip
+	Visits []models.Visit `json:"visits"`
+	// RouteLengthKm is the great-circle distance from visit to visit
+	RouteLengthKm float64 `json:"routeLengthKm"`
+}
+
+// TripSuggestion is a run of visits close together in time that aren't in a
+// trip yet
+type TripSuggestion struct {
+	Name          string    `json:"name"`
+	StartDate     time.Time `json:"startDate"`
+	EndDate       time.Time `json:"endDate"`
+	VisitIDs      []uint    `json:"visitIds"`
+	RouteLengthKm float64   `json:"routeLengthKm"`
+}
+
+// routeLength of the visits in order, going by their cities
+func routeLength(db *gorm.DB, visits []models.Visit) (float64, error) {
+	if len(visits) < 2 {
+		return 0, nil
+	}
+	cityIDs := make([]uint, len(visits))
+	for i, visit := range visits {
+		cityIDs[i] = visit.CityID
+	}
+	var cities []models.City
+	if err := db.Where("id IN (?)", cityIDs).Find(&cities).Error; err != nil {
+		return 0, err
+	}
+	points := map[uint]geo.Point{}
+	for _, city := range cities {
+		points[city.ID] = geo.Point{Lat: city.Lat, Lon: city.Lon}
+	}
+	path := make([]geo.Point, len(visits))
+	for i, visit := range visits {
+		path[i] = points[visit.CityID]
+	}
+	return geo.PathLength(path), nil
+}
+
+// getTripResponse loads the trip's visits the audience can see and fills in
+// what's worked out from them
+func getTripResponse(
+	db *gorm.DB, user *models.User, trip *models.Trip, audience string,
+) (*TripResponse, error) {
+	visible, visibleArgs := visibleClause(user, audience)
+	visits := []models.Visit{}
+	err := db.Joins("JOIN trip_visits ON trip_visits.visit_id = visits.id").
+		Where("trip_visits.trip_id = ?", trip.ID).
+		Where(visible, visibleArgs...).
+		Order("trip_visits.position").
+		Find(&visits).Error
+	if err != nil {
+		return nil, err
+	}
+	length, err := routeLength(db, visits)
+	if err != nil {
+		return nil, err
+	}
+	response := &TripResponse{*trip, visits, length}
+	if len(visits) > 0 {
+		first, last := visits[0].CreatedAt, visits[0].CreatedAt
+		for _, visit := range visits[1:] {
+			if visit.CreatedAt.Before(first) {
+				first = visit.CreatedAt
+			}
+			if visit.CreatedAt.After(last) {
+				last = visit.CreatedAt
+			}
+		}
+		if response.StartDate == nil {
+			response.StartDate = &first
+		}
+		if response.EndDate == nil {
+			response.EndDate = &last
+		}
+	}
+	return response, nil
+}
+
+// look up the user's trip in the path
+// sends a json error response and returns nil if it can't
+func getTrip(c *gin.Context, db *gorm.DB, user *models.User) *models.Trip {
+	tripID, ok := getPathID(c, "tripID")
+	if !ok {
+		return nil
+	}
+	var trip models.Trip
+	q := db.Where("id = ? AND user_id = ?", tripID, user.ID).First(&trip)
+	if q.RecordNotFound() {
+		jsonErrorStatus(c, http.StatusNotFound, "trip not found", nil)
+		return nil
+	} else if err := q.Error; err != nil {
+		jsonError(c, "error looking up trip", err)
+		return nil
+	}
+	return &trip
+}
+
+// bindTripRequest reads and checks a trip request
+// sends a json error response and returns false if it is invalid
+func bindTripRequest(c *gin.Context, db *gorm.DB, user *models.User) (*TripRequest, bool) {
+	var req TripRequest
+	if !bindJSON(c, &req) {
+		return nil, false
+	}
+	if len(req.VisitIDs) == 0 {
+		return &req, true
+	}
+	seen := map[uint]bool{}
+	for _, id := range req.VisitIDs {
+		if seen[id] {
+			jsonError(c, "invalid visits", fmt.Errorf(
+				"visit %d is in the trip more than once", id))
+			return nil, false
+		}
+		seen[id] = true
+	}
+	var count int
+	err := db.Model(&models.Visit{}).
+		Where("id IN (?) AND user_id = ?", req.VisitIDs, user.ID).
+		Count(&count).Error
+	if err != nil {
+		jsonError(c, "error looking up visits", err)
+		return nil, false
+	}
+	if count != len(req.VisitIDs) {
+		jsonError(c, "invalid visits", fmt.Errorf(
+			"trips can only include your own visits"))
+		return nil, false
+	}
+	return &req, true
+}
+
+// setTripVisits replaces the trip's visits
+func setTripVisits(tx *gorm.DB, trip *models.Trip, visitIDs []uint) error {
+	err := tx.Where("trip_id = ?", trip.ID).Delete(&models.TripVisit{}).Error
+	if err != nil {
+		return err
+	}
+	for i, visitID := range visitIDs {
+		tv := models.TripVisit{TripID: trip.ID, VisitID: visitID, Position: i}
+		if err := tx.Create(&tv).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveTrip saves the trip, and its visits if there are any
+// sends a json error response and returns false if it can't
+func saveTrip(
+	c *gin.Context, db *gorm.DB, trip *models.Trip, visitIDs []uint,
+) bool {
+	tx := db.Begin()
+	if err := tx.Save(trip).Error; err != nil {
+		tx.Rollback()
+		jsonError(c, "error saving trip", err)
+		return false
+	}
+	if visitIDs != nil {
+		if err := setTripVisits(tx, trip, visitIDs); err != nil {
+			tx.Rollback()
+			jsonError(c, "error saving trip visits", err)
+			return false
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		jsonError(c, "error saving trip", err)
+		return false
+	}
+	return true
+}
+
+// write the trip as its owner sees it
+func respondTrip(
+	c *gin.Context, db *gorm.DB, user *models.User, trip *models.Trip,
+	status int,
+) {
+	response, err := getTripResponse(db, user, trip, models.VisibilityPrivate)
+	if err != nil {
+		jsonError(c, "error looking up trip visits", err)
+		return
+	}
+	c.JSON(status, response)
+}
+
+func getNewTripHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		req, ok := bindTripRequest(c, db, user)
+		if !ok {
+			return
+		}
+		trip := models.Trip{
+			UserID:      user.ID,
+			Name:        req.Name,
+			Description: req.Description,
+			StartDate:   req.StartDate,
+			EndDate:     req.EndDate,
+		}
+		if !saveTrip(c, db, &trip, req.VisitIDs) {
+			return
+		}
+		respondTrip(c, db, user, &trip, http.StatusCreated)
+	}
+}
+
+func getUpdateTripHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		trip := getTrip(c, db, user)
+		if trip == nil {
+			return
+		}
+		req, ok := bindTripRequest(c, db, user)
+		if !ok {
+			return
+		}
+		trip.Name = req.Name
+		trip.Description = req.Description
+		trip.StartDate = req.StartDate
+		trip.EndDate = req.EndDate
+		if !saveTrip(c, db, trip, req.VisitIDs) {
+			return
+		}
+		respondTrip(c, db, user, trip, http.StatusOK)
+	}
+}
+
+func getDeleteTripHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		trip := getTrip(c, db, user)
+		if trip == nil {
+			return
+		}
+		tx := db.Begin()
+		err := tx.Where("trip_id = ?", trip.ID).Delete(&models.TripVisit{}).Error
+		if err == nil {
+			err = tx.Delete(trip).Error
+		}
+		if err != nil {
+			tx.Rollback()
+			jsonError(c, "error deleting trip", err)
+			return
+		}
+		if err := tx.Commit().Error; err != nil {
+			jsonError(c, "error deleting trip", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// tripsNotModified checks the request's conditional headers against the
+// user's trips and the visits in them, responding 304 if they haven't
+// changed. Saving a trip's visits saves the trip too.
+// sends a json error response and returns true if it can't check
+func tripsNotModified(
+	c *gin.Context, db *gorm.DB, user *models.User, audience string,
+) bool {
+	version, err := rowsVersion(db, "trips", "user_id = ?", user.ID)
+	if err != nil {
+		jsonError(c, "error looking up trips", err)
+		return true
+	}
+	visits, err := visitsVersion(db, user)
+	if err != nil {
+		jsonError(c, "error looking up visits", err)
+		return true
+	}
+	return notModified(c, version.and(visits), audience)
+}
+
+// getTripsHandler lists the user's trips, newest first. Trips are only
+// shown to viewers who can see the user's visits by default, and only with
+// the visits they can see.
+func getTripsHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getUser(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" || tripsNotModified(c, db, user, audience) {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		trips := []models.Trip{}
+		var count int
+		if canSee(audience, user.DefaultVisibility()) {
+			q := db.Model(&models.Trip{}).Where("user_id = ?", user.ID)
+			if err := q.Count(&count).Error; err != nil {
+				jsonError(c, "error counting trips", err)
+				return
+			}
+			err := q.Order("created_at DESC, id DESC").
+				Limit(limit).Offset(offset).Find(&trips).Error
+			if err != nil {
+				jsonError(c, "error looking up trips", err)
+				return
+			}
+		}
+		responses := make([]*TripResponse, len(trips))
+		for i := range trips {
+			response, err := getTripResponse(db, user, &trips[i], audience)
+			if err != nil {
+				jsonError(c, "error looking up trip visits", err)
+				return
+			}
+			responses[i] = response
+		}
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(count), responses,
+		})
+	}
+}
+
+func getTripHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getUser(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return
+		}
+		if !canSee(audience, user.DefaultVisibility()) {
+			jsonErrorStatus(c, http.StatusForbidden,
+				"you can't see this user's trips", nil)
+			return
+		}
+		trip := getTrip(c, db, user)
+		if trip == nil || tripsNotModified(c, db, user, audience) {
+			return
+		}
+		response, err := getTripResponse(db, user, trip, audience)
+		if err != nil {
+			jsonError(c, "error looking up trip visits", err)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// suggestTrips splits visits, in time order, wherever the gap between two
+// is longer than the gap given. Runs of at least minVisits are suggested.
+func suggestTrips(
+	visits []models.Visit, gap time.Duration, minVisits int,
+) [][]models.Visit {
+	suggestions := [][]models.Visit{}
+	start := 0
+	for i := 1; i <= len(visits); i++ {
+		if i < len(visits) &&
+			visits[i].CreatedAt.Sub(visits[i-1].CreatedAt) <= gap {
+			continue
+		}
+		if i-start >= minVisits {
+			suggestions = append(suggestions, visits[start:i])
+		}
+		start = i
+	}
+	return suggestions
+}
+
+// getQueryInt reads a positive int query param, or the default
+// sends a json error response and returns false if it is invalid
+func getQueryInt(c *gin.Context, name string, def int) (int, bool) {
+	s := c.Query(name)
+	if s == "" {
+		return def, true
+	}
+	n, errs := validate.Int(name, s, "min=1")
+	if errs != nil {
+		jsonFieldErrors(c, errs)
+		return 0, false
+	}
+	return n, true
+}
+
+// getTripSuggestionsHandler suggests trips from the user's visits that
+// aren't in one yet, grouping visits made within `gapDays` of each other
+func getTripSuggestionsHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		gapDays, ok := getQueryInt(c, "gapDays", defaultTripGapDays)
+		if !ok {
+			return
+		}
+		minVisits, ok := getQueryInt(c, "minVisits", defaultTripMinVisits)
+		if !ok {
+			return
+		}
+		visits := []models.Visit{}
+		err := db.Where("user_id = ?", user.ID).
+			Where(`id NOT IN (
+				SELECT trip_visits.visit_id FROM trip_visits
+				JOIN trips ON trips.id = trip_visits.trip_id
+				WHERE trips.deleted_at IS NULL)`).
+			Order("created_at, id").Find(&visits).Error
+		if err != nil {
+			jsonError(c, "error looking up visits", err)
+			return
+		}
+		gap := time.Duration(gapDays) * 24 * time.Hour
+		suggestions := []TripSuggestion{}
+		for _, run := range suggestTrips(visits, gap, minVisits) {
+			length, err := routeLength(db, run)
+			if err != nil {
+				jsonError(c, "error working out route", err)
+				return
+			}
+			s := TripSuggestion{
+				StartDate:     run[0].CreatedAt,
+				EndDate:       run[len(run)-1].CreatedAt,
+				RouteLengthKm: length,
+			}
+			s.Name = s.StartDate.Format("January 2006") + " trip"
+			for _, visit := range run {
+				s.VisitIDs = append(s.VisitIDs, visit.ID)
+			}
+			suggestions = append(suggestions, s)
+		}
+		// most recent first, like trips
+		sort.SliceStable(suggestions, func(i, j int) bool {
+			return suggestions[i].StartDate.After(suggestions[j].StartDate)
+		})
+		limit, offset := getLimitOffset(c)
+		start, end := pageBounds(len(suggestions), limit, offset)
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(len(suggestions)), suggestions[start:end],
+		})
+	}
+}
+
+func setTripRoutes(cfg *conf.Config, db *gorm.DB, r *gin.Engine) {
+	auth := requireAuth(db)
+	viewer := optionalAuth(db)
+	r.POST("/user/:userID/trips", auth, getNewTripHandler(cfg, db))
+	r.GET("/user/:userID/trips", viewer, getTripsHandler(cfg, db))
+	r.GET("/user/:userID/trips/:tripID", viewer, getTripHandler(cfg, db))
+	r.PUT("/user/:userID/trips/:tripID", auth, getUpdateTripHandler(cfg, db))
+	r.DELETE("/user/:userID/trips/:tripID", auth,
+		getDeleteTripHandler(cfg, db))
+	r.GET("/user/:userID/trip-suggestions", auth,
+		getTripSuggestionsHandler(cfg, db))
+}
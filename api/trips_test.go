@@ -0,0 +1,163 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trips", func() {
+	var (
+		db     *gorm.DB
+		ts     *httptest.Server
+		visits []models.Visit
+	)
+
+	const (
+		winterfell   = `{ "city": "Winterfell", "state": "WS" }`
+		kingsLanding = `{ "city": "Kings Landing", "state": "WS" }`
+		qarth        = `{ "city": "Qarth", "state": "ES" }`
+	)
+
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+	parseTrip := func(body []byte) TripResponse {
+		var trip TripResponse
+		Ω(json.Unmarshal(body, &trip)).Should(Succeed())
+		return trip
+	}
+	ids := func(visits ...models.Visit) string {
+		s := "["
+		for i, v := range visits {
+			if i > 0 {
+				s += ", "
+			}
+			s += strconv.Itoa(int(v.ID))
+		}
+		return s + "]"
+	}
+	createTrip := func(name string, visits ...models.Visit) TripResponse {
+		status, body := asArya("POST", "/user/2/trips",
+			`{"name": "`+name+`", "visitIds": `+ids(visits...)+`}`)
+		Ω(status).Should(Equal(201), string(body))
+		return parseTrip(body)
+	}
+	// backdate the visit by some days
+	backdate := func(visit models.Visit, days int) {
+		err := db.Model(&visit).UpdateColumn(
+			"created_at", time.Now().AddDate(0, 0, -days)).Error
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		visits = postVisits(ts, 2, winterfell, kingsLanding, qarth)
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("creates a trip with its visits in order", func() {
+		trip := createTrip("Going south", visits[1], visits[0], visits[2])
+		Ω(trip.ID).ShouldNot(BeZero())
+		Ω(trip.Name).Should(Equal("Going south"))
+		Ω(trip.Visits).Should(HaveLen(3))
+		Ω(trip.Visits[0].ID).Should(Equal(visits[1].ID))
+		Ω(trip.Visits[1].ID).Should(Equal(visits[0].ID))
+		// kings landing -> winterfell -> qarth
+		Ω(trip.RouteLengthKm).Should(BeNumerically("~", 285+10063, 5))
+		Ω(trip.StartDate).ShouldNot(BeNil())
+		Ω(trip.EndDate).ShouldNot(BeNil())
+	})
+
+	It("only takes the user's own visits, once each", func() {
+		john := postVisits(ts, 1, winterfell)
+		status, _ := asArya("POST", "/user/2/trips",
+			`{"name": "Stolen", "visitIds": `+ids(visits[0], john[0])+`}`)
+		Ω(status).Should(Equal(400))
+		status, _ = asArya("POST", "/user/2/trips",
+			`{"name": "Twice", "visitIds": `+ids(visits[0], visits[0])+`}`)
+		Ω(status).Should(Equal(400))
+		status, _ = asArya("POST", "/user/2/trips", `{"visitIds": []}`)
+		Ω(status).Should(Equal(400))
+	})
+
+	It("updates and deletes trips", func() {
+		trip := createTrip("North", visits[0])
+		url := "/user/2/trips/" + strconv.Itoa(int(trip.ID))
+		status, body := asArya("PUT", url, `{"name": "The North"}`)
+		Ω(status).Should(Equal(200), string(body))
+		updated := parseTrip(body)
+		Ω(updated.Name).Should(Equal("The North"))
+		// visits are kept when they're left out
+		Ω(updated.Visits).Should(HaveLen(1))
+
+		status, body = asArya("PUT", url,
+			`{"name": "The North", "visitIds": `+ids(visits[0], visits[1])+`}`)
+		Ω(status).Should(Equal(200), string(body))
+		Ω(parseTrip(body).Visits).Should(HaveLen(2))
+
+		status, _ = asArya("DELETE", url, "")
+		Ω(status).Should(Equal(204))
+		status, _ = asArya("GET", url, "")
+		Ω(status).Should(Equal(404))
+	})
+
+	It("lists trips with the visits the viewer can see", func() {
+		createTrip("Everywhere", visits...)
+		status, _ := asArya("PUT",
+			"/user/2/visits/"+strconv.Itoa(int(visits[2].ID))+"/visibility",
+			`{"visibility": "private"}`)
+		Ω(status).Should(Equal(200))
+
+		var out struct {
+			Count int
+			Data  []TripResponse
+		}
+		Ω(getTestJSON(ts, "/user/2/trips", &out)).Should(Equal(200))
+		Ω(out.Count).Should(Equal(1))
+		Ω(out.Data[0].Visits).Should(HaveLen(2))
+
+		_, body := asArya("GET", "/user/2/trips", "")
+		json.Unmarshal(body, &out)
+		Ω(out.Data[0].Visits).Should(HaveLen(3))
+	})
+
+	It("suggests trips from gaps between visits", func() {
+		backdate(visits[0], 30)
+		more := postVisits(ts, 2, winterfell)
+		createTrip("Already a trip", more[0])
+
+		var out struct {
+			Count int
+			Data  []TripSuggestion
+		}
+		status, body := asArya("GET", "/user/2/trip-suggestions", "")
+		Ω(status).Should(Equal(200), string(body))
+		json.Unmarshal(body, &out)
+		// winterfell alone a month ago isn't a trip
+		Ω(out.Count).Should(Equal(1))
+		Ω(out.Data[0].VisitIDs).Should(Equal([]uint{visits[1].ID, visits[2].ID}))
+		Ω(out.Data[0].RouteLengthKm).Should(BeNumerically(">", 10000))
+		Ω(out.Data[0].Name).Should(HaveSuffix(" trip"))
+
+		_, body = asArya("GET", "/user/2/trip-suggestions?gapDays=60", "")
+		json.Unmarshal(body, &out)
+		Ω(out.Data[0].VisitIDs).Should(HaveLen(3))
+
+		status, _ = asArya("GET", "/user/2/trip-suggestions?gapDays=none", "")
+		Ω(status).Should(Equal(400))
+	})
+})
@@ -0,0 +1,143 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validation", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	// fieldErrors maps each invalid field in the body to its detail
+	fieldErrors := func(body []byte) map[string]string {
+		var errs []map[string]map[string]string
+		Ω(json.Unmarshal(body, &errs)).Should(Succeed(), string(body))
+		fields := map[string]string{}
+		for _, e := range errs {
+			fields[e["error"]["field"]] = e["error"]["detail"]
+		}
+		return fields
+	}
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("rejects incomplete visits with an error for each field", func() {
+		status, body := doUserRequest("POST", ts.URL+"/user/2/visits", 2,
+			`{"visibility": "secret"}`)
+		Ω(status).Should(Equal(400))
+		fields := fieldErrors(body)
+		Ω(fields).Should(HaveLen(3))
+		Ω(fields["city"]).Should(Equal("city is required"))
+		Ω(fields["state"]).Should(Equal("state is required"))
+		Ω(fields["visibility"]).Should(ContainSubstring("one of public"))
+	})
+
+	It("rejects a blank visibility", func() {
+		status, body := doUserRequest("POST", ts.URL+"/user/2/visits", 2,
+			`{"city": "Winterfell", "state": "WS", "visibility": " "}`)
+		Ω(status).Should(Equal(400))
+		fields := fieldErrors(body)
+		Ω(fields).Should(HaveLen(1))
+		Ω(fields["visibility"]).Should(ContainSubstring("one of public"))
+	})
+
+	It("rejects states that aren't abbreviations", func() {
+		status, body := doUserRequest("POST", ts.URL+"/user/2/visits", 2,
+			`{"city": "Winterfell", "state": "ws"}`)
+		Ω(status).Should(Equal(400))
+		Ω(fieldErrors(body)).Should(HaveKey("state"))
+	})
+
+	It("rejects trips without a name or that end before they start", func() {
+		status, body := asArya("POST", "/user/2/trips",
+			`{"startDate": "2017-02-01T00:00:00Z", "endDate": "2017-01-01T00:00:00Z"}`)
+		Ω(status).Should(Equal(400))
+		Ω(fieldErrors(body)).Should(HaveKey("name"))
+		status, body = asArya("POST", "/user/2/trips",
+			`{"name": "North", "startDate": "2017-02-01T00:00:00Z", "endDate": "2017-01-01T00:00:00Z"}`)
+		Ω(status).Should(Equal(400))
+		Ω(fieldErrors(body)).Should(HaveKey("endDate"))
+	})
+
+	It("rejects webhooks with a bad url or unknown events", func() {
+		status, body := asArya("POST", "/user/2/webhooks",
+			`{"url": "ftp://example.com", "events": ["visit.created"]}`)
+		Ω(status).Should(Equal(400))
+		Ω(fieldErrors(body)).Should(HaveKey("url"))
+		status, body = asArya("POST", "/user/2/webhooks",
+			`{"url": "http://example.com", "events": ["visit.eaten"]}`)
+		Ω(status).Should(Equal(400))
+		Ω(fieldErrors(body)).Should(HaveKey("events"))
+	})
+
+	It("requires a visibility for users but not visits", func() {
+		status, body := asArya("PUT", "/user/2/visibility", `{}`)
+		Ω(status).Should(Equal(400))
+		Ω(fieldErrors(body)).Should(HaveKey("visibility"))
+	})
+
+	DescribeTable("rejects path ids that aren't whole numbers from 1",
+		func(url, field string) {
+			resp, err := http.Get(ts.URL + url)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resp.StatusCode).Should(Equal(400))
+			Ω(fieldErrors(getRespBody(resp))).Should(HaveKey(field))
+		},
+		Entry("words", "/user/abc/visits", "userID"),
+		Entry("zero", "/user/0/visits", "userID"),
+		Entry("negative", "/user/-1/visits", "userID"),
+		Entry("state", "/state/x/cities", "stateID"),
+	)
+
+	DescribeTable("rejects numbers in the query or path with the field",
+		func(url, field string) {
+			resp, err := http.Get(ts.URL + url)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resp.StatusCode).Should(Equal(400))
+			Ω(fieldErrors(getRespBody(resp))).Should(HaveKey(field))
+		},
+		Entry("tile zoom", "/tiles/cities/30/0/0.mvt", "z"),
+		Entry("tile x", "/tiles/cities/1/2/0.mvt", "x"),
+		Entry("tile y", "/tiles/cities/1/0/a.mvt", "y"),
+		Entry("tile format", "/tiles/cities/0/0/0.png", "y"),
+		Entry("tile user", "/tiles/visits/0/0/0.mvt?userId=x", "userId"),
+		Entry("cluster zoom", "/map/clusters?zoom=-1", "zoom"),
+		Entry("cluster user", "/map/clusters?zoom=1&userId=0", "userId"),
+		Entry("stream user", "/stream?userId=abc", "userId"),
+		Entry("radius", "/cities/near?lat=0&lon=0&radiusKm=far", "radiusKm"),
+		Entry("no radius", "/cities/near?lat=0&lon=0&radiusKm=0", "radiusKm"),
+	)
+
+	DescribeTable("rejects coordinates out of range",
+		func(query, field string) {
+			resp, err := http.Get(ts.URL + "/cities/near?" + query)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(resp.StatusCode).Should(Equal(400))
+			Ω(fieldErrors(getRespBody(resp))).Should(HaveKey(field))
+		},
+		Entry("latitude", "lat=91&lon=0", "lat"),
+		Entry("longitude", "lat=0&lon=-181", "lon"),
+		Entry("not a number", "lat=north&lon=0", "lat"),
+	)
+})
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// An audience is named by the most private visibility it is allowed to see
+var audienceVisibilities = map[string][]string{
+	models.VisibilityPublic: {models.VisibilityPublic},
+	models.VisibilityFollowers: {
+		models.VisibilityPublic, models.VisibilityFollowers},
+	models.VisibilityPrivate: {
+		models.VisibilityPublic, models.VisibilityFollowers,
+		models.VisibilityPrivate},
+	shareLinkAudience: {
+		models.VisibilityPublic, models.VisibilityFollowers},
+}
+
+// effectiveVisibility is the visibility of a visit in sql, for queries that
+// join `visits` and `users` across many users
+const effectiveVisibility = `COALESCE(
+	NULLIF(visits.visibility, ''), NULLIF(users.visibility, ''), 'public')`
+
+// VisibilityRequest is the struct for changing a user's or visit's
+// visibility
+type VisibilityRequest struct {
+	Visibility string `json:"visibility" validate:"oneof=public followers private"`
+}
+
+// getAudience works out what the authenticated user (if any) is allowed to
+// see of the owner's visits
+func getAudience(c *gin.Context, db *gorm.DB, owner *models.User) (string, error) {
+	viewer := authUser(c)
+	if viewer == nil {
+		return models.VisibilityPublic, nil
+	}
+	if viewer.ID == owner.ID {
+		return models.VisibilityPrivate, nil
+	}
+	var count int
+	err := db.Model(&models.Follow{}).
+		Where("follower_id = ? AND followee_id = ? AND status = ?",
+			viewer.ID, owner.ID, models.FollowAccepted).
+		Count(&count).Error
+	if err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return models.VisibilityFollowers, nil
+	}
+	return models.VisibilityPublic, nil
+}
+
+// visibleClause returns a where clause, and its args, limiting the owner's
+// `visits` rows to those the audience can see
+func visibleClause(owner *models.User, audience string) (string, []interface{}) {
+	if audience == models.VisibilityPrivate {
+		return "1 = 1", nil
+	}
+	levels := audienceVisibilities[audience]
+	args := []interface{}{}
+	inheritsDefault := audience == shareLinkAudience
+	for _, level := range levels {
+		args = append(args, level)
+		if level == owner.DefaultVisibility() {
+			inheritsDefault = true
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(levels)), ", ")
+	clause := fmt.Sprintf("visits.visibility IN (%s)", placeholders)
+	if inheritsDefault {
+		clause = fmt.Sprintf(
+			"(COALESCE(visits.visibility, '') = '' OR %s)", clause)
+	}
+	return clause, args
+}
+
+// visitVisibility is who can see the user's visit
+func visitVisibility(user *models.User, visit *models.Visit) string {
+	if visit.Visibility != "" {
+		return visit.Visibility
+	}
+	return user.DefaultVisibility()
+}
+
+// canSee is true if the audience is allowed to see the visibility
+func canSee(audience, visibility string) bool {
+	for _, level := range audienceVisibilities[audience] {
+		if level == visibility {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleVisit is the user's visit if the audience is allowed to see it, or
+// nil
+func visibleVisit(
+	audience string, user *models.User, visit *models.Visit,
+) *models.Visit {
+	if visit == nil || !canSee(audience, visitVisibility(user, visit)) {
+		return nil
+	}
+	return visit
+}
+
+// get the audience for the user in the path
+// sends a json error response and returns "" if it can't
+func getPathAudience(c *gin.Context, db *gorm.DB, owner *models.User) string {
+	audience, err := getAudience(c, db, owner)
+	if err != nil {
+		jsonError(c, "error checking visibility", err)
+		return ""
+	}
+	return audience
+}
+
+func bindVisibility(c *gin.Context, allowBlank bool) (string, bool) {
+	var req VisibilityRequest
+	if !bindJSON(c, &req) {
+		return "", false
+	}
+	if !allowBlank {
+		if errs := validate.Var("visibility", req.Visibility, "required"); errs != nil {
+			jsonFieldErrors(c, errs)
+			return "", false
+		}
+	}
+	return req.Visibility, true
+}
+
+// UserUpdate is the data of a user.updated event. Anyone can subscribe to
+// those, so it only has what's public about the user.
+type UserUpdate struct {
+	PublicUser
+	Visibility string `json:"visibility"`
+}
+
+func getSetUserVisibilityHandler(
+	cfg *conf.Config, db *gorm.DB, publish publisher,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		visibility, ok := bindVisibility(c, false)
+		if !ok {
+			return
+		}
+		if err := db.Model(user).Update("visibility", visibility).Error; err != nil {
+			jsonError(c, "error saving visibility", err)
+			return
+		}
+		publish(stream.Event{
+			Type: stream.UserUpdated, UserID: user.ID,
+			Data: &UserUpdate{
+				PublicUser{user.ID, user.FirstName, user.LastName},
+				user.DefaultVisibility(),
+			},
+			Visibility: models.VisibilityPublic,
+		})
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+func getSetVisitVisibilityHandler(
+	cfg *conf.Config, db *gorm.DB, publish publisher,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		visitID, ok := getPathID(c, "visitID")
+		if !ok {
+			return
+		}
+		var visit models.Visit
+		q := db.Where("id = ? AND user_id = ?", visitID, user.ID).First(&visit)
+		if q.RecordNotFound() {
+			jsonErrorStatus(c, http.StatusNotFound, "visit not found", nil)
+			return
+		} else if err := q.Error; err != nil {
+			jsonError(c, "error looking up visit", err)
+			return
+		}
+		// a blank visibility goes back to the user's default
+		visibility, ok := bindVisibility(c, true)
+		if !ok {
+			return
+		}
+		err := db.Model(&visit).Update("visibility", visibility).Error
+		if err != nil {
+			jsonError(c, "error saving visibility", err)
+			return
+		}
+		publish(stream.Event{
+			Type: stream.VisitUpdated, UserID: user.ID, Data: &visit,
+			Visibility: visitVisibility(user, &visit),
+		})
+		c.JSON(http.StatusOK, &visit)
+	}
+}
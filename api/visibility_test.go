@@ -0,0 +1,245 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Visibility", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+	asSansa := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "sansa@winterfell.net", "lemoncakes", body)
+	}
+	asHound := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "hound@clegane.net", "chicken", body)
+	}
+	cityNames := func(status int, body []byte) []string {
+		Ω(status).Should(Equal(200), string(body))
+		var out struct {
+			Count int
+			Data  []models.City
+		}
+		json.Unmarshal(body, &out)
+		Ω(out.Data).Should(HaveLen(out.Count))
+		names := []string{}
+		for _, city := range out.Data {
+			names = append(names, city.Name)
+		}
+		return names
+	}
+	anonymous := func(url string) (int, []byte) {
+		return doAuthRequest("GET", ts.URL+url, "", "", "")
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+		createTestUser(db, "Sansa", "sansa@winterfell.net", "lemoncakes")
+		createTestUser(db, "Sandor", "hound@clegane.net", "chicken")
+		// arya follows sansa
+		status, _ := asArya("POST", "/user/2/following", `{"userId": 3}`)
+		Ω(status).Should(Equal(201))
+		status, _ = asSansa("POST", "/user/3/follow-requests/2/accept", "")
+		Ω(status).Should(Equal(200))
+		postVisits(ts, 3,
+			`{ "city": "Winterfell", "state": "WS" }`,
+			`{ "city": "Kings Landing", "state": "WS", "visibility": "followers" }`,
+			`{ "city": "Qarth", "state": "ES", "visibility": "private" }`,
+		)
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	Context("a public user", func() {
+		It("shows public visits to everyone", func() {
+			Ω(cityNames(anonymous("/user/3/visits"))).Should(
+				ConsistOf("Winterfell"))
+			Ω(cityNames(asHound("GET", "/user/3/visits", ""))).Should(
+				ConsistOf("Winterfell"))
+		})
+
+		It("shows followers-only visits to followers", func() {
+			Ω(cityNames(asArya("GET", "/user/3/visits", ""))).Should(
+				ConsistOf("Winterfell", "Kings Landing"))
+		})
+
+		It("shows everything to the user", func() {
+			Ω(cityNames(asSansa("GET", "/user/3/visits", ""))).Should(
+				ConsistOf("Winterfell", "Kings Landing", "Qarth"))
+		})
+
+		It("applies to visited states", func() {
+			var out struct{ Data []VisitedState }
+			_, body := anonymous("/user/3/visits/states")
+			json.Unmarshal(body, &out)
+			Ω(out.Data).Should(HaveLen(1))
+			Ω(out.Data[0].CitiesVisited).Should(Equal(uint(1)))
+			_, body = asSansa("GET", "/user/3/visits/states", "")
+			json.Unmarshal(body, &out)
+			Ω(out.Data).Should(HaveLen(2))
+		})
+
+		It("applies to stats", func() {
+			var stats UserStats
+			_, body := anonymous("/user/3/stats")
+			json.Unmarshal(body, &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(1)))
+			_, body = asArya("GET", "/user/3/stats", "")
+			json.Unmarshal(body, &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(2)))
+			_, body = asSansa("GET", "/user/3/stats", "")
+			json.Unmarshal(body, &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(3)))
+		})
+
+		It("rejects bad credentials", func() {
+			status, _ := doAuthRequest(
+				"GET", ts.URL+"/user/3/visits", "arya@winterfell.net", "no", "")
+			Ω(status).Should(Equal(401))
+		})
+	})
+
+	Context("changing visibility", func() {
+		It("hides default visits from strangers for followers-only users", func() {
+			status, _ := asSansa(
+				"PUT", "/user/3/visibility", `{"visibility": "followers"}`)
+			Ω(status).Should(Equal(200))
+			Ω(cityNames(anonymous("/user/3/visits"))).Should(BeEmpty())
+			Ω(cityNames(asArya("GET", "/user/3/visits", ""))).Should(
+				ConsistOf("Winterfell", "Kings Landing"))
+		})
+
+		It("lets public visits of private users through", func() {
+			status, _ := asSansa(
+				"PUT", "/user/3/visibility", `{"visibility": "private"}`)
+			Ω(status).Should(Equal(200))
+			Ω(cityNames(asArya("GET", "/user/3/visits", ""))).Should(
+				ConsistOf("Kings Landing"))
+			status, _ = asSansa("PUT", "/user/3/visits/2/visibility",
+				`{"visibility": "public"}`)
+			Ω(status).Should(Equal(200))
+			Ω(cityNames(anonymous("/user/3/visits"))).Should(
+				ConsistOf("Kings Landing"))
+		})
+
+		It("invalidates cached stats", func() {
+			var stats UserStats
+			_, body := anonymous("/user/3/stats")
+			json.Unmarshal(body, &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(1)))
+			asSansa("PUT", "/user/3/visits/3/visibility", `{"visibility": ""}`)
+			_, body = anonymous("/user/3/stats")
+			json.Unmarshal(body, &stats)
+			Ω(stats.TotalVisits).Should(Equal(uint(2)))
+		})
+
+		It("only lets the user change it", func() {
+			status, _ := asArya(
+				"PUT", "/user/3/visibility", `{"visibility": "public"}`)
+			Ω(status).Should(Equal(403))
+			status, _ = asArya(
+				"PUT", "/user/3/visits/3/visibility", `{"visibility": "public"}`)
+			Ω(status).Should(Equal(403))
+		})
+
+		It("won't change other users' visits", func() {
+			postVisits(ts, 2, `{ "city": "Qarth", "state": "ES" }`)
+			status, _ := asSansa(
+				"PUT", "/user/3/visits/4/visibility", `{"visibility": "public"}`)
+			Ω(status).Should(Equal(404))
+		})
+
+		It("rejects unknown settings", func() {
+			status, _ := asSansa(
+				"PUT", "/user/3/visibility", `{"visibility": "secret"}`)
+			Ω(status).Should(Equal(400))
+			status, _ = asSansa(
+				"PUT", "/user/3/visibility", `{"visibility": ""}`)
+			Ω(status).Should(Equal(400))
+		})
+	})
+
+	Context("share links", func() {
+		var link models.ShareLink
+
+		BeforeEach(func() {
+			status, body := asSansa("POST", "/user/3/share-links", "")
+			Ω(status).Should(Equal(201))
+			json.Unmarshal(body, &link)
+			Ω(link.Token).ShouldNot(BeEmpty())
+		})
+
+		It("shows what followers can see", func() {
+			Ω(cityNames(anonymous("/shared/" + link.Token + "/visits"))).Should(
+				ConsistOf("Winterfell", "Kings Landing"))
+			var out struct{ Count int }
+			_, body := anonymous("/shared/" + link.Token + "/visits/states")
+			json.Unmarshal(body, &out)
+			Ω(out.Count).Should(Equal(1))
+		})
+
+		It("shows visits left at a private default", func() {
+			status, _ := asSansa(
+				"PUT", "/user/3/visibility", `{"visibility": "private"}`)
+			Ω(status).Should(Equal(200))
+			Ω(cityNames(anonymous("/user/3/visits"))).Should(BeEmpty())
+			// still not the visit marked private itself
+			Ω(cityNames(anonymous("/shared/" + link.Token + "/visits"))).Should(
+				ConsistOf("Winterfell", "Kings Landing"))
+		})
+
+		It("doesn't work with a made up token", func() {
+			status, _ := anonymous("/shared/nope/visits")
+			Ω(status).Should(Equal(404))
+		})
+
+		It("lists links without the token", func() {
+			var out struct {
+				Count int
+				Data  []models.ShareLink
+			}
+			status, body := asSansa("GET", "/user/3/share-links", "")
+			Ω(status).Should(Equal(200))
+			json.Unmarshal(body, &out)
+			Ω(out.Count).Should(Equal(1))
+			Ω(out.Data[0].ID).Should(Equal(link.ID))
+			Ω(out.Data[0].Token).Should(BeEmpty())
+		})
+
+		It("can be revoked", func() {
+			status, _ := asArya("DELETE", "/user/3/share-links/1", "")
+			Ω(status).Should(Equal(403))
+			status, _ = asSansa("DELETE", "/user/3/share-links/1", "")
+			Ω(status).Should(Equal(204))
+			status, _ = asSansa("DELETE", "/user/3/share-links/1", "")
+			Ω(status).Should(Equal(404))
+			status, _ = anonymous("/shared/" + link.Token + "/visits")
+			Ω(status).Should(Equal(404))
+		})
+
+		It("requires authentication", func() {
+			status, _ := doAuthRequest(
+				"POST", ts.URL+"/user/3/share-links", "", "", "")
+			Ω(status).Should(Equal(401))
+		})
+	})
+})
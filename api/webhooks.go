@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/bobisme/RestApiProject/validate"
+	"github.com/bobisme/RestApiProject/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// events webhooks can subscribe to
+var webhookEvents = map[string]bool{
+	stream.VisitCreated: true,
+	stream.VisitUpdated: true,
+	stream.VisitDeleted: true,
+	stream.UserUpdated:  true,
+}
+
+// WebhookRequest is the struct for registering a webhook
+type WebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required"`
+	// AllUsers gets every user's events, for admins only
+	AllUsers bool `json:"allUsers"`
+}
+
+// NewWebhookResponse includes the signing secret, which is only ever shown
+// when the webhook is created
+type NewWebhookResponse struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Validate that the webhook only asks for events there are, and isn't sent
+// anywhere inside the network
+func (req WebhookRequest) Validate() validate.Errors {
+	for _, event := range req.Events {
+		if !webhookEvents[event] {
+			return validate.Errors{{
+				Field: "events", Rule: "event",
+				Message: fmt.Sprintf("events has unknown event %q", event),
+			}}
+		}
+	}
+	if err := webhook.CheckURL(req.URL); err != nil {
+		return validate.Errors{{
+			Field: "url", Rule: "public",
+			Message: "url must be a public address: " + err.Error(),
+		}}
+	}
+	return nil
+}
+
+// look up the actor's webhook in the path
+// sends a json error response and returns nil if it can't
+func getWebhook(c *gin.Context, db *gorm.DB, user *models.User) *models.Webhook {
+	webhookID, ok := getPathID(c, "webhookID")
+	if !ok {
+		return nil
+	}
+	var hook models.Webhook
+	q := db.Where("id = ? AND user_id = ?", webhookID, user.ID).First(&hook)
+	if q.RecordNotFound() {
+		jsonErrorStatus(c, http.StatusNotFound, "webhook not found", nil)
+		return nil
+	} else if err := q.Error; err != nil {
+		jsonError(c, "error looking up webhook", err)
+		return nil
+	}
+	return &hook
+}
+
+func getNewWebhookHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		var req WebhookRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		if req.AllUsers && !user.Admin {
+			jsonErrorStatus(c, http.StatusForbidden,
+				"only admins can get every user's events", nil)
+			return
+		}
+		secret, err := newWebhookSecret()
+		if err != nil {
+			jsonErrorStatus(c, http.StatusInternalServerError,
+				"error creating webhook", err)
+			return
+		}
+		hook := models.Webhook{
+			UserID:     user.ID,
+			URL:        req.URL,
+			AllUsers:   req.AllUsers,
+			EventTypes: req.Events,
+			Secret:     secret,
+		}
+		if err := db.Create(&hook).Error; err != nil {
+			jsonError(c, "error saving webhook", err)
+			return
+		}
+		c.JSON(http.StatusCreated, &NewWebhookResponse{hook, secret})
+	}
+}
+
+func getWebhooksHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		var count int
+		hooks := []models.Webhook{}
+		q := db.Model(&models.Webhook{}).Where("user_id = ?", user.ID)
+		if err := q.Count(&count).Error; err != nil {
+			jsonError(c, "error counting webhooks", err)
+			return
+		}
+		if err := q.Limit(limit).Offset(offset).Find(&hooks).Error; err != nil {
+			jsonError(c, "error looking up webhooks", err)
+			return
+		}
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(count), hooks,
+		})
+	}
+}
+
+func getDeleteWebhookHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		hook := getWebhook(c, db, user)
+		if hook == nil {
+			return
+		}
+		// pending deliveries fail once the dispatcher sees the webhook is gone
+		if err := db.Delete(hook).Error; err != nil {
+			jsonError(c, "error removing webhook", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getWebhookDeliveriesHandler lists the webhook's deliveries, newest first
+func getWebhookDeliveriesHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		hook := getWebhook(c, db, user)
+		if hook == nil {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		var count int
+		deliveries := []models.WebhookDelivery{}
+		q := db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+		if status := c.Query("status"); status != "" {
+			q = q.Where("status = ?", status)
+		}
+		if err := q.Count(&count).Error; err != nil {
+			jsonError(c, "error counting deliveries", err)
+			return
+		}
+		err := q.Order("created_at DESC, id DESC").
+			Limit(limit).Offset(offset).Find(&deliveries).Error
+		if err != nil {
+			jsonError(c, "error looking up deliveries", err)
+			return
+		}
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(count), deliveries,
+		})
+	}
+}
+
+func setWebhookRoutes(cfg *conf.Config, db *gorm.DB, r *gin.Engine) {
+	auth := requireAuth(db)
+	r.POST("/user/:userID/webhooks", auth, getNewWebhookHandler(cfg, db))
+	r.GET("/user/:userID/webhooks", auth, getWebhooksHandler(cfg, db))
+	r.DELETE("/user/:userID/webhooks/:webhookID", auth,
+		getDeleteWebhookHandler(cfg, db))
+	r.GET("/user/:userID/webhooks/:webhookID/deliveries", auth,
+		getWebhookDeliveriesHandler(cfg, db))
+}
@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhooks", func() {
+	const winterfell = `{ "city": "Winterfell", "state": "WS" }`
+
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+	createHook := func(body string) NewWebhookResponse {
+		status, resp := asArya("POST", "/user/2/webhooks", body)
+		Ω(status).Should(Equal(201), string(resp))
+		var hook NewWebhookResponse
+		Ω(json.Unmarshal(resp, &hook)).Should(Succeed())
+		return hook
+	}
+	getDeliveries := func(url string) []models.WebhookDelivery {
+		status, body := asArya("GET", url, "")
+		Ω(status).Should(Equal(200), string(body))
+		var out struct {
+			Count int
+			Data  []models.WebhookDelivery
+		}
+		Ω(json.Unmarshal(body, &out)).Should(Succeed())
+		Ω(out.Count).Should(Equal(len(out.Data)))
+		return out.Data
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("creates a webhook and shows the secret once", func() {
+		hook := createHook(
+			`{"url": "http://example.com/hook", "events": ["visit.created"]}`)
+		Ω(hook.ID).ShouldNot(BeZero())
+		Ω(hook.URL).Should(Equal("http://example.com/hook"))
+		Ω(hook.EventTypes).Should(Equal([]string{"visit.created"}))
+		Ω(hook.Secret).Should(HaveLen(64))
+
+		status, body := asArya("GET", "/user/2/webhooks", "")
+		Ω(status).Should(Equal(200))
+		Ω(string(body)).Should(ContainSubstring(`"visit.created"`))
+		Ω(string(body)).ShouldNot(ContainSubstring(hook.Secret))
+	})
+
+	It("rejects bad urls and events", func() {
+		status, _ := asArya("POST", "/user/2/webhooks",
+			`{"url": "ftp://example.com", "events": ["visit.created"]}`)
+		Ω(status).Should(Equal(400))
+		status, _ = asArya("POST", "/user/2/webhooks",
+			`{"url": "http://example.com", "events": ["visit.eaten"]}`)
+		Ω(status).Should(Equal(400))
+		status, _ = asArya("POST", "/user/2/webhooks",
+			`{"url": "http://example.com", "events": []}`)
+		Ω(status).Should(Equal(400))
+	})
+
+	It("rejects urls inside the network", func() {
+		for _, url := range []string{
+			"http://localhost:8080/hook",
+			"http://127.0.0.1/hook",
+			"http://169.254.169.254/latest/meta-data",
+			"http://10.1.2.3/hook",
+			"http://192.168.0.1/hook",
+			"http://[::1]/hook",
+			"http://[::ffff:172.16.0.1]/hook",
+		} {
+			status, body := asArya("POST", "/user/2/webhooks",
+				`{"url": "`+url+`", "events": ["visit.created"]}`)
+			Ω(status).Should(Equal(400), url)
+			Ω(string(body)).Should(ContainSubstring(`"field":"url"`))
+			Ω(string(body)).Should(ContainSubstring("not a public address"))
+		}
+		hook := createHook(
+			`{"url": "https://93.184.216.34/hook", "events": ["visit.created"]}`)
+		Ω(hook.URL).Should(Equal("https://93.184.216.34/hook"))
+	})
+
+	It("only lets admins get every user's events", func() {
+		body := `{"url": "http://example.com", "events": ["visit.created"],
+			"allUsers": true}`
+		status, _ := asArya("POST", "/user/2/webhooks", body)
+		Ω(status).Should(Equal(403))
+
+		db.Model(&models.User{}).Where("id = ?", 2).Update("admin", true)
+		hook := createHook(body)
+		Ω(hook.AllUsers).Should(BeTrue())
+	})
+
+	It("requires the actor to be the authenticated user", func() {
+		status, _ := asArya("GET", "/user/1/webhooks", "")
+		Ω(status).Should(Equal(403))
+	})
+
+	It("logs deliveries for the user's events", func() {
+		hook := createHook(
+			`{"url": "http://example.com", "events": ["visit.created"]}`)
+		postVisits(ts, 2, winterfell, winterfell)
+		// john's visits aren't arya's business
+		postVisits(ts, 1, winterfell)
+
+		url := "/user/2/webhooks/" + strconv.Itoa(int(hook.ID)) + "/deliveries"
+		deliveries := getDeliveries(url)
+		Ω(deliveries).Should(HaveLen(2))
+		Ω(deliveries[0].ID).Should(BeNumerically(">", deliveries[1].ID))
+		Ω(deliveries[0].EventType).Should(Equal("visit.created"))
+		Ω(deliveries[0].Status).Should(Equal(models.DeliveryPending))
+		Ω(getDeliveries(url + "?status=delivered")).Should(BeEmpty())
+
+		var payload struct{ Time time.Time }
+		Ω(json.Unmarshal([]byte(deliveries[0].Payload), &payload)).Should(Succeed())
+		Ω(payload.Time).Should(BeTemporally("~", time.Now(), time.Minute))
+	})
+
+	It("deletes webhooks", func() {
+		hook := createHook(
+			`{"url": "http://example.com", "events": ["visit.created"]}`)
+		url := "/user/2/webhooks/" + strconv.Itoa(int(hook.ID))
+		status, _ := asArya("DELETE", url, "")
+		Ω(status).Should(Equal(204))
+		status, _ = asArya("DELETE", url, "")
+		Ω(status).Should(Equal(404))
+		postVisits(ts, 2, winterfell)
+		var count int
+		db.Model(&models.WebhookDelivery{}).Count(&count)
+		Ω(count).Should(BeZero())
+	})
+})
@@ -0,0 +1,246 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// WishlistRequest is the struct for adding a city to a wishlist
+type WishlistRequest struct {
+	City  string `json:"city" validate:"required,max=100"`
+	State string `json:"state" validate:"required,state"`
+	Note  string `json:"note" validate:"max=500"`
+}
+
+// SuggestedWishlistEntry is a wishlist entry and how far it is from where
+// the user was last
+type SuggestedWishlistEntry struct {
+	models.WishlistEntry
+	// DistanceKm is from the user's most recent visit, or null if they
+	// haven't visited anywhere
+	DistanceKm *float64 `json:"distanceKm"`
+}
+
+// look up the user's wishlist entry in the path
+// sends a json error response and returns nil if it can't
+func getWishlistEntry(
+	c *gin.Context, db *gorm.DB, user *models.User,
+) *models.WishlistEntry {
+	entryID, ok := getPathID(c, "entryID")
+	if !ok {
+		return nil
+	}
+	var entry models.WishlistEntry
+	q := db.Preload("City").
+		Where("id = ? AND user_id = ?", entryID, user.ID).First(&entry)
+	if q.RecordNotFound() {
+		jsonErrorStatus(c, http.StatusNotFound, "wishlist entry not found", nil)
+		return nil
+	} else if err := q.Error; err != nil {
+		jsonError(c, "error looking up wishlist entry", err)
+		return nil
+	}
+	return &entry
+}
+
+// getWishlist gets every entry in the user's wishlist, newest first
+func getWishlist(db *gorm.DB, user *models.User) ([]models.WishlistEntry, error) {
+	entries := []models.WishlistEntry{}
+	err := db.Preload("City").Where("user_id = ?", user.ID).
+		Order("created_at DESC, id DESC").Find(&entries).Error
+	return entries, err
+}
+
+func getNewWishlistEntryHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		var req WishlistRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		city := lookupCity(c, db, req.City, req.State)
+		if city == nil {
+			return
+		}
+		var count int
+		err := db.Model(&models.WishlistEntry{}).
+			Where("user_id = ? AND city_id = ?", user.ID, city.ID).
+			Count(&count).Error
+		if err != nil {
+			jsonError(c, "error looking up wishlist", err)
+			return
+		} else if count > 0 {
+			jsonErrorStatus(c, http.StatusConflict,
+				"city is already on your wishlist", nil)
+			return
+		}
+		entry := models.WishlistEntry{
+			UserID: user.ID, CityID: city.ID, Note: req.Note,
+		}
+		if err := db.Create(&entry).Error; err != nil {
+			jsonError(c, "error saving wishlist entry", err)
+			return
+		}
+		entry.City = *city
+		c.JSON(http.StatusCreated, &entry)
+	}
+}
+
+// getWishlistHandler lists the user's wishlist for viewers who can see
+// the user's visits by default
+func getWishlistHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getUser(c, db)
+		if user == nil {
+			return
+		}
+		audience := getPathAudience(c, db, user)
+		if audience == "" {
+			return
+		}
+		version, err := rowsVersion(db, "wishlist_entries", "user_id = ?", user.ID)
+		if err != nil {
+			jsonError(c, "error looking up wishlist", err)
+			return
+		}
+		version = version.and(resourceVersion{modified: user.UpdatedAt})
+		if notModified(c, version, audience) {
+			return
+		}
+		limit, offset := getLimitOffset(c)
+		var count int
+		entries := []models.WishlistEntry{}
+		if canSee(audience, user.DefaultVisibility()) {
+			q := db.Model(&models.WishlistEntry{}).Where("user_id = ?", user.ID)
+			if err := q.Count(&count).Error; err != nil {
+				jsonError(c, "error counting wishlist entries", err)
+				return
+			}
+			err := q.Preload("City").Order("created_at DESC, id DESC").
+				Limit(limit).Offset(offset).Find(&entries).Error
+			if err != nil {
+				jsonError(c, "error looking up wishlist", err)
+				return
+			}
+		}
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(count), entries,
+		})
+	}
+}
+
+func getDeleteWishlistEntryHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		entry := getWishlistEntry(c, db, user)
+		if entry == nil {
+			return
+		}
+		if err := db.Delete(entry).Error; err != nil {
+			jsonError(c, "error removing wishlist entry", err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// getVisitWishlistEntryHandler turns a wishlist entry into a real visit
+// and takes it off the wishlist
+func getVisitWishlistEntryHandler(
+	cfg *conf.Config, db *gorm.DB, publish publisher,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		entry := getWishlistEntry(c, db, user)
+		if entry == nil {
+			return
+		}
+		// a body is optional, it's only for the visibility
+		visibility := ""
+		if c.Request.ContentLength != 0 {
+			var ok bool
+			if visibility, ok = bindVisibility(c, true); !ok {
+				return
+			}
+		}
+		createVisit(c, cfg, db, publish, user, &entry.City, visibility,
+			func(tx *gorm.DB) error {
+				return tx.Delete(entry).Error
+			})
+	}
+}
+
+// getSuggestedWishlistHandler ranks the user's wishlist by how close each
+// city is to where they were last
+func getSuggestedWishlistHandler(cfg *conf.Config, db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getActor(c, db)
+		if user == nil {
+			return
+		}
+		entries, err := getWishlist(db, user)
+		if err != nil {
+			jsonError(c, "error looking up wishlist", err)
+			return
+		}
+		var last models.Visit
+		q := db.Preload("City").Where("user_id = ?", user.ID).
+			Order("created_at DESC, id DESC").First(&last)
+		if q.Error != nil && !q.RecordNotFound() {
+			jsonError(c, "error looking up your last visit", q.Error)
+			return
+		}
+
+		suggested := make([]SuggestedWishlistEntry, len(entries))
+		for i, entry := range entries {
+			suggested[i].WishlistEntry = entry
+			if !q.RecordNotFound() {
+				d := geo.Distance(
+					geo.Point{Lat: last.City.Lat, Lon: last.City.Lon},
+					geo.Point{Lat: entry.City.Lat, Lon: entry.City.Lon})
+				suggested[i].DistanceKm = &d
+			}
+		}
+		// without a last visit, the wishlist stays newest first
+		sort.SliceStable(suggested, func(i, j int) bool {
+			a, b := suggested[i].DistanceKm, suggested[j].DistanceKm
+			return a != nil && b != nil && *a < *b
+		})
+		limit, offset := getLimitOffset(c)
+		start, end := pageBounds(len(suggested), limit, offset)
+		c.JSON(http.StatusOK, &MetaResponse{
+			limit, offset, uint(len(suggested)), suggested[start:end],
+		})
+	}
+}
+
+func setWishlistRoutes(
+	cfg *conf.Config, db *gorm.DB, r *gin.Engine, publish publisher,
+) {
+	auth := requireAuth(db)
+	viewer := optionalAuth(db)
+	r.POST("/user/:userID/wishlist", auth,
+		getNewWishlistEntryHandler(cfg, db))
+	r.GET("/user/:userID/wishlist", viewer, getWishlistHandler(cfg, db))
+	r.GET("/user/:userID/wishlist/suggested", auth,
+		getSuggestedWishlistHandler(cfg, db))
+	r.DELETE("/user/:userID/wishlist/:entryID", auth,
+		getDeleteWishlistEntryHandler(cfg, db))
+	r.POST("/user/:userID/wishlist/:entryID/visit", auth,
+		getVisitWishlistEntryHandler(cfg, db, publish))
+}
@@ -0,0 +1,129 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+
+	. "github.com/bobisme/RestApiProject/api"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wishlist", func() {
+	var (
+		db *gorm.DB
+		ts *httptest.Server
+	)
+
+	asArya := func(method, url, body string) (int, []byte) {
+		return doAuthRequest(
+			method, ts.URL+url, "arya@winterfell.net", "needle", body)
+	}
+	wish := func(city, state string) models.WishlistEntry {
+		status, body := asArya("POST", "/user/2/wishlist",
+			`{"city": "`+city+`", "state": "`+state+`", "note": "someday"}`)
+		Ω(status).Should(Equal(201), string(body))
+		var entry models.WishlistEntry
+		Ω(json.Unmarshal(body, &entry)).Should(Succeed())
+		return entry
+	}
+	entryURL := func(entry models.WishlistEntry) string {
+		return "/user/2/wishlist/" + strconv.Itoa(int(entry.ID))
+	}
+
+	BeforeEach(func() {
+		db, ts = startTestServer()
+		createTestUser(db, "Arya", "arya@winterfell.net", "needle")
+	})
+
+	AfterEach(func() {
+		stopTestServer(db, ts)
+	})
+
+	It("adds cities to the wishlist once", func() {
+		entry := wish("Qarth", "ES")
+		Ω(entry.City.Name).Should(Equal("Qarth"))
+		Ω(entry.Note).Should(Equal("someday"))
+		status, _ := asArya("POST", "/user/2/wishlist",
+			`{"city": "Qarth", "state": "ES"}`)
+		Ω(status).Should(Equal(409))
+		status, _ = asArya("POST", "/user/2/wishlist",
+			`{"city": "Braavos", "state": "ES"}`)
+		Ω(status).Should(Equal(400))
+	})
+
+	It("lists and removes entries", func() {
+		wish("Qarth", "ES")
+		entry := wish("Winterfell", "WS")
+		var out struct {
+			Count int
+			Data  []models.WishlistEntry
+		}
+		Ω(getTestJSON(ts, "/user/2/wishlist", &out)).Should(Equal(200))
+		Ω(out.Count).Should(Equal(2))
+		Ω(out.Data[0].City.Name).Should(Equal("Winterfell"))
+
+		status, _ := asArya("DELETE", entryURL(entry), "")
+		Ω(status).Should(Equal(204))
+		getTestJSON(ts, "/user/2/wishlist", &out)
+		Ω(out.Count).Should(Equal(1))
+	})
+
+	It("isn't a visit", func() {
+		wish("Qarth", "ES")
+		var out struct{ Count int }
+		getTestJSON(ts, "/user/2/visits", &out)
+		Ω(out.Count).Should(Equal(0))
+	})
+
+	It("turns an entry into a visit", func() {
+		entry := wish("Qarth", "ES")
+		status, body := asArya("POST", entryURL(entry)+"/visit",
+			`{"visibility": "followers"}`)
+		Ω(status).Should(Equal(201), string(body))
+		var visit models.Visit
+		json.Unmarshal(body, &visit)
+		Ω(visit.CityID).Should(Equal(entry.CityID))
+		Ω(visit.UserID).Should(Equal(uint(2)))
+		Ω(visit.Visibility).Should(Equal(models.VisibilityFollowers))
+
+		var out struct{ Count int }
+		getTestJSON(ts, "/user/2/wishlist", &out)
+		Ω(out.Count).Should(Equal(0))
+		status, _ = asArya("POST", entryURL(entry)+"/visit", "")
+		Ω(status).Should(Equal(404))
+	})
+
+	It("suggests the closest cities to the last visit first", func() {
+		wish("Winterfell", "WS")
+		wish("Qarth", "ES")
+		postVisits(ts, 2, `{ "city": "Kings Landing", "state": "WS" }`)
+
+		status, body := asArya("GET", "/user/2/wishlist/suggested", "")
+		Ω(status).Should(Equal(200), string(body))
+		var out struct {
+			Data []SuggestedWishlistEntry
+		}
+		json.Unmarshal(body, &out)
+		Ω(out.Data).Should(HaveLen(2))
+		Ω(out.Data[0].City.Name).Should(Equal("Winterfell"))
+		Ω(*out.Data[0].DistanceKm).Should(BeNumerically("~", 285, 1))
+		Ω(out.Data[1].City.Name).Should(Equal("Qarth"))
+	})
+
+	It("suggests newest first without any visits", func() {
+		wish("Winterfell", "WS")
+		wish("Qarth", "ES")
+		_, body := asArya("GET", "/user/2/wishlist/suggested", "")
+		var out struct {
+			Data []SuggestedWishlistEntry
+		}
+		json.Unmarshal(body, &out)
+		Ω(out.Data[0].City.Name).Should(Equal("Qarth"))
+		Ω(out.Data[0].DistanceKm).Should(BeNil())
+	})
+})
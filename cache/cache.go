@@ -0,0 +1,16 @@
+// Package cache stores bytes by key for a while, either in memory or in a
+// Redis server that several API servers can share
+package cache
+
+import "time"
+
+// Cache stores values by key. Keys that aren't set, or have expired, are
+// missing rather than errors.
+type Cache interface {
+	// Get the value for the key, and whether there was one
+	Get(key string) ([]byte, bool, error)
+	// Set the value for the key, expiring after ttl, or never if it is 0
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete the keys
+	Delete(keys ...string) error
+}
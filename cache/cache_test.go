@@ -0,0 +1,75 @@
+package cache_test
+
+import (
+	"time"
+
+	. "github.com/bobisme/RestApiProject/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// behavesLikeACache checks what every backend should do
+func behavesLikeACache(newCache func() Cache) {
+	var c Cache
+
+	BeforeEach(func() {
+		c = newCache()
+	})
+
+	It("misses keys that were never set", func() {
+		_, ok, err := c.Get("nothing")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ok).Should(BeFalse())
+	})
+
+	It("gets what was set", func() {
+		Ω(c.Set("winterfell", []byte("stark"), 0)).Should(Succeed())
+		value, ok, err := c.Get("winterfell")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ok).Should(BeTrue())
+		Ω(string(value)).Should(Equal("stark"))
+
+		Ω(c.Set("winterfell", []byte("bolton"), 0)).Should(Succeed())
+		value, _, _ = c.Get("winterfell")
+		Ω(string(value)).Should(Equal("bolton"))
+	})
+
+	It("keeps empty and binary values", func() {
+		Ω(c.Set("empty", []byte{}, 0)).Should(Succeed())
+		value, ok, _ := c.Get("empty")
+		Ω(ok).Should(BeTrue())
+		Ω(value).Should(BeEmpty())
+
+		binary := []byte("line\r\nbreak\x00")
+		Ω(c.Set("binary", binary, 0)).Should(Succeed())
+		value, _, _ = c.Get("binary")
+		Ω(value).Should(Equal(binary))
+	})
+
+	It("deletes keys", func() {
+		c.Set("a", []byte("1"), 0)
+		c.Set("b", []byte("2"), 0)
+		c.Set("c", []byte("3"), 0)
+		Ω(c.Delete("a", "b", "missing")).Should(Succeed())
+		Ω(c.Delete()).Should(Succeed())
+		_, ok, _ := c.Get("a")
+		Ω(ok).Should(BeFalse())
+		_, ok, _ = c.Get("b")
+		Ω(ok).Should(BeFalse())
+		_, ok, _ = c.Get("c")
+		Ω(ok).Should(BeTrue())
+	})
+
+	It("expires keys after their ttl", func() {
+		Ω(c.Set("brief", []byte("1"), 20*time.Millisecond)).Should(Succeed())
+		Ω(c.Set("lasting", []byte("2"), time.Minute)).Should(Succeed())
+		_, ok, _ := c.Get("brief")
+		Ω(ok).Should(BeTrue())
+		Eventually(func() bool {
+			_, ok, _ := c.Get("brief")
+			return ok
+		}).Should(BeFalse())
+		_, ok, _ = c.Get("lasting")
+		Ω(ok).Should(BeTrue())
+	})
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// Memory is a Cache in this process. When it's full the least recently
+// used key is dropped.
+type Memory struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+// NewMemory holding up to size keys
+func NewMemory(size int) *Memory {
+	if size < 1 {
+		size = 1
+	}
+	return &Memory{size: size, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get the value for the key
+func (m *Memory) Get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && !time.Now().Before(entry.expires) {
+		m.remove(el)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(el)
+	return append([]byte(nil), entry.value...), true, nil
+}
+
+// Set the value for the key
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := &memoryEntry{key: key, value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if el, ok := m.items[key]; ok {
+		el.Value = entry
+		m.order.MoveToFront(el)
+		return nil
+	}
+	m.items[key] = m.order.PushFront(entry)
+	for m.order.Len() > m.size {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+// Delete the keys
+func (m *Memory) Delete(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := m.items[key]; ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+// Len is how many keys are held, including expired ones that haven't been
+// dropped yet
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.order.Len()
+}
+
+func (m *Memory) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.items, el.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,38 @@
+package cache_test
+
+import (
+	. "github.com/bobisme/RestApiProject/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory", func() {
+	behavesLikeACache(func() Cache { return NewMemory(16) })
+
+	It("drops the least recently used key when full", func() {
+		m := NewMemory(2)
+		m.Set("a", []byte("1"), 0)
+		m.Set("b", []byte("2"), 0)
+		m.Get("a")
+		m.Set("c", []byte("3"), 0)
+		Ω(m.Len()).Should(Equal(2))
+		_, ok, _ := m.Get("b")
+		Ω(ok).Should(BeFalse())
+		_, ok, _ = m.Get("a")
+		Ω(ok).Should(BeTrue())
+		_, ok, _ = m.Get("c")
+		Ω(ok).Should(BeTrue())
+	})
+
+	It("doesn't share values with callers", func() {
+		m := NewMemory(2)
+		value := []byte("stark")
+		m.Set("winterfell", value, 0)
+		value[0] = 'S'
+		got, _, _ := m.Get("winterfell")
+		Ω(string(got)).Should(Equal("stark"))
+		got[0] = 'S'
+		got, _, _ = m.Get("winterfell")
+		Ω(string(got)).Should(Equal("stark"))
+	})
+})
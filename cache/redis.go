@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how many connections are kept open between commands
+const redisMaxIdle = 8
+
+// ErrRedisDown is returned without trying the server while it's backing
+// off after the server couldn't be reached
+var ErrRedisDown = errors.New("redis: server unreachable, backing off")
+
+// RedisError is an error reply from the server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// Redis is a Cache kept in a Redis server, or anything else that speaks
+// its protocol. Connections are opened as they're needed and reused.
+type Redis struct {
+	addr, password string
+	// Timeout for connecting and for each command
+	Timeout time.Duration
+	// Backoff is how long to skip the server for after it couldn't be
+	// reached, so callers aren't each held up by the timeout
+	Backoff time.Duration
+
+	mu        sync.Mutex
+	idle      []*redisConn
+	downUntil time.Time
+}
+
+// NewRedis for the server at addr, like localhost:6379. The password is
+// sent with AUTH unless it's blank.
+func NewRedis(addr, password string) *Redis {
+	return &Redis{
+		addr: addr, password: password,
+		Timeout: time.Second, Backoff: 5 * time.Second,
+	}
+}
+
+// conn takes an idle connection, or dials a new one if there aren't any or
+// fresh is true. reused says which.
+func (r *Redis) conn(fresh bool) (c *redisConn, reused bool, err error) {
+	r.mu.Lock()
+	if time.Now().Before(r.downUntil) {
+		r.mu.Unlock()
+		return nil, false, ErrRedisDown
+	}
+	if n := len(r.idle); n > 0 && !fresh {
+		c = r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return c, true, nil
+	}
+	r.mu.Unlock()
+	conn, err := net.DialTimeout("tcp", r.addr, r.Timeout)
+	if err != nil {
+		r.down()
+		return nil, false, err
+	}
+	c = &redisConn{conn, bufio.NewReader(conn), bufio.NewWriter(conn)}
+	if r.password != "" {
+		if _, err := c.do(r.Timeout, "AUTH", r.password); err != nil {
+			conn.Close()
+			if _, ok := err.(RedisError); !ok {
+				r.down()
+			}
+			return nil, false, err
+		}
+	}
+	return c, false, nil
+}
+
+// down starts backing off from the server
+func (r *Redis) down() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downUntil = time.Now().Add(r.Backoff)
+}
+
+func (r *Redis) release(c *redisConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.idle) >= redisMaxIdle {
+		c.conn.Close()
+		return
+	}
+	r.idle = append(r.idle, c)
+}
+
+// do sends the command and reads the reply, which is a string for a
+// status, []byte for a bulk string, int64, []interface{} or nil. Every
+// command the cache sends can safely be repeated, so one that fails on an
+// idle connection the server may have closed is tried again on a new one.
+func (r *Redis) do(args ...string) (interface{}, error) {
+	fresh := false
+	for {
+		c, reused, err := r.conn(fresh)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := c.do(r.Timeout, args...)
+		if _, ok := err.(RedisError); err != nil && !ok {
+			// the connection might be part way through a reply
+			c.conn.Close()
+			if reused {
+				fresh = true
+				continue
+			}
+			// a new connection failing too is as good as not connecting
+			r.down()
+			return nil, err
+		}
+		r.release(c)
+		return reply, err
+	}
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RedisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Ping checks the server can be reached
+func (r *Redis) Ping() error {
+	_, err := r.do("PING")
+	return err
+}
+
+// Get the value for the key
+func (r *Redis) Get(key string) ([]byte, bool, error) {
+	reply, err := r.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: GET replied with %T", reply)
+	}
+	return value, true, nil
+}
+
+// Set the value for the key
+func (r *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		ms := int64(ttl / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	_, err := r.do(args...)
+	return err
+}
+
+// Delete the keys
+func (r *Redis) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := r.do(append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// Close the connections that aren't being used
+func (r *Redis) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.idle {
+		c.conn.Close()
+	}
+	r.idle = nil
+}
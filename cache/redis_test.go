@@ -0,0 +1,106 @@
+package cache_test
+
+import (
+	"os"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/cache"
+	"github.com/bobisme/RestApiProject/cache/redistest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redis", func() {
+	var server *redistest.Server
+
+	BeforeEach(func() {
+		server = redistest.NewServer("")
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	behavesLikeACache(func() Cache { return NewRedis(server.Addr, "") })
+
+	// set REDIS_ADDR to check against a real server too. The specs only
+	// touch their own keys.
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		Context("at "+addr, func() {
+			behavesLikeACache(func() Cache {
+				return NewRedis(addr, os.Getenv("REDIS_PASSWORD"))
+			})
+		})
+	}
+
+	It("pings", func() {
+		Ω(NewRedis(server.Addr, "").Ping()).Should(Succeed())
+	})
+
+	It("sends the password", func() {
+		server.Close()
+		server = redistest.NewServer("winter is coming")
+		r := NewRedis(server.Addr, "winter is coming")
+		Ω(r.Set("a", []byte("1"), 0)).Should(Succeed())
+		Ω(NewRedis(server.Addr, "").Ping()).ShouldNot(Succeed())
+		Ω(NewRedis(server.Addr, "summer").Ping()).ShouldNot(Succeed())
+	})
+
+	It("returns error replies as errors", func() {
+		server.Close()
+		server = redistest.NewServer("secret")
+		err := NewRedis(server.Addr, "").Ping()
+		Ω(err).Should(BeAssignableToTypeOf(RedisError("")))
+		Ω(err.Error()).Should(ContainSubstring("NOAUTH"))
+	})
+
+	It("reuses connections", func() {
+		r := NewRedis(server.Addr, "")
+		for i := 0; i < 10; i++ {
+			Ω(r.Ping()).Should(Succeed())
+		}
+		r.Close()
+	})
+
+	It("reconnects after the server drops it", func() {
+		r := NewRedis(server.Addr, "")
+		Ω(r.Set("a", []byte("1"), 0)).Should(Succeed())
+		server.Disconnect()
+		value, ok, err := r.Get("a")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(ok).Should(BeTrue())
+		Ω(string(value)).Should(Equal("1"))
+	})
+
+	It("fails when the server is gone", func() {
+		r := NewRedis(server.Addr, "")
+		Ω(r.Ping()).Should(Succeed())
+		server.Close()
+		Ω(r.Ping()).ShouldNot(Succeed())
+		server = redistest.NewServer("")
+	})
+
+	It("backs off while the server is gone", func() {
+		r := NewRedis(server.Addr, "")
+		r.Backoff = 100 * time.Millisecond
+		Ω(r.Set("a", []byte("1"), 0)).Should(Succeed())
+		server.Close()
+		_, _, err := r.Get("a")
+		Ω(err).Should(HaveOccurred())
+		Ω(err).ShouldNot(Equal(ErrRedisDown))
+
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			_, _, err = r.Get("a")
+			Ω(err).Should(Equal(ErrRedisDown))
+		}
+		Ω(time.Since(start)).Should(BeNumerically("<", r.Timeout))
+
+		// tries again once the backoff is over
+		time.Sleep(r.Backoff)
+		_, _, err = r.Get("a")
+		Ω(err).Should(HaveOccurred())
+		Ω(err).ShouldNot(Equal(ErrRedisDown))
+		server = redistest.NewServer("")
+	})
+})
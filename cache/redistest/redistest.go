@@ -0,0 +1,218 @@
+// Package redistest runs a small stand-in for a Redis server, for testing
+// code that uses cache.Redis without a real one. It knows just the commands
+// the cache sends.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// Server listens on a random local port until it is closed
+type Server struct {
+	// Addr is the host:port to connect to
+	Addr string
+
+	password string
+	listener net.Listener
+	mu       sync.Mutex
+	data     map[string]entry
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server with nothing in it. If password is set, it has
+// to be sent with AUTH before anything else.
+func NewServer(password string) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("redistest: could not listen: " + err.Error())
+	}
+	s := &Server{
+		Addr:     l.Addr().String(),
+		password: password,
+		listener: l,
+		data:     map[string]entry{},
+		conns:    map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close stops listening and drops every connection
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Disconnect drops every connection but keeps listening, like a server
+// timing out idle clients
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Keys is how many keys are set, including expired ones that haven't been
+// asked for since
+func (s *Server) Keys() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	authed := s.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var reply string
+		if strings.ToUpper(args[0]) == "AUTH" {
+			if len(args) == 2 && args[1] == s.password {
+				authed = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		} else if !authed {
+			reply = "-NOAUTH Authentication required.\r\n"
+		} else {
+			reply = s.run(args)
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readHeader(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("redistest: empty command")
+	}
+	args := make([]string, n)
+	for i := range args {
+		size, err := readHeader(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func readHeader(r *bufio.Reader, kind byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 3 || line[0] != kind {
+		return 0, fmt.Errorf("redistest: expected %q, got %q", kind, line)
+	}
+	return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+}
+
+// run the command and return the reply
+func (s *Server) run(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if len(args) != 2 {
+			break
+		}
+		e, ok := s.data[args[1]]
+		if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+			delete(s.data, args[1])
+			ok = false
+		}
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(e.value), e.value)
+	case "SET":
+		e := entry{}
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, err := strconv.Atoi(args[4])
+			if err != nil || ms < 1 {
+				return "-ERR invalid expire time in 'set' command\r\n"
+			}
+			e.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		} else if len(args) != 3 {
+			break
+		}
+		e.value = args[2]
+		s.data[args[1]] = e
+		return "+OK\r\n"
+	case "DEL":
+		if len(args) < 2 {
+			break
+		}
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+	return fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n",
+		strings.ToLower(args[0]))
+}
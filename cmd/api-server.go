@@ -1,17 +1,27 @@
 package cmd
 
 import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/bobisme/RestApiProject/api"
 	"github.com/bobisme/RestApiProject/conf"
+	"github.com/bobisme/RestApiProject/webhook"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/gorm"
 	_ "github.com/mattn/go-sqlite3" // load sqlite3 support
 	"github.com/tucnak/climax"
 )
 
+// how long requests get to finish when the server is shutting down
+const shutdownTimeout = 10 * time.Second
+
 func startAPIServer(ctx climax.Context) int {
 	cfg := conf.LoadFile(getConfigPath(ctx))
 	if cfg.ReleaseMode {
@@ -25,12 +35,52 @@ func startAPIServer(ctx climax.Context) int {
 		return 1
 	}
 
-	r := api.GetRouter(cfg, db)
-	err = r.Run(":" + strconv.Itoa(cfg.Port))
-	if err != nil {
+	dispatcher := webhook.NewDispatcher(
+		db, cfg.WebhookWorkers, cfg.WebhookMaxAttempts)
+	if err := dispatcher.Start(); err != nil {
+		logrus.Errorln(err)
+		return 1
+	}
+
+	sweeper := api.NewIdempotencySweeper(db,
+		time.Duration(cfg.IdempotencySweepSeconds)*time.Second)
+	sweeper.Start()
+
+	app := api.NewApp(cfg, db)
+	app.Start()
+	// create a default router with logger and recovery
+	r := gin.Default()
+	app.SetRoutes(r)
+	srv := &http.Server{Addr: ":" + strconv.Itoa(cfg.Port), Handler: r}
+
+	// shut down cleanly on ctrl-c or kill, closing streams first since the
+	// server won't wait for them. done is closed once everything has stopped.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		logrus.Infoln("shutting down")
+		app.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logrus.Errorln(err)
+		}
+		// unsent deliveries stay queued for next time
+		dispatcher.Stop()
+		sweeper.Stop()
+	}()
+
+	err = srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		logrus.Errorln(err)
 		return 1
 	}
+	// the server stops listening as soon as shutting down starts, so wait
+	// for the requests and deliveries that are still going
+	<-done
 
 	return 0
 }
@@ -134,13 +134,15 @@ func loadInitalData(filename string) error {
 		}
 
 		latSin, latCos, lonSin, lonCos := geo.LatLonSinCos(lat, lon)
+		geohash := geo.EncodeGeohash(
+			geo.Point{Lat: lat, Lon: lon}, geo.GeohashPrecision)
 		_, err = db.Exec(
 			`INSERT INTO cities (
 				name, state_id, lat, lon, lat_sin, lat_cos, lon_sin, lon_cos,
-				created_at, updated_at)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				geohash, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			record[0], record[1], lat, lon,
-			latSin, latCos, lonSin, lonCos,
+			latSin, latCos, lonSin, lonCos, geohash,
 			marchFirst, marchFirst)
 		if err != nil {
 			return err
@@ -62,6 +62,16 @@ var _ = Describe("InitDB", func() {
 				Entry("cities", "cities"),
 				Entry("users", "users"),
 				Entry("visits", "visits"),
+				Entry("follows", "follows"),
+				Entry("share_links", "share_links"),
+				Entry("feed_items", "feed_items"),
+				Entry("webhooks", "webhooks"),
+				Entry("webhook_deliveries", "webhook_deliveries"),
+				Entry("user_achievements", "user_achievements"),
+				Entry("leaderboard_entries", "leaderboard_entries"),
+				Entry("trips", "trips"),
+				Entry("trip_visits", "trip_visits"),
+				Entry("wishlist_entries", "wishlist_entries"),
 			)
 
 			DescribeTable(
@@ -101,16 +111,17 @@ var _ = Describe("InitDB", func() {
 					name                                     string
 					stateID                                  int
 					lat, lon, latSin, latCos, lonSin, lonCos float64
+					geohash                                  string
 					created, modified                        time.Time
 				}{}
 				err := db.QueryRow(
 					`SELECT name, state_id, lat, lon,
-					lat_sin, lat_cos, lon_sin, lon_cos,
+					lat_sin, lat_cos, lon_sin, lon_cos, geohash,
 					created_at, updated_at
 					FROM cities WHERE id=1`,
 				).Scan(
 					&d.name, &d.stateID, &d.lat, &d.lon,
-					&d.latSin, &d.latCos, &d.lonSin, &d.lonCos,
+					&d.latSin, &d.latCos, &d.lonSin, &d.lonCos, &d.geohash,
 					&d.created, &d.modified,
 				)
 				Ω(err).ShouldNot(HaveOccurred())
@@ -122,6 +133,7 @@ var _ = Describe("InitDB", func() {
 				Ω(d.latCos).Should(BeNumerically("~", 0.83982817716))
 				Ω(d.lonSin).Should(BeNumerically("~", -0.99922491192))
 				Ω(d.lonCos).Should(BeNumerically("~", 0.0393646464))
+				Ω(d.geohash).Should(Equal("djce536b2"))
 				Ω(d.created).Should(Equal(marchFirst))
 				Ω(d.modified).Should(Equal(marchFirst))
 			})
@@ -1,5 +1,40 @@
 package conf
 
+// Dedup policies say what happens when a user visits a city they visited
+// within the dedup window
+const (
+	// DedupAllow saves every visit
+	DedupAllow = "allow"
+	// DedupReject turns the new visit away
+	DedupReject = "reject"
+	// DedupMerge keeps the earlier visit instead of saving a new one. The
+	// earlier visit is left as it was, even if the repeat asked for another
+	// visibility, and nothing is published for the repeat, so streams and
+	// webhooks don't hear about it.
+	DedupMerge = "merge"
+)
+
+// Cache backends say where cached responses are kept
+const (
+	// CacheMemory keeps them in each server's memory
+	CacheMemory = "memory"
+	// CacheRedis keeps them in a Redis server that every server shares
+	CacheRedis = "redis"
+)
+
+// DefaultRateLimit is the key in RateLimits for routes without their own
+const DefaultRateLimit = "default"
+
+// RateLimit is how many requests each client can make to a route
+type RateLimit struct {
+	// Requests can be made every PerSeconds, spread evenly. 0 means there's
+	// no limit.
+	Requests   int `toml:"requests"`
+	PerSeconds int `toml:"per_seconds"`
+	// Burst is how many can be made at once, or Requests if it's 0
+	Burst int `toml:"burst"`
+}
+
 // Config holds the configuration for the whole app
 type Config struct {
 	// DBPath is the path to the sqlite3 database file
@@ -8,12 +43,84 @@ type Config struct {
 	Port int `toml:"port"`
 	// ReleaseMode is true if this is to be run in production
 	ReleaseMode bool
+	// MaterializeFeed writes new visits to each follower's feed when they
+	// are made instead of working out feeds when they are read
+	MaterializeFeed bool `toml:"materialize_feed"`
+	// StreamBufferSize is how many events a /stream client can fall behind
+	// before it is disconnected
+	StreamBufferSize int `toml:"stream_buffer_size"`
+	// WebhookWorkers is how many webhook deliveries are sent at once
+	WebhookWorkers int `toml:"webhook_workers"`
+	// WebhookMaxAttempts is how many times a delivery is tried before it is
+	// marked failed
+	WebhookMaxAttempts int `toml:"webhook_max_attempts"`
+	// AchievementsPath is the toml file of achievement rules
+	AchievementsPath string `toml:"achievements_path"`
+	// LeaderboardRefreshSeconds is how often leaderboards are rebuilt from
+	// visits
+	LeaderboardRefreshSeconds int `toml:"leaderboard_refresh_seconds"`
+	// StateBoundariesPath is the GeoJSON file of state outlines, keyed by
+	// each feature's "abbrev" property
+	StateBoundariesPath string `toml:"state_boundaries_path"`
+	// TileCacheSize is how many encoded map tiles are kept in memory
+	TileCacheSize int `toml:"tile_cache_size"`
+	// BatchMaxOperations is how many visits can be created or removed in
+	// one batch request
+	BatchMaxOperations int `toml:"batch_max_operations"`
+	// IdempotencyKeySeconds is how long the response to a POST with an
+	// Idempotency-Key header is kept for retries
+	IdempotencyKeySeconds int `toml:"idempotency_key_seconds"`
+	// IdempotencySweepSeconds is how often expired idempotency keys are
+	// deleted
+	IdempotencySweepSeconds int `toml:"idempotency_sweep_seconds"`
+	// VisitDedup is the policy for repeat visits to a city: DedupAllow,
+	// DedupReject or DedupMerge
+	VisitDedup string `toml:"visit_dedup"`
+	// VisitDedupWindowSeconds is how recent an earlier visit has to be for
+	// a new one to count as a repeat, or 0 for any time
+	VisitDedupWindowSeconds int `toml:"visit_dedup_window_seconds"`
+	// CacheBackend is where cached responses are kept: CacheMemory or
+	// CacheRedis. Use Redis when running more than one server.
+	CacheBackend string `toml:"cache_backend"`
+	// CacheSize is how many responses the memory cache holds
+	CacheSize int `toml:"cache_size"`
+	// CacheTTLSeconds is how long a cached response is kept
+	CacheTTLSeconds int `toml:"cache_ttl_seconds"`
+	// RedisAddr is the host:port of the Redis server
+	RedisAddr string `toml:"redis_addr"`
+	// RedisPassword is sent with AUTH if it isn't blank
+	RedisPassword string `toml:"redis_password"`
+	// RateLimits are keyed by method and route, like
+	// "POST /user/:userID/visits", or DefaultRateLimit for the rest
+	RateLimits map[string]RateLimit `toml:"rate_limits"`
 }
 
 // Default returns a configuration with default values
 func Default() *Config {
 	return &Config{
-		Port:   8080,
-		DBPath: "database.sqlite3",
+		Port:               8080,
+		DBPath:             "database.sqlite3",
+		StreamBufferSize:   64,
+		WebhookWorkers:     4,
+		WebhookMaxAttempts: 8,
+		AchievementsPath:   "data/achievements.toml",
+		// rebuilding scans every visit, so not too often
+		LeaderboardRefreshSeconds: 300,
+		StateBoundariesPath:       "data/states.geojson",
+		TileCacheSize:             1024,
+		BatchMaxOperations:        100,
+		IdempotencyKeySeconds:     24 * 60 * 60,
+		IdempotencySweepSeconds:   10 * 60,
+		VisitDedup:                DedupAllow,
+		VisitDedupWindowSeconds:   24 * 60 * 60,
+		CacheBackend:              CacheMemory,
+		CacheSize:                 4096,
+		CacheTTLSeconds:           10 * 60,
+		RedisAddr:                 "localhost:6379",
+		RateLimits: map[string]RateLimit{
+			DefaultRateLimit: {Requests: 600, PerSeconds: 60},
+			// a script hammering this once filled the database
+			"POST /user/:userID/visits": {Requests: 60, PerSeconds: 60, Burst: 20},
+		},
 	}
 }
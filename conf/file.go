@@ -21,5 +21,27 @@ func LoadFile(path string) *Config {
 	if err != nil {
 		panic("Error reading config file: " + err.Error())
 	}
+	switch cfg.VisitDedup {
+	case DedupAllow, DedupReject, DedupMerge:
+	default:
+		panic("Unknown visit_dedup policy: " + cfg.VisitDedup)
+	}
+	switch cfg.CacheBackend {
+	case CacheMemory, CacheRedis:
+	default:
+		panic("Unknown cache_backend: " + cfg.CacheBackend)
+	}
+	if cfg.LeaderboardRefreshSeconds <= 0 {
+		panic("leaderboard_refresh_seconds must be more than 0")
+	}
+	if cfg.IdempotencySweepSeconds <= 0 {
+		panic("idempotency_sweep_seconds must be more than 0")
+	}
+	for route, limit := range cfg.RateLimits {
+		if limit.Requests < 0 || limit.Burst < 0 ||
+			(limit.Requests > 0 && limit.PerSeconds < 1) {
+			panic("Invalid rate limit for " + route)
+		}
+	}
 	return cfg
 }
@@ -12,6 +12,11 @@ import (
 var (
 	missingFile, _ = filepath.Abs("test-missing-config.toml")
 	blankFile, _   = filepath.Abs("test-blank-config.toml")
+	dedupFile, _   = filepath.Abs("test-dedup-config.toml")
+	cacheFile, _   = filepath.Abs("test-cache-config.toml")
+	limitsFile, _  = filepath.Abs("test-limits-config.toml")
+	boardFile, _   = filepath.Abs("test-board-config.toml")
+	sweepFile, _   = filepath.Abs("test-sweep-config.toml")
 	normalFile, _  = filepath.Abs("test-non-blank-config.toml")
 )
 
@@ -53,6 +58,76 @@ var _ = Describe("File", func() {
 			Ω(c).Should(Equal(d))
 		})
 
+		It("should panic on an unknown dedup policy", func() {
+			f, err := os.Create(dedupFile)
+			Ω(err).ShouldNot(HaveOccurred())
+			f.WriteString(`visit_dedup = "sometimes"`)
+			f.Close()
+			defer os.Remove(dedupFile)
+			Ω(func() { LoadFile(dedupFile) }).Should(Panic())
+		})
+
+		It("should panic on an unknown cache backend", func() {
+			f, err := os.Create(cacheFile)
+			Ω(err).ShouldNot(HaveOccurred())
+			f.WriteString(`cache_backend = "disk"`)
+			f.Close()
+			defer os.Remove(cacheFile)
+			Ω(func() { LoadFile(cacheFile) }).Should(Panic())
+		})
+
+		It("should panic on leaderboards that are never rebuilt", func() {
+			f, err := os.Create(boardFile)
+			Ω(err).ShouldNot(HaveOccurred())
+			f.WriteString(`leaderboard_refresh_seconds = 0`)
+			f.Close()
+			defer os.Remove(boardFile)
+			Ω(func() { LoadFile(boardFile) }).Should(Panic())
+		})
+
+		It("should panic on idempotency keys that are never swept", func() {
+			f, err := os.Create(sweepFile)
+			Ω(err).ShouldNot(HaveOccurred())
+			f.WriteString(`idempotency_sweep_seconds = -1`)
+			f.Close()
+			defer os.Remove(sweepFile)
+			Ω(func() { LoadFile(sweepFile) }).Should(Panic())
+		})
+
+		Describe("rate limits", func() {
+			write := func(s string) {
+				f, err := os.Create(limitsFile)
+				Ω(err).ShouldNot(HaveOccurred())
+				f.WriteString(s)
+				f.Close()
+			}
+
+			AfterEach(func() {
+				os.Remove(limitsFile)
+			})
+
+			It("should keep the default limits for other routes", func() {
+				write(`
+[rate_limits."GET /cities/near"]
+requests = 10
+per_seconds = 1
+`)
+				c := LoadFile(limitsFile)
+				Ω(c.RateLimits).Should(HaveKeyWithValue("GET /cities/near",
+					RateLimit{Requests: 10, PerSeconds: 1}))
+				Ω(c.RateLimits).Should(HaveKeyWithValue(DefaultRateLimit,
+					Default().RateLimits[DefaultRateLimit]))
+			})
+
+			It("should panic on a limit without a period", func() {
+				write(`
+[rate_limits.default]
+requests = 10
+`)
+				Ω(func() { LoadFile(limitsFile) }).Should(Panic())
+			})
+		})
+
 		It("should return the proper config settings", func() {
 			c := LoadFile(normalFile)
 			d := Default()
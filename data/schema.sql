@@ -21,6 +21,8 @@ CREATE TABLE cities (
     lat_cos REAL,
     lon_sin REAL,
     lon_cos REAL,
+    -- nearby cities share a prefix, so range queries find them
+    geohash TEXT,
 
     created_at DATETIME,
     updated_at DATETIME,
@@ -29,6 +31,8 @@ CREATE TABLE cities (
     FOREIGN KEY(state_id) REFERENCES states(id)
 );
 
+CREATE INDEX cities_geohash ON cities(geohash);
+
 CREATE TABLE users (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     first_name TEXT,
@@ -36,6 +40,9 @@ CREATE TABLE users (
 
     email TEXT,
     password_hash TEXT,
+    -- who can see visits by default: "public", "followers" or "private"
+    visibility TEXT DEFAULT 'public',
+    admin BOOLEAN DEFAULT 0,
 
     created_at DATETIME,
     updated_at DATETIME,
@@ -53,11 +60,202 @@ CREATE TABLE visits (
     lat_cos REAL,
     lon_sin REAL,
     lon_cos REAL,
+    -- of the coordinates if checked in by them, otherwise the city's
+    geohash TEXT,
     -- "city" if by city, "coords" if by coordinates
     visit_method TEXT,
+    -- overrides the user's visibility unless NULL
+    visibility TEXT NULL,
 
     created_at DATETIME,
     -- modified won't be used, but it's here for consitency and future use
     updated_at DATETIME,
     deleted_at DATETIME NULL
 );
+
+CREATE INDEX visits_geohash ON visits(geohash);
+
+CREATE TABLE follows (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    follower_id INTEGER,
+    followee_id INTEGER,
+    -- "pending" until the followee accepts, then "accepted"
+    status TEXT,
+
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME NULL,
+
+    FOREIGN KEY(follower_id) REFERENCES users(id),
+    FOREIGN KEY(followee_id) REFERENCES users(id)
+);
+-- unfollowing removes the row outright, so a pair only ever has one row
+CREATE UNIQUE INDEX follows_pair ON follows(follower_id, followee_id);
+CREATE INDEX follows_followee_id ON follows(followee_id);
+
+CREATE TABLE share_links (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    -- sha256 of the token, the token itself is only given out once
+    token_hash TEXT,
+
+    created_at DATETIME,
+    updated_at DATETIME,
+    -- revoked links are deleted
+    deleted_at DATETIME NULL,
+
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE UNIQUE INDEX share_links_token_hash ON share_links(token_hash);
+
+-- only used when feeds are materialized, otherwise feeds are read from visits
+CREATE TABLE feed_items (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- the follower whose feed this is in
+    user_id INTEGER,
+    visit_id INTEGER,
+
+    created_at DATETIME,
+
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(visit_id) REFERENCES visits(id)
+);
+CREATE INDEX feed_items_user_id ON feed_items(user_id, visit_id);
+
+CREATE TABLE webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    url TEXT,
+    -- every user's events instead of just the owner's, admins only
+    all_users BOOLEAN DEFAULT 0,
+    -- comma separated event types
+    events TEXT,
+    -- hmac key for signing payloads
+    secret TEXT,
+
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME NULL,
+
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX webhooks_user_id ON webhooks(user_id);
+
+CREATE TABLE webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER,
+    event_type TEXT,
+    payload TEXT,
+    -- "pending", "sending", "delivered" or "failed" after too many attempts
+    status TEXT,
+    attempts INTEGER DEFAULT 0,
+    next_attempt_at DATETIME,
+    last_error TEXT,
+    response_status INTEGER,
+    delivered_at DATETIME NULL,
+
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME NULL,
+
+    FOREIGN KEY(webhook_id) REFERENCES webhooks(id)
+);
+CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+
+CREATE TABLE user_achievements (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    -- the key of the rule in data/achievements.toml
+    achievement_key TEXT,
+    -- when the visit that earned it was made
+    earned_at DATETIME,
+
+    created_at DATETIME,
+
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE UNIQUE INDEX user_achievements_user_key
+    ON user_achievements(user_id, achievement_key);
+
+-- rebuilt from visits every so often by the leaderboard package
+CREATE TABLE leaderboard_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- "states", "cities" or "distance"
+    metric TEXT,
+    -- "all", "year" or "30d"
+    period TEXT,
+    user_id INTEGER,
+    value REAL,
+    rank INTEGER,
+    computed_at DATETIME,
+
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX leaderboard_entries_rank
+    ON leaderboard_entries(metric, period, rank);
+CREATE UNIQUE INDEX leaderboard_entries_user
+    ON leaderboard_entries(metric, period, user_id);
+
+CREATE TABLE trips (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    name TEXT,
+    description TEXT,
+    start_date DATETIME NULL,
+    end_date DATETIME NULL,
+
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME NULL,
+
+    FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX trips_user_id ON trips(user_id);
+
+CREATE TABLE trip_visits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    trip_id INTEGER,
+    visit_id INTEGER,
+    -- the order of the visit in the trip, from 0
+    position INTEGER,
+
+    FOREIGN KEY(trip_id) REFERENCES trips(id),
+    FOREIGN KEY(visit_id) REFERENCES visits(id)
+);
+CREATE UNIQUE INDEX trip_visits_trip_visit ON trip_visits(trip_id, visit_id);
+CREATE INDEX trip_visits_visit_id ON trip_visits(visit_id);
+
+CREATE TABLE wishlist_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    city_id INTEGER,
+    note TEXT,
+
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME NULL,
+
+    FOREIGN KEY(user_id) REFERENCES users(id),
+    FOREIGN KEY(city_id) REFERENCES cities(id)
+);
+CREATE INDEX wishlist_entries_user_id ON wishlist_entries(user_id);
+
+CREATE TABLE idempotency_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    -- the Idempotency-Key header the client sent
+    idempotency_key TEXT,
+    -- the method and url the key was used for
+    scope TEXT,
+    -- sha256 of the credentials and body of the first request
+    request_hash TEXT,
+    -- the stored response, with a status of 0 until the first request is done
+    status INTEGER DEFAULT 0,
+    content_type TEXT,
+    body BLOB,
+    expires_at DATETIME,
+
+    created_at DATETIME
+);
+CREATE UNIQUE INDEX idempotency_keys_key_scope ON idempotency_keys(idempotency_key, scope);
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys(expires_at);
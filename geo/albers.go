@@ -0,0 +1,93 @@
+package geo
+
+import "math"
+
+// Albers is an Albers equal-area conic projection. Any two areas on the
+// earth that are the same size are the same size on the map, which makes it
+// the usual choice for shading states.
+type Albers struct {
+	lon0, n, c, rho0 float64
+}
+
+// NewAlbers centered on the meridian lon0, with the cone cutting the earth
+// along the standard parallels lat1 and lat2
+func NewAlbers(lon0, lat1, lat2 float64) *Albers {
+	sin1 := math.Sin(DegToRad(lat1))
+	n := (sin1 + math.Sin(DegToRad(lat2))) / 2
+	c := 1 + sin1*(2*n-sin1)
+	return &Albers{lon0: lon0, n: n, c: c, rho0: math.Sqrt(c) / n}
+}
+
+// Project the point onto a sphere of radius 1, with x going east and y
+// going north from where lon0 meets the equator
+func (a *Albers) Project(p Point) (float64, float64) {
+	// keep the longitude on the near side of the cone so places across the
+	// antimeridian, like the Aleutians, stay next to their neighbours
+	lon := math.Mod(p.Lon-a.lon0+540, 360) - 180
+	theta := a.n * DegToRad(lon)
+	rho := math.Sqrt(a.c-2*a.n*math.Sin(DegToRad(p.Lat))) / a.n
+	return rho * math.Sin(theta), a.rho0 - rho*math.Cos(theta)
+}
+
+// AlbersUSA draws the lower 48 states with one Albers projection and Alaska
+// and Hawaii with their own, shrunk and moved into the empty space below the
+// southwest like on most US maps
+type AlbersUSA struct {
+	// Scale is how many pixels a unit of the projections is
+	Scale float64
+	// X and Y are where the center of the lower 48 goes on the image
+	X, Y float64
+
+	lower48, alaska, hawaii albersInset
+}
+
+// albersInset is a projection with where its center goes relative to the
+// lower 48 and how much it is shrunk
+type albersInset struct {
+	albers           *Albers
+	centerX, centerY float64
+	dx, dy, scale    float64
+}
+
+func newAlbersInset(
+	lon0, lat1, lat2 float64, center Point, dx, dy, scale float64,
+) albersInset {
+	a := NewAlbers(lon0, lat1, lat2)
+	x, y := a.Project(center)
+	return albersInset{a, x, y, dx, dy, scale}
+}
+
+// NewAlbersUSA draws the lower 48 at the scale centered on x and y, using
+// the same parallels and insets as the USGS and most web maps. A scale of
+// 1070 centered at 480,250 fills an image 960 by 500.
+func NewAlbersUSA(scale, x, y float64) *AlbersUSA {
+	return &AlbersUSA{
+		Scale: scale, X: x, Y: y,
+		lower48: newAlbersInset(-96, 29.5, 45.5,
+			Point{Lat: 38.7, Lon: -96.6}, 0, 0, 1),
+		alaska: newAlbersInset(-154, 55, 65,
+			Point{Lat: 58.5, Lon: -156}, -0.307, 0.201, 0.35),
+		hawaii: newAlbersInset(-157, 8, 18,
+			Point{Lat: 19.9, Lon: -160}, -0.205, 0.212, 1),
+	}
+}
+
+// inset picks the projection for where the point is
+func (a *AlbersUSA) inset(p Point) *albersInset {
+	if p.Lat >= 50 && (p.Lon <= -129 || p.Lon >= 170) {
+		return &a.alaska
+	}
+	if p.Lat >= 18 && p.Lat < 23 && p.Lon >= -161 && p.Lon <= -154 {
+		return &a.hawaii
+	}
+	return &a.lower48
+}
+
+// Project returns where the point goes on the image, with y going down
+func (a *AlbersUSA) Project(p Point) (float64, float64) {
+	in := a.inset(p)
+	x, y := in.albers.Project(p)
+	k := a.Scale * in.scale
+	return a.X + a.Scale*in.dx + k*(x-in.centerX),
+		a.Y + a.Scale*in.dy - k*(y-in.centerY)
+}
@@ -0,0 +1,111 @@
+package geo_test
+
+import (
+	"math"
+
+	. "github.com/bobisme/RestApiProject/geo"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// projectedArea of the cell between the latitudes and longitudes, with the
+// parallels broken into short pieces since they come out curved
+func projectedArea(a *Albers, lat1, lat2, lon1, lon2 float64) float64 {
+	const steps = 50
+	xs, ys := []float64{}, []float64{}
+	add := func(lat, lon float64) {
+		x, y := a.Project(Point{Lat: lat, Lon: lon})
+		xs, ys = append(xs, x), append(ys, y)
+	}
+	for i := 0; i <= steps; i++ {
+		add(lat1, lon1+(lon2-lon1)*float64(i)/steps)
+	}
+	for i := steps; i >= 0; i-- {
+		add(lat2, lon1+(lon2-lon1)*float64(i)/steps)
+	}
+	area := 0.0
+	for i := range xs {
+		j := (i + 1) % len(xs)
+		area += xs[i]*ys[j] - xs[j]*ys[i]
+	}
+	return math.Abs(area) / 2
+}
+
+var _ = Describe("Albers", func() {
+	a := NewAlbers(-96, 29.5, 45.5)
+
+	It("puts the central meridian straight up", func() {
+		x, _ := a.Project(Point{Lat: 10, Lon: -96})
+		Ω(x).Should(BeNumerically("~", 0))
+		x, _ = a.Project(Point{Lat: 60, Lon: -96})
+		Ω(x).Should(BeNumerically("~", 0))
+		_, y := a.Project(Point{Lat: 0, Lon: -96})
+		Ω(y).Should(BeNumerically("~", 0))
+	})
+
+	DescribeTable("keeps areas the same as on the sphere",
+		func(lat1, lat2, lon1, lon2 float64) {
+			sphere := DegToRad(lon2-lon1) *
+				(math.Sin(DegToRad(lat2)) - math.Sin(DegToRad(lat1)))
+			Ω(projectedArea(a, lat1, lat2, lon1, lon2)).
+				Should(BeNumerically("~", sphere, sphere*1e-3))
+		},
+		Entry("near the center", 38.0, 39.0, -97.0, -96.0),
+		Entry("far south", 20.0, 21.0, -80.0, -79.0),
+		Entry("far north", 60.0, 62.0, -120.0, -118.0),
+		Entry("big", 25.0, 50.0, -125.0, -65.0),
+	)
+
+	It("keeps the Aleutians next to the rest of Alaska", func() {
+		ak := NewAlbers(-154, 55, 65)
+		x1, y1 := ak.Project(Point{Lat: 52, Lon: 179.9})
+		x2, y2 := ak.Project(Point{Lat: 52, Lon: -179.9})
+		Ω(math.Hypot(x1-x2, y1-y2)).Should(BeNumerically("<", 0.01))
+	})
+})
+
+var _ = Describe("AlbersUSA", func() {
+	usa := NewAlbersUSA(1070, 480, 250)
+
+	It("centers the lower 48", func() {
+		x, y := usa.Project(Point{Lat: 38.7, Lon: -96.6})
+		Ω(x).Should(BeNumerically("~", 480))
+		Ω(y).Should(BeNumerically("~", 250))
+	})
+
+	It("puts north up and east right", func() {
+		x1, y1 := usa.Project(Point{Lat: 47.6, Lon: -122.3})
+		x2, y2 := usa.Project(Point{Lat: 25.8, Lon: -80.2})
+		Ω(x1).Should(BeNumerically("<", x2))
+		Ω(y1).Should(BeNumerically("<", y2))
+	})
+
+	DescribeTable("fits the country in 960 by 500",
+		func(lat, lon float64) {
+			x, y := usa.Project(Point{Lat: lat, Lon: lon})
+			Ω(x).Should(BeNumerically(">", 0))
+			Ω(x).Should(BeNumerically("<", 960))
+			Ω(y).Should(BeNumerically(">", 0))
+			Ω(y).Should(BeNumerically("<", 500))
+		},
+		Entry("Cape Flattery", 48.38, -124.72),
+		Entry("West Quoddy Head", 44.81, -66.95),
+		Entry("Key West", 24.55, -81.78),
+		Entry("Point Barrow", 71.39, -156.48),
+		Entry("Attu", 52.93, 172.9),
+		Entry("Ketchikan", 55.34, -131.64),
+		Entry("Hilo", 19.72, -155.08),
+		Entry("Kauai", 22.07, -159.5),
+	)
+
+	It("moves Alaska and Hawaii into the southwest corner", func() {
+		x, y := usa.Project(Point{Lat: 64.8, Lon: -147.7})
+		Ω(x).Should(BeNumerically("<", 480))
+		Ω(y).Should(BeNumerically(">", 250))
+		x, y = usa.Project(Point{Lat: 21.3, Lon: -157.9})
+		Ω(x).Should(BeNumerically("<", 480))
+		Ω(y).Should(BeNumerically(">", 250))
+	})
+})
@@ -0,0 +1,127 @@
+package geo
+
+// MaxCellLevel is the deepest level of cells. Cells there are a few
+// centimeters across.
+const MaxCellLevel = 30
+
+// CellID is a cell in a quadtree over latitude and longitude. Level 0 is the
+// whole world and every level splits each cell into four. The ID is the path
+// down the tree, a longitude bit then a latitude bit for each level like a
+// geohash, followed by a 1 bit to mark where the path ends.
+//
+// Every cell's descendants sort between its RangeMin and RangeMax, so finding
+// everything in a cell is one range query on an integer column. IDs fit in
+// an int64.
+type CellID uint64
+
+// CellIDFromPoint returns the cell at the level the point is in. The level is
+// clamped from 0 to MaxCellLevel.
+func CellIDFromPoint(p Point, level int) CellID {
+	if level < 0 {
+		level = 0
+	} else if level > MaxCellLevel {
+		level = MaxCellLevel
+	}
+	n := uint64(1) << uint(level)
+	lat := cellIndex((p.Lat+90)/180, n)
+	lon := cellIndex((p.Lon+180)/360, n)
+	var path uint64
+	for i := level - 1; i >= 0; i-- {
+		path = path<<2 | (lon>>uint(i)&1)<<1 | lat>>uint(i)&1
+	}
+	shift := uint(62 - 2*level)
+	return CellID(path<<(shift+1) | 1<<shift)
+}
+
+// cellIndex is which of n slices the fraction from 0 to 1 falls in
+func cellIndex(f float64, n uint64) uint64 {
+	if f <= 0 {
+		return 0
+	}
+	i := uint64(f * float64(n))
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// lsb is the bit marking the end of the path
+func (id CellID) lsb() CellID {
+	return id & -id
+}
+
+// Valid is true if the ID is a cell at some level
+func (id CellID) Valid() bool {
+	if id == 0 || id >= 1<<63 {
+		return false
+	}
+	zeros := 0
+	for lsb := id.lsb(); lsb > 1; lsb >>= 1 {
+		zeros++
+	}
+	return zeros%2 == 0 && zeros <= 62
+}
+
+// Level of the cell, from 0 for the whole world to MaxCellLevel
+func (id CellID) Level() int {
+	level := 32
+	for lsb := id.lsb(); lsb > 0; lsb >>= 2 {
+		level--
+	}
+	return level
+}
+
+// Parent is the cell this one is in. The world has no parent and returns
+// itself.
+func (id CellID) Parent() CellID {
+	if id.Level() == 0 {
+		return id
+	}
+	lsb := id.lsb() << 2
+	return id&^(lsb<<1-1) | lsb
+}
+
+// Children are the four cells this one splits into. Cells at MaxCellLevel
+// have none.
+func (id CellID) Children() []CellID {
+	if id.Level() >= MaxCellLevel {
+		return nil
+	}
+	lsb := id.lsb()
+	children := make([]CellID, 4)
+	for i := range children {
+		children[i] = id - lsb + lsb>>2*CellID(2*i+1)
+	}
+	return children
+}
+
+// RangeMin is the smallest ID of any cell inside this one
+func (id CellID) RangeMin() CellID {
+	return id - (id.lsb() - 1)
+}
+
+// RangeMax is the biggest ID of any cell inside this one
+func (id CellID) RangeMax() CellID {
+	return id + (id.lsb() - 1)
+}
+
+// Contains is true if the other cell is this one or inside it
+func (id CellID) Contains(other CellID) bool {
+	return other >= id.RangeMin() && other <= id.RangeMax()
+}
+
+// BBox is the area the cell covers
+func (id CellID) BBox() BBox {
+	level := id.Level()
+	path := uint64(id) >> uint(63-2*level)
+	var lat, lon uint64
+	for i := 0; i < level; i++ {
+		lon = lon<<1 | path>>uint(2*(level-1-i)+1)&1
+		lat = lat<<1 | path>>uint(2*(level-1-i))&1
+	}
+	n := float64(uint64(1) << uint(level))
+	return BBox{
+		MinLat: -90 + 180*float64(lat)/n, MaxLat: -90 + 180*float64(lat+1)/n,
+		MinLon: -180 + 360*float64(lon)/n, MaxLon: -180 + 360*float64(lon+1)/n,
+	}
+}
@@ -0,0 +1,69 @@
+package geo_test
+
+import (
+	. "github.com/bobisme/RestApiProject/geo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CellID", func() {
+	p := Point{Lat: 35.2271, Lon: -80.8431}
+
+	It("has a level", func() {
+		for level := 0; level <= MaxCellLevel; level++ {
+			id := CellIDFromPoint(p, level)
+			Ω(id.Valid()).Should(BeTrue())
+			Ω(id.Level()).Should(Equal(level))
+			Ω(int64(id)).Should(BeNumerically(">", 0))
+		}
+		Ω(CellIDFromPoint(p, 99).Level()).Should(Equal(MaxCellLevel))
+	})
+
+	It("is in the parent cell", func() {
+		for level := 1; level <= MaxCellLevel; level++ {
+			id := CellIDFromPoint(p, level)
+			parent := CellIDFromPoint(p, level-1)
+			Ω(id.Parent()).Should(Equal(parent))
+			Ω(parent.Contains(id)).Should(BeTrue())
+			Ω(parent.Children()).Should(ContainElement(id))
+			Ω(id.Contains(parent)).Should(BeFalse())
+		}
+		world := CellIDFromPoint(p, 0)
+		Ω(world.Parent()).Should(Equal(world))
+		Ω(CellIDFromPoint(p, MaxCellLevel).Children()).Should(BeNil())
+	})
+
+	It("contains the point", func() {
+		for _, level := range []int{0, 1, 5, 12, MaxCellLevel} {
+			Ω(CellIDFromPoint(p, level).BBox().Contains(p)).Should(BeTrue())
+		}
+		Ω(CellIDFromPoint(p, 1).BBox()).Should(Equal(BBox{
+			MinLat: 0, MaxLat: 90, MinLon: -180, MaxLon: 0,
+		}))
+	})
+
+	It("keeps children in the parent's range", func() {
+		parent := CellIDFromPoint(p, 10)
+		for _, child := range parent.Children() {
+			Ω(child.Parent()).Should(Equal(parent))
+			Ω(child.RangeMin()).Should(BeNumerically(">=", parent.RangeMin()))
+			Ω(child.RangeMax()).Should(BeNumerically("<=", parent.RangeMax()))
+		}
+		other := CellIDFromPoint(Point{Lat: -35, Lon: 80}, 10)
+		Ω(parent.Contains(other)).Should(BeFalse())
+	})
+
+	It("handles the edges of the world", func() {
+		Ω(CellIDFromPoint(Point{Lat: 90, Lon: 180}, 3).BBox().MaxLat).
+			Should(Equal(90.0))
+		Ω(CellIDFromPoint(Point{Lat: -90, Lon: -180}, 3).BBox().MinLon).
+			Should(Equal(-180.0))
+	})
+
+	It("knows invalid ids", func() {
+		Ω(CellID(0).Valid()).Should(BeFalse())
+		Ω(CellID(1 << 63).Valid()).Should(BeFalse())
+		Ω(CellID(1 << 61).Valid()).Should(BeFalse())
+	})
+})
@@ -0,0 +1,187 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// ClusterCellPixels is how wide the cells points are grouped in are, in
+// pixels of a 256 pixel map tile. Clusters end up about this far apart
+// on screen at every zoom.
+const ClusterCellPixels = 64
+
+// the cells across one tile
+const clusterCellsPerTile = 256 / ClusterCellPixels
+
+// ClusterPoint is something to group into clusters, like a city
+type ClusterPoint struct {
+	ID uint
+	Point
+}
+
+// Cluster is a group of points close together at some zoom
+type Cluster struct {
+	Count int `json:"count"`
+	// Center is the average of the points as they are on the map
+	Center Point `json:"center"`
+	BBox   BBox  `json:"bbox"`
+	// ID of the point when the cluster is only one point
+	ID uint `json:"id,omitempty"`
+
+	// the sum of the points projected with Mercator, so merged clusters have
+	// the right center
+	x, y float64
+}
+
+func (c *Cluster) add(other *Cluster) {
+	if c.Count == 0 {
+		c.BBox = EmptyBBox()
+	}
+	c.Count += other.Count
+	c.BBox = c.BBox.Extend(Point{Lat: other.BBox.MinLat, Lon: other.BBox.MinLon}).
+		Extend(Point{Lat: other.BBox.MaxLat, Lon: other.BBox.MaxLon})
+	c.x, c.y = c.x+other.x, c.y+other.y
+	c.ID = 0
+	if c.Count == 1 {
+		c.ID = other.ID
+	}
+}
+
+func (c *Cluster) finish() {
+	n := float64(c.Count)
+	center := InverseMercator(c.x/n, c.y/n)
+	// projecting there and back can be off in the last digit, which would
+	// put a lone point outside its own box
+	c.Center = Point{
+		Lat: math.Max(c.BBox.MinLat, math.Min(c.BBox.MaxLat, center.Lat)),
+		Lon: math.Max(c.BBox.MinLon, math.Min(c.BBox.MaxLon, center.Lon)),
+	}
+}
+
+type clusterCell struct {
+	x, y int
+}
+
+// ClusterIndex holds the clusters for every zoom up to its max. Clusters
+// at the max zoom are built from the points, then each zoom out merges the
+// four cells under each of its cells, so building is one pass over the
+// points and reading is a lookup.
+type ClusterIndex struct {
+	levels []map[clusterCell]*Cluster
+}
+
+// cellsAcross is how many cells there are across the world at the zoom
+func cellsAcross(zoom int) int {
+	return clusterCellsPerTile << uint(zoom)
+}
+
+func cellIndexAt(f float64, n int) int {
+	i := int(math.Floor(f * float64(n)))
+	if i < 0 {
+		return 0
+	} else if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// NewClusterIndex groups the points for zooms 0 to maxZoom
+func NewClusterIndex(points []ClusterPoint, maxZoom int) *ClusterIndex {
+	if maxZoom < 0 {
+		maxZoom = 0
+	}
+	idx := &ClusterIndex{levels: make([]map[clusterCell]*Cluster, maxZoom+1)}
+	bottom := map[clusterCell]*Cluster{}
+	n := cellsAcross(maxZoom)
+	for _, p := range points {
+		x, y := Mercator(p.Point)
+		cell := clusterCell{cellIndexAt(x, n), cellIndexAt(y, n)}
+		single := &Cluster{
+			Count: 1, ID: p.ID, BBox: EmptyBBox().Extend(p.Point), x: x, y: y,
+		}
+		if bottom[cell] == nil {
+			bottom[cell] = &Cluster{}
+		}
+		bottom[cell].add(single)
+	}
+	idx.levels[maxZoom] = bottom
+	for zoom := maxZoom - 1; zoom >= 0; zoom-- {
+		level := map[clusterCell]*Cluster{}
+		for cell, c := range idx.levels[zoom+1] {
+			parent := clusterCell{cell.x / 2, cell.y / 2}
+			if level[parent] == nil {
+				level[parent] = &Cluster{}
+			}
+			level[parent].add(c)
+		}
+		idx.levels[zoom] = level
+	}
+	for _, level := range idx.levels {
+		for _, c := range level {
+			c.finish()
+		}
+	}
+	return idx
+}
+
+// MaxZoom is the deepest zoom the points are clustered for
+func (idx *ClusterIndex) MaxZoom() int {
+	return len(idx.levels) - 1
+}
+
+// Clusters at the zoom whose centers are in the box, from the top left.
+// Zooms past the max get the clusters at the max. A box whose MinLon is
+// more than its MaxLon goes across the antimeridian.
+func (idx *ClusterIndex) Clusters(box BBox, zoom int) []Cluster {
+	if zoom < 0 {
+		zoom = 0
+	} else if zoom > idx.MaxZoom() {
+		zoom = idx.MaxZoom()
+	}
+	if box.MinLon > box.MaxLon {
+		east, west := box, box
+		east.MaxLon, west.MinLon = 180, -180
+		return append(idx.Clusters(east, zoom), idx.Clusters(west, zoom)...)
+	}
+	level := idx.levels[zoom]
+	n := cellsAcross(zoom)
+	minX, minY := Mercator(Point{Lat: box.MaxLat, Lon: box.MinLon})
+	maxX, maxY := Mercator(Point{Lat: box.MinLat, Lon: box.MaxLon})
+	lo := clusterCell{cellIndexAt(minX, n), cellIndexAt(minY, n)}
+	hi := clusterCell{cellIndexAt(maxX, n), cellIndexAt(maxY, n)}
+
+	type found struct {
+		cell    clusterCell
+		cluster *Cluster
+	}
+	matches := []found{}
+	check := func(cell clusterCell, c *Cluster) {
+		if c != nil && box.Contains(c.Center) {
+			matches = append(matches, found{cell, c})
+		}
+	}
+	// look up each cell in the box, unless there are fewer clusters than that
+	if (hi.x-lo.x+1)*(hi.y-lo.y+1) < len(level) {
+		for y := lo.y; y <= hi.y; y++ {
+			for x := lo.x; x <= hi.x; x++ {
+				cell := clusterCell{x, y}
+				check(cell, level[cell])
+			}
+		}
+	} else {
+		for cell, c := range level {
+			if cell.x >= lo.x && cell.x <= hi.x && cell.y >= lo.y && cell.y <= hi.y {
+				check(cell, c)
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i].cell, matches[j].cell
+		return a.y < b.y || a.y == b.y && a.x < b.x
+	})
+	clusters := make([]Cluster, len(matches))
+	for i, m := range matches {
+		clusters[i] = *m.cluster
+	}
+	return clusters
+}
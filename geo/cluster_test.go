@@ -0,0 +1,115 @@
+package geo_test
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	. "github.com/bobisme/RestApiProject/geo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var world = BBox{MinLat: -90, MinLon: -180, MaxLat: 90, MaxLon: 180}
+
+func loadSeededCities() []ClusterPoint {
+	f, err := os.Open("../data/City.csv")
+	Ω(err).ShouldNot(HaveOccurred())
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	Ω(err).ShouldNot(HaveOccurred())
+	points := []ClusterPoint{}
+	for i, record := range records[1:] {
+		lat, _ := strconv.ParseFloat(record[3], 64)
+		lon, _ := strconv.ParseFloat(record[4], 64)
+		points = append(points, ClusterPoint{
+			ID: uint(i + 1), Point: Point{Lat: lat, Lon: lon},
+		})
+	}
+	return points
+}
+
+func total(clusters []Cluster) int {
+	n := 0
+	for _, c := range clusters {
+		n += c.Count
+	}
+	return n
+}
+
+var _ = Describe("ClusterIndex", func() {
+	var cities []ClusterPoint
+	var idx *ClusterIndex
+
+	BeforeEach(func() {
+		cities = loadSeededCities()
+		idx = NewClusterIndex(cities, 14)
+	})
+
+	It("keeps every point at every zoom", func() {
+		Ω(idx.MaxZoom()).Should(Equal(14))
+		last := 0
+		for zoom := 0; zoom <= idx.MaxZoom(); zoom++ {
+			clusters := idx.Clusters(world, zoom)
+			Ω(total(clusters)).Should(Equal(len(cities)))
+			Ω(len(clusters)).Should(BeNumerically(">=", last))
+			last = len(clusters)
+		}
+	})
+
+	It("groups more when zoomed out", func() {
+		Ω(len(idx.Clusters(world, 0))).Should(BeNumerically("<", 10))
+		Ω(len(idx.Clusters(world, 14))).Should(BeNumerically(">", 400))
+	})
+
+	It("holds the points in each cluster's box", func() {
+		for _, c := range idx.Clusters(world, 3) {
+			Ω(c.BBox.Contains(c.Center)).Should(BeTrue())
+			if c.Count == 1 {
+				Ω(c.ID).ShouldNot(BeZero())
+				p := cities[c.ID-1].Point
+				Ω(c.Center.Lat).Should(BeNumerically("~", p.Lat, 1e-9))
+				Ω(c.Center.Lon).Should(BeNumerically("~", p.Lon, 1e-9))
+			} else {
+				Ω(c.ID).Should(BeZero())
+			}
+		}
+	})
+
+	It("only finds clusters in the box", func() {
+		// Arizona
+		box := BBox{MinLat: 31, MinLon: -115, MaxLat: 37, MaxLon: -109}
+		for _, zoom := range []int{0, 4, 8, 14} {
+			clusters := idx.Clusters(box, zoom)
+			for _, c := range clusters {
+				Ω(box.Contains(c.Center)).Should(BeTrue())
+			}
+			if zoom >= 4 {
+				Ω(total(clusters)).Should(Equal(96))
+			}
+		}
+	})
+
+	It("finds clusters across the antimeridian", func() {
+		points := []ClusterPoint{
+			{ID: 1, Point: Point{Lat: 52, Lon: 179}},
+			{ID: 2, Point: Point{Lat: 52, Lon: -179}},
+			{ID: 3, Point: Point{Lat: 52, Lon: 0}},
+		}
+		idx := NewClusterIndex(points, 10)
+		box := BBox{MinLat: 50, MinLon: 170, MaxLat: 55, MaxLon: -170}
+		clusters := idx.Clusters(box, 10)
+		Ω(clusters).Should(HaveLen(2))
+		Ω(total(clusters)).Should(Equal(2))
+	})
+
+	It("uses the max zoom past it", func() {
+		Ω(idx.Clusters(world, 20)).Should(Equal(idx.Clusters(world, 14)))
+		Ω(idx.Clusters(world, -1)).Should(Equal(idx.Clusters(world, 0)))
+	})
+
+	It("handles no points", func() {
+		Ω(NewClusterIndex(nil, 5).Clusters(world, 3)).Should(BeEmpty())
+	})
+})
@@ -3,11 +3,25 @@ package geo
 
 import "math"
 
+// EarthRadiusKm is the mean radius of the earth in kilometers
+const EarthRadiusKm = 6371.0088
+
+// Point is a latitude and longitude in degrees
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
 // DegToRad converts degrees to radians
 func DegToRad(degrees float64) float64 {
 	return degrees * math.Pi / 180
 }
 
+// RadToDeg converts radians to degrees
+func RadToDeg(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
 // LatLonSinCos takes latitude and longitude in degrees and returns
 // sin(lat), cos(lat), sin(lon), cos(lon)
 func LatLonSinCos(lat, lon float64) (float64, float64, float64, float64) {
@@ -15,3 +29,46 @@ func LatLonSinCos(lat, lon float64) (float64, float64, float64, float64) {
 	lonRad := DegToRad(lon)
 	return math.Sin(latRad), math.Cos(latRad), math.Sin(lonRad), math.Cos(lonRad)
 }
+
+// Distance returns the great-circle distance between two points in
+// kilometers using the haversine formula
+func Distance(a, b Point) float64 {
+	dLat := DegToRad(b.Lat - a.Lat)
+	dLon := DegToRad(b.Lon - a.Lon)
+	h := math.Pow(math.Sin(dLat/2), 2) +
+		math.Cos(DegToRad(a.Lat))*math.Cos(DegToRad(b.Lat))*
+			math.Pow(math.Sin(dLon/2), 2)
+	return 2 * EarthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// PathLength returns the total great-circle distance in kilometers when
+// travelling through the points in order
+func PathLength(points []Point) float64 {
+	total := 0.0
+	for i := 1; i < len(points); i++ {
+		total += Distance(points[i-1], points[i])
+	}
+	return total
+}
+
+// Centroid returns the geographic center of the points. The points are
+// averaged as vectors on the unit sphere so it works across the antimeridian.
+// The zero Point is returned if there are no points.
+func Centroid(points []Point) Point {
+	if len(points) == 0 {
+		return Point{}
+	}
+	var x, y, z float64
+	for _, p := range points {
+		latSin, latCos, lonSin, lonCos := LatLonSinCos(p.Lat, p.Lon)
+		x += latCos * lonCos
+		y += latCos * lonSin
+		z += latSin
+	}
+	n := float64(len(points))
+	x, y, z = x/n, y/n, z/n
+	return Point{
+		Lat: RadToDeg(math.Atan2(z, math.Sqrt(x*x+y*y))),
+		Lon: RadToDeg(math.Atan2(y, x)),
+	}
+}
@@ -35,4 +35,57 @@ var _ = Describe("Geo", func() {
 			Ω(lonCos).Should(BeNumerically("~", 0.15913858219))
 		})
 	})
+	Describe("Distance", func() {
+		charlotte := Point{Lat: 35.2271, Lon: -80.8431}
+		chicago := Point{Lat: 41.8781, Lon: -87.6298}
+
+		It("is zero for the same point", func() {
+			Ω(Distance(charlotte, charlotte)).Should(BeNumerically("~", 0))
+		})
+
+		It("works", func() {
+			Ω(Distance(charlotte, chicago)).Should(BeNumerically("~", 945.5, 1))
+		})
+
+		It("is symmetric", func() {
+			Ω(Distance(charlotte, chicago)).Should(
+				BeNumerically("~", Distance(chicago, charlotte)))
+		})
+
+		It("handles antipodes", func() {
+			d := Distance(Point{Lat: 0, Lon: 0}, Point{Lat: 0, Lon: 180})
+			Ω(d).Should(BeNumerically("~", math.Pi*EarthRadiusKm))
+		})
+	})
+	Describe("PathLength", func() {
+		It("adds up the legs", func() {
+			a := Point{Lat: 0, Lon: 0}
+			b := Point{Lat: 0, Lon: 1}
+			c := Point{Lat: 1, Lon: 1}
+			Ω(PathLength([]Point{a, b, c})).Should(
+				BeNumerically("~", Distance(a, b)+Distance(b, c)))
+		})
+
+		It("is zero for fewer than two points", func() {
+			Ω(PathLength(nil)).Should(BeZero())
+			Ω(PathLength([]Point{{Lat: 1, Lon: 1}})).Should(BeZero())
+		})
+	})
+	Describe("Centroid", func() {
+		It("is the point itself for one point", func() {
+			c := Centroid([]Point{{Lat: 35.2271, Lon: -80.8431}})
+			Ω(c.Lat).Should(BeNumerically("~", 35.2271))
+			Ω(c.Lon).Should(BeNumerically("~", -80.8431))
+		})
+
+		It("works across the antimeridian", func() {
+			c := Centroid([]Point{{Lat: 0, Lon: 179}, {Lat: 0, Lon: -179}})
+			Ω(c.Lat).Should(BeNumerically("~", 0))
+			Ω(math.Abs(c.Lon)).Should(BeNumerically("~", 180))
+		})
+
+		It("is zero for no points", func() {
+			Ω(Centroid(nil)).Should(Equal(Point{}))
+		})
+	})
 })
@@ -0,0 +1,186 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// the geohash alphabet, base32 without a, i, l and o
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// MaxGeohashPrecision is the longest geohash there is. At 12 characters the
+// cells are a few centimeters across.
+const MaxGeohashPrecision = 12
+
+// GeohashPrecision is how long the geohashes stored with cities and visits
+// are. Cells are about 5 meters across.
+const GeohashPrecision = 9
+
+// geohashBits is how many of the bits in a geohash are for latitude and
+// longitude. Longitude gets the odd one out.
+func geohashBits(precision int) (int, int) {
+	bits := 5 * precision
+	return bits / 2, bits - bits/2
+}
+
+// EncodeGeohash returns the geohash of the point with the given number of
+// characters, which is clamped from 1 to MaxGeohashPrecision
+func EncodeGeohash(p Point, precision int) string {
+	if precision < 1 {
+		precision = 1
+	} else if precision > MaxGeohashPrecision {
+		precision = MaxGeohashPrecision
+	}
+	latLo, latHi := -90.0, 90.0
+	lonLo, lonHi := -180.0, 180.0
+	hash := make([]byte, 0, precision)
+	even := true
+	char, bit := 0, 0
+	for len(hash) < precision {
+		if even {
+			mid := (lonLo + lonHi) / 2
+			char <<= 1
+			if p.Lon >= mid {
+				char |= 1
+				lonLo = mid
+			} else {
+				lonHi = mid
+			}
+		} else {
+			mid := (latLo + latHi) / 2
+			char <<= 1
+			if p.Lat >= mid {
+				char |= 1
+				latLo = mid
+			} else {
+				latHi = mid
+			}
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash = append(hash, geohashBase32[char])
+			char, bit = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// GeohashBBox returns the cell the geohash stands for
+func GeohashBBox(hash string) (BBox, error) {
+	if hash == "" {
+		return BBox{}, fmt.Errorf("empty geohash")
+	}
+	box := BBox{MinLat: -90, MaxLat: 90, MinLon: -180, MaxLon: 180}
+	even := true
+	for _, c := range strings.ToLower(hash) {
+		n := strings.IndexRune(geohashBase32, c)
+		if n < 0 {
+			return BBox{}, fmt.Errorf("invalid geohash character %q", c)
+		}
+		for mask := 16; mask > 0; mask >>= 1 {
+			if even {
+				mid := (box.MinLon + box.MaxLon) / 2
+				if n&mask != 0 {
+					box.MinLon = mid
+				} else {
+					box.MaxLon = mid
+				}
+			} else {
+				mid := (box.MinLat + box.MaxLat) / 2
+				if n&mask != 0 {
+					box.MinLat = mid
+				} else {
+					box.MaxLat = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return box, nil
+}
+
+// DecodeGeohash returns the point in the middle of the geohash's cell
+func DecodeGeohash(hash string) (Point, error) {
+	box, err := GeohashBBox(hash)
+	if err != nil {
+		return Point{}, err
+	}
+	return Point{
+		Lat: (box.MinLat + box.MaxLat) / 2,
+		Lon: (box.MinLon + box.MaxLon) / 2,
+	}, nil
+}
+
+// GeohashNeighbours returns the geohashes of the cells around this one, the
+// same length, going clockwise from north. Cells past a pole are left out
+// and cells past the antimeridian wrap around.
+func GeohashNeighbours(hash string) ([]string, error) {
+	box, err := GeohashBBox(hash)
+	if err != nil {
+		return nil, err
+	}
+	center := Point{
+		Lat: (box.MinLat + box.MaxLat) / 2,
+		Lon: (box.MinLon + box.MaxLon) / 2,
+	}
+	height, width := box.MaxLat-box.MinLat, box.MaxLon-box.MinLon
+	steps := [][2]float64{
+		{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1},
+	}
+	neighbours := make([]string, 0, len(steps))
+	for _, step := range steps {
+		lat := center.Lat + step[0]*height
+		if lat > 90 || lat < -90 {
+			continue
+		}
+		lon := center.Lon + step[1]*width
+		if lon >= 180 {
+			lon -= 360
+		} else if lon < -180 {
+			lon += 360
+		}
+		neighbours = append(neighbours,
+			EncodeGeohash(Point{Lat: lat, Lon: lon}, len(hash)))
+	}
+	return neighbours, nil
+}
+
+// GeohashCover returns geohash prefixes whose cells between them hold every
+// point within radiusKm of p. It's the cell p is in and its neighbours, as
+// long as the cells can be while the circle still fits in them. It returns
+// nil when the circle is too big or too close to a pole to cover that way,
+// and everywhere has to be searched.
+func GeohashCover(p Point, radiusKm float64) []string {
+	angle := radiusKm / EarthRadiusKm
+	dLat := RadToDeg(angle)
+	if math.Abs(p.Lat)+dLat >= 90 {
+		return nil
+	}
+	// the furthest east or west the circle reaches
+	s := math.Sin(angle) / math.Cos(DegToRad(p.Lat))
+	if angle >= math.Pi/2 || s >= 1 {
+		return nil
+	}
+	dLon := RadToDeg(math.Asin(s))
+	for precision := MaxGeohashPrecision; precision > 0; precision-- {
+		latBits, lonBits := geohashBits(precision)
+		height := 180 / math.Pow(2, float64(latBits))
+		width := 360 / math.Pow(2, float64(lonBits))
+		if height < dLat || width < dLon {
+			continue
+		}
+		hash := EncodeGeohash(p, precision)
+		neighbours, _ := GeohashNeighbours(hash)
+		cover := []string{hash}
+		seen := map[string]bool{hash: true}
+		for _, n := range neighbours {
+			if !seen[n] {
+				seen[n] = true
+				cover = append(cover, n)
+			}
+		}
+		return cover
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package geo_test
+
+import (
+	"math"
+
+	. "github.com/bobisme/RestApiProject/geo"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// destination is how far you get going km from p on the bearing in degrees
+func destination(p Point, bearing, km float64) Point {
+	angle := km / EarthRadiusKm
+	lat, lon, b := DegToRad(p.Lat), DegToRad(p.Lon), DegToRad(bearing)
+	lat2 := math.Asin(math.Sin(lat)*math.Cos(angle) +
+		math.Cos(lat)*math.Sin(angle)*math.Cos(b))
+	lon2 := lon + math.Atan2(math.Sin(b)*math.Sin(angle)*math.Cos(lat),
+		math.Cos(angle)-math.Sin(lat)*math.Sin(lat2))
+	return Point{Lat: RadToDeg(lat2), Lon: RadToDeg(lon2)}
+}
+
+var _ = Describe("Geohash", func() {
+	Describe("EncodeGeohash", func() {
+		DescribeTable("works",
+			func(p Point, precision int, expected string) {
+				Ω(EncodeGeohash(p, precision)).Should(Equal(expected))
+			},
+			Entry("Jutland", Point{Lat: 57.64911, Lon: 10.40744}, 11, "u4pruydqqvj"),
+			Entry("shorter", Point{Lat: 57.64911, Lon: 10.40744}, 5, "u4pru"),
+			Entry("the origin", Point{}, 4, "s000"),
+			Entry("the far corner", Point{Lat: -90, Lon: -180}, 3, "000"),
+			Entry("too short", Point{Lat: 57.64911, Lon: 10.40744}, 0, "u"),
+			Entry("too long", Point{Lat: 57.64911, Lon: 10.40744}, 20, "u4pruydqqvj8"),
+		)
+	})
+
+	Describe("DecodeGeohash", func() {
+		It("finds the middle of the cell", func() {
+			p, err := DecodeGeohash("ezs42")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(p.Lat).Should(BeNumerically("~", 42.605, 0.001))
+			Ω(p.Lon).Should(BeNumerically("~", -5.603, 0.001))
+		})
+
+		It("round trips", func() {
+			p := Point{Lat: 35.2271, Lon: -80.8431}
+			decoded, err := DecodeGeohash(EncodeGeohash(p, GeohashPrecision))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(Distance(p, decoded)).Should(BeNumerically("<", 0.005))
+		})
+
+		It("rejects bad hashes", func() {
+			_, err := DecodeGeohash("")
+			Ω(err).Should(HaveOccurred())
+			_, err = DecodeGeohash("abc")
+			Ω(err).Should(HaveOccurred())
+		})
+	})
+
+	Describe("GeohashNeighbours", func() {
+		It("surrounds the cell", func() {
+			neighbours, err := GeohashNeighbours("ezs42")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(neighbours).Should(Equal([]string{
+				"ezs48", "ezs49", "ezs43", "ezs41",
+				"ezs40", "ezefp", "ezefr", "ezefx",
+			}))
+		})
+
+		It("wraps around the antimeridian", func() {
+			neighbours, err := GeohashNeighbours(EncodeGeohash(
+				Point{Lat: 0.1, Lon: 179.9}, 4))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(neighbours).Should(ContainElement(
+				EncodeGeohash(Point{Lat: 0.1, Lon: -179.9}, 4)))
+		})
+
+		It("stops at the poles", func() {
+			neighbours, err := GeohashNeighbours(EncodeGeohash(
+				Point{Lat: 89.99, Lon: 0}, 3))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(neighbours).Should(HaveLen(5))
+		})
+	})
+
+	Describe("GeohashCover", func() {
+		center := Point{Lat: 35.2271, Lon: -80.8431}
+
+		It("covers every point in the circle", func() {
+			for _, radius := range []float64{0.01, 1, 25, 300} {
+				cover := GeohashCover(center, radius)
+				Ω(cover).ShouldNot(BeEmpty())
+				for bearing := 0; bearing < 360; bearing += 15 {
+					p := destination(center, float64(bearing), radius*0.999)
+					hash := EncodeGeohash(p, MaxGeohashPrecision)
+					covered := false
+					for _, prefix := range cover {
+						if hash[:len(prefix)] == prefix {
+							covered = true
+						}
+					}
+					Ω(covered).Should(BeTrue(), "%v km at %d°", radius, bearing)
+				}
+			}
+		})
+
+		It("uses smaller cells for smaller circles", func() {
+			Ω(len(GeohashCover(center, 1)[0])).Should(BeNumerically(">",
+				len(GeohashCover(center, 100)[0])))
+		})
+
+		It("gives up near the poles and for huge circles", func() {
+			Ω(GeohashCover(Point{Lat: 89.5, Lon: 0}, 100)).Should(BeNil())
+			Ω(GeohashCover(center, 20000)).Should(BeNil())
+		})
+	})
+})
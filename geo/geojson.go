@@ -0,0 +1,86 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+type geoJSONFeature struct {
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// GeoJSON positions are [lon, lat]
+type geoJSONPolygon [][][2]float64
+
+func (g geoJSONPolygon) polygon() Polygon {
+	poly := make(Polygon, len(g))
+	for i, ring := range g {
+		poly[i] = make(Ring, len(ring))
+		for j, pos := range ring {
+			poly[i][j] = Point{Lat: pos[1], Lon: pos[0]}
+		}
+	}
+	return poly
+}
+
+// DecodeRegions reads a GeoJSON FeatureCollection of Polygon and
+// MultiPolygon features. Each feature's keyProperty becomes the region's key
+// and its "name" property the region's name.
+func DecodeRegions(r io.Reader, keyProperty string) ([]Region, error) {
+	var collection struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, err
+	}
+	if collection.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("expected a FeatureCollection, got %q",
+			collection.Type)
+	}
+	regions := make([]Region, len(collection.Features))
+	for i, f := range collection.Features {
+		key, _ := f.Properties[keyProperty].(string)
+		if key == "" {
+			return nil, fmt.Errorf("feature %d has no %q property", i, keyProperty)
+		}
+		name, _ := f.Properties["name"].(string)
+		var polygons []geoJSONPolygon
+		var err error
+		switch f.Geometry.Type {
+		case "Polygon":
+			var p geoJSONPolygon
+			err = json.Unmarshal(f.Geometry.Coordinates, &p)
+			polygons = []geoJSONPolygon{p}
+		case "MultiPolygon":
+			err = json.Unmarshal(f.Geometry.Coordinates, &polygons)
+		default:
+			err = fmt.Errorf("unsupported geometry type %q", f.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("feature %q: %v", key, err)
+		}
+		converted := make([]Polygon, len(polygons))
+		for j, p := range polygons {
+			converted[j] = p.polygon()
+		}
+		regions[i] = NewRegion(key, name, converted)
+	}
+	return regions, nil
+}
+
+// LoadRegions from a GeoJSON file
+func LoadRegions(path, keyProperty string) ([]Region, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeRegions(f, keyProperty)
+}
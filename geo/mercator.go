@@ -0,0 +1,25 @@
+package geo
+
+import "math"
+
+// MaxMercatorLat is as far north or south as Web Mercator goes. Cutting it
+// off there makes the map square.
+const MaxMercatorLat = 85.05112878
+
+// Mercator projects the point with Web Mercator, scaled so the world goes
+// from 0 to 1 across and down, with y = 0 at the top
+func Mercator(p Point) (float64, float64) {
+	lat := math.Max(-MaxMercatorLat, math.Min(MaxMercatorLat, p.Lat))
+	x := (p.Lon + 180) / 360
+	latSin := math.Sin(DegToRad(lat))
+	y := 0.5 - math.Log((1+latSin)/(1-latSin))/(4*math.Pi)
+	return x, y
+}
+
+// InverseMercator is the point at x and y on the map Mercator projects to
+func InverseMercator(x, y float64) Point {
+	return Point{
+		Lat: RadToDeg(math.Atan(math.Sinh(math.Pi * (1 - 2*y)))),
+		Lon: x*360 - 180,
+	}
+}
@@ -0,0 +1,32 @@
+package geo_test
+
+import (
+	. "github.com/bobisme/RestApiProject/geo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mercator", func() {
+	It("puts the origin in the middle", func() {
+		x, y := Mercator(Point{})
+		Ω(x).Should(BeNumerically("~", 0.5))
+		Ω(y).Should(BeNumerically("~", 0.5))
+	})
+
+	It("puts the corners of the map at 0 and 1", func() {
+		x, y := Mercator(Point{Lat: MaxMercatorLat, Lon: -180})
+		Ω(x).Should(BeNumerically("~", 0))
+		Ω(y).Should(BeNumerically("~", 0))
+		x, y = Mercator(Point{Lat: -90, Lon: 180})
+		Ω(x).Should(BeNumerically("~", 1))
+		Ω(y).Should(BeNumerically("~", 1))
+	})
+
+	It("round trips", func() {
+		p := Point{Lat: 35.2271, Lon: -80.8431}
+		back := InverseMercator(Mercator(p))
+		Ω(back.Lat).Should(BeNumerically("~", p.Lat, 1e-9))
+		Ω(back.Lon).Should(BeNumerically("~", p.Lon, 1e-9))
+	})
+})
@@ -0,0 +1,174 @@
+package geo
+
+import "math"
+
+// Ring is a closed loop of points. The last point joins back to the first
+// whether or not it is repeated.
+type Ring []Point
+
+// Contains is true if the point is inside the ring. It counts how many edges
+// a line going east from the point crosses, treating lat and lon as flat.
+func (r Ring) Contains(p Point) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		a, b := r[i], r[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Polygon is an outer ring followed by any holes in it
+type Polygon []Ring
+
+// Contains is true if the point is inside the outer ring and not in a hole
+func (poly Polygon) Contains(p Point) bool {
+	if len(poly) == 0 || !poly[0].Contains(p) {
+		return false
+	}
+	for _, hole := range poly[1:] {
+		if hole.Contains(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// BBox is the smallest box of latitudes and longitudes holding some points.
+// Boxes don't wrap around the antimeridian.
+type BBox struct {
+	MinLat float64 `json:"minLat"`
+	MinLon float64 `json:"minLon"`
+	MaxLat float64 `json:"maxLat"`
+	MaxLon float64 `json:"maxLon"`
+}
+
+// EmptyBBox holds no points. Extending it gives the box around just the new
+// point.
+func EmptyBBox() BBox {
+	return BBox{
+		MinLat: math.Inf(1), MinLon: math.Inf(1),
+		MaxLat: math.Inf(-1), MaxLon: math.Inf(-1),
+	}
+}
+
+// Extend returns the box grown to hold the point
+func (b BBox) Extend(p Point) BBox {
+	return BBox{
+		MinLat: math.Min(b.MinLat, p.Lat), MinLon: math.Min(b.MinLon, p.Lon),
+		MaxLat: math.Max(b.MaxLat, p.Lat), MaxLon: math.Max(b.MaxLon, p.Lon),
+	}
+}
+
+// Contains is true if the point is in the box or on its edge
+func (b BBox) Contains(p Point) bool {
+	return p.Lat >= b.MinLat && p.Lat <= b.MaxLat &&
+		p.Lon >= b.MinLon && p.Lon <= b.MaxLon
+}
+
+// BBox returns the box around the polygon's outer ring
+func (poly Polygon) BBox() BBox {
+	box := EmptyBBox()
+	if len(poly) > 0 {
+		for _, p := range poly[0] {
+			box = box.Extend(p)
+		}
+	}
+	return box
+}
+
+// Region is a named area made of one or more polygons, like a state
+type Region struct {
+	// Key identifies the region, like a state's abbreviation
+	Key      string
+	Name     string
+	Polygons []Polygon
+	BBox     BBox
+}
+
+// NewRegion works out the bounding box of the polygons
+func NewRegion(key, name string, polygons []Polygon) Region {
+	box := EmptyBBox()
+	for _, poly := range polygons {
+		b := poly.BBox()
+		box = box.Extend(Point{Lat: b.MinLat, Lon: b.MinLon}).
+			Extend(Point{Lat: b.MaxLat, Lon: b.MaxLon})
+	}
+	return Region{Key: key, Name: name, Polygons: polygons, BBox: box}
+}
+
+// Contains is true if the point is in any of the region's polygons
+func (r *Region) Contains(p Point) bool {
+	if !r.BBox.Contains(p) {
+		return false
+	}
+	for _, poly := range r.Polygons {
+		if poly.BBox().Contains(p) && poly.Contains(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// the size in degrees of the cells regions are bucketed into
+const indexCellDegrees = 1.0
+
+type indexCell struct {
+	lat, lon int
+}
+
+func cellOf(lat, lon float64) indexCell {
+	return indexCell{
+		int(math.Floor(lat / indexCellDegrees)),
+		int(math.Floor(lon / indexCellDegrees)),
+	}
+}
+
+// RegionIndex finds which region a point is in. Each region is listed in
+// every grid cell its bounding box touches, so finding a point only checks
+// the polygons of the few regions near it.
+type RegionIndex struct {
+	regions []Region
+	cells   map[indexCell][]int
+}
+
+// NewRegionIndex of the regions. Where regions overlap, the first one wins.
+func NewRegionIndex(regions []Region) *RegionIndex {
+	idx := &RegionIndex{regions: regions, cells: map[indexCell][]int{}}
+	for i, r := range regions {
+		if len(r.Polygons) == 0 {
+			continue
+		}
+		lo := cellOf(r.BBox.MinLat, r.BBox.MinLon)
+		hi := cellOf(r.BBox.MaxLat, r.BBox.MaxLon)
+		for lat := lo.lat; lat <= hi.lat; lat++ {
+			for lon := lo.lon; lon <= hi.lon; lon++ {
+				cell := indexCell{lat, lon}
+				idx.cells[cell] = append(idx.cells[cell], i)
+			}
+		}
+	}
+	return idx
+}
+
+// Len is the number of regions in the index
+func (idx *RegionIndex) Len() int {
+	return len(idx.regions)
+}
+
+// Regions are every region in the index, in the order they were given
+func (idx *RegionIndex) Regions() []Region {
+	return idx.regions
+}
+
+// Find the region containing the point, or nil if there isn't one
+func (idx *RegionIndex) Find(p Point) *Region {
+	for _, i := range idx.cells[cellOf(p.Lat, p.Lon)] {
+		if idx.regions[i].Contains(p) {
+			return &idx.regions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package geo_test
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/bobisme/RestApiProject/geo"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// a box from (0, 0) to (10, 10) with a bite out of the top right, and
+// a hole in the bottom left
+var square = Polygon{
+	Ring{{0, 0}, {0, 10}, {5, 10}, {5, 5}, {10, 5}, {10, 0}},
+	Ring{{1, 1}, {1, 2}, {2, 2}, {2, 1}},
+}
+
+var _ = Describe("Polygon", func() {
+	DescribeTable("Contains",
+		func(p Point, expected bool) {
+			Ω(square.Contains(p)).Should(Equal(expected))
+		},
+		Entry("inside", Point{Lat: 3, Lon: 3}, true),
+		Entry("inside the bottom right", Point{Lat: 8, Lon: 2}, true),
+		Entry("in the bite", Point{Lat: 8, Lon: 8}, false),
+		Entry("in the hole", Point{Lat: 1.5, Lon: 1.5}, false),
+		Entry("outside", Point{Lat: -1, Lon: 3}, false),
+		Entry("far away", Point{Lat: 50, Lon: 50}, false),
+	)
+
+	It("has a bounding box", func() {
+		Ω(square.BBox()).Should(Equal(BBox{MinLat: 0, MinLon: 0, MaxLat: 10, MaxLon: 10}))
+		Ω(Polygon{}.BBox()).Should(Equal(EmptyBBox()))
+	})
+})
+
+var _ = Describe("RegionIndex", func() {
+	regions := []Region{
+		NewRegion("SQ", "Square", []Polygon{square}),
+		NewRegion("TWO", "Two boxes", []Polygon{
+			{Ring{{20, 20}, {20, 21}, {21, 21}, {21, 20}}},
+			{Ring{{-20, -20}, {-20, -19}, {-19, -19}, {-19, -20}}},
+		}),
+	}
+	idx := NewRegionIndex(regions)
+
+	It("finds the region a point is in", func() {
+		Ω(idx.Len()).Should(Equal(2))
+		Ω(idx.Find(Point{Lat: 3, Lon: 3}).Key).Should(Equal("SQ"))
+		Ω(idx.Find(Point{Lat: 20.5, Lon: 20.5}).Key).Should(Equal("TWO"))
+		Ω(idx.Find(Point{Lat: -19.5, Lon: -19.5}).Key).Should(Equal("TWO"))
+	})
+
+	It("finds nothing outside every region", func() {
+		Ω(idx.Find(Point{Lat: 8, Lon: 8})).Should(BeNil())
+		Ω(idx.Find(Point{Lat: 0, Lon: 0.5})).ShouldNot(BeNil())
+		Ω(idx.Find(Point{Lat: 0, Lon: 15})).Should(BeNil())
+		Ω(NewRegionIndex(nil).Find(Point{})).Should(BeNil())
+	})
+})
+
+var _ = Describe("DecodeRegions", func() {
+	It("reads polygons and multipolygons", func() {
+		regions, err := DecodeRegions(strings.NewReader(`{
+			"type": "FeatureCollection",
+			"features": [
+				{"type": "Feature", "properties": {"name": "One", "abbrev": "ON"},
+					"geometry": {"type": "Polygon",
+						"coordinates": [[[0, 0], [1, 0], [1, 2], [0, 0]]]}},
+				{"type": "Feature", "properties": {"name": "Two", "abbrev": "TW"},
+					"geometry": {"type": "MultiPolygon",
+						"coordinates": [[[[5, 5], [6, 5], [6, 6], [5, 5]]],
+							[[[7, 7], [8, 7], [8, 8], [7, 7]]]]}}
+			]
+		}`), "abbrev")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(regions).Should(HaveLen(2))
+		Ω(regions[0].Key).Should(Equal("ON"))
+		Ω(regions[0].Name).Should(Equal("One"))
+		// positions are [lon, lat]
+		Ω(regions[0].Polygons[0][0][2]).Should(Equal(Point{Lat: 2, Lon: 1}))
+		Ω(regions[1].Polygons).Should(HaveLen(2))
+		Ω(regions[1].BBox).Should(Equal(BBox{MinLat: 5, MinLon: 5, MaxLat: 8, MaxLon: 8}))
+	})
+
+	DescribeTable("rejects",
+		func(data string) {
+			_, err := DecodeRegions(strings.NewReader(data), "abbrev")
+			Ω(err).Should(HaveOccurred())
+		},
+		Entry("bad json", `{`),
+		Entry("a single feature", `{"type": "Feature"}`),
+		Entry("a missing key", `{"type": "FeatureCollection", "features": [
+			{"properties": {}, "geometry": {"type": "Polygon", "coordinates": []}}]}`),
+		Entry("points", `{"type": "FeatureCollection", "features": [
+			{"properties": {"abbrev": "PT"},
+				"geometry": {"type": "Point", "coordinates": [0, 0]}}]}`),
+	)
+})
+
+var _ = Describe("state boundaries", func() {
+	var idx *RegionIndex
+
+	BeforeEach(func() {
+		regions, err := LoadRegions("../data/states.geojson", "abbrev")
+		Ω(err).ShouldNot(HaveOccurred())
+		idx = NewRegionIndex(regions)
+	})
+
+	readCSV := func(path string) [][]string {
+		f, err := os.Open(path)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer f.Close()
+		records, err := csv.NewReader(f).ReadAll()
+		Ω(err).ShouldNot(HaveOccurred())
+		return records[1:]
+	}
+
+	It("cover every seeded state", func() {
+		keys := map[string]bool{}
+		for _, r := range idx.Regions() {
+			keys[r.Key] = true
+		}
+		missing := []string{}
+		records := readCSV("../data/State.csv")
+		for _, record := range records {
+			if !keys[record[1]] {
+				missing = append(missing, record[1])
+			}
+		}
+		Ω(missing).Should(BeEmpty())
+		Ω(idx.Len()).Should(Equal(len(records)))
+	})
+
+	DescribeTable("put cities in their states",
+		func(lat, lon float64, abbrev string) {
+			region := idx.Find(Point{Lat: lat, Lon: lon})
+			Ω(region).ShouldNot(BeNil())
+			Ω(region.Key).Should(Equal(abbrev))
+		},
+		Entry("Seattle", 47.61, -122.33, "WA"),
+		Entry("Portland", 45.52, -122.68, "OR"),
+		Entry("Denver", 39.74, -104.99, "CO"),
+		Entry("Kansas City, Missouri", 39.10, -94.58, "MO"),
+		Entry("Kansas City, Kansas", 39.11, -94.70, "KS"),
+		Entry("St. Louis", 38.63, -90.20, "MO"),
+		Entry("Chicago", 41.88, -87.63, "IL"),
+		Entry("Memphis", 35.15, -90.05, "TN"),
+		Entry("New Orleans", 29.95, -90.07, "LA"),
+		Entry("El Paso", 31.76, -106.49, "TX"),
+		Entry("Washington", 38.90, -77.04, "DC"),
+		Entry("Arlington", 38.88, -77.10, "VA"),
+		Entry("Brooklyn", 40.65, -73.95, "NY"),
+		Entry("Newark", 40.74, -74.17, "NJ"),
+		Entry("Providence", 41.82, -71.41, "RI"),
+		Entry("Marquette", 46.54, -87.40, "MI"),
+		Entry("Honolulu", 21.31, -157.86, "HI"),
+	)
+
+	It("hold the seeded cities", func() {
+		records := readCSV("../data/City.csv")
+
+		// state ids are the order of State.csv
+		abbrevs := map[string]string{"1": "AL", "2": "AK", "3": "AZ"}
+		misplaced := []string{}
+		for _, record := range records {
+			lat, _ := strconv.ParseFloat(record[3], 64)
+			lon, _ := strconv.ParseFloat(record[4], 64)
+			region := idx.Find(Point{Lat: lat, Lon: lon})
+			if region == nil || region.Key != abbrevs[record[1]] {
+				misplaced = append(misplaced, record[0])
+			}
+		}
+		// the seed data has these in Alaska, but their coordinates are in
+		// Minnesota and Arkansas
+		Ω(misplaced).Should(ConsistOf("St. Paul", "Wiseman Village"))
+	})
+})
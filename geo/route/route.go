@@ -0,0 +1,261 @@
+// Package route finds short routes through a set of points. It builds a
+// route by always going to the nearest unvisited point, then improves it
+// with 2-opt and Or-opt moves until no move helps or time runs out.
+//
+// Routes are open paths: they don't come back to where they started.
+package route
+
+import (
+	"time"
+
+	"github.com/bobisme/RestApiProject/geo"
+)
+
+// improvements smaller than this many km are treated as rounding error, so
+// the search can't go around in circles
+const epsilon = 1e-9
+
+// the longest segment Or-opt tries to move
+const maxSegment = 3
+
+// Options for Optimize
+type Options struct {
+	// Start and End fix the index of the first and last point. Negative
+	// means any point can go there.
+	Start, End int
+	// TimeBudget is how long improving the route can take. The nearest
+	// neighbour route is always built. Zero means no limit.
+	TimeBudget time.Duration
+}
+
+// NewOptions with neither end fixed and no time limit
+func NewOptions() Options {
+	return Options{Start: -1, End: -1}
+}
+
+type optimizer struct {
+	dist     [][]float64
+	order    []int
+	start    bool
+	end      bool
+	deadline time.Time
+}
+
+// Optimize returns the indexes of the points in the order to visit them
+func Optimize(points []geo.Point, opts Options) []int {
+	n := len(points)
+	dist := make([][]float64, n)
+	for i := range dist {
+		dist[i] = make([]float64, n)
+		for j := 0; j < i; j++ {
+			dist[i][j] = geo.Distance(points[i], points[j])
+			dist[j][i] = dist[i][j]
+		}
+	}
+	o := &optimizer{
+		dist:  dist,
+		start: opts.Start >= 0 && opts.Start < n,
+		end:   opts.End >= 0 && opts.End < n && opts.End != opts.Start,
+	}
+	if opts.TimeBudget > 0 {
+		o.deadline = time.Now().Add(opts.TimeBudget)
+	}
+	o.order = nearestNeighbour(dist, opts.Start, opts.End, o.start, o.end)
+	for !o.expired() {
+		improved := o.twoOpt()
+		if o.orOpt() {
+			improved = true
+		}
+		if !improved {
+			break
+		}
+	}
+	return o.order
+}
+
+// Length of the route through the points in the order given
+func Length(points []geo.Point, order []int) float64 {
+	path := make([]geo.Point, len(order))
+	for i, index := range order {
+		path[i] = points[index]
+	}
+	return geo.PathLength(path)
+}
+
+// nearestNeighbour builds a route starting at start, or the first point if
+// it isn't fixed, going to the closest point not yet visited each time
+func nearestNeighbour(dist [][]float64, start, end int, hasStart, hasEnd bool) []int {
+	n := len(dist)
+	order := make([]int, 0, n)
+	if n == 0 {
+		return order
+	}
+	visited := make([]bool, n)
+	current := 0
+	if hasStart {
+		current = start
+	} else if hasEnd && end == 0 && n > 1 {
+		current = 1
+	}
+	visited[current] = true
+	order = append(order, current)
+	remaining := n - 1
+	if hasEnd && !visited[end] {
+		// the end is saved for last
+		visited[end] = true
+		remaining--
+	}
+	for ; remaining > 0; remaining-- {
+		next := -1
+		for j := 0; j < n; j++ {
+			if !visited[j] && (next < 0 || dist[current][j] < dist[current][next]) {
+				next = j
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		current = next
+	}
+	if hasEnd && order[len(order)-1] != end {
+		order = append(order, end)
+	}
+	return order
+}
+
+func (o *optimizer) expired() bool {
+	return !o.deadline.IsZero() && time.Now().After(o.deadline)
+}
+
+// edge is the length between two points in the route, where -1 is off the
+// end of it and costs nothing
+func (o *optimizer) edge(a, b int) float64 {
+	if a < 0 || b < 0 {
+		return 0
+	}
+	return o.dist[a][b]
+}
+
+// at is the point at position i of the route, or -1 off either end
+func (o *optimizer) at(i int) int {
+	if i < 0 || i >= len(o.order) {
+		return -1
+	}
+	return o.order[i]
+}
+
+// bounds are the first and last positions that can move
+func (o *optimizer) bounds() (int, int) {
+	lo, hi := 0, len(o.order)-1
+	if o.start {
+		lo++
+	}
+	if o.end {
+		hi--
+	}
+	return lo, hi
+}
+
+// twoOpt reverses parts of the route wherever that makes it shorter.
+// Returns true if anything changed.
+func (o *optimizer) twoOpt() bool {
+	lo, hi := o.bounds()
+	improved := false
+	for i := lo; i < hi; i++ {
+		if o.expired() {
+			return improved
+		}
+		for j := i + 1; j <= hi; j++ {
+			before, first := o.at(i-1), o.at(i)
+			last, after := o.at(j), o.at(j+1)
+			delta := o.edge(before, last) + o.edge(first, after) -
+				o.edge(before, first) - o.edge(last, after)
+			if delta < -epsilon {
+				reverse(o.order[i : j+1])
+				improved = true
+			}
+		}
+	}
+	return improved
+}
+
+func reverse(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+// orOpt moves short runs of points, maybe reversed, to wherever they make
+// the route shortest. Returns true if anything changed.
+func (o *optimizer) orOpt() bool {
+	improved := false
+	for length := 1; length <= maxSegment; length++ {
+		lo, hi := o.bounds()
+		for i := lo; i+length-1 <= hi; i++ {
+			if o.expired() {
+				return improved
+			}
+			if o.moveSegment(i, length) {
+				improved = true
+			}
+		}
+	}
+	return improved
+}
+
+// moveSegment moves the run of points at i to the best place for it, if
+// that's better than where it is
+func (o *optimizer) moveSegment(i, length int) bool {
+	j := i + length - 1
+	first, last := o.order[i], o.order[j]
+	before, after := o.at(i-1), o.at(j+1)
+	gain := o.edge(before, first) + o.edge(last, after) - o.edge(before, after)
+
+	// the route without the segment
+	rest := make([]int, 0, len(o.order)-length)
+	rest = append(rest, o.order[:i]...)
+	rest = append(rest, o.order[j+1:]...)
+	restAt := func(k int) int {
+		if k < 0 || k >= len(rest) {
+			return -1
+		}
+		return rest[k]
+	}
+
+	// the segment can go between rest[k-1] and rest[k], for any k that
+	// keeps the fixed ends where they are
+	kMin, kMax := 0, len(rest)
+	if o.start {
+		kMin = 1
+	}
+	if o.end {
+		kMax = len(rest) - 1
+	}
+	best, bestK, bestReversed := gain-epsilon, -1, false
+	for k := kMin; k <= kMax; k++ {
+		if k == i {
+			continue
+		}
+		x, y := restAt(k-1), restAt(k)
+		cost := o.edge(x, first) + o.edge(last, y) - o.edge(x, y)
+		if cost < best {
+			best, bestK, bestReversed = cost, k, false
+		}
+		cost = o.edge(x, last) + o.edge(first, y) - o.edge(x, y)
+		if cost < best {
+			best, bestK, bestReversed = cost, k, true
+		}
+	}
+	if bestK < 0 {
+		return false
+	}
+	segment := append([]int{}, o.order[i:j+1]...)
+	if bestReversed {
+		reverse(segment)
+	}
+	order := make([]int, 0, len(o.order))
+	order = append(order, rest[:bestK]...)
+	order = append(order, segment...)
+	order = append(order, rest[bestK:]...)
+	o.order = order
+	return true
+}
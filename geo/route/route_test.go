@@ -0,0 +1,223 @@
+package route_test
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bobisme/RestApiProject/geo"
+	. "github.com/bobisme/RestApiProject/geo/route"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// loadCities reads the first n cities from the seed data
+func loadCities(n int) ([]geo.Point, error) {
+	f, err := os.Open("../../data/City.csv")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	points := []geo.Point{}
+	for _, record := range records[1:] {
+		if len(points) == n {
+			break
+		}
+		lat, err := strconv.ParseFloat(record[3], 64)
+		if err != nil {
+			return nil, err
+		}
+		lon, err := strconv.ParseFloat(record[4], 64)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, geo.Point{Lat: lat, Lon: lon})
+	}
+	return points, nil
+}
+
+// permutations calls f with every ordering of order[k:]
+func permutations(order []int, k int, f func([]int)) {
+	if k == len(order) {
+		f(order)
+		return
+	}
+	for i := k; i < len(order); i++ {
+		order[k], order[i] = order[i], order[k]
+		permutations(order, k+1, f)
+		order[k], order[i] = order[i], order[k]
+	}
+}
+
+// shortest is the length of the best route found by trying them all
+func shortest(points []geo.Point) float64 {
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	best := -1.0
+	permutations(order, 0, func(order []int) {
+		if l := Length(points, order); best < 0 || l < best {
+			best = l
+		}
+	})
+	return best
+}
+
+// nearestNeighbour is the greedy route from the first point
+func nearestNeighbour(points []geo.Point) []int {
+	visited := make([]bool, len(points))
+	order := []int{0}
+	visited[0] = true
+	for len(order) < len(points) {
+		current, next := order[len(order)-1], -1
+		for j, p := range points {
+			if visited[j] {
+				continue
+			}
+			d := geo.Distance(points[current], p)
+			if next < 0 || d < geo.Distance(points[current], points[next]) {
+				next = j
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+func isPermutation(order []int, n int) bool {
+	sorted := append([]int{}, order...)
+	sort.Ints(sorted)
+	for i, index := range sorted {
+		if i != index {
+			return false
+		}
+	}
+	return len(sorted) == n
+}
+
+var _ = Describe("Route", func() {
+	var cities []geo.Point
+
+	BeforeEach(func() {
+		var err error
+		cities, err = loadCities(60)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(cities).Should(HaveLen(60))
+	})
+
+	Describe("Optimize", func() {
+		It("handles no points", func() {
+			Ω(Optimize(nil, NewOptions())).Should(BeEmpty())
+		})
+
+		It("handles one point", func() {
+			Ω(Optimize(cities[:1], NewOptions())).Should(Equal([]int{0}))
+		})
+
+		It("visits every point once", func() {
+			order := Optimize(cities, NewOptions())
+			Ω(isPermutation(order, len(cities))).Should(BeTrue())
+		})
+
+		It("does better than nearest neighbour", func() {
+			order := Optimize(cities, NewOptions())
+			Ω(Length(cities, order)).Should(BeNumerically("<",
+				Length(cities, nearestNeighbour(cities))))
+		})
+
+		It("is deterministic", func() {
+			Ω(Optimize(cities, NewOptions())).Should(Equal(
+				Optimize(cities, NewOptions())))
+		})
+
+		It("leaves no improving 2-opt move", func() {
+			order := Optimize(cities, NewOptions())
+			length := Length(cities, order)
+			for i := 0; i < len(order)-1; i++ {
+				for j := i + 1; j < len(order); j++ {
+					swapped := append([]int{}, order...)
+					for a, b := i, j; a < b; a, b = a+1, b-1 {
+						swapped[a], swapped[b] = swapped[b], swapped[a]
+					}
+					Ω(Length(cities, swapped)).Should(
+						BeNumerically(">=", length-1e-6))
+				}
+			}
+		})
+
+		It("finds the best route through a few points", func() {
+			points := cities[:8]
+			order := Optimize(points, NewOptions())
+			Ω(Length(points, order)).Should(
+				BeNumerically("~", shortest(points), 1e-6))
+		})
+
+		It("keeps a fixed start", func() {
+			opts := NewOptions()
+			opts.Start = 17
+			order := Optimize(cities, opts)
+			Ω(isPermutation(order, len(cities))).Should(BeTrue())
+			Ω(order[0]).Should(Equal(17))
+		})
+
+		It("keeps a fixed end", func() {
+			opts := NewOptions()
+			opts.End = 0
+			order := Optimize(cities, opts)
+			Ω(isPermutation(order, len(cities))).Should(BeTrue())
+			Ω(order[len(order)-1]).Should(Equal(0))
+		})
+
+		It("keeps a fixed start and end", func() {
+			opts := NewOptions()
+			opts.Start, opts.End = 5, 42
+			order := Optimize(cities, opts)
+			Ω(isPermutation(order, len(cities))).Should(BeTrue())
+			Ω(order[0]).Should(Equal(5))
+			Ω(order[len(order)-1]).Should(Equal(42))
+		})
+
+		It("stops when the time budget runs out", func() {
+			many, err := loadCities(400)
+			Ω(err).ShouldNot(HaveOccurred())
+			opts := NewOptions()
+			opts.TimeBudget = time.Millisecond
+			start := time.Now()
+			order := Optimize(many, opts)
+			Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+			Ω(isPermutation(order, len(many))).Should(BeTrue())
+		})
+	})
+
+	Describe("Length", func() {
+		It("is the length of the path in that order", func() {
+			points := cities[:3]
+			Ω(Length(points, []int{2, 0, 1})).Should(BeNumerically("~",
+				geo.Distance(points[2], points[0])+geo.Distance(points[0], points[1])))
+		})
+	})
+})
+
+func benchmarkOptimize(b *testing.B, n int) {
+	points, err := loadCities(n)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Optimize(points, NewOptions())
+	}
+}
+
+func BenchmarkOptimize50(b *testing.B)  { benchmarkOptimize(b, 50) }
+func BenchmarkOptimize200(b *testing.B) { benchmarkOptimize(b, 200) }
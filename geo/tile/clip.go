@@ -0,0 +1,123 @@
+package tile
+
+import "math"
+
+// Coord is a position on a tile, in the units of its extent
+type Coord struct {
+	X, Y float64
+}
+
+// ClipRing cuts the ring down to the part inside the square from lo to hi on
+// both axes, using Sutherland-Hodgman. Rings join their last point back to
+// the first, and may come back empty.
+func ClipRing(ring []Coord, lo, hi float64) []Coord {
+	edges := []struct {
+		inside func(Coord) bool
+		cross  func(a, b Coord) Coord
+	}{
+		{func(c Coord) bool { return c.X >= lo }, func(a, b Coord) Coord {
+			return Coord{lo, a.Y + (b.Y-a.Y)*(lo-a.X)/(b.X-a.X)}
+		}},
+		{func(c Coord) bool { return c.X <= hi }, func(a, b Coord) Coord {
+			return Coord{hi, a.Y + (b.Y-a.Y)*(hi-a.X)/(b.X-a.X)}
+		}},
+		{func(c Coord) bool { return c.Y >= lo }, func(a, b Coord) Coord {
+			return Coord{a.X + (b.X-a.X)*(lo-a.Y)/(b.Y-a.Y), lo}
+		}},
+		{func(c Coord) bool { return c.Y <= hi }, func(a, b Coord) Coord {
+			return Coord{a.X + (b.X-a.X)*(hi-a.Y)/(b.Y-a.Y), hi}
+		}},
+	}
+	out := ring
+	for _, edge := range edges {
+		in := out
+		out = nil
+		for i, current := range in {
+			previous := in[(i+len(in)-1)%len(in)]
+			if edge.inside(current) {
+				if !edge.inside(previous) {
+					out = append(out, edge.cross(previous, current))
+				}
+				out = append(out, current)
+			} else if edge.inside(previous) {
+				out = append(out, edge.cross(previous, current))
+			}
+		}
+	}
+	return out
+}
+
+// Simplify drops points from the line that are within tolerance of the line
+// through their neighbours, using Douglas-Peucker. The ends are kept.
+func Simplify(line []Coord, tolerance float64) []Coord {
+	if len(line) < 3 {
+		return line
+	}
+	keep := make([]bool, len(line))
+	keep[0], keep[len(line)-1] = true, true
+	simplify(line, 0, len(line)-1, tolerance*tolerance, keep)
+	out := make([]Coord, 0, len(line))
+	for i, c := range line {
+		if keep[i] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func simplify(line []Coord, first, last int, sqTolerance float64, keep []bool) {
+	farthest, maxSqDist := -1, sqTolerance
+	for i := first + 1; i < last; i++ {
+		if d := sqSegmentDistance(line[i], line[first], line[last]); d > maxSqDist {
+			farthest, maxSqDist = i, d
+		}
+	}
+	if farthest < 0 {
+		return
+	}
+	keep[farthest] = true
+	simplify(line, first, farthest, sqTolerance, keep)
+	simplify(line, farthest, last, sqTolerance, keep)
+}
+
+// sqSegmentDistance is the squared distance from p to the segment a-b
+func sqSegmentDistance(p, a, b Coord) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	x, y := a.X, a.Y
+	if dx != 0 || dy != 0 {
+		t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / (dx*dx + dy*dy)
+		t = math.Max(0, math.Min(1, t))
+		x, y = a.X+dx*t, a.Y+dy*t
+	}
+	return (p.X-x)*(p.X-x) + (p.Y-y)*(p.Y-y)
+}
+
+// SimplifyRing is Simplify for a closed ring. A ring's first and last points
+// are the same place, so it's split at the point farthest from the first to
+// keep the simplified ring from collapsing.
+func SimplifyRing(ring []Coord, tolerance float64) []Coord {
+	if len(ring) < 4 {
+		return ring
+	}
+	farthest, maxSqDist := 0, 0.0
+	for i, c := range ring {
+		dx, dy := c.X-ring[0].X, c.Y-ring[0].Y
+		if d := dx*dx + dy*dy; d > maxSqDist {
+			farthest, maxSqDist = i, d
+		}
+	}
+	first := Simplify(ring[:farthest+1], tolerance)
+	second := Simplify(append(append([]Coord{}, ring[farthest:]...), ring[0]), tolerance)
+	return append(first, second[1:len(second)-1]...)
+}
+
+// Area is the signed area of the ring by the shoelace formula. With y going
+// down, rings going clockwise on screen have positive area.
+func Area(ring []Coord) float64 {
+	sum := 0.0
+	for i, a := range ring {
+		b := ring[(i+1)%len(ring)]
+		sum += a.X*b.Y - b.X*a.Y
+	}
+	return sum / 2
+}
@@ -0,0 +1,317 @@
+package tile
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultExtent is how many units across a tile's geometry is given in
+const DefaultExtent = 4096
+
+// GeomType is the kind of geometry a feature has
+type GeomType int
+
+// Geometry types from the spec
+const (
+	GeomUnknown GeomType = iota
+	GeomPoint
+	GeomLineString
+	GeomPolygon
+)
+
+// geometry commands
+const (
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type feature struct {
+	id       uint64
+	tags     []uint32
+	geomType GeomType
+	geometry []uint32
+}
+
+// Layer is a named set of features in a tile. Property keys and values are
+// shared by all the features, so repeated ones are only written once.
+type Layer struct {
+	Name   string
+	Extent int
+
+	features   []feature
+	keys       []string
+	keyIndex   map[string]uint32
+	values     []interface{}
+	valueIndex map[interface{}]uint32
+}
+
+// NewLayer with no features
+func NewLayer(name string, extent int) *Layer {
+	return &Layer{
+		Name: name, Extent: extent,
+		keyIndex: map[string]uint32{}, valueIndex: map[interface{}]uint32{},
+	}
+}
+
+// Len is the number of features in the layer
+func (l *Layer) Len() int {
+	return len(l.features)
+}
+
+// tags turns the properties into pairs of key and value indexes, in key
+// order. Values can be strings, bools, floats and ints. Others are left out.
+func (l *Layer) tags(props map[string]interface{}) []uint32 {
+	keys := make([]string, 0, len(props))
+	for key := range props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tags := make([]uint32, 0, 2*len(keys))
+	for _, key := range keys {
+		var value interface{}
+		switch v := props[key].(type) {
+		case string, bool, float64, int64, uint64:
+			value = v
+		case float32:
+			value = float64(v)
+		case int:
+			value = int64(v)
+		case int32:
+			value = int64(v)
+		case uint:
+			value = uint64(v)
+		case uint32:
+			value = uint64(v)
+		default:
+			continue
+		}
+		k, ok := l.keyIndex[key]
+		if !ok {
+			k = uint32(len(l.keys))
+			l.keyIndex[key] = k
+			l.keys = append(l.keys, key)
+		}
+		v, ok := l.valueIndex[value]
+		if !ok {
+			v = uint32(len(l.values))
+			l.valueIndex[value] = v
+			l.values = append(l.values, value)
+		}
+		tags = append(tags, k, v)
+	}
+	return tags
+}
+
+func command(id, count int) uint32 {
+	return uint32(id&7 | count<<3)
+}
+
+func zigzag(n int32) uint32 {
+	return uint32(n<<1) ^ uint32(n>>31)
+}
+
+// cursor writes positions as offsets from the one before
+type cursor struct {
+	x, y int32
+}
+
+func (c *cursor) to(p [2]int32) []uint32 {
+	dx, dy := p[0]-c.x, p[1]-c.y
+	c.x, c.y = p[0], p[1]
+	return []uint32{zigzag(dx), zigzag(dy)}
+}
+
+func round(c Coord) [2]int32 {
+	return [2]int32{int32(math.Floor(c.X + 0.5)), int32(math.Floor(c.Y + 0.5))}
+}
+
+// AddPoints adds a feature of one or more points. Points are given in tile
+// units and ones off the tile are left out. Returns false if none were on it.
+func (l *Layer) AddPoints(
+	id uint64, points []Coord, props map[string]interface{},
+) bool {
+	var c cursor
+	var params []uint32
+	count := 0
+	for _, p := range points {
+		if p.X < 0 || p.Y < 0 || p.X >= float64(l.Extent) || p.Y >= float64(l.Extent) {
+			continue
+		}
+		params = append(params, c.to(round(p))...)
+		count++
+	}
+	if count == 0 {
+		return false
+	}
+	geometry := append([]uint32{command(cmdMoveTo, count)}, params...)
+	l.features = append(l.features, feature{id, l.tags(props), GeomPoint, geometry})
+	return true
+}
+
+// cleanRing rounds the ring to whole units and drops repeated points. It
+// returns nil if there aren't enough points left to have any area.
+func cleanRing(ring []Coord) [][2]int32 {
+	out := make([][2]int32, 0, len(ring))
+	for _, c := range ring {
+		p := round(c)
+		if len(out) == 0 || out[len(out)-1] != p {
+			out = append(out, p)
+		}
+	}
+	if len(out) > 1 && out[0] == out[len(out)-1] {
+		out = out[:len(out)-1]
+	}
+	if len(out) < 3 {
+		return nil
+	}
+	return out
+}
+
+func ringArea(ring [][2]int32) int64 {
+	var sum int64
+	for i, a := range ring {
+		b := ring[(i+1)%len(ring)]
+		sum += int64(a[0])*int64(b[1]) - int64(b[0])*int64(a[1])
+	}
+	return sum
+}
+
+// AddPolygons adds a feature of one or more polygons, each an outer ring then
+// any holes, in tile units. Rings should already be clipped to the tile.
+// Outer rings are turned to go clockwise on screen and holes the other way,
+// as the spec wants. Returns false if nothing was left to draw.
+func (l *Layer) AddPolygons(
+	id uint64, polygons [][][]Coord, props map[string]interface{},
+) bool {
+	var c cursor
+	var geometry []uint32
+	for _, polygon := range polygons {
+		for i, ring := range polygon {
+			cleaned := cleanRing(ring)
+			if cleaned == nil || ringArea(cleaned) == 0 {
+				if i == 0 {
+					// no outer ring, so no polygon
+					break
+				}
+				continue
+			}
+			if outer := i == 0; outer != (ringArea(cleaned) > 0) {
+				for a, b := 0, len(cleaned)-1; a < b; a, b = a+1, b-1 {
+					cleaned[a], cleaned[b] = cleaned[b], cleaned[a]
+				}
+			}
+			geometry = append(geometry, command(cmdMoveTo, 1))
+			geometry = append(geometry, c.to(cleaned[0])...)
+			geometry = append(geometry, command(cmdLineTo, len(cleaned)-1))
+			for _, p := range cleaned[1:] {
+				geometry = append(geometry, c.to(p)...)
+			}
+			geometry = append(geometry, command(cmdClosePath, 1))
+		}
+	}
+	if len(geometry) == 0 {
+		return false
+	}
+	l.features = append(l.features, feature{id, l.tags(props), GeomPolygon, geometry})
+	return true
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendKey(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendPacked(b []byte, field int, values []uint32) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = appendVarint(packed, uint64(v))
+	}
+	return appendBytes(b, field, packed)
+}
+
+func encodeValue(value interface{}) []byte {
+	var b []byte
+	switch v := value.(type) {
+	case string:
+		b = appendBytes(b, 1, []byte(v))
+	case float64:
+		b = appendKey(b, 3, wireFixed64)
+		bits := math.Float64bits(v)
+		for i := uint(0); i < 8; i++ {
+			b = append(b, byte(bits>>(8*i)))
+		}
+	case int64:
+		b = appendKey(b, 4, wireVarint)
+		b = appendVarint(b, uint64(v))
+	case uint64:
+		b = appendKey(b, 5, wireVarint)
+		b = appendVarint(b, v)
+	case bool:
+		b = appendKey(b, 7, wireVarint)
+		if v {
+			b = appendVarint(b, 1)
+		} else {
+			b = appendVarint(b, 0)
+		}
+	}
+	return b
+}
+
+func (l *Layer) encode() []byte {
+	var b []byte
+	b = appendKey(b, 15, wireVarint)
+	b = appendVarint(b, 2)
+	b = appendBytes(b, 1, []byte(l.Name))
+	for _, f := range l.features {
+		var fb []byte
+		if f.id != 0 {
+			fb = appendKey(fb, 1, wireVarint)
+			fb = appendVarint(fb, f.id)
+		}
+		if len(f.tags) > 0 {
+			fb = appendPacked(fb, 2, f.tags)
+		}
+		fb = appendKey(fb, 3, wireVarint)
+		fb = appendVarint(fb, uint64(f.geomType))
+		fb = appendPacked(fb, 4, f.geometry)
+		b = appendBytes(b, 2, fb)
+	}
+	for _, key := range l.keys {
+		b = appendBytes(b, 3, []byte(key))
+	}
+	for _, value := range l.values {
+		b = appendBytes(b, 4, encodeValue(value))
+	}
+	b = appendKey(b, 5, wireVarint)
+	return appendVarint(b, uint64(l.Extent))
+}
+
+// Encode the layers as a vector tile
+func Encode(layers ...*Layer) []byte {
+	var b []byte
+	for _, l := range layers {
+		b = appendBytes(b, 3, l.encode())
+	}
+	return b
+}
@@ -0,0 +1,161 @@
+package tile_test
+
+import (
+	"encoding/binary"
+	"math"
+
+	. "github.com/bobisme/RestApiProject/geo/tile"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// message is a decoded protobuf message: the varints and byte strings for
+// each field number
+type message map[int][]interface{}
+
+func readVarint(b []byte) (uint64, []byte) {
+	v, n := binary.Uvarint(b)
+	Ω(n).Should(BeNumerically(">", 0))
+	return v, b[n:]
+}
+
+func decode(b []byte) message {
+	m := message{}
+	for len(b) > 0 {
+		var key uint64
+		key, b = readVarint(b)
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			var v uint64
+			v, b = readVarint(b)
+			m[field] = append(m[field], v)
+		case 1:
+			m[field] = append(m[field], math.Float64frombits(
+				binary.LittleEndian.Uint64(b[:8])))
+			b = b[8:]
+		case 2:
+			var n uint64
+			n, b = readVarint(b)
+			m[field] = append(m[field], b[:n])
+			b = b[n:]
+		default:
+			Fail("unexpected wire type")
+		}
+	}
+	return m
+}
+
+func packed(b []byte) []uint32 {
+	values := []uint32{}
+	for len(b) > 0 {
+		var v uint64
+		v, b = readVarint(b)
+		values = append(values, uint32(v))
+	}
+	return values
+}
+
+func unzigzag(v uint32) int32 {
+	return int32(v>>1) ^ -int32(v&1)
+}
+
+var _ = Describe("Encode", func() {
+	It("encodes layers of points", func() {
+		l := NewLayer("cities", DefaultExtent)
+		Ω(l.AddPoints(7, []Coord{{10, 20}}, map[string]interface{}{
+			"name": "Winterfell", "stateId": 1, "capital": false,
+		})).Should(BeTrue())
+		Ω(l.AddPoints(8, []Coord{{25, 17}}, map[string]interface{}{
+			"name": "Kings Landing", "stateId": 1, "capital": true,
+		})).Should(BeTrue())
+		Ω(l.AddPoints(9, []Coord{{-1, 5}}, nil)).Should(BeFalse())
+		Ω(l.Len()).Should(Equal(2))
+
+		tile := decode(Encode(l))
+		Ω(tile[3]).Should(HaveLen(1))
+		layer := decode(tile[3][0].([]byte))
+		Ω(layer[15]).Should(Equal([]interface{}{uint64(2)}))
+		Ω(string(layer[1][0].([]byte))).Should(Equal("cities"))
+		Ω(layer[5]).Should(Equal([]interface{}{uint64(DefaultExtent)}))
+
+		keys := []string{}
+		for _, k := range layer[3] {
+			keys = append(keys, string(k.([]byte)))
+		}
+		// in key order, and not repeated
+		Ω(keys).Should(Equal([]string{"capital", "name", "stateId"}))
+		// false, Winterfell, 1, true, Kings Landing
+		Ω(layer[4]).Should(HaveLen(5))
+		Ω(decode(layer[4][1].([]byte))[1][0]).Should(Equal([]byte("Winterfell")))
+		Ω(decode(layer[4][2].([]byte))[4][0]).Should(Equal(uint64(1)))
+
+		Ω(layer[2]).Should(HaveLen(2))
+		f := decode(layer[2][1].([]byte))
+		Ω(f[1]).Should(Equal([]interface{}{uint64(8)}))
+		Ω(f[3]).Should(Equal([]interface{}{uint64(GeomPoint)}))
+		Ω(packed(f[2][0].([]byte))).Should(Equal([]uint32{0, 3, 1, 4, 2, 2}))
+		geometry := packed(f[4][0].([]byte))
+		Ω(geometry[0]).Should(Equal(uint32(1 | 1<<3)))
+		Ω(unzigzag(geometry[1])).Should(Equal(int32(25)))
+		Ω(unzigzag(geometry[2])).Should(Equal(int32(17)))
+	})
+
+	It("encodes polygons clockwise with holes counterclockwise", func() {
+		l := NewLayer("states", 100)
+		// both rings counterclockwise on screen
+		outer := []Coord{{0, 0}, {0, 10}, {10, 10}, {10, 0}}
+		hole := []Coord{{2, 2}, {2, 4}, {4, 4}, {4, 2}}
+		Ω(l.AddPolygons(1, [][][]Coord{{outer, hole}}, nil)).Should(BeTrue())
+
+		layer := decode(decode(Encode(l))[3][0].([]byte))
+		f := decode(layer[2][0].([]byte))
+		Ω(f[3]).Should(Equal([]interface{}{uint64(GeomPolygon)}))
+		geometry := packed(f[4][0].([]byte))
+		Ω(geometry).Should(HaveLen(2 * (1 + 2 + 1 + 6 + 1)))
+
+		// walk the commands back into rings
+		rings := [][]Coord{}
+		var x, y int32
+		for i := 0; i < len(geometry); {
+			id, count := geometry[i]&7, int(geometry[i]>>3)
+			i++
+			switch id {
+			case 1:
+				x += unzigzag(geometry[i])
+				y += unzigzag(geometry[i+1])
+				rings = append(rings, []Coord{{float64(x), float64(y)}})
+				i += 2
+			case 2:
+				for j := 0; j < count; j++ {
+					x += unzigzag(geometry[i+2*j])
+					y += unzigzag(geometry[i+2*j+1])
+					last := len(rings) - 1
+					rings[last] = append(rings[last], Coord{float64(x), float64(y)})
+				}
+				i += 2 * count
+			case 7:
+			default:
+				Fail("unknown command")
+			}
+		}
+		Ω(rings).Should(HaveLen(2))
+		Ω(Area(rings[0])).Should(Equal(100.0))
+		Ω(Area(rings[1])).Should(Equal(-4.0))
+	})
+
+	It("leaves out polygons too small to see", func() {
+		l := NewLayer("states", 100)
+		tiny := []Coord{{1, 1}, {1.1, 1}, {1.1, 1.1}}
+		Ω(l.AddPolygons(1, [][][]Coord{{tiny}}, nil)).Should(BeFalse())
+		Ω(l.Len()).Should(Equal(0))
+	})
+
+	It("encodes floats as doubles", func() {
+		l := NewLayer("x", 10)
+		l.AddPoints(1, []Coord{{1, 1}}, map[string]interface{}{"km": 2.5})
+		layer := decode(decode(Encode(l))[3][0].([]byte))
+		Ω(decode(layer[4][0].([]byte))[3][0]).Should(Equal(2.5))
+	})
+})
@@ -0,0 +1,96 @@
+// Package tile does the math for Web Mercator map tiles and encodes Mapbox
+// Vector Tiles, following version 2.1 of the spec at
+// https://github.com/mapbox/vector-tile-spec
+package tile
+
+import (
+	"fmt"
+
+	"github.com/bobisme/RestApiProject/geo"
+)
+
+// MaxZoom is the deepest zoom tiles are made for
+const MaxZoom = 24
+
+// Tile is one square of the map at a zoom. At zoom Z there are 2^Z tiles
+// across and down, with X going east from the antimeridian and Y going
+// south from the top of the map.
+type Tile struct {
+	Z, X, Y int
+}
+
+// New returns the tile if it's on the map
+func New(z, x, y int) (Tile, error) {
+	if z < 0 || z > MaxZoom {
+		return Tile{}, fmt.Errorf("zoom must be from 0 to %d", MaxZoom)
+	}
+	n := 1 << uint(z)
+	if x < 0 || x >= n || y < 0 || y >= n {
+		return Tile{}, fmt.Errorf("x and y must be from 0 to %d at zoom %d", n-1, z)
+	}
+	return Tile{z, x, y}, nil
+}
+
+// Containing returns the tile at the zoom the point is in
+func Containing(p geo.Point, z int) Tile {
+	n := 1 << uint(z)
+	x, y := geo.Mercator(p)
+	clamp := func(f float64) int {
+		i := int(f * float64(n))
+		if i < 0 {
+			return 0
+		} else if i >= n {
+			return n - 1
+		}
+		return i
+	}
+	return Tile{z, clamp(x), clamp(y)}
+}
+
+// String is the tile's path, like 3/2/1
+func (t Tile) String() string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
+
+// BBox is the area the tile covers
+func (t Tile) BBox() geo.BBox {
+	n := float64(int(1) << uint(t.Z))
+	topLeft := geo.InverseMercator(float64(t.X)/n, float64(t.Y)/n)
+	bottomRight := geo.InverseMercator(float64(t.X+1)/n, float64(t.Y+1)/n)
+	return geo.BBox{
+		MinLat: bottomRight.Lat, MinLon: topLeft.Lon,
+		MaxLat: topLeft.Lat, MaxLon: bottomRight.Lon,
+	}
+}
+
+// BufferedBBox is the area the tile covers plus a buffer on every side,
+// given as a fraction of the tile's size. The latitudes are clamped to the
+// map.
+func (t Tile) BufferedBBox(buffer float64) geo.BBox {
+	n := float64(int(1) << uint(t.Z))
+	clamp := func(f float64) float64 {
+		if f < 0 {
+			return 0
+		} else if f > 1 {
+			return 1
+		}
+		return f
+	}
+	topLeft := geo.InverseMercator(
+		(float64(t.X)-buffer)/n, clamp((float64(t.Y)-buffer)/n))
+	bottomRight := geo.InverseMercator(
+		(float64(t.X+1)+buffer)/n, clamp((float64(t.Y+1)+buffer)/n))
+	return geo.BBox{
+		MinLat: bottomRight.Lat, MinLon: topLeft.Lon,
+		MaxLat: topLeft.Lat, MaxLon: bottomRight.Lon,
+	}
+}
+
+// Project returns where the point is on the tile, from 0 to extent across
+// and down. Points off the tile are outside that range.
+func (t Tile) Project(p geo.Point, extent int) (float64, float64) {
+	n := float64(int(1) << uint(t.Z))
+	x, y := geo.Mercator(p)
+	return (x*n - float64(t.X)) * float64(extent),
+		(y*n - float64(t.Y)) * float64(extent)
+}
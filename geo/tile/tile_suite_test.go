@@ -0,0 +1,13 @@
+package tile_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTile(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tile Suite")
+}
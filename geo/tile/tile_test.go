@@ -0,0 +1,110 @@
+package tile_test
+
+import (
+	"github.com/bobisme/RestApiProject/geo"
+	. "github.com/bobisme/RestApiProject/geo/tile"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tile", func() {
+	DescribeTable("New rejects tiles off the map",
+		func(z, x, y int) {
+			_, err := New(z, x, y)
+			Ω(err).Should(HaveOccurred())
+		},
+		Entry("negative zoom", -1, 0, 0),
+		Entry("too deep", MaxZoom+1, 0, 0),
+		Entry("x too big", 2, 4, 0),
+		Entry("negative y", 2, 0, -1),
+	)
+
+	It("covers the world at zoom 0", func() {
+		t, err := New(0, 0, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		box := t.BBox()
+		Ω(box.MinLon).Should(BeNumerically("~", -180))
+		Ω(box.MaxLon).Should(BeNumerically("~", 180))
+		Ω(box.MaxLat).Should(BeNumerically("~", geo.MaxMercatorLat, 1e-6))
+		Ω(box.MinLat).Should(BeNumerically("~", -geo.MaxMercatorLat, 1e-6))
+	})
+
+	It("finds the tile a point is in", func() {
+		winterfell := geo.Point{Lat: 35.2271, Lon: -80.8431}
+		t := Containing(winterfell, 10)
+		Ω(t).Should(Equal(Tile{Z: 10, X: 282, Y: 404}))
+		Ω(t.String()).Should(Equal("10/282/404"))
+		Ω(t.BBox().Contains(winterfell)).Should(BeTrue())
+
+		x, y := t.Project(winterfell, DefaultExtent)
+		Ω(x).Should(BeNumerically(">=", 0))
+		Ω(x).Should(BeNumerically("<", DefaultExtent))
+		Ω(y).Should(BeNumerically(">=", 0))
+		Ω(y).Should(BeNumerically("<", DefaultExtent))
+	})
+
+	It("projects the corners of the tile to the corners of the extent", func() {
+		t := Tile{Z: 3, X: 2, Y: 5}
+		box := t.BBox()
+		x, y := t.Project(geo.Point{Lat: box.MaxLat, Lon: box.MinLon}, 256)
+		Ω(x).Should(BeNumerically("~", 0, 1e-6))
+		Ω(y).Should(BeNumerically("~", 0, 1e-6))
+		x, y = t.Project(geo.Point{Lat: box.MinLat, Lon: box.MaxLon}, 256)
+		Ω(x).Should(BeNumerically("~", 256, 1e-6))
+		Ω(y).Should(BeNumerically("~", 256, 1e-6))
+	})
+
+	It("buffers the box", func() {
+		t := Tile{Z: 3, X: 2, Y: 5}
+		box, buffered := t.BBox(), t.BufferedBBox(0.1)
+		Ω(buffered.MinLon).Should(BeNumerically("<", box.MinLon))
+		Ω(buffered.MaxLat).Should(BeNumerically(">", box.MaxLat))
+		world := Tile{}.BufferedBBox(0.1)
+		Ω(world.MaxLat).Should(BeNumerically("~", geo.MaxMercatorLat, 1e-6))
+	})
+})
+
+var _ = Describe("ClipRing", func() {
+	It("keeps rings inside", func() {
+		ring := []Coord{{1, 1}, {3, 1}, {3, 3}}
+		Ω(ClipRing(ring, 0, 10)).Should(Equal(ring))
+	})
+
+	It("cuts rings off at the edges", func() {
+		ring := []Coord{{-2, 5}, {5, -2}, {12, 5}, {5, 12}}
+		clipped := ClipRing(ring, 0, 10)
+		for _, c := range clipped {
+			Ω(c.X).Should(BeNumerically(">=", 0))
+			Ω(c.X).Should(BeNumerically("<=", 10))
+			Ω(c.Y).Should(BeNumerically(">=", 0))
+			Ω(c.Y).Should(BeNumerically("<=", 10))
+		}
+		// a diamond over the box covers all but the corners
+		Ω(Area(clipped)).Should(BeNumerically("~", 100-4*4.5))
+	})
+
+	It("drops rings outside", func() {
+		Ω(ClipRing([]Coord{{20, 20}, {30, 20}, {30, 30}}, 0, 10)).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("Simplify", func() {
+	It("drops points close to the line", func() {
+		line := []Coord{{0, 0}, {1, 0.1}, {2, -0.1}, {3, 5}, {4, 6}, {5, 7}}
+		Ω(Simplify(line, 0.5)).Should(Equal(
+			[]Coord{{0, 0}, {2, -0.1}, {3, 5}, {5, 7}}))
+	})
+
+	It("keeps short lines", func() {
+		line := []Coord{{0, 0}, {1, 1}}
+		Ω(Simplify(line, 10)).Should(Equal(line))
+	})
+
+	It("keeps the shape of rings", func() {
+		ring := []Coord{{0, 0}, {5, 0.01}, {10, 0}, {10, 10}, {5, 10.01}, {0, 10}}
+		Ω(SimplifyRing(ring, 0.5)).Should(Equal(
+			[]Coord{{0, 0}, {10, 0}, {10, 10}, {0, 10}}))
+	})
+})
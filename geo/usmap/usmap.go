@@ -0,0 +1,277 @@
+// Package usmap draws a map of the US with some states shaded and places
+// marked on it, as SVG or PNG. Everything is drawn from the outlines it's
+// given, so it works without any network access.
+package usmap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/bobisme/RestApiProject/geo"
+)
+
+// the size of the image and where the lower 48 goes on it
+const (
+	Width  = 960
+	Height = 540
+	scale  = 1070
+)
+
+// colors of the map
+var (
+	backgroundColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	stateColor      = color.RGBA{0xe2, 0xe4, 0xe8, 0xff}
+	shadedColor     = color.RGBA{0x3b, 0x82, 0xc4, 0xff}
+	borderColor     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	pointColor      = color.RGBA{0xd6, 0x3a, 0x2f, 0xff}
+)
+
+// pointRadius is how big places are drawn, in pixels
+const pointRadius = 4
+
+// Map is what to draw
+type Map struct {
+	// Title is read out by screen readers. It's only in the SVG.
+	Title string
+	// Regions are the outlines of the states
+	Regions []geo.Region
+	// Shaded has the keys of the regions to shade
+	Shaded map[string]bool
+	// Points are places to mark, drawn over the states
+	Points []geo.Point
+}
+
+type pixel struct {
+	x, y float64
+}
+
+// shape is a region projected onto the image
+type shape struct {
+	key    string
+	shaded bool
+	rings  [][]pixel
+}
+
+// Projection is where places go on the map
+func Projection() *geo.AlbersUSA {
+	return geo.NewAlbersUSA(scale, Width/2, Height/2)
+}
+
+func (m *Map) shapes() []shape {
+	proj := Projection()
+	shapes := make([]shape, 0, len(m.Regions))
+	for _, r := range m.Regions {
+		s := shape{key: r.Key, shaded: m.Shaded[r.Key]}
+		for _, poly := range r.Polygons {
+			for _, ring := range poly {
+				projected := make([]pixel, 0, len(ring))
+				for _, p := range ring {
+					x, y := proj.Project(p)
+					projected = append(projected, pixel{x, y})
+				}
+				s.rings = append(s.rings, projected)
+			}
+		}
+		shapes = append(shapes, s)
+	}
+	return shapes
+}
+
+func (m *Map) points() []pixel {
+	proj := Projection()
+	points := make([]pixel, 0, len(m.Points))
+	for _, p := range m.Points {
+		x, y := proj.Project(p)
+		points = append(points, pixel{x, y})
+	}
+	return points
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// WriteSVG draws the map as an SVG image
+func (m *Map) WriteSVG(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" `+
+		`width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		Width, Height, Width, Height)
+	if m.Title != "" {
+		fmt.Fprintf(&b, "<title>%s</title>\n", escape(m.Title))
+	}
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="%s"/>`+"\n",
+		Width, Height, hexColor(backgroundColor))
+	fmt.Fprintf(&b, `<g stroke="%s" stroke-width="0.5" fill-rule="evenodd">`+"\n",
+		hexColor(borderColor))
+	for _, s := range m.shapes() {
+		fill := stateColor
+		if s.shaded {
+			fill = shadedColor
+		}
+		fmt.Fprintf(&b, `<path data-state="%s" fill="%s" d="`,
+			escape(s.key), hexColor(fill))
+		for _, ring := range s.rings {
+			for i, p := range ring {
+				if i == 0 {
+					b.WriteString("M")
+				} else {
+					b.WriteString("L")
+				}
+				fmt.Fprintf(&b, "%.1f,%.1f", p.x, p.y)
+			}
+			b.WriteString("Z")
+		}
+		b.WriteString("\"/>\n")
+	}
+	b.WriteString("</g>\n")
+	fmt.Fprintf(&b, `<g fill="%s" stroke="%s" stroke-width="1">`+"\n",
+		hexColor(pointColor), hexColor(borderColor))
+	for _, p := range m.points() {
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="%d"/>`+"\n",
+			p.x, p.y, pointRadius)
+	}
+	b.WriteString("</g>\n</svg>\n")
+	_, err := b.WriteTo(w)
+	return err
+}
+
+// WritePNG draws the map as a PNG image
+func (m *Map) WritePNG(w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	fillRect(img, img.Bounds(), backgroundColor)
+	for _, s := range m.shapes() {
+		fill := stateColor
+		if s.shaded {
+			fill = shadedColor
+		}
+		fillRings(img, s.rings, fill)
+	}
+	for _, s := range m.shapes() {
+		for _, ring := range s.rings {
+			for i := range ring {
+				drawLine(img, ring[i], ring[(i+1)%len(ring)], borderColor)
+			}
+		}
+	}
+	for _, p := range m.points() {
+		fillCircle(img, p, pointRadius+1, borderColor)
+		fillCircle(img, p, pointRadius, pointColor)
+	}
+	return png.Encode(w, img)
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// fillRings fills the rings with the even-odd rule, one row of pixels at a
+// time, so holes are left empty. A pixel is filled if its center is inside.
+func fillRings(img *image.RGBA, rings [][]pixel, c color.RGBA) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := float64(y) + 0.5
+		crossings := []float64{}
+		for _, ring := range rings {
+			for i := range ring {
+				a, b := ring[i], ring[(i+1)%len(ring)]
+				if (a.y <= cy) != (b.y <= cy) {
+					crossings = append(crossings,
+						a.x+(cy-a.y)*(b.x-a.x)/(b.y-a.y))
+				}
+			}
+		}
+		sort.Float64s(crossings)
+		for i := 0; i+1 < len(crossings); i += 2 {
+			from := int(math.Ceil(crossings[i] - 0.5))
+			to := int(math.Ceil(crossings[i+1] - 0.5))
+			if from < bounds.Min.X {
+				from = bounds.Min.X
+			}
+			if to > bounds.Max.X {
+				to = bounds.Max.X
+			}
+			for x := from; x < to; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// drawLine draws a line a pixel wide with Bresenham's algorithm
+func drawLine(img *image.RGBA, a, b pixel, c color.RGBA) {
+	x0, y0 := int(math.Floor(a.x)), int(math.Floor(a.y))
+	x1, y1 := int(math.Floor(b.x)), int(math.Floor(b.y))
+	dx, dy := x1-x0, y1-y0
+	if dx < 0 {
+		dx = -dx
+	}
+	if dy < 0 {
+		dy = -dy
+	}
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	bounds := img.Bounds()
+	// lines can run far off the image, so stop once they leave it for good
+	if (x0 < bounds.Min.X && x1 < bounds.Min.X) ||
+		(x0 >= bounds.Max.X && x1 >= bounds.Max.X) ||
+		(y0 < bounds.Min.Y && y1 < bounds.Min.Y) ||
+		(y0 >= bounds.Max.Y && y1 >= bounds.Max.Y) {
+		return
+	}
+	err := dx - dy
+	for {
+		if image.Pt(x0, y0).In(bounds) {
+			img.SetRGBA(x0, y0, c)
+		}
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 > -dy {
+			err -= dy
+			x0 += sx
+		}
+		if e2 < dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func fillCircle(img *image.RGBA, center pixel, radius float64, c color.RGBA) {
+	r := image.Rect(
+		int(math.Floor(center.x-radius)), int(math.Floor(center.y-radius)),
+		int(math.Ceil(center.x+radius)), int(math.Ceil(center.y+radius)),
+	).Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dx, dy := float64(x)+0.5-center.x, float64(y)+0.5-center.y
+			if dx*dx+dy*dy <= radius*radius {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package usmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestUsmap(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Usmap Suite")
+}
@@ -0,0 +1,116 @@
+package usmap_test
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"strings"
+
+	"github.com/bobisme/RestApiProject/geo"
+	. "github.com/bobisme/RestApiProject/geo/usmap"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// square region around the point, with a hole in the middle
+func square(key string, center geo.Point, size float64) geo.Region {
+	ring := func(d float64) geo.Ring {
+		return geo.Ring{
+			{Lat: center.Lat - d, Lon: center.Lon - d},
+			{Lat: center.Lat - d, Lon: center.Lon + d},
+			{Lat: center.Lat + d, Lon: center.Lon + d},
+			{Lat: center.Lat + d, Lon: center.Lon - d},
+		}
+	}
+	return geo.NewRegion(key, key, []geo.Polygon{{ring(size), ring(size / 4)}})
+}
+
+var _ = Describe("Map", func() {
+	kansas := geo.Point{Lat: 38.5, Lon: -98}
+	georgia := geo.Point{Lat: 32.5, Lon: -83.5}
+	m := &Map{
+		Title: "Arya's <visits>",
+		Regions: []geo.Region{
+			square("KS", kansas, 2), square("GA", georgia, 2),
+		},
+		Shaded: map[string]bool{"GA": true},
+		Points: []geo.Point{{Lat: 33.5, Lon: -84.5}},
+	}
+
+	It("draws SVG", func() {
+		var b bytes.Buffer
+		Ω(m.WriteSVG(&b)).Should(Succeed())
+		svg := b.String()
+		Ω(svg).Should(HavePrefix(`<svg xmlns="http://www.w3.org/2000/svg"`))
+		Ω(svg).Should(ContainSubstring("<title>Arya&#39;s &lt;visits&gt;</title>"))
+		Ω(strings.Count(svg, "<path ")).Should(Equal(2))
+		Ω(svg).Should(ContainSubstring(`data-state="KS"`))
+		Ω(strings.Count(svg, "<circle ")).Should(Equal(1))
+		Ω(svg).Should(HaveSuffix("</svg>\n"))
+	})
+
+	It("draws PNG", func() {
+		var b bytes.Buffer
+		Ω(m.WritePNG(&b)).Should(Succeed())
+		img, err := png.Decode(&b)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(img.Bounds().Dx()).Should(Equal(Width))
+		Ω(img.Bounds().Dy()).Should(Equal(Height))
+
+		at := func(lat, lon float64) [4]uint32 {
+			x, y := Projection().Project(geo.Point{Lat: lat, Lon: lon})
+			r, g, b, a := img.At(int(x), int(y)).RGBA()
+			return [4]uint32{r, g, b, a}
+		}
+		background := at(45, -120)
+		unshaded := at(kansas.Lat+1, kansas.Lon)
+		shaded := at(georgia.Lat-1, georgia.Lon)
+		Ω(unshaded).ShouldNot(Equal(background))
+		Ω(shaded).ShouldNot(Equal(background))
+		Ω(shaded).ShouldNot(Equal(unshaded))
+		// holes are left empty
+		Ω(at(kansas.Lat, kansas.Lon)).Should(Equal(background))
+		point := at(33.5, -84.5)
+		Ω(point).ShouldNot(Equal(shaded))
+		Ω(point).ShouldNot(Equal(background))
+	})
+
+	It("draws the bundled outlines on the image", func() {
+		regions, err := geo.LoadRegions("../../data/states.geojson", "abbrev")
+		Ω(err).ShouldNot(HaveOccurred())
+		// the 50 states and DC
+		Ω(regions).Should(HaveLen(51))
+		proj := Projection()
+		// how far the lower 48 reach across the image
+		left, right, top, bottom := float64(Width), 0.0, float64(Height), 0.0
+		for _, r := range regions {
+			for _, poly := range r.Polygons {
+				for _, ring := range poly {
+					for _, p := range ring {
+						x, y := proj.Project(p)
+						Ω(x).Should(BeNumerically(">=", 0), r.Key)
+						Ω(x).Should(BeNumerically("<=", Width), r.Key)
+						Ω(y).Should(BeNumerically(">=", 0), r.Key)
+						Ω(y).Should(BeNumerically("<=", Height), r.Key)
+						if r.Key == "AK" || r.Key == "HI" {
+							continue
+						}
+						left, right = math.Min(left, x), math.Max(right, x)
+						top, bottom = math.Min(top, y), math.Max(bottom, y)
+					}
+				}
+			}
+		}
+		Ω(left).Should(BeNumerically("<", Width*0.1))
+		Ω(right).Should(BeNumerically(">", Width*0.9))
+		Ω(top).Should(BeNumerically("<", Height*0.1))
+		Ω(bottom).Should(BeNumerically(">", Height*0.9))
+
+		var b bytes.Buffer
+		Ω((&Map{Regions: regions}).WriteSVG(&b)).Should(Succeed())
+		Ω(strings.Count(b.String(), "<path ")).Should(Equal(51))
+		b.Reset()
+		Ω((&Map{Regions: regions}).WritePNG(&b)).Should(Succeed())
+	})
+})
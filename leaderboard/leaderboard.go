@@ -0,0 +1,347 @@
+// Package leaderboard ranks users by how much they've travelled. Ranks are
+// materialized in the leaderboard_entries table and rebuilt from visits in
+// the background every refresh interval, so reading a leaderboard doesn't
+// scan every visit.
+//
+// Leaderboards are public, so only publicly visible visits count and users
+// whose visits are private by default are left off entirely.
+package leaderboard
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bobisme/RestApiProject/geo"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+)
+
+// Metrics users are ranked by
+const (
+	// MetricStates is the number of distinct states visited
+	MetricStates = "states"
+	// MetricCities is the number of distinct cities visited
+	MetricCities = "cities"
+	// MetricDistance is the km travelled going from visit to visit
+	MetricDistance = "distance"
+)
+
+// Periods of time the visits are counted over
+const (
+	PeriodAll      = "all"
+	PeriodYear     = "year"
+	PeriodLastDays = "30d"
+)
+
+// Metrics in the order they are listed
+var Metrics = []string{MetricStates, MetricCities, MetricDistance}
+
+// Periods in the order they are listed
+var Periods = []string{PeriodAll, PeriodYear, PeriodLastDays}
+
+// Valid is true if the metric and period are known
+func Valid(metric, period string) bool {
+	return contains(Metrics, metric) && contains(Periods, period)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// periodStart is when the period began at the time now, or the zero time for
+// all time
+func periodStart(period string, now time.Time) time.Time {
+	switch period {
+	case PeriodYear:
+		return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location())
+	case PeriodLastDays:
+		return now.AddDate(0, 0, -30)
+	}
+	return time.Time{}
+}
+
+// Entry is a user's place on a leaderboard
+type Entry struct {
+	Rank      int     `json:"rank"`
+	UserID    uint    `json:"userId"`
+	FirstName string  `json:"firstName"`
+	LastName  string  `json:"lastName"`
+	Value     float64 `json:"value"`
+}
+
+// tally is what's been counted for one user over one period
+type tally struct {
+	cities   map[uint]bool
+	states   map[uint]bool
+	distance float64
+	last     *geo.Point
+}
+
+func (t *tally) add(cityID, stateID uint, p geo.Point) {
+	t.cities[cityID] = true
+	t.states[stateID] = true
+	if t.last != nil {
+		t.distance += geo.Distance(*t.last, p)
+	}
+	t.last = &p
+}
+
+func (t *tally) value(metric string) float64 {
+	switch metric {
+	case MetricStates:
+		return float64(len(t.states))
+	case MetricCities:
+		return float64(len(t.cities))
+	}
+	return t.distance
+}
+
+// Board keeps the materialized leaderboards up to date
+type Board struct {
+	db       *gorm.DB
+	interval time.Duration
+
+	// rebuilding lets one rebuild count visits at a time. mutex is only
+	// held for writing while the new entries replace the old ones, so
+	// readers aren't held up by the counting.
+	rebuilding sync.Mutex
+	mutex      sync.RWMutex
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewBoard that is rebuilt every interval once it's started
+func NewBoard(db *gorm.DB, interval time.Duration) *Board {
+	return &Board{db: db, interval: interval}
+}
+
+// Start rebuilding every interval. The first rebuild is done before it
+// returns, so the leaderboards are ready to read.
+func (b *Board) Start() {
+	if err := b.Refresh(time.Now()); err != nil {
+		log.Errorln("could not rebuild leaderboards:", err)
+	}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+	go b.loop()
+}
+
+// Stop rebuilding, waiting for a rebuild in progress to finish. It does
+// nothing if the board wasn't started.
+func (b *Board) Stop() {
+	if b.stop == nil {
+		return
+	}
+	close(b.stop)
+	<-b.done
+}
+
+func (b *Board) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case now := <-ticker.C:
+			if err := b.Refresh(now); err != nil {
+				log.Errorln("could not rebuild leaderboards:", err)
+			}
+		}
+	}
+}
+
+// Refresh rebuilds every leaderboard from the visits
+func (b *Board) Refresh(now time.Time) error {
+	b.rebuilding.Lock()
+	defer b.rebuilding.Unlock()
+	tallies, err := b.tally(now)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.replace(tallies, now)
+}
+
+// replace the entries with ones ranked from the tallies
+func (b *Board) replace(tallies map[string]map[uint]*tally, now time.Time) error {
+	tx := b.db.Begin()
+	if err := tx.Delete(&models.LeaderboardEntry{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, period := range Periods {
+		for _, metric := range Metrics {
+			for _, entry := range rank(tallies[period], metric) {
+				entry.Metric = metric
+				entry.Period = period
+				entry.ComputedAt = now
+				if err := tx.Create(&entry).Error; err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+		}
+	}
+	return tx.Commit().Error
+}
+
+// tally every period in one pass over the visits
+func (b *Board) tally(now time.Time) (map[string]map[uint]*tally, error) {
+	starts := map[string]time.Time{}
+	tallies := map[string]map[uint]*tally{}
+	for _, period := range Periods {
+		starts[period] = periodStart(period, now)
+		tallies[period] = map[uint]*tally{}
+	}
+	rows, err := b.db.Raw(`
+		SELECT visits.user_id, cities.id, cities.state_id, cities.lat,
+			cities.lon, visits.created_at
+		FROM visits
+		JOIN cities ON cities.id = visits.city_id
+		JOIN users ON users.id = visits.user_id
+		WHERE visits.deleted_at IS NULL AND users.deleted_at IS NULL
+			AND COALESCE(NULLIF(users.visibility, ''), 'public') != 'private'
+			AND COALESCE(NULLIF(visits.visibility, ''),
+				NULLIF(users.visibility, ''), 'public') = 'public'
+		ORDER BY visits.user_id, visits.created_at, visits.id
+	`).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID, cityID, stateID uint
+		var p geo.Point
+		var visitedAt time.Time
+		err := rows.Scan(&userID, &cityID, &stateID, &p.Lat, &p.Lon, &visitedAt)
+		if err != nil {
+			return nil, err
+		}
+		for _, period := range Periods {
+			if visitedAt.Before(starts[period]) {
+				continue
+			}
+			t := tallies[period][userID]
+			if t == nil {
+				t = &tally{cities: map[uint]bool{}, states: map[uint]bool{}}
+				tallies[period][userID] = t
+			}
+			t.add(cityID, stateID, p)
+		}
+	}
+	return tallies, rows.Err()
+}
+
+// rank the users by the metric, highest first. Ties share a rank and the
+// next rank is skipped, like 1, 2, 2, 4.
+func rank(tallies map[uint]*tally, metric string) []models.LeaderboardEntry {
+	entries := make([]models.LeaderboardEntry, 0, len(tallies))
+	for userID, t := range tallies {
+		entries = append(entries, models.LeaderboardEntry{
+			UserID: userID, Value: t.value(metric),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries
+}
+
+const entryQuery = `
+	SELECT leaderboard_entries.rank, leaderboard_entries.user_id,
+		COALESCE(users.first_name, ''), COALESCE(users.last_name, ''),
+		leaderboard_entries.value
+	FROM leaderboard_entries
+	JOIN users ON users.id = leaderboard_entries.user_id
+	WHERE leaderboard_entries.metric = ? AND leaderboard_entries.period = ?`
+
+func scanEntries(rows *sql.Rows) ([]Entry, error) {
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		err := rows.Scan(&e.Rank, &e.UserID, &e.FirstName, &e.LastName, &e.Value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Top returns a page of the leaderboard as it was last built, and how many
+// users are on it
+func (b *Board) Top(
+	metric, period string, limit, offset uint,
+) ([]Entry, uint, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	var count uint
+	err := b.db.Model(&models.LeaderboardEntry{}).
+		Where("metric = ? AND period = ?", metric, period).
+		Count(&count).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, err := b.db.Raw(entryQuery+`
+		ORDER BY leaderboard_entries.rank, leaderboard_entries.user_id
+		LIMIT ? OFFSET ?`, metric, period, limit, offset).Rows()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries, err := scanEntries(rows)
+	return entries, count, err
+}
+
+// ComputedAt is when the leaderboards were last built. Ranks only change
+// when it does. It is zero if no one is on any leaderboard.
+func (b *Board) ComputedAt() (time.Time, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	// another server may have rebuilt them since this one did
+	var entry models.LeaderboardEntry
+	q := b.db.Order("computed_at DESC").First(&entry)
+	if q.RecordNotFound() {
+		return time.Time{}, nil
+	}
+	return entry.ComputedAt, q.Error
+}
+
+// Rank returns the user's place on the leaderboard as it was last built,
+// or nil if they aren't on it
+func (b *Board) Rank(metric, period string, userID uint) (*Entry, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	rows, err := b.db.Raw(entryQuery+`
+		AND leaderboard_entries.user_id = ?`, metric, period, userID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries, err := scanEntries(rows)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	return &entries[0], nil
+}
@@ -0,0 +1,196 @@
+package leaderboard_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/bobisme/RestApiProject/cmd"
+	. "github.com/bobisme/RestApiProject/leaderboard"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testDB = "test-leaderboard.db"
+
+var _ = Describe("Leaderboard", func() {
+	var db *gorm.DB
+	var board *Board
+	var now = time.Now()
+
+	createCity := func(name string, stateID uint, lat, lon float64) models.City {
+		city := models.City{Name: name, StateID: stateID, Lat: lat, Lon: lon}
+		Ω(db.Create(&city).Error).ShouldNot(HaveOccurred())
+		return city
+	}
+	createUser := func(name, visibility string) models.User {
+		user := models.User{FirstName: name, Visibility: visibility}
+		Ω(db.Create(&user).Error).ShouldNot(HaveOccurred())
+		return user
+	}
+	visit := func(user models.User, city models.City, daysAgo int, visibility string) {
+		v := models.Visit{
+			UserID: user.ID, CityID: city.ID, Lat: city.Lat, Lon: city.Lon,
+			Visibility: visibility,
+		}
+		Ω(db.Create(&v).Error).ShouldNot(HaveOccurred())
+		// created_at is set on create, so backdate it after
+		err := db.Model(&v).UpdateColumn(
+			"created_at", now.AddDate(0, 0, -daysAgo)).Error
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+	userIDs := func(entries []Entry) []uint {
+		ids := []uint{}
+		for _, e := range entries {
+			ids = append(ids, e.UserID)
+		}
+		return ids
+	}
+
+	var boston, denver, austin models.City
+	var arya, sansa, bran, hound models.User
+
+	BeforeEach(func() {
+		Ω(cmd.CreateDb(testDB, true)).Should(Succeed())
+		var err error
+		db, err = gorm.Open("sqlite3", testDB)
+		Ω(err).ShouldNot(HaveOccurred())
+		// not started, so only explicit refreshes happen
+		board = NewBoard(db, time.Hour)
+
+		boston = createCity("Boston", 1, 42.3601, -71.0589)
+		denver = createCity("Denver", 2, 39.7392, -104.9903)
+		austin = createCity("Austin", 3, 30.2672, -97.7431)
+
+		arya = createUser("Arya", "")
+		sansa = createUser("Sansa", models.VisibilityPublic)
+		bran = createUser("Bran", models.VisibilityFollowers)
+		hound = createUser("Hound", models.VisibilityPrivate)
+
+		// arya has been everywhere, but only recently to two places
+		visit(arya, boston, 400, "")
+		visit(arya, denver, 10, "")
+		visit(arya, austin, 5, "")
+		// sansa's been to the same state twice and one place privately
+		visit(sansa, boston, 20, "")
+		visit(sansa, boston, 3, "")
+		visit(sansa, austin, 3, models.VisibilityPrivate)
+		// bran's only public visit counts
+		visit(bran, denver, 1, models.VisibilityPublic)
+		visit(bran, austin, 1, "")
+		// the hound is private, even with a public visit
+		visit(hound, boston, 1, models.VisibilityPublic)
+		visit(hound, denver, 1, models.VisibilityPublic)
+		Ω(board.Refresh(now)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.Remove(testDB)
+	})
+
+	It("validates metrics and periods", func() {
+		Ω(Valid(MetricStates, PeriodAll)).Should(BeTrue())
+		Ω(Valid(MetricDistance, PeriodLastDays)).Should(BeTrue())
+		Ω(Valid("visits", PeriodAll)).Should(BeFalse())
+		Ω(Valid(MetricCities, "decade")).Should(BeFalse())
+	})
+
+	It("ranks users by distinct states, sharing ties", func() {
+		entries, count, err := board.Top(MetricStates, PeriodAll, 10, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(count).Should(Equal(uint(3)))
+		// ties are in user id order
+		Ω(userIDs(entries)).Should(Equal([]uint{arya.ID, sansa.ID, bran.ID}))
+		Ω(entries[0].Rank).Should(Equal(1))
+		Ω(entries[0].Value).Should(Equal(3.0))
+		Ω(entries[0].FirstName).Should(Equal("Arya"))
+		Ω(entries[1].Rank).Should(Equal(2))
+		Ω(entries[2].Rank).Should(Equal(2))
+	})
+
+	It("only counts visits in the period", func() {
+		entries, _, err := board.Top(MetricCities, PeriodLastDays, 10, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(entries[0].UserID).Should(Equal(arya.ID))
+		Ω(entries[0].Value).Should(Equal(2.0))
+	})
+
+	It("adds up the distance between visits", func() {
+		entries, _, err := board.Top(MetricDistance, PeriodAll, 10, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(userIDs(entries)).Should(Equal([]uint{arya.ID, sansa.ID, bran.ID}))
+		// boston -> denver -> austin
+		Ω(entries[0].Value).Should(BeNumerically("~", 2839+1243, 10))
+		Ω(entries[1].Value).Should(BeZero())
+	})
+
+	It("pages", func() {
+		entries, count, err := board.Top(MetricStates, PeriodAll, 1, 1)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(count).Should(Equal(uint(3)))
+		Ω(userIDs(entries)).Should(Equal([]uint{sansa.ID}))
+	})
+
+	It("finds a user's rank", func() {
+		entry, err := board.Rank(MetricStates, PeriodAll, sansa.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(entry.Rank).Should(Equal(2))
+		entry, err = board.Rank(MetricStates, PeriodAll, hound.ID)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(entry).Should(BeNil())
+	})
+
+	It("isn't rebuilt until it's refreshed", func() {
+		_, count, _ := board.Top(MetricStates, PeriodAll, 10, 0)
+		Ω(count).Should(Equal(uint(3)))
+		hotpie := createUser("Hot Pie", "")
+		visit(hotpie, boston, 0, "")
+		_, count, _ = board.Top(MetricStates, PeriodAll, 10, 0)
+		Ω(count).Should(Equal(uint(3)))
+		Ω(board.Refresh(time.Now())).Should(Succeed())
+		_, count, _ = board.Top(MetricStates, PeriodAll, 10, 0)
+		Ω(count).Should(Equal(uint(4)))
+	})
+
+	It("knows when it was built", func() {
+		built, err := board.ComputedAt()
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(built.Equal(now)).Should(BeTrue())
+		again, _ := board.ComputedAt()
+		Ω(again.Equal(built)).Should(BeTrue())
+		later := built.Add(time.Minute)
+		Ω(board.Refresh(later)).Should(Succeed())
+		again, _ = board.ComputedAt()
+		Ω(again.Equal(later)).Should(BeTrue())
+	})
+
+	It("is built by the time it has started", func() {
+		Ω(db.Delete(&models.LeaderboardEntry{}).Error).ShouldNot(HaveOccurred())
+		started := NewBoard(db, time.Hour)
+		started.Start()
+		defer started.Stop()
+		_, count, err := started.Top(MetricStates, PeriodAll, 10, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(count).Should(Equal(uint(3)))
+	})
+
+	It("rebuilds in the background once it's started", func() {
+		background := NewBoard(db, 10*time.Millisecond)
+		background.Start()
+		defer background.Stop()
+		hotpie := createUser("Hot Pie", "")
+		visit(hotpie, boston, 0, "")
+		Eventually(func() uint {
+			_, count, _ := background.Top(MetricStates, PeriodAll, 10, 0)
+			return count
+		}).Should(Equal(uint(4)))
+	})
+
+	It("can be stopped without being started", func() {
+		board.Stop()
+	})
+})
@@ -2,8 +2,10 @@ package models
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/bobisme/RestApiProject/geo"
 	"github.com/jinzhu/gorm"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -35,6 +37,14 @@ type City struct {
 	LatCos  float64 `json:"-"`
 	LonSin  float64 `json:"-"`
 	LonCos  float64 `json:"-"`
+	Geohash string  `json:"geohash"`
+}
+
+// BeforeSave keeps the geohash in step with the coordinates
+func (c *City) BeforeSave() error {
+	c.Geohash = geo.EncodeGeohash(
+		geo.Point{Lat: c.Lat, Lon: c.Lon}, geo.GeohashPrecision)
+	return nil
 }
 
 // User model
@@ -46,6 +56,34 @@ type User struct {
 	Email        string  `json:"email"`
 	PasswordHash []byte  `json:"-"`
 	Visits       []Visit `json:"visits"`
+	// Visibility is who can see the user's visits by default
+	Visibility string `json:"visibility"`
+	Admin      bool   `json:"-"`
+}
+
+// Visibility settings for users and visits
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityPrivate   = "private"
+)
+
+// ValidVisibility is true if the string is one of the visibility settings
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityFollowers, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// DefaultVisibility for the user's visits. Users without a setting are
+// public.
+func (u *User) DefaultVisibility() string {
+	if u.Visibility == "" {
+		return VisibilityPublic
+	}
+	return u.Visibility
 }
 
 // SetPassword for the user
@@ -82,5 +120,200 @@ type Visit struct {
 	Lon            float64 `json:"lon"`
 	LatSin, LatCos float64 `json:"-"`
 	LonSin, LonCos float64 `json:"-"`
+	Geohash        string  `json:"geohash"`
 	VisitMethod    string  `json:"visitMethod"`
+	// Visibility overrides the user's default visibility if set
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// BeforeCreate sets the geohash from the coordinates the visit was checked
+// in at, or else from the city
+func (v *Visit) BeforeCreate(db *gorm.DB) error {
+	if v.VisitMethod == "coords" {
+		v.Geohash = geo.EncodeGeohash(
+			geo.Point{Lat: v.Lat, Lon: v.Lon}, geo.GeohashPrecision)
+		return nil
+	}
+	city := v.City
+	if city.ID == 0 && v.CityID != 0 {
+		if err := db.Where("id = ?", v.CityID).First(&city).Error; err != nil {
+			return err
+		}
+	}
+	v.Geohash = city.Geohash
+	if v.Geohash == "" && city.ID != 0 {
+		v.Geohash = geo.EncodeGeohash(
+			geo.Point{Lat: city.Lat, Lon: city.Lon}, geo.GeohashPrecision)
+	}
+	return nil
+}
+
+// Follow statuses
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+)
+
+// Follow is a request by one user to follow another. The followee has to
+// accept it before the follower can see anything.
+type Follow struct {
+	Model
+
+	Follower   User   `json:"-"`
+	FollowerID uint   `json:"followerId"`
+	Followee   User   `json:"-"`
+	FolloweeID uint   `json:"followeeId"`
+	Status     string `json:"status"`
+}
+
+// ShareLink gives anyone with the token read-only access to a user's map.
+// Only a hash of the token is stored.
+type ShareLink struct {
+	Model
+
+	User      User   `json:"-"`
+	UserID    uint   `json:"userId"`
+	TokenHash string `json:"-"`
+	// Token is only filled in when the link is created
+	Token string `json:"token,omitempty" sql:"-"`
+}
+
+// FeedItem puts a visit in a follower's feed. Only used when feeds are
+// materialized.
+type FeedItem struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	UserID    uint      `json:"userId"`
+	VisitID   uint      `json:"visitId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Webhook is a url that gets event payloads POSTed to it
+type Webhook struct {
+	Model
+
+	User   User   `json:"-"`
+	UserID uint   `json:"userId"`
+	URL    string `json:"url"`
+	// AllUsers webhooks get every user's events. Only admins can make them.
+	AllUsers   bool     `json:"allUsers"`
+	EventTypes []string `json:"events" sql:"-"`
+	// Events is EventTypes joined with commas for storage
+	Events string `json:"-"`
+	// Secret is the key for signing payloads
+	Secret string `json:"-"`
+}
+
+// BeforeSave joins the event types for storage
+func (w *Webhook) BeforeSave() error {
+	w.Events = strings.Join(w.EventTypes, ",")
+	return nil
+}
+
+// AfterFind splits the stored event types
+func (w *Webhook) AfterFind() error {
+	w.EventTypes = []string{}
+	if w.Events != "" {
+		w.EventTypes = strings.Split(w.Events, ",")
+	}
+	return nil
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event to be sent to a webhook, and how that went
+type WebhookDelivery struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"-"`
+	DeletedAt *time.Time `json:"-" sql:"index"`
+
+	Webhook   Webhook `json:"-"`
+	WebhookID uint    `json:"webhookId"`
+	EventType string  `json:"event"`
+	Payload   string  `json:"payload"`
+	Status    string  `json:"status"`
+	Attempts  int     `json:"attempts"`
+	// NextAttemptAt is when a pending delivery will be tried
+	NextAttemptAt  time.Time  `json:"nextAttemptAt"`
+	LastError      string     `json:"lastError,omitempty"`
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+// UserAchievement records an achievement a user has earned. Once earned it
+// is kept, even if the visits that earned it are deleted.
+type UserAchievement struct {
+	ID             uint      `json:"-" gorm:"primary_key"`
+	UserID         uint      `json:"userId"`
+	AchievementKey string    `json:"key"`
+	EarnedAt       time.Time `json:"earnedAt"`
+	CreatedAt      time.Time `json:"-"`
+}
+
+// LeaderboardEntry is a user's materialized place on a leaderboard
+type LeaderboardEntry struct {
+	ID     uint    `json:"-" gorm:"primary_key"`
+	Metric string  `json:"-"`
+	Period string  `json:"-"`
+	UserID uint    `json:"userId"`
+	Value  float64 `json:"value"`
+	// Rank starts at 1. Tied users share a rank.
+	Rank       int       `json:"rank"`
+	ComputedAt time.Time `json:"-"`
+}
+
+// Trip groups a user's visits into one journey
+type Trip struct {
+	Model
+
+	User        User   `json:"-"`
+	UserID      uint   `json:"userId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// StartDate and EndDate are set by the user, or else are the times of
+	// the first and last visits
+	StartDate *time.Time `json:"startDate"`
+	EndDate   *time.Time `json:"endDate"`
+}
+
+// TripVisit puts a visit in a trip. Position orders the visits.
+type TripVisit struct {
+	ID       uint `json:"-" gorm:"primary_key"`
+	TripID   uint `json:"tripId"`
+	VisitID  uint `json:"visitId"`
+	Position int  `json:"position"`
+}
+
+// WishlistEntry is a city a user wants to visit
+type WishlistEntry struct {
+	Model
+
+	User   User   `json:"-"`
+	UserID uint   `json:"userId"`
+	City   City   `json:"city"`
+	CityID uint   `json:"cityId"`
+	Note   string `json:"note"`
+}
+
+// IdempotencyKey stores the response to a POST request so that a retry with
+// the same Idempotency-Key header gets the same response instead of making
+// the change again
+type IdempotencyKey struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	Key       string `gorm:"column:idempotency_key"`
+	// Scope is the method and url the key was used for, and by whom
+	Scope       string
+	RequestHash string
+	// Status is 0 until the first request with the key is done
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
 }
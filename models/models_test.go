@@ -166,6 +166,28 @@ var _ = Describe("Models", func() {
 		It("loads deleted correctly", func() {
 			Ω(charlotte.Model.DeletedAt).Should(BeNil())
 		})
+
+		It("sets the geohash when saved", func() {
+			Ω(charlotte.Geohash).Should(Equal(""))
+			Ω(db.Save(&charlotte).Error).ShouldNot(HaveOccurred())
+			Ω(charlotte.Geohash).Should(Equal("dnq82kgue"))
+		})
+	})
+
+	Context("Visit", func() {
+		It("takes the geohash of the city", func() {
+			visit := Visit{UserID: 1, CityID: 1}
+			Ω(db.Create(&visit).Error).ShouldNot(HaveOccurred())
+			Ω(visit.Geohash).Should(Equal("dnq82kgue"))
+		})
+
+		It("takes the geohash of the coordinates checked in at", func() {
+			visit := Visit{
+				UserID: 1, CityID: 1, Lat: 35.3, Lon: -80.9, VisitMethod: "coords",
+			}
+			Ω(db.Create(&visit).Error).ShouldNot(HaveOccurred())
+			Ω(visit.Geohash).Should(Equal("dnq2z44mm"))
+		})
 	})
 
 	Context("User", func() {
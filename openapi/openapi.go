@@ -0,0 +1,179 @@
+// Package openapi builds the parts of an OpenAPI 3 document that can be
+// worked out from Go: JSON schemas for the types handlers read and write,
+// and paths from gin routes.
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Version of the OpenAPI spec the documents follow
+const Version = "3.0.3"
+
+// Schema is a JSON schema object
+type Schema map[string]interface{}
+
+// Ref to a schema in the document's components
+func Ref(name string) Schema {
+	return Schema{"$ref": "#/components/schemas/" + name}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schemas makes schemas for Go types the way encoding/json writes them.
+// Named structs are added to the components once and referred to by name.
+type Schemas struct {
+	components map[string]Schema
+	names      map[reflect.Type]string
+}
+
+// NewSchemas with no components yet
+func NewSchemas() *Schemas {
+	return &Schemas{
+		components: map[string]Schema{},
+		names:      map[reflect.Type]string{},
+	}
+}
+
+// Components are the named schemas made so far
+func (s *Schemas) Components() map[string]Schema {
+	return s.components
+}
+
+// Define a component by hand, for shapes that have no Go type
+func (s *Schemas) Define(name string, schema Schema) Schema {
+	s.components[name] = schema
+	return Ref(name)
+}
+
+// Of the value's type
+func (s *Schemas) Of(v interface{}) Schema {
+	return s.of(reflect.TypeOf(v))
+}
+
+func (s *Schemas) of(t reflect.Type) Schema {
+	if t == nil {
+		// a nil interface{} can hold anything
+		return Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.of(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			// nothing can go next to a $ref, so it has to be wrapped
+			return Schema{"allOf": []Schema{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "format": "byte"}
+		}
+		return Schema{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return Schema{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return s.named(t)
+	}
+	return Schema{}
+}
+
+// named adds the struct to the components the first time it's seen. Types
+// from different packages with the same name get the package put in front.
+func (s *Schemas) named(t reflect.Type) Schema {
+	if name, ok := s.names[t]; ok {
+		return Ref(name)
+	}
+	name := t.Name()
+	if _, taken := s.components[name]; taken {
+		pkg := t.PkgPath()
+		name = strings.Title(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	s.names[t] = name
+	// set before filling in so types that refer to themselves work
+	s.components[name] = Schema{}
+	s.components[name] = s.object(t)
+	return Ref(name)
+}
+
+func (s *Schemas) object(t reflect.Type) Schema {
+	properties := map[string]Schema{}
+	required := []string{}
+	s.addFields(t, properties, &required)
+	schema := Schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds the struct's fields as encoding/json would, with embedded
+// structs' fields brought up into the outer object
+func (s *Schemas) addFields(
+	t reflect.Type, properties map[string]Schema, required *[]string,
+) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma+1:]
+		}
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.addFields(ft, properties, required)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := properties[name]; !ok && !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+		properties[name] = s.of(ft)
+	}
+}
+
+// Path turns a gin route path like /user/:userID into the OpenAPI form
+// /user/{userID} and returns the names of its parameters
+func Path(route string) (string, []string) {
+	parts := strings.Split(route, "/")
+	params := []string{}
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			params = append(params, part[1:])
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
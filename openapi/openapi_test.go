@@ -0,0 +1,104 @@
+package openapi_test
+
+import (
+	"time"
+
+	. "github.com/bobisme/RestApiProject/openapi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type base struct {
+	ID      uint      `json:"id"`
+	Created time.Time `json:"-"`
+}
+
+type place struct {
+	Name string `json:"name"`
+}
+
+type Place place
+
+type visit struct {
+	base
+	Place    Place          `json:"place"`
+	Previous *Place         `json:"previous"`
+	Note     string         `json:"note,omitempty"`
+	Tags     []string       `json:"tags"`
+	Extra    map[string]int `json:"extra"`
+	At       time.Time      `json:"at"`
+	Hash     []byte         `json:"hash"`
+	Any      interface{}    `json:"any"`
+	Untagged bool
+	hidden   string
+	Skipped  map[string]string `json:"-"`
+}
+
+var _ = Describe("Schemas", func() {
+	var s *Schemas
+
+	BeforeEach(func() {
+		s = NewSchemas()
+	})
+
+	It("describes basic types", func() {
+		Ω(s.Of("")).Should(Equal(Schema{"type": "string"}))
+		Ω(s.Of(1)).Should(Equal(Schema{"type": "integer"}))
+		Ω(s.Of(uint(1))).Should(Equal(Schema{"type": "integer", "minimum": 0}))
+		Ω(s.Of(1.5)).Should(Equal(Schema{"type": "number"}))
+		Ω(s.Of(true)).Should(Equal(Schema{"type": "boolean"}))
+		Ω(s.Of(time.Time{})).
+			Should(Equal(Schema{"type": "string", "format": "date-time"}))
+		Ω(s.Of([]float64{})).Should(Equal(Schema{
+			"type": "array", "items": Schema{"type": "number"}}))
+	})
+
+	It("describes structs as encoding/json writes them", func() {
+		Ω(s.Of(visit{})).Should(Equal(Ref("visit")))
+		schema := s.Components()["visit"]
+		Ω(schema["type"]).Should(Equal("object"))
+		properties := schema["properties"].(map[string]Schema)
+		Ω(properties).Should(HaveLen(10))
+		Ω(properties).Should(HaveKey("id"))
+		Ω(properties).Should(HaveKey("Untagged"))
+		Ω(properties).ShouldNot(HaveKey("hidden"))
+		Ω(properties).ShouldNot(HaveKey("Skipped"))
+		Ω(properties["place"]).Should(Equal(Ref("Place")))
+		Ω(properties["previous"]).Should(Equal(Schema{
+			"allOf": []Schema{Ref("Place")}, "nullable": true}))
+		Ω(properties["hash"]).Should(Equal(Schema{"type": "string", "format": "byte"}))
+		Ω(properties["any"]).Should(Equal(Schema{}))
+		Ω(properties["extra"]).Should(Equal(Schema{
+			"type": "object", "additionalProperties": Schema{"type": "integer"}}))
+		Ω(schema["required"]).ShouldNot(ContainElement("note"))
+		Ω(schema["required"]).Should(ContainElement("tags"))
+	})
+
+	It("adds named structs to the components once", func() {
+		s.Of(visit{})
+		s.Of([]Place{})
+		Ω(s.Components()).Should(HaveLen(2))
+		Ω(s.Components()).Should(HaveKey("visit"))
+		Ω(s.Components()["Place"]["properties"]).Should(HaveKey("name"))
+	})
+
+	It("can have hand made components", func() {
+		Ω(s.Define("Error", Schema{"type": "object"})).Should(Equal(Ref("Error")))
+		Ω(s.Components()).Should(HaveKey("Error"))
+	})
+})
+
+var _ = Describe("Path", func() {
+	It("turns gin params into OpenAPI params", func() {
+		path, params := Path("/user/:userID/visits/:visitID")
+		Ω(path).Should(Equal("/user/{userID}/visits/{visitID}"))
+		Ω(params).Should(Equal([]string{"userID", "visitID"}))
+		path, params = Path("/files/*name")
+		Ω(path).Should(Equal("/files/{name}"))
+		Ω(params).Should(Equal([]string{"name"}))
+		path, params = Path("/")
+		Ω(path).Should(Equal("/"))
+		Ω(params).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,135 @@
+// Package ratelimit decides whether requests can go ahead using token
+// buckets. Each key has a bucket that holds up to a burst of tokens and
+// refills at a steady rate, and every request takes a token.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is how fast requests can be made
+type Limit struct {
+	// Rate is how many tokens are added each second
+	Rate float64
+	// Burst is how many tokens the bucket holds, which is how many requests
+	// can be made at once
+	Burst int
+}
+
+// Result of taking a token
+type Result struct {
+	Allowed bool
+	// Remaining is how many whole tokens are left
+	Remaining int
+	// RetryAfter is how long until there's a token, if it wasn't allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter keeps a bucket for each key. Memory keeps them in one server, so
+// a limiter that keeps them somewhere shared is needed to limit clients
+// across several.
+type Limiter interface {
+	// Take a token from the key's bucket if there is one
+	Take(key string, limit Limit) (Result, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// refill the bucket for the time since it was last updated
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.updated = now
+	}
+}
+
+func (b *bucket) full() bool {
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// wait is how long until the bucket has the tokens
+func (b *bucket) wait(tokens float64) time.Duration {
+	if b.tokens >= tokens {
+		return 0
+	}
+	return time.Duration((tokens - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// Memory is a Limiter in this process
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// buckets are swept when there are this many
+	sweepAt int
+}
+
+// how many buckets there have to be before full ones are dropped
+const minSweep = 1024
+
+// NewMemory with no buckets
+func NewMemory() *Memory {
+	return &Memory{buckets: map[string]*bucket{}, sweepAt: minSweep}
+}
+
+// Take a token from the key's bucket
+func (m *Memory) Take(key string, limit Limit) (Result, error) {
+	return m.TakeAt(key, limit, time.Now()), nil
+}
+
+// TakeAt is Take at the given time
+func (m *Memory) TakeAt(key string, limit Limit, now time.Time) Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[key]
+	if !ok {
+		if len(m.buckets) >= m.sweepAt {
+			m.sweep(now)
+		}
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+	b.refill(now)
+
+	var result Result
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = b.wait(1)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = b.wait(float64(limit.Burst))
+	return result
+}
+
+// Len is how many buckets are kept
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buckets)
+}
+
+// sweep drops the buckets that have filled up again, since a full bucket
+// is the same as a new one
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		b.refill(now)
+		if b.full() {
+			delete(m.buckets, key)
+		}
+	}
+	// don't sweep again until it's worth it
+	m.sweepAt = 2 * len(m.buckets)
+	if m.sweepAt < minSweep {
+		m.sweepAt = minSweep
+	}
+}
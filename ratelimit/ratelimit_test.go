@@ -0,0 +1,74 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/bobisme/RestApiProject/ratelimit"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory", func() {
+	var (
+		m     *Memory
+		start = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+		// a token every half second, up to 3 at once
+		limit = Limit{Rate: 2, Burst: 3}
+	)
+
+	BeforeEach(func() {
+		m = NewMemory()
+	})
+
+	It("allows a burst then turns requests away", func() {
+		for i := 2; i >= 0; i-- {
+			result := m.TakeAt("arya", limit, start)
+			Ω(result.Allowed).Should(BeTrue())
+			Ω(result.Remaining).Should(Equal(i))
+		}
+		result := m.TakeAt("arya", limit, start)
+		Ω(result.Allowed).Should(BeFalse())
+		Ω(result.Remaining).Should(Equal(0))
+		Ω(result.RetryAfter).Should(Equal(500 * time.Millisecond))
+		Ω(result.Reset).Should(Equal(1500 * time.Millisecond))
+	})
+
+	It("refills at the rate", func() {
+		for i := 0; i < 3; i++ {
+			m.TakeAt("arya", limit, start)
+		}
+		later := start.Add(250 * time.Millisecond)
+		result := m.TakeAt("arya", limit, later)
+		Ω(result.Allowed).Should(BeFalse())
+		Ω(result.RetryAfter).Should(Equal(250 * time.Millisecond))
+
+		later = start.Add(500 * time.Millisecond)
+		Ω(m.TakeAt("arya", limit, later).Allowed).Should(BeTrue())
+		Ω(m.TakeAt("arya", limit, later).Allowed).Should(BeFalse())
+	})
+
+	It("doesn't fill past the burst", func() {
+		m.TakeAt("arya", limit, start)
+		result := m.TakeAt("arya", limit, start.Add(time.Hour))
+		Ω(result.Allowed).Should(BeTrue())
+		Ω(result.Remaining).Should(Equal(2))
+	})
+
+	It("keeps a bucket for each key", func() {
+		for i := 0; i < 3; i++ {
+			m.TakeAt("arya", limit, start)
+		}
+		Ω(m.TakeAt("arya", limit, start).Allowed).Should(BeFalse())
+		Ω(m.TakeAt("sansa", limit, start).Allowed).Should(BeTrue())
+	})
+
+	It("drops buckets that have filled up", func() {
+		for i := 0; i < 1024; i++ {
+			m.TakeAt(fmt.Sprint(i), limit, start)
+		}
+		Ω(m.Len()).Should(Equal(1024))
+		m.TakeAt("arya", limit, start.Add(time.Minute))
+		Ω(m.Len()).Should(Equal(1))
+	})
+})
@@ -0,0 +1,146 @@
+// Package stream is an in-process pub/sub hub for pushing events to
+// connected clients. Every subscriber gets a bounded buffer and is evicted
+// if it falls behind, so a slow client can never hold up a publisher.
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	VisitCreated = "visit.created"
+	VisitUpdated = "visit.updated"
+	VisitDeleted = "visit.deleted"
+	UserUpdated  = "user.updated"
+)
+
+var (
+	// ErrEvicted is the reason given to subscribers that fell behind
+	ErrEvicted = errors.New("subscriber fell too far behind")
+	// ErrClosed is the reason given to subscribers when the hub closes
+	ErrClosed = errors.New("hub closed")
+)
+
+// Event is something that happened to a user's data
+type Event struct {
+	Type   string      `json:"type"`
+	UserID uint        `json:"userId"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+	// Visibility of the data, for subscribers to filter on
+	Visibility string `json:"-"`
+}
+
+// Subscriber receives events from a hub
+type Subscriber struct {
+	events chan Event
+	filter func(Event) bool
+	err    error
+}
+
+// Events is closed when the subscriber is unsubscribed, evicted or the hub
+// closes. Err gives the reason after that.
+func (s *Subscriber) Events() <-chan Event {
+	return s.events
+}
+
+// Err is why the events channel was closed, nil if it is still open or the
+// subscriber unsubscribed itself
+func (s *Subscriber) Err() error {
+	return s.err
+}
+
+// Hub sends published events to subscribers
+type Hub struct {
+	sync.Mutex
+	bufferSize  int
+	subscribers map[*Subscriber]bool
+	closed      bool
+}
+
+// NewHub returns a hub that buffers up to bufferSize events per subscriber
+func NewHub(bufferSize int) *Hub {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	return &Hub{
+		bufferSize:  bufferSize,
+		subscribers: map[*Subscriber]bool{},
+	}
+}
+
+// Subscribe to events that the filter returns true for. A nil filter gets
+// everything.
+func (h *Hub) Subscribe(filter func(Event) bool) *Subscriber {
+	s := &Subscriber{
+		events: make(chan Event, h.bufferSize),
+		filter: filter,
+	}
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		s.err = ErrClosed
+		close(s.events)
+		return s
+	}
+	h.subscribers[s] = true
+	return s
+}
+
+// Unsubscribe stops sending events to the subscriber. It is safe to call
+// more than once.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.Lock()
+	defer h.Unlock()
+	h.remove(s, nil)
+}
+
+// must hold the lock
+func (h *Hub) remove(s *Subscriber, err error) {
+	if !h.subscribers[s] {
+		return
+	}
+	delete(h.subscribers, s)
+	s.err = err
+	close(s.events)
+}
+
+// Publish the event to every interested subscriber without blocking.
+// Subscribers with full buffers are evicted.
+func (h *Hub) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.Lock()
+	defer h.Unlock()
+	for s := range h.subscribers {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			h.remove(s, ErrEvicted)
+		}
+	}
+}
+
+// Len is the number of subscribers
+func (h *Hub) Len() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.subscribers)
+}
+
+// Close the hub, ending every subscription
+func (h *Hub) Close() {
+	h.Lock()
+	defer h.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		h.remove(s, ErrClosed)
+	}
+}
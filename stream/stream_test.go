@@ -0,0 +1,92 @@
+package stream_test
+
+import (
+	"sync"
+
+	. "github.com/bobisme/RestApiProject/stream"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hub", func() {
+	var hub *Hub
+
+	BeforeEach(func() {
+		hub = NewHub(2)
+	})
+
+	It("sends events to subscribers", func() {
+		a := hub.Subscribe(nil)
+		b := hub.Subscribe(nil)
+		hub.Publish(Event{Type: VisitCreated, UserID: 1})
+		Ω((<-a.Events()).Type).Should(Equal(VisitCreated))
+		e := <-b.Events()
+		Ω(e.UserID).Should(Equal(uint(1)))
+		Ω(e.Time).ShouldNot(BeZero())
+	})
+
+	It("filters events", func() {
+		s := hub.Subscribe(func(e Event) bool { return e.UserID == 2 })
+		hub.Publish(Event{Type: VisitCreated, UserID: 1})
+		hub.Publish(Event{Type: VisitDeleted, UserID: 2})
+		Ω((<-s.Events()).Type).Should(Equal(VisitDeleted))
+		Ω(s.Events()).ShouldNot(Receive())
+	})
+
+	It("evicts slow subscribers", func() {
+		slow := hub.Subscribe(nil)
+		fast := hub.Subscribe(nil)
+		for i := 0; i < 3; i++ {
+			hub.Publish(Event{Type: VisitCreated, UserID: uint(i)})
+			<-fast.Events()
+		}
+		Ω(hub.Len()).Should(Equal(1))
+		// the buffered events are still delivered before the channel closes
+		Ω(slow.Events()).Should(Receive())
+		Ω(slow.Events()).Should(Receive())
+		Ω(slow.Events()).Should(BeClosed())
+		Ω(slow.Err()).Should(Equal(ErrEvicted))
+		Ω(fast.Err()).Should(BeNil())
+	})
+
+	It("unsubscribes", func() {
+		s := hub.Subscribe(nil)
+		hub.Unsubscribe(s)
+		hub.Unsubscribe(s)
+		Ω(hub.Len()).Should(BeZero())
+		Ω(s.Events()).Should(BeClosed())
+		Ω(s.Err()).Should(BeNil())
+		hub.Publish(Event{Type: VisitCreated})
+	})
+
+	It("closes every subscription", func() {
+		a := hub.Subscribe(nil)
+		hub.Close()
+		Ω(a.Events()).Should(BeClosed())
+		Ω(a.Err()).Should(Equal(ErrClosed))
+		b := hub.Subscribe(nil)
+		Ω(b.Events()).Should(BeClosed())
+		Ω(b.Err()).Should(Equal(ErrClosed))
+		hub.Publish(Event{Type: VisitCreated})
+	})
+
+	It("is safe to use concurrently", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				s := hub.Subscribe(nil)
+				for range s.Events() {
+				}
+			}()
+			go func(i int) {
+				defer wg.Done()
+				hub.Publish(Event{Type: VisitCreated, UserID: uint(i)})
+			}(i)
+		}
+		hub.Close()
+		wg.Wait()
+	})
+})
@@ -0,0 +1,282 @@
+// Package validate checks request structs against rules in their `validate`
+// struct tags, like
+//
+//	City  string `json:"city" validate:"required,max=100"`
+//	State string `json:"state" validate:"required,state"`
+//
+// Rules are separated by commas. Fields are named by their json tag in
+// errors. Apart from required, rules only check fields that are set, so
+// optional fields can be left out.
+//
+//	required  must be set: not blank, zero, nil or empty
+//	min=N     at least N, or N characters or items long
+//	max=N     at most N, or N characters or items long
+//	len=N     exactly N characters or items long
+//	oneof=a b one of the values given, separated by spaces
+//	state     a two letter state abbreviation, like NC
+//	lat       a latitude, from -90 to 90
+//	lon       a longitude, from -180 to 180
+//	email     an email address
+//	url       an absolute http or https url
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError is a field that broke a rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors are every rule a request broke, in the order of its fields
+type Errors []FieldError
+
+func (errs Errors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator is for checks tags can't express, like one field depending on
+// another. Struct calls Validate once the tags pass.
+type Validator interface {
+	Validate() Errors
+}
+
+// Struct checks the fields of the struct, or pointer to one, against their
+// tags. Returns nil if they all pass.
+func Struct(v interface{}) Errors {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic("validate: Struct needs a struct, not " + rv.Kind().String())
+	}
+	errs := checkFields(rv)
+	if len(errs) == 0 {
+		if validator, ok := v.(Validator); ok {
+			errs = validator.Validate()
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func checkFields(rv reflect.Value) Errors {
+	var errs Errors
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			errs = append(errs, checkFields(rv.Field(i))...)
+			continue
+		}
+		rules := f.Tag.Get("validate")
+		if rules == "" || f.PkgPath != "" {
+			continue
+		}
+		errs = append(errs, check(fieldName(f), rv.Field(i), rules)...)
+	}
+	return errs
+}
+
+// fieldName is the name the field has in JSON
+func fieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// Var checks one value against the rules, naming it field in the errors.
+// Returns nil if it passes.
+func Var(field string, v interface{}, rules string) Errors {
+	errs := check(field, reflect.ValueOf(v), rules)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Int parses a whole number, like one from a URL, and checks it against the
+// rules
+func Int(field, s, rules string) (int, Errors) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, Errors{{field, "int", field + " must be a whole number"}}
+	}
+	return n, Var(field, n, rules)
+}
+
+// Float parses a number, like one from a URL, and checks it against the
+// rules
+func Float(field, s, rules string) (float64, Errors) {
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, Errors{{field, "number", field + " must be a number"}}
+	}
+	return x, Var(field, x, rules)
+}
+
+// ID parses a database id, which is a whole number from 1
+func ID(field, s string) (uint, Errors) {
+	n, errs := Int(field, s, "required,min=1")
+	if errs != nil {
+		return 0, Errors{{field, "id", field + " must be a whole number from 1"}}
+	}
+	return uint(n), nil
+}
+
+// check the value against each rule, stopping at the first it breaks
+func check(field string, v reflect.Value, rules string) Errors {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg := rule, ""
+		if eq := strings.Index(rule, "="); eq >= 0 {
+			name, arg = rule[:eq], rule[eq+1:]
+		}
+		if name == "required" {
+			if isBlank(v) {
+				return Errors{{field, name, field + " is required"}}
+			}
+			continue
+		}
+		if isZero(v) {
+			// optional and not set. Blank strings are still checked, so
+			// they don't get past rules like oneof.
+			return nil
+		}
+		if message := breaks(v, name, arg); message != "" {
+			return Errors{{field, name, field + " must be " + message}}
+		}
+	}
+	return nil
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+// isBlank is isZero, but counts strings of only spaces as not set too
+func isBlank(v reflect.Value) bool {
+	if v.Kind() == reflect.String {
+		return strings.TrimSpace(v.String()) == ""
+	}
+	return isZero(v)
+}
+
+// size is how big the value is for min, max and len: the number itself,
+// or how many characters or items it has
+func size(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	panic("validate: can't size a " + v.Kind().String())
+}
+
+func float(v reflect.Value) float64 {
+	n, _ := size(v)
+	return n
+}
+
+func mustNumber(rule, arg string) float64 {
+	n, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: %s needs a number, not %q", rule, arg))
+	}
+	return n
+}
+
+// breaks returns how the value should have been if it breaks the rule, or
+// "" if it doesn't
+func breaks(v reflect.Value, rule, arg string) string {
+	switch rule {
+	case "min":
+		if n, unit := size(v); n < mustNumber(rule, arg) {
+			return "at least " + arg + unit
+		}
+	case "max":
+		if n, unit := size(v); n > mustNumber(rule, arg) {
+			return "at most " + arg + unit
+		}
+	case "len":
+		if n, unit := size(v); n != mustNumber(rule, arg) {
+			return arg + unit + " long"
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		s := fmt.Sprint(v.Interface())
+		for _, option := range options {
+			if s == option {
+				return ""
+			}
+		}
+		return "one of " + strings.Join(options, ", ")
+	case "state":
+		s := v.String()
+		if len(s) != 2 || s[0] < 'A' || s[0] > 'Z' || s[1] < 'A' || s[1] > 'Z' {
+			return "a two letter state abbreviation, like NC"
+		}
+	case "lat":
+		if n := float(v); n < -90 || n > 90 {
+			return "a latitude from -90 to 90"
+		}
+	case "lon":
+		if n := float(v); n < -180 || n > 180 {
+			return "a longitude from -180 to 180"
+		}
+	case "email":
+		a, err := mail.ParseAddress(v.String())
+		if err != nil || a.Address != v.String() {
+			return "an email address"
+		}
+	case "url":
+		u, err := url.Parse(v.String())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "an absolute http or https url"
+		}
+	default:
+		panic("validate: unknown rule " + rule)
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package validate_test
+
+import (
+	"time"
+
+	. "github.com/bobisme/RestApiProject/validate"
+	. "github.com/onsi/ginkgo/extensions/table"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type base struct {
+	Note string `json:"note" validate:"max=5"`
+}
+
+type request struct {
+	base
+	City    string     `json:"city" validate:"required,max=10"`
+	State   string     `json:"state" validate:"required,state"`
+	Kind    string     `json:"kind,omitempty" validate:"oneof=a b"`
+	Lat     float64    `json:"lat" validate:"lat"`
+	Lon     *float64   `json:"lon" validate:"required,lon"`
+	IDs     []uint     `json:"ids" validate:"max=2"`
+	Email   string     `json:"email" validate:"email"`
+	URL     string     `json:"url" validate:"url"`
+	Code    string     `json:"code" validate:"len=3"`
+	Count   int        `json:"count" validate:"min=1,max=10"`
+	When    *time.Time `json:"when"`
+	Untaged string     `validate:"max=1"`
+	ignored string
+}
+
+// ordered rejects requests that end before they start
+type ordered struct {
+	Start int `json:"start" validate:"required"`
+	End   int `json:"end"`
+}
+
+func (o *ordered) Validate() Errors {
+	if o.End < o.Start {
+		return Errors{{"end", "order", "end must be after start"}}
+	}
+	return nil
+}
+
+func valid() *request {
+	lon := -80.8
+	return &request{City: "Charlotte", State: "NC", Lon: &lon}
+}
+
+var _ = Describe("Struct", func() {
+	It("passes valid structs", func() {
+		Ω(Struct(valid())).Should(BeNil())
+		r := valid()
+		r.Kind, r.Lat, r.IDs, r.Email = "b", -45, []uint{1, 2}, "arya@winterfell.net"
+		r.URL, r.Code, r.Count = "https://example.com/hook", "abc", 10
+		r.Note = "héllo"
+		Ω(Struct(r)).Should(BeNil())
+	})
+
+	It("names fields by their json tag", func() {
+		errs := Struct(&request{})
+		Ω(errs).Should(Equal(Errors{
+			{"city", "required", "city is required"},
+			{"state", "required", "state is required"},
+			{"lon", "required", "lon is required"},
+		}))
+		Ω(errs.Error()).Should(Equal(
+			"city is required; state is required; lon is required"))
+	})
+
+	It("treats blank strings as missing", func() {
+		r := valid()
+		r.City = "  "
+		Ω(Struct(r)).Should(Equal(Errors{{"city", "required", "city is required"}}))
+	})
+
+	It("checks blank strings in optional fields", func() {
+		r := valid()
+		r.Kind = " "
+		Ω(Struct(r)).Should(Equal(Errors{{"kind", "oneof", "kind must be one of a, b"}}))
+	})
+
+	DescribeTable("checks rules",
+		func(change func(r *request), field, rule, message string) {
+			r := valid()
+			change(r)
+			Ω(Struct(r)).Should(Equal(Errors{{field, rule, message}}))
+		},
+		Entry("max length", func(r *request) { r.City = "Kings Landing" },
+			"city", "max", "city must be at most 10 characters"),
+		Entry("embedded fields", func(r *request) { r.Note = "too long" },
+			"note", "max", "note must be at most 5 characters"),
+		Entry("state", func(r *request) { r.State = "nc" },
+			"state", "state", "state must be a two letter state abbreviation, like NC"),
+		Entry("state length", func(r *request) { r.State = "NCA" },
+			"state", "state", "state must be a two letter state abbreviation, like NC"),
+		Entry("oneof", func(r *request) { r.Kind = "c" },
+			"kind", "oneof", "kind must be one of a, b"),
+		Entry("lat", func(r *request) { r.Lat = 90.5 },
+			"lat", "lat", "lat must be a latitude from -90 to 90"),
+		Entry("lon", func(r *request) { lon := -181.0; r.Lon = &lon },
+			"lon", "lon", "lon must be a longitude from -180 to 180"),
+		Entry("items", func(r *request) { r.IDs = []uint{1, 2, 3} },
+			"ids", "max", "ids must be at most 2 items"),
+		Entry("email", func(r *request) { r.Email = "Arya <arya@winterfell.net>" },
+			"email", "email", "email must be an email address"),
+		Entry("url", func(r *request) { r.URL = "ftp://example.com" },
+			"url", "url", "url must be an absolute http or https url"),
+		Entry("len", func(r *request) { r.Code = "ab" },
+			"code", "len", "code must be 3 characters long"),
+		Entry("min", func(r *request) { r.Count = -1 },
+			"count", "min", "count must be at least 1"),
+		Entry("max", func(r *request) { r.Count = 11 },
+			"count", "max", "count must be at most 10"),
+		Entry("untagged", func(r *request) { r.Untaged = "ab" },
+			"Untaged", "max", "Untaged must be at most 1 characters"),
+	)
+
+	It("lists every field that's wrong", func() {
+		r := valid()
+		r.State, r.Count = "X", 20
+		Ω(Struct(r)).Should(HaveLen(2))
+	})
+
+	It("runs custom checks once the tags pass", func() {
+		Ω(Struct(&ordered{Start: 2, End: 3})).Should(BeNil())
+		Ω(Struct(&ordered{Start: 2, End: 1})).Should(Equal(
+			Errors{{"end", "order", "end must be after start"}}))
+		Ω(Struct(&ordered{End: 1})).Should(Equal(
+			Errors{{"start", "required", "start is required"}}))
+	})
+
+	It("panics on rules it doesn't know", func() {
+		bad := struct {
+			X string `validate:"shiny"`
+		}{"x"}
+		Ω(func() { Struct(&bad) }).Should(Panic())
+	})
+})
+
+var _ = Describe("Int, Float and ID", func() {
+	It("parses numbers", func() {
+		n, errs := Int("zoom", "3", "min=0,max=24")
+		Ω(errs).Should(BeNil())
+		Ω(n).Should(Equal(3))
+		_, errs = Int("zoom", "30", "min=0,max=24")
+		Ω(errs).Should(Equal(Errors{{"zoom", "max", "zoom must be at most 24"}}))
+		_, errs = Int("zoom", "x", "")
+		Ω(errs).Should(Equal(Errors{{"zoom", "int", "zoom must be a whole number"}}))
+	})
+
+	It("parses decimals", func() {
+		x, errs := Float("lat", "35.5", "lat")
+		Ω(errs).Should(BeNil())
+		Ω(x).Should(Equal(35.5))
+		_, errs = Float("radiusKm", "1001", "max=1000")
+		Ω(errs).Should(Equal(Errors{{"radiusKm", "max", "radiusKm must be at most 1000"}}))
+		_, errs = Float("lat", "north", "lat")
+		Ω(errs).Should(Equal(Errors{{"lat", "number", "lat must be a number"}}))
+	})
+
+	It("parses ids", func() {
+		id, errs := ID("userID", "12")
+		Ω(errs).Should(BeNil())
+		Ω(id).Should(Equal(uint(12)))
+		for _, s := range []string{"0", "-1", "one", ""} {
+			_, errs = ID("userID", s)
+			Ω(errs).Should(Equal(Errors{
+				{"userID", "id", "userID must be a whole number from 1"}}))
+		}
+	})
+
+	It("checks single values", func() {
+		Ω(Var("lat", 12.5, "lat")).Should(BeNil())
+		Ω(Var("lat", 100.0, "lat")).Should(HaveLen(1))
+		Ω(Var("email", "", "required,email")).Should(Equal(
+			Errors{{"email", "required", "email is required"}}))
+	})
+})
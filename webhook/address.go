@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ranges webhooks can't be sent to, since the server would be making
+// requests from inside the network for whoever registered the webhook
+var privateNets = parseCIDRs(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, including cloud metadata services
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved and broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// PublicIP is false for loopback, link-local, private and other addresses
+// that aren't on the internet
+func PublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// how long CheckURL waits for the host to resolve
+const lookupTimeout = 2 * time.Second
+
+// CheckURL returns an error if the webhook URL's host is, or resolves to,
+// an address that isn't public. Hosts that can't be resolved right now are
+// allowed, since deliveries check the address again when they connect.
+func CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return fmt.Errorf("%s is not a public address", ip)
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return fmt.Errorf("%s resolves to %s, which is not a public address",
+				host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// newClient for deliveries. Every connection's address is checked as it's
+// made, after the name is resolved, so a name that resolved to a public
+// address when the webhook was registered can't be pointed inside the
+// network later. Redirects go through the same dialer. allowPrivate is
+// called each time to see if the check is off.
+func newClient(allowPrivate func() bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if allowPrivate() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		// no proxy, since it would be the proxy's address that's checked
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 4,
+		},
+	}
+}
@@ -0,0 +1,370 @@
+// Package webhook queues events for registered webhooks and delivers them in
+// the background. Deliveries are kept in the database so nothing is lost if
+// the server restarts, and failures are retried with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	"github.com/jinzhu/gorm"
+)
+
+// Headers sent with every delivery
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader is when the delivery was sent, in unix seconds. It's
+	// covered by the signature.
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+// SignatureTolerance is how far a delivery's timestamp may be from the
+// receiver's clock. Receivers should reject deliveries outside of it, so a
+// captured delivery can't be replayed later, and can remember the delivery
+// ids they've seen within it to catch replays inside the window. Retries are
+// signed again when they're sent, so they're never stale.
+const SignatureTolerance = 5 * time.Minute
+
+// Errors from Verify
+var (
+	ErrBadSignature = errors.New("webhook signature doesn't match")
+	ErrBadTimestamp = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// Sign returns the signature header value for a payload sent at the given
+// unix time: the hex encoded HMAC-SHA256 of the timestamp, a dot and the
+// body, prefixed with "sha256="
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify the signature and timestamp headers of a delivery received at now.
+// It's what receivers are expected to do, written in Go.
+func Verify(secret, signature, timestamp string, payload []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrBadTimestamp
+	}
+	expected := Sign(secret, ts, payload)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrBadSignature
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrBadTimestamp
+	}
+	return nil
+}
+
+// Enqueue a delivery of the event for every webhook that wants it
+func Enqueue(db *gorm.DB, e stream.Event) error {
+	var hooks []models.Webhook
+	err := db.Where(
+		"(user_id = ? OR all_users = ?) AND ',' || events || ',' LIKE ?",
+		e.UserID, true, "%,"+e.Type+",%").Find(&hooks).Error
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, hook := range hooks {
+		delivery := models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventType:     e.Type,
+			Payload:       string(payload),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+		}
+		if err := db.Create(&delivery).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// job is a claimed delivery on its way to a worker
+type job struct {
+	delivery models.WebhookDelivery
+	url      string
+	secret   string
+}
+
+// result of trying to send a job
+type result struct {
+	delivery models.WebhookDelivery
+	status   int
+	err      error
+	// cancelled sends don't count as an attempt
+	cancelled bool
+}
+
+// Dispatcher sends pending deliveries with a pool of workers. Only the
+// dispatcher's own loop touches the database, the workers just do http.
+type Dispatcher struct {
+	db          *gorm.DB
+	workers     int
+	maxAttempts int
+	client      *http.Client
+
+	// AllowPrivateAddresses lets deliveries go to loopback and private
+	// addresses, for testing. Set it before calling Start.
+	AllowPrivateAddresses bool
+	// PollInterval is how often the database is checked for due deliveries
+	PollInterval time.Duration
+	// BaseDelay is the wait before the first retry. It doubles each time,
+	// up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// StaleAfter is how long a delivery can be left sending before it's
+	// taken to belong to a server that went away, and is sent again. It
+	// should be longer than a send can take.
+	StaleAfter time.Duration
+
+	jobs    chan job
+	results chan result
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewDispatcher with the number of workers and attempts before giving up
+func NewDispatcher(db *gorm.DB, workers, maxAttempts int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	d := &Dispatcher{
+		db:           db,
+		workers:      workers,
+		maxAttempts:  maxAttempts,
+		PollInterval: time.Second,
+		BaseDelay:    10 * time.Second,
+		MaxDelay:     time.Hour,
+		StaleAfter:   time.Minute,
+	}
+	d.client = newClient(func() bool { return d.AllowPrivateAddresses })
+	return d
+}
+
+// Backoff is how long to wait after the given number of failed attempts
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempts && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	return delay
+}
+
+// Start the workers. Deliveries left sending by a crash are retried once
+// they're stale, since other servers may still be sending the newer ones.
+func (d *Dispatcher) Start() error {
+	if err := d.release(); err != nil {
+		return err
+	}
+	d.jobs = make(chan job, d.workers)
+	d.results = make(chan result, d.workers)
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.done = make(chan struct{})
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	go d.loop()
+	return nil
+}
+
+// Stop sending, cancelling any requests in progress, and wait for the
+// workers to finish
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	<-d.done
+}
+
+func (d *Dispatcher) loop() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	inFlight := 0
+	for {
+		select {
+		case <-d.ctx.Done():
+			close(d.jobs)
+			for ; inFlight > 0; inFlight-- {
+				d.record(<-d.results)
+			}
+			d.wg.Wait()
+			return
+		case res := <-d.results:
+			inFlight--
+			d.record(res)
+		case <-ticker.C:
+			inFlight += d.claim(d.workers - inFlight)
+		}
+	}
+}
+
+// release deliveries that have been sending for longer than StaleAfter
+func (d *Dispatcher) release() error {
+	return d.db.Model(&models.WebhookDelivery{}).
+		Where("status = ? AND updated_at < ?",
+			models.DeliverySending, time.Now().Add(-d.StaleAfter)).
+		Update("status", models.DeliveryPending).Error
+}
+
+// claim up to n due deliveries and hand them to the workers
+func (d *Dispatcher) claim(n int) int {
+	if n < 1 {
+		return 0
+	}
+	if err := d.release(); err != nil {
+		log.Errorln("could not release stale webhook deliveries:", err)
+	}
+	var due []models.WebhookDelivery
+	err := d.db.Where("status = ? AND next_attempt_at <= ?",
+		models.DeliveryPending, time.Now()).
+		Order("next_attempt_at").Limit(n).Find(&due).Error
+	if err != nil {
+		log.Errorln("could not look up webhook deliveries:", err)
+		return 0
+	}
+	claimed := 0
+	for _, delivery := range due {
+		var hook models.Webhook
+		q := d.db.Where("id = ?", delivery.WebhookID).First(&hook)
+		if q.RecordNotFound() {
+			// the webhook was removed, so there's nowhere to send it
+			d.take(delivery, map[string]interface{}{
+				"status": models.DeliveryFailed, "last_error": "webhook removed",
+			})
+			continue
+		} else if q.Error != nil {
+			log.Errorln("could not look up webhook:", q.Error)
+			continue
+		}
+		if !d.take(delivery, map[string]interface{}{"status": models.DeliverySending}) {
+			continue
+		}
+		delivery.Status = models.DeliverySending
+		// the jobs channel holds as many jobs as there are workers, and
+		// never more than that are in flight, so this doesn't block
+		d.jobs <- job{delivery, hook.URL, hook.Secret}
+		claimed++
+	}
+	return claimed
+}
+
+// take a pending delivery by updating it only if it's still pending, so when
+// more than one server is dispatching only one of them gets it
+func (d *Dispatcher) take(delivery models.WebhookDelivery, fields map[string]interface{}) bool {
+	q := d.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ?", delivery.ID, models.DeliveryPending).
+		Updates(fields)
+	if q.Error != nil {
+		log.Errorln("could not claim webhook delivery:", q.Error)
+		return false
+	}
+	return q.RowsAffected == 1
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for j := range d.jobs {
+		d.results <- d.send(j)
+	}
+}
+
+func (d *Dispatcher) send(j job) result {
+	res := result{delivery: j.delivery}
+	if d.ctx.Err() != nil {
+		res.cancelled = true
+		return res
+	}
+	payload := []byte(j.delivery.Payload)
+	req, err := http.NewRequest("POST", j.url, bytes.NewReader(payload))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	req = req.WithContext(d.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, j.delivery.EventType)
+	req.Header.Set(DeliveryHeader, fmt.Sprint(j.delivery.ID))
+	now := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now, 10))
+	req.Header.Set(SignatureHeader, Sign(j.secret, now, payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		res.err = err
+		res.cancelled = d.ctx.Err() != nil
+		return res
+	}
+	// drain so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	res.status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		res.err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return res
+}
+
+// record the result of a send
+func (d *Dispatcher) record(res result) {
+	delivery := res.delivery
+	switch {
+	case res.cancelled:
+		delivery.Status = models.DeliveryPending
+	case res.err == nil:
+		now := time.Now()
+		delivery.Attempts++
+		delivery.Status = models.DeliveryDelivered
+		delivery.ResponseStatus = res.status
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+	default:
+		delivery.Attempts++
+		delivery.ResponseStatus = res.status
+		delivery.LastError = res.err.Error()
+		if delivery.Attempts >= d.maxAttempts {
+			delivery.Status = models.DeliveryFailed
+		} else {
+			delivery.Status = models.DeliveryPending
+			delivery.NextAttemptAt = time.Now().Add(d.Backoff(delivery.Attempts))
+		}
+	}
+	if err := d.db.Save(&delivery).Error; err != nil {
+		log.Errorln("could not save webhook delivery:", err)
+	}
+}
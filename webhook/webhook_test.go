@@ -0,0 +1,343 @@
+package webhook_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobisme/RestApiProject/cmd"
+	"github.com/bobisme/RestApiProject/models"
+	"github.com/bobisme/RestApiProject/stream"
+	. "github.com/bobisme/RestApiProject/webhook"
+	"github.com/jinzhu/gorm"
+	_ "github.com/mattn/go-sqlite3"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testDB = "test-webhook.db"
+
+// deliveries take a few polls, which can be slow when the machine is busy
+const deliveryTimeout = 5 * time.Second
+
+// receiver records what gets posted to it, answering with the statuses it's
+// given and then 200s
+type receiver struct {
+	sync.Mutex
+	statuses   []int
+	bodies     []string
+	signatures []string
+	timestamps []string
+	events     []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.Lock()
+	defer r.Unlock()
+	r.bodies = append(r.bodies, string(body))
+	r.signatures = append(r.signatures, req.Header.Get(SignatureHeader))
+	r.timestamps = append(r.timestamps, req.Header.Get(TimestampHeader))
+	r.events = append(r.events, req.Header.Get(EventHeader))
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.bodies)
+}
+
+var _ = Describe("Webhook", func() {
+	var db *gorm.DB
+	var ts *httptest.Server
+	var recv *receiver
+	var dispatcher *Dispatcher
+
+	BeforeEach(func() {
+		Ω(cmd.CreateDb(testDB, true)).Should(Succeed())
+		var err error
+		db, err = gorm.Open("sqlite3", testDB)
+		Ω(err).ShouldNot(HaveOccurred())
+		recv = &receiver{}
+		ts = httptest.NewServer(recv)
+		dispatcher = NewDispatcher(db, 2, 3)
+		dispatcher.PollInterval = 10 * time.Millisecond
+		dispatcher.BaseDelay = 10 * time.Millisecond
+		dispatcher.MaxDelay = 40 * time.Millisecond
+		// the test receiver is on localhost
+		dispatcher.AllowPrivateAddresses = true
+	})
+
+	AfterEach(func() {
+		ts.Close()
+		db.Close()
+		os.Remove(testDB)
+	})
+
+	createHook := func(userID uint, allUsers bool, events ...string) models.Webhook {
+		hook := models.Webhook{
+			UserID: userID, URL: ts.URL, AllUsers: allUsers,
+			EventTypes: events, Secret: "shh",
+		}
+		Ω(db.Create(&hook).Error).ShouldNot(HaveOccurred())
+		return hook
+	}
+
+	enqueueVisit := func() {
+		err := Enqueue(db, stream.Event{Type: stream.VisitCreated, UserID: 1})
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	getDeliveries := func() []models.WebhookDelivery {
+		deliveries := []models.WebhookDelivery{}
+		Ω(db.Order("id").Find(&deliveries).Error).ShouldNot(HaveOccurred())
+		return deliveries
+	}
+
+	// wait on the stored status rather than the receiver, since a send
+	// that's stopped before the response comes back is sent again later
+	deliveryStatus := func() string {
+		return getDeliveries()[0].Status
+	}
+
+	Describe("Sign", func() {
+		It("is the hex hmac-sha256 of the timestamp and payload", func() {
+			// the key and message from RFC 4231 test case 2
+			Ω(Sign("Jefe", 1700000000, []byte("what do ya want for nothing?"))).Should(Equal(
+				"sha256=1cdd0650c8be1cb0974b1788d458b1e781206cfef59b85faafc582d2e182c57e"))
+		})
+	})
+
+	Describe("Verify", func() {
+		payload := []byte(`{"type":"visit.created"}`)
+		sent := time.Unix(1700000000, 0)
+		signature := Sign("shh", sent.Unix(), payload)
+
+		It("accepts signatures within the tolerance", func() {
+			Ω(Verify("shh", signature, "1700000000", payload, sent)).Should(Succeed())
+			Ω(Verify("shh", signature, "1700000000", payload,
+				sent.Add(SignatureTolerance))).Should(Succeed())
+		})
+
+		It("rejects replays outside the tolerance", func() {
+			Ω(Verify("shh", signature, "1700000000", payload,
+				sent.Add(SignatureTolerance+time.Second))).Should(Equal(ErrBadTimestamp))
+			Ω(Verify("shh", signature, "1700000000", payload,
+				sent.Add(-SignatureTolerance-time.Second))).Should(Equal(ErrBadTimestamp))
+			Ω(Verify("shh", signature, "soon", payload, sent)).Should(Equal(ErrBadTimestamp))
+		})
+
+		It("rejects changed timestamps and payloads", func() {
+			Ω(Verify("shh", signature, "1700000001", payload, sent)).
+				Should(Equal(ErrBadSignature))
+			Ω(Verify("shh", signature, "1700000000", []byte("{}"), sent)).
+				Should(Equal(ErrBadSignature))
+			Ω(Verify("psst", signature, "1700000000", payload, sent)).
+				Should(Equal(ErrBadSignature))
+		})
+	})
+
+	Describe("Enqueue", func() {
+		It("queues deliveries for hooks that want the event", func() {
+			createHook(1, false, stream.VisitCreated, stream.VisitDeleted)
+			createHook(1, false, stream.UserUpdated)
+			createHook(2, false, stream.VisitCreated)
+			createHook(3, true, stream.VisitCreated)
+			err := Enqueue(db, stream.Event{Type: stream.VisitCreated, UserID: 1})
+			Ω(err).ShouldNot(HaveOccurred())
+			deliveries := getDeliveries()
+			Ω(deliveries).Should(HaveLen(2))
+			Ω(deliveries[0].WebhookID).Should(Equal(uint(1)))
+			Ω(deliveries[1].WebhookID).Should(Equal(uint(4)))
+			Ω(deliveries[0].Status).Should(Equal(models.DeliveryPending))
+			Ω(deliveries[0].Payload).Should(ContainSubstring(`"visit.created"`))
+		})
+
+		It("doesn't match event type prefixes", func() {
+			createHook(1, false, "visit.create")
+			err := Enqueue(db, stream.Event{Type: stream.VisitCreated, UserID: 1})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(getDeliveries()).Should(BeEmpty())
+		})
+	})
+
+	Describe("Dispatcher", func() {
+		It("posts signed payloads", func() {
+			createHook(1, false, stream.VisitCreated)
+			enqueueVisit()
+			Ω(dispatcher.Start()).Should(Succeed())
+			Eventually(deliveryStatus, deliveryTimeout).
+				Should(Equal(models.DeliveryDelivered))
+			dispatcher.Stop()
+
+			Ω(recv.count()).Should(Equal(1))
+			Ω(recv.events[0]).Should(Equal(stream.VisitCreated))
+			Ω(Verify("shh", recv.signatures[0], recv.timestamps[0],
+				[]byte(recv.bodies[0]), time.Now())).Should(Succeed())
+			delivery := getDeliveries()[0]
+			Ω(delivery.Status).Should(Equal(models.DeliveryDelivered))
+			Ω(delivery.Attempts).Should(Equal(1))
+			Ω(delivery.ResponseStatus).Should(Equal(http.StatusOK))
+			Ω(delivery.DeliveredAt).ShouldNot(BeNil())
+		})
+
+		It("retries failed deliveries", func() {
+			recv.statuses = []int{http.StatusInternalServerError, http.StatusBadGateway}
+			createHook(1, false, stream.VisitCreated)
+			enqueueVisit()
+			Ω(dispatcher.Start()).Should(Succeed())
+			Eventually(deliveryStatus, deliveryTimeout).
+				Should(Equal(models.DeliveryDelivered))
+			dispatcher.Stop()
+
+			Ω(recv.count()).Should(Equal(3))
+			Ω(getDeliveries()[0].Attempts).Should(Equal(3))
+		})
+
+		It("gives up after the max attempts", func() {
+			recv.statuses = []int{500, 500, 500, 500}
+			createHook(1, false, stream.VisitCreated)
+			enqueueVisit()
+			Ω(dispatcher.Start()).Should(Succeed())
+			Eventually(deliveryStatus, deliveryTimeout).
+				Should(Equal(models.DeliveryFailed))
+			dispatcher.Stop()
+
+			Ω(recv.count()).Should(Equal(3))
+			delivery := getDeliveries()[0]
+			Ω(delivery.Attempts).Should(Equal(3))
+			Ω(delivery.LastError).Should(ContainSubstring("500"))
+		})
+
+		It("fails deliveries for removed webhooks", func() {
+			hook := createHook(1, false, stream.VisitCreated)
+			enqueueVisit()
+			Ω(db.Delete(&hook).Error).ShouldNot(HaveOccurred())
+			Ω(dispatcher.Start()).Should(Succeed())
+			Eventually(deliveryStatus, deliveryTimeout).
+				Should(Equal(models.DeliveryFailed))
+			dispatcher.Stop()
+			Ω(recv.count()).Should(Equal(0))
+		})
+
+		It("sends each delivery once with more than one dispatcher", func() {
+			createHook(1, false, stream.VisitCreated)
+			for i := 0; i < 6; i++ {
+				enqueueVisit()
+			}
+			other := NewDispatcher(db, 2, 3)
+			other.PollInterval = dispatcher.PollInterval
+			other.AllowPrivateAddresses = true
+			Ω(dispatcher.Start()).Should(Succeed())
+			Ω(other.Start()).Should(Succeed())
+			Eventually(func() int {
+				delivered := 0
+				for _, d := range getDeliveries() {
+					if d.Status == models.DeliveryDelivered {
+						delivered++
+					}
+				}
+				return delivered
+			}, deliveryTimeout).Should(Equal(6))
+			dispatcher.Stop()
+			other.Stop()
+			Ω(recv.count()).Should(Equal(6))
+		})
+
+		It("retries deliveries left sending", func() {
+			createHook(1, false, stream.VisitCreated)
+			enqueueVisit()
+			Ω(db.Model(&models.WebhookDelivery{}).UpdateColumns(map[string]interface{}{
+				"status": models.DeliverySending, "updated_at": time.Now().Add(-time.Hour),
+			}).Error).ShouldNot(HaveOccurred())
+			Ω(dispatcher.Start()).Should(Succeed())
+			Eventually(deliveryStatus, deliveryTimeout).
+				Should(Equal(models.DeliveryDelivered))
+			dispatcher.Stop()
+			Ω(recv.count()).Should(Equal(1))
+		})
+
+		It("leaves deliveries another server is sending", func() {
+			createHook(1, false, stream.VisitCreated)
+			enqueueVisit()
+			Ω(db.Model(&models.WebhookDelivery{}).
+				Update("status", models.DeliverySending).Error).ShouldNot(HaveOccurred())
+			Ω(dispatcher.Start()).Should(Succeed())
+			Consistently(deliveryStatus, 100*time.Millisecond).
+				Should(Equal(models.DeliverySending))
+			dispatcher.Stop()
+			Ω(recv.count()).Should(Equal(0))
+		})
+	})
+
+	Describe("addresses", func() {
+		It("only counts addresses on the internet as public", func() {
+			for _, ip := range []string{
+				"127.0.0.1", "10.0.0.1", "172.31.255.255", "192.168.1.1",
+				"169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1",
+				"fd00::1", "::ffff:10.0.0.1",
+			} {
+				Ω(PublicIP(net.ParseIP(ip))).Should(BeFalse(), ip)
+			}
+			for _, ip := range []string{"93.184.216.34", "172.32.0.1", "2606:4700::1"} {
+				Ω(PublicIP(net.ParseIP(ip))).Should(BeTrue(), ip)
+			}
+		})
+
+		It("checks the url's host", func() {
+			Ω(CheckURL("http://localhost/hook")).ShouldNot(Succeed())
+			Ω(CheckURL("http://api.localhost/hook")).ShouldNot(Succeed())
+			Ω(CheckURL("http://169.254.169.254/")).ShouldNot(Succeed())
+			Ω(CheckURL("https://93.184.216.34:8443/hook")).Should(Succeed())
+		})
+
+		It("won't deliver to private addresses", func() {
+			dispatcher.AllowPrivateAddresses = false
+			createHook(1, false, stream.VisitCreated)
+			// a name that resolves inside the network is caught too
+			hook := createHook(1, false, stream.VisitCreated)
+			Ω(db.Model(&hook).Update("url",
+				strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)).Error).
+				ShouldNot(HaveOccurred())
+			enqueueVisit()
+			Ω(dispatcher.Start()).Should(Succeed())
+			Eventually(func() []string {
+				statuses := []string{}
+				for _, d := range getDeliveries() {
+					statuses = append(statuses, d.Status)
+				}
+				return statuses
+			}, deliveryTimeout).Should(Equal(
+				[]string{models.DeliveryFailed, models.DeliveryFailed}))
+			dispatcher.Stop()
+
+			Ω(recv.count()).Should(Equal(0))
+			for _, delivery := range getDeliveries() {
+				Ω(delivery.LastError).Should(ContainSubstring("not a public address"))
+			}
+		})
+	})
+
+	Describe("Backoff", func() {
+		It("doubles up to the max", func() {
+			d := NewDispatcher(db, 1, 1)
+			d.BaseDelay = time.Second
+			d.MaxDelay = 5 * time.Second
+			Ω(d.Backoff(1)).Should(Equal(time.Second))
+			Ω(d.Backoff(2)).Should(Equal(2 * time.Second))
+			Ω(d.Backoff(3)).Should(Equal(4 * time.Second))
+			Ω(d.Backoff(4)).Should(Equal(5 * time.Second))
+		})
+	})
+})